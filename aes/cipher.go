@@ -10,8 +10,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"regexp"
-	"strconv"
-	"time"
 
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/logging"
@@ -33,11 +31,23 @@ type encryptedValue struct {
 
 const nonceSize int = 32
 
+// Prefix is the prefix of the values encrypted with this cipher.
+const Prefix = "ENC[AES256_GCM,"
+
 type stashKey struct {
 	additionalData string
 	plaintext      interface{}
 }
 
+// newStashKey returns the stash key of a plaintext. Byte slices cannot be
+// compared, so they are stashed as strings.
+func newStashKey(plaintext interface{}, additionalData string) stashKey {
+	if b, ok := plaintext.([]byte); ok {
+		plaintext = string(b)
+	}
+	return stashKey{plaintext: plaintext, additionalData: additionalData}
+}
+
 // Cipher encrypts and decrypts data keys with AES GCM 256
 type Cipher struct {
 	// stash is a map that stores IVs for reuse, so that the ciphertext doesn't change when decrypting and reencrypting
@@ -99,29 +109,12 @@ func (c Cipher) Decrypt(ciphertext string, key []byte, additionalData string) (p
 	if err != nil {
 		return nil, fmt.Errorf("Could not decrypt with AES_GCM: %s", err)
 	}
-	decryptedValue := string(decryptedBytes)
-	switch encryptedValue.datatype {
-	case "str":
-		plaintext = decryptedValue
-	case "int":
-		plaintext, err = strconv.Atoi(decryptedValue)
-	case "float":
-		plaintext, err = strconv.ParseFloat(decryptedValue, 64)
-	case "bytes":
-		plaintext = decryptedBytes
-	case "bool":
-		plaintext, err = strconv.ParseBool(decryptedValue)
-	case "time":
-		var value time.Time
-		err = value.UnmarshalText(decryptedBytes)
-		plaintext = value
-	case "comment":
-		plaintext = sops.Comment{Value: decryptedValue}
-	default:
-		return nil, fmt.Errorf("Unknown datatype: %s", encryptedValue.datatype)
+	plaintext, err = sops.DecodeValue(encryptedValue.datatype, decryptedBytes)
+	if err != nil {
+		return nil, err
 	}
-	c.stash[stashKey{plaintext: plaintext, additionalData: additionalData}] = encryptedValue.iv
-	return plaintext, err
+	c.stash[newStashKey(plaintext, additionalData)] = encryptedValue.iv
+	return plaintext, nil
 }
 
 func isEmpty(value interface{}) bool {
@@ -137,7 +130,7 @@ func isEmpty(value interface{}) bool {
 	}
 }
 
-// Encrypt takes one of (string, int, float, bool) and encrypts it with the provided key and additional auth data, returning a sops-format encrypted string.
+// Encrypt takes one of (string, bytes, int, float, bool, number, timestamp, null) and encrypts it with the provided key and additional auth data, returning a sops-format encrypted string.
 func (c Cipher) Encrypt(plaintext interface{}, key []byte, additionalData string) (ciphertext string, err error) {
 	if isEmpty(plaintext) {
 		return "", nil
@@ -147,7 +140,7 @@ func (c Cipher) Encrypt(plaintext interface{}, key []byte, additionalData string
 		return "", fmt.Errorf("Could not initialize AES GCM encryption cipher: %s", err)
 	}
 	var iv []byte
-	if stash, ok := c.stash[stashKey{plaintext: plaintext, additionalData: additionalData}]; !ok {
+	if stash, ok := c.stash[newStashKey(plaintext, additionalData)]; !ok {
 		iv = make([]byte, nonceSize)
 		_, err = rand.Read(iv)
 		if err != nil {
@@ -160,38 +153,9 @@ func (c Cipher) Encrypt(plaintext interface{}, key []byte, additionalData string
 	if err != nil {
 		return "", fmt.Errorf("Could not create GCM: %s", err)
 	}
-	var plainBytes []byte
-	var encryptedType string
-	switch value := plaintext.(type) {
-	case string:
-		encryptedType = "str"
-		plainBytes = []byte(value)
-	case int:
-		encryptedType = "int"
-		plainBytes = []byte(strconv.Itoa(value))
-	case float64:
-		encryptedType = "float"
-		// The Python version encodes floats without padding 0s after the decimal point.
-		plainBytes = []byte(strconv.FormatFloat(value, 'f', -1, 64))
-	case bool:
-		encryptedType = "bool"
-		// The Python version encodes booleans with Titlecase
-		if value {
-			plainBytes = []byte("True")
-		} else {
-			plainBytes = []byte("False")
-		}
-	case time.Time:
-		encryptedType = "time"
-		plainBytes, err = value.MarshalText()
-		if err != nil {
-			return "", fmt.Errorf("Error marshaling timestamp %q: %w", value, err)
-		}
-	case sops.Comment:
-		encryptedType = "comment"
-		plainBytes = []byte(value.Value)
-	default:
-		return "", fmt.Errorf("Value to encrypt has unsupported type %T", value)
+	encryptedType, plainBytes, err := sops.EncodeValue(plaintext)
+	if err != nil {
+		return "", err
 	}
 	out := gcm.Seal(nil, iv, plainBytes, []byte(additionalData))
 	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
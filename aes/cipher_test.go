@@ -141,6 +141,63 @@ func TestRoundtripTime(t *testing.T) {
 	}
 }
 
+func TestRoundtripNumber(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	values := map[sops.Number]string{
+		"12345678901234567890":    "type:int]",
+		"-98765432109876543210":   "type:int]",
+		"0.10000000000000000001":  "type:float]",
+		"123456789012345678901.5": "type:float]",
+		"1e400":                   "type:float]",
+	}
+	for value, datatype := range values {
+		s, err := NewCipher().Encrypt(value, key, "foo")
+		assert.Nil(t, err)
+		assert.True(t, strings.HasSuffix(s, datatype), s)
+		d, err := NewCipher().Decrypt(s, key, "foo")
+		assert.Nil(t, err)
+		assert.Equal(t, value, d)
+	}
+}
+
+func TestRoundtripTimestamp(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	for _, value := range []sops.Timestamp{"2024-01-02", "2001-12-14t21:59:43.10-05:00", "1979-05-27 07:32:00.999999", "07:32:00"} {
+		s, err := NewCipher().Encrypt(value, key, "foo")
+		assert.Nil(t, err)
+		assert.True(t, strings.HasSuffix(s, "type:timestamp]"), s)
+		d, err := NewCipher().Decrypt(s, key, "foo")
+		assert.Nil(t, err)
+		assert.Equal(t, value, d)
+	}
+}
+
+func TestRoundtripNull(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	s, err := NewCipher().Encrypt(nil, key, "foo")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(s, "type:null]"), s)
+	d, err := NewCipher().Decrypt(s, key, "foo")
+	assert.Nil(t, err)
+	assert.Nil(t, d)
+}
+
+func TestRoundtripBytes(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	value := []byte{0x00, 0xff, 0x80, 'a'}
+	c := NewCipher()
+	s, err := c.Encrypt(value, key, "foo")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(s, "type:bytes]"), s)
+	d, err := c.Decrypt(s, key, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, value, d)
+	// The IV is stashed for bytes as for other values
+	again, err := c.Encrypt(d, key, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, s, again)
+}
+
 func TestEncryptEmptyComment(t *testing.T) {
 	key := []byte(strings.Repeat("f", 32))
 	s, err := NewCipher().Encrypt(sops.Comment{}, key, "")
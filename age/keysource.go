@@ -1,6 +1,7 @@
 package age
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
@@ -11,8 +12,12 @@ import (
 	"strings"
 
 	"filippo.io/age"
+	"filippo.io/age/agessh"
 	"filippo.io/age/armor"
+	"filippo.io/age/plugin"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 
 	"github.com/getsops/sops/v3/logging"
 )
@@ -27,6 +32,10 @@ const (
 	// SopsAgeKeyUserConfigPath is the default age keys file path in
 	// getUserConfigDir().
 	SopsAgeKeyUserConfigPath = "sops/age/keys.txt"
+	// SopsAgeSshPrivateKeyFileEnv can be set as an environment variable
+	// pointing to an SSH private key file. When unset, the default SSH
+	// private key paths in the user's home directory are tried instead.
+	SopsAgeSshPrivateKeyFileEnv = "SOPS_AGE_SSH_PRIVATE_KEY_FILE"
 	// On macOS, os.UserConfigDir() ignores XDG_CONFIG_HOME. So we handle that manually.
 	xdgConfigHome = "XDG_CONFIG_HOME"
 	// KeyTypeIdentifier is the string used to identify an age MasterKey.
@@ -48,7 +57,9 @@ type MasterKey struct {
 	// using ParsedIdentities.ApplyToMasterKey, or loaded from the runtime
 	// environment (variables) as defined by the `SopsAgeKey*` constants.
 	Identity string
-	// Recipient contains the Bench32-encoded age public key used to Encrypt.
+	// Recipient contains the Bench32-encoded age public key (including
+	// plugin recipients), or the authorized_keys formatted SSH public key,
+	// used to Encrypt.
 	Recipient string
 	// EncryptedKey contains the SOPS data key encrypted with age.
 	EncryptedKey string
@@ -58,13 +69,14 @@ type MasterKey struct {
 	// It can also be injected by a (local) keyservice.KeyServiceServer using
 	// ParsedIdentities.ApplyToMasterKey().
 	parsedIdentities []age.Identity
-	// parsedRecipient contains a parsed age public key.
+	// parsedRecipient contains a parsed age, age plugin or SSH public key.
 	// It is used to lazy-load the Recipient at-most once.
-	parsedRecipient *age.X25519Recipient
+	parsedRecipient age.Recipient
 }
 
 // MasterKeysFromRecipients takes a comma-separated list of Bech32-encoded
-// public keys, parses them, and returns a slice of new MasterKeys.
+// age public keys or SSH public keys, parses them, and returns a slice of new
+// MasterKeys.
 func MasterKeysFromRecipients(commaSeparatedRecipients string) ([]*MasterKey, error) {
 	if commaSeparatedRecipients == "" {
 		// otherwise Split returns [""] and MasterKeyFromRecipient is unhappy
@@ -83,8 +95,8 @@ func MasterKeysFromRecipients(commaSeparatedRecipients string) ([]*MasterKey, er
 	return keys, nil
 }
 
-// MasterKeyFromRecipient takes a Bech32-encoded age public key, parses it, and
-// returns a new MasterKey.
+// MasterKeyFromRecipient takes a Bech32-encoded age public key or an SSH
+// public key, parses it, and returns a new MasterKey.
 func MasterKeyFromRecipient(recipient string) (*MasterKey, error) {
 	recipient = strings.TrimSpace(recipient)
 	parsedRecipient, err := parseRecipient(recipient)
@@ -107,6 +119,7 @@ type ParsedIdentities []age.Identity
 // It returns any parsing error.
 // A single identity argument is allowed to be a multiline string containing
 // multiple identities. Empty lines and lines starting with "#" are ignored.
+// Plugin identities ("AGE-PLUGIN-...") are supported as well.
 // It is not thread safe, and parallel importing would better be done by
 // parsing (using age.ParseIdentities) and appending to the slice yourself, in
 // combination with e.g. a sync.Mutex.
@@ -130,7 +143,7 @@ func (key *MasterKey) Encrypt(dataKey []byte) error {
 	if key.parsedRecipient == nil {
 		parsedRecipient, err := parseRecipient(key.Recipient)
 		if err != nil {
-			log.WithField("recipient", key.parsedRecipient).Info("Encryption failed")
+			log.WithField("recipient", key.Recipient).Info("Encryption failed")
 			return err
 		}
 		key.parsedRecipient = parsedRecipient
@@ -140,24 +153,24 @@ func (key *MasterKey) Encrypt(dataKey []byte) error {
 	aw := armor.NewWriter(&buffer)
 	w, err := age.Encrypt(aw, key.parsedRecipient)
 	if err != nil {
-		log.WithField("recipient", key.parsedRecipient).Info("Encryption failed")
+		log.WithField("recipient", key.Recipient).Info("Encryption failed")
 		return fmt.Errorf("failed to create writer for encrypting sops data key with age: %w", err)
 	}
 	if _, err := w.Write(dataKey); err != nil {
-		log.WithField("recipient", key.parsedRecipient).Info("Encryption failed")
+		log.WithField("recipient", key.Recipient).Info("Encryption failed")
 		return fmt.Errorf("failed to encrypt sops data key with age: %w", err)
 	}
 	if err := w.Close(); err != nil {
-		log.WithField("recipient", key.parsedRecipient).Info("Encryption failed")
+		log.WithField("recipient", key.Recipient).Info("Encryption failed")
 		return fmt.Errorf("failed to close writer for encrypting sops data key with age: %w", err)
 	}
 	if err := aw.Close(); err != nil {
-		log.WithField("recipient", key.parsedRecipient).Info("Encryption failed")
+		log.WithField("recipient", key.Recipient).Info("Encryption failed")
 		return fmt.Errorf("failed to close armored writer: %w", err)
 	}
 
 	key.SetEncryptedDataKey(buffer.Bytes())
-	log.WithField("recipient", key.parsedRecipient).Info("Encryption succeeded")
+	log.WithField("recipient", key.Recipient).Info("Encryption succeeded")
 	return nil
 }
 
@@ -244,9 +257,19 @@ func getUserConfigDir() (string, error) {
 
 // loadIdentities attempts to load the age identities based on runtime
 // environment configurations (e.g. SopsAgeKeyEnv, SopsAgeKeyFileEnv,
-// SopsAgeKeyUserConfigPath). It will load all found references, and expects
-// at least one configuration to be present.
+// SopsAgeSshPrivateKeyFileEnv, SopsAgeKeyUserConfigPath). It will load all
+// found references, and expects at least one configuration to be present.
 func (key *MasterKey) loadIdentities() (ParsedIdentities, error) {
+	var identities ParsedIdentities
+
+	sshIdentity, err := loadAgeSSHIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH identity: %w", err)
+	}
+	if sshIdentity != nil {
+		identities = append(identities, sshIdentity)
+	}
+
 	var readers = make(map[string]io.Reader, 0)
 
 	if ageKey, ok := os.LookupEnv(SopsAgeKeyEnv); ok {
@@ -263,7 +286,7 @@ func (key *MasterKey) loadIdentities() (ParsedIdentities, error) {
 	}
 
 	userConfigDir, err := getUserConfigDir()
-	if err != nil && len(readers) == 0 {
+	if err != nil && len(readers) == 0 && len(identities) == 0 {
 		return nil, fmt.Errorf("user config directory could not be determined: %w", err)
 	}
 	if userConfigDir != "" {
@@ -272,7 +295,7 @@ func (key *MasterKey) loadIdentities() (ParsedIdentities, error) {
 		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return nil, fmt.Errorf("failed to open file: %w", err)
 		}
-		if errors.Is(err, os.ErrNotExist) && len(readers) == 0 {
+		if errors.Is(err, os.ErrNotExist) && len(readers) == 0 && len(identities) == 0 {
 			// If we have no other readers, presence of the file is required.
 			return nil, fmt.Errorf("failed to open file: %w", err)
 		}
@@ -282,9 +305,8 @@ func (key *MasterKey) loadIdentities() (ParsedIdentities, error) {
 		}
 	}
 
-	var identities ParsedIdentities
 	for n, r := range readers {
-		ids, err := age.ParseIdentities(r)
+		ids, err := parseIdentitiesFromReader(r)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse '%s' age identities: %w", n, err)
 		}
@@ -293,9 +315,114 @@ func (key *MasterKey) loadIdentities() (ParsedIdentities, error) {
 	return identities, nil
 }
 
+// loadAgeSSHIdentity attempts to load an SSH private key as an age identity.
+// The key is read from the file SopsAgeSshPrivateKeyFileEnv points to, or
+// else from ~/.ssh/id_ed25519 or ~/.ssh/id_rsa. It returns a nil identity
+// without error if none of these are configured or can be loaded, as only
+// the file SopsAgeSshPrivateKeyFileEnv points to is required to be an age
+// identity.
+func loadAgeSSHIdentity() (age.Identity, error) {
+	if sshKeyFilePath, ok := os.LookupEnv(SopsAgeSshPrivateKeyFileEnv); ok {
+		return parseSSHIdentityFromPrivateKeyFile(sshKeyFilePath)
+	}
+
+	userHomeDir, err := os.UserHomeDir()
+	if err != nil || userHomeDir == "" {
+		log.Warnf("could not determine the user home directory: %v", err)
+		return nil, nil
+	}
+	for _, name := range []string{"id_ed25519", "id_rsa"} {
+		sshKeyFilePath := filepath.Join(userHomeDir, ".ssh", name)
+		if _, err := os.Stat(sshKeyFilePath); err != nil {
+			continue
+		}
+		identity, err := parseSSHIdentityFromPrivateKeyFile(sshKeyFilePath)
+		if err != nil {
+			log.Debugf("skipping SSH private key %q: %v", sshKeyFilePath, err)
+			continue
+		}
+		return identity, nil
+	}
+	return nil, nil
+}
+
+// parseSSHIdentityFromPrivateKeyFile parses the SSH private key at the given
+// path into an age identity. If the private key is protected by a passphrase,
+// the passphrase is requested from the terminal the first time the identity
+// is used to decrypt.
+func parseSSHIdentityFromPrivateKeyFile(keyPath string) (age.Identity, error) {
+	keyFile, err := os.Open(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer keyFile.Close()
+	contents, err := io.ReadAll(io.LimitReader(keyFile, 16384))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", keyPath, err)
+	}
+	id, err := agessh.ParseIdentity(contents)
+	if sshErr, ok := err.(*ssh.PassphraseMissingError); ok {
+		pubKey := sshErr.PublicKey
+		if pubKey == nil {
+			pubKey, err = readPublicKeyFile(keyPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		passphrasePrompt := func() ([]byte, error) {
+			fmt.Fprintf(os.Stderr, "Enter passphrase for %q: ", keyPath)
+			pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return nil, fmt.Errorf("could not read passphrase for %q: %v", keyPath, err)
+			}
+			return pass, nil
+		}
+		i, err := agessh.NewEncryptedSSHIdentity(pubKey, contents, passphrasePrompt)
+		if err != nil {
+			return nil, fmt.Errorf("could not create encrypted SSH identity: %w", err)
+		}
+		return i, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed SSH identity in %q: %w", keyPath, err)
+	}
+	return id, nil
+}
+
+// readPublicKeyFile reads the SSH public key that accompanies the private key
+// at the given path, as required to decrypt with encrypted private keys in
+// formats which do not embed their public key.
+func readPublicKeyFile(privateKeyPath string) (ssh.PublicKey, error) {
+	publicKeyPath := privateKeyPath + ".pub"
+	f, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain public key for %q SSH key: %w", privateKeyPath, err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", publicKeyPath, err)
+	}
+	return pubKey, nil
+}
+
 // parseRecipient attempts to parse a string containing an encoded age public
-// key.
-func parseRecipient(recipient string) (*age.X25519Recipient, error) {
+// key, an age plugin recipient, or an SSH public key in authorized_keys format.
+func parseRecipient(recipient string) (age.Recipient, error) {
+	if isPluginRecipient(recipient) {
+		parsedRecipient, err := plugin.NewRecipient(recipient, pluginTerminalUI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse input as age plugin recipient: %w", err)
+		}
+		return parsedRecipient, nil
+	}
+	if strings.HasPrefix(recipient, "ssh-") {
+		parsedRecipient, err := agessh.ParseRecipient(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse input as SSH public key: %w", err)
+		}
+		return parsedRecipient, nil
+	}
 	parsedRecipient, err := age.ParseX25519Recipient(recipient)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse input as Bech32-encoded age public key: %w", err)
@@ -303,13 +430,20 @@ func parseRecipient(recipient string) (*age.X25519Recipient, error) {
 	return parsedRecipient, nil
 }
 
+// isPluginRecipient returns whether the recipient is an age plugin recipient
+// ("age1name1..."). As the Bech32 data part can not contain a "1", these are
+// told apart from X25519 recipients by a second separator.
+func isPluginRecipient(recipient string) bool {
+	return strings.HasPrefix(recipient, "age1") && strings.Count(recipient, "1") > 1
+}
+
 // parseIdentities attempts to parse the string set of encoded age identities.
 // A single identity argument is allowed to be a multiline string containing
 // multiple identities. Empty lines and lines starting with "#" are ignored.
 func parseIdentities(identity ...string) (ParsedIdentities, error) {
 	var identities []age.Identity
 	for _, i := range identity {
-		parsed, err := age.ParseIdentities(strings.NewReader(i))
+		parsed, err := parseIdentitiesFromReader(strings.NewReader(i))
 		if err != nil {
 			return nil, err
 		}
@@ -317,3 +451,39 @@ func parseIdentities(identity ...string) (ParsedIdentities, error) {
 	}
 	return identities, nil
 }
+
+// parseIdentitiesFromReader parses a file with one or more age identities,
+// like age.ParseIdentities does. In addition to X25519 identities, it accepts
+// plugin identities ("AGE-PLUGIN-..."), which are handled by the
+// "age-plugin-<name>" binary found in PATH.
+func parseIdentitiesFromReader(f io.Reader) (ParsedIdentities, error) {
+	const privateKeySizeLimit = 1 << 24 // 16 MiB
+	var ids ParsedIdentities
+	scanner := bufio.NewScanner(io.LimitReader(f, privateKeySizeLimit))
+	var n int
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		var i age.Identity
+		var err error
+		if strings.HasPrefix(line, "AGE-PLUGIN-") {
+			i, err = plugin.NewIdentity(line, pluginTerminalUI)
+		} else {
+			i, err = age.ParseX25519Identity(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error at line %d: %v", n, err)
+		}
+		ids = append(ids, i)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read secret keys file: %v", err)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no secret keys found")
+	}
+	return ids, nil
+}
@@ -0,0 +1,52 @@
+package age
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age/plugin"
+	"golang.org/x/term"
+)
+
+// pluginTerminalUI is the plugin.ClientUI used for age plugin recipients and
+// identities. Messages and prompts are written to stderr, and values are read
+// from the terminal.
+var pluginTerminalUI = &plugin.ClientUI{
+	DisplayMessage: func(name, message string) error {
+		log.Infof("age-plugin-%s: %s", name, message)
+		return nil
+	},
+	RequestValue: func(name, prompt string, secret bool) (string, error) {
+		fmt.Fprintf(os.Stderr, "age-plugin-%s: %s ", name, prompt)
+		defer fmt.Fprintln(os.Stderr)
+		if secret {
+			value, err := term.ReadPassword(int(os.Stdin.Fd()))
+			if err != nil {
+				return "", fmt.Errorf("could not read value for age-plugin-%s: %w", name, err)
+			}
+			return string(value), nil
+		}
+		value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("could not read value for age-plugin-%s: %w", name, err)
+		}
+		return strings.TrimRight(value, "\r\n"), nil
+	},
+	Confirm: func(name, prompt, yes, no string) (bool, error) {
+		if no == "" {
+			fmt.Fprintf(os.Stderr, "age-plugin-%s: %s [press enter for %q] ", name, prompt, yes)
+		} else {
+			fmt.Fprintf(os.Stderr, "age-plugin-%s: %s [1 for %q, 2 for %q] ", name, prompt, yes, no)
+		}
+		value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return false, fmt.Errorf("could not read confirmation for age-plugin-%s: %w", name, err)
+		}
+		return no == "" || strings.TrimSpace(value) != "2", nil
+	},
+	WaitTimer: func(name string) {
+		log.Infof("Waiting for age-plugin-%s...", name)
+	},
+}
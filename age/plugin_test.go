@@ -0,0 +1,176 @@
+package age
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"filippo.io/age/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPluginName is the name of the stub age plugin, which is served by the
+// test binary itself when invoked as "age-plugin-sopstest".
+const mockPluginName = "sopstest"
+
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "age-plugin-"+mockPluginName {
+		runMockPlugin(os.Args[1])
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMockPlugin implements a stub of the age plugin protocol. It "wraps" the
+// file key by storing it as-is in a stanza of its own type, and "unwraps" it
+// by returning the body of such a stanza. It must never be used for anything
+// other than tests.
+func runMockPlugin(protocol string) {
+	r := bufio.NewReader(os.Stdin)
+	var stanzas [][]string
+	var bodies [][]byte
+	for {
+		args, body := readMockStanza(r)
+		if args[0] == "done" {
+			break
+		}
+		stanzas = append(stanzas, args)
+		bodies = append(bodies, body)
+	}
+
+	switch protocol {
+	case "--age-plugin=recipient-v1":
+		for i, args := range stanzas {
+			if args[0] == "wrap-file-key" {
+				writeMockStanza("recipient-stanza 0 "+mockPluginName, bodies[i])
+				readMockStanza(r) // ok
+			}
+		}
+	case "--age-plugin=identity-v1":
+		for i, args := range stanzas {
+			if args[0] == "recipient-stanza" && len(args) > 2 && args[2] == mockPluginName {
+				writeMockStanza("file-key 0", bodies[i])
+				readMockStanza(r) // ok
+				break
+			}
+		}
+	default:
+		panic(protocol)
+	}
+	writeMockStanza("done", nil)
+}
+
+func readMockStanza(r *bufio.Reader) ([]string, []byte) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		panic(err)
+	}
+	args := strings.Fields(strings.TrimPrefix(line, "-> "))
+	var body []byte
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			panic(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		b, err := base64.RawStdEncoding.DecodeString(line)
+		if err != nil {
+			panic(err)
+		}
+		body = append(body, b...)
+		if len(line) < 64 {
+			return args, body
+		}
+	}
+}
+
+func writeMockStanza(args string, body []byte) {
+	fmt.Fprintf(os.Stdout, "-> %s\n%s\n", args, base64.RawStdEncoding.EncodeToString(body))
+}
+
+// installMockPlugin makes the stub age plugin available in PATH.
+func installMockPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub age plugin is not supported on Windows")
+	}
+	ex, err := os.Executable()
+	assert.NoError(t, err)
+	binDir := t.TempDir()
+	assert.NoError(t, os.Symlink(ex, filepath.Join(binDir, "age-plugin-"+mockPluginName)))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestMasterKey_EncryptDecrypt_PluginRoundTrip(t *testing.T) {
+	installMockPlugin(t)
+
+	recipient := plugin.EncodeRecipient(mockPluginName, []byte("recipient"))
+	identity := plugin.EncodeIdentity(mockPluginName, []byte("identity"))
+
+	encryptKey, err := MasterKeyFromRecipient(recipient)
+	assert.NoError(t, err)
+	assert.IsType(t, &plugin.Recipient{}, encryptKey.parsedRecipient)
+
+	data := []byte("some secret data")
+	assert.NoError(t, encryptKey.Encrypt(data))
+	assert.NotEmpty(t, encryptKey.EncryptedKey)
+
+	t.Run("parsed identities", func(t *testing.T) {
+		var ids ParsedIdentities
+		assert.NoError(t, ids.Import(identity))
+
+		decryptKey := &MasterKey{EncryptedKey: encryptKey.EncryptedKey}
+		ids.ApplyToMasterKey(decryptKey)
+
+		got, err := decryptKey.Decrypt()
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("loaded identities", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		overwriteUserConfigDir(t, tmpDir)
+		t.Setenv(SopsAgeKeyEnv, "# plugin identity\n"+identity+"\n"+mockIdentity)
+
+		decryptKey := &MasterKey{EncryptedKey: encryptKey.EncryptedKey}
+		got, err := decryptKey.Decrypt()
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+}
+
+func TestMasterKey_Encrypt_MissingPlugin(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	key, err := MasterKeyFromRecipient(plugin.EncodeRecipient("missing", []byte("recipient")))
+	assert.NoError(t, err)
+
+	err = key.Encrypt([]byte(mockEncryptedKeyPlain))
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, "couldn't start plugin")
+	assert.Empty(t, key.EncryptedKey)
+}
+
+func TestParseIdentitiesFromReader(t *testing.T) {
+	got, err := parseIdentitiesFromReader(strings.NewReader("# comment\n\n" +
+		mockIdentity + "\n" + plugin.EncodeIdentity(mockPluginName, nil) + "\n"))
+	assert.NoError(t, err)
+	assert.Len(t, got, 2)
+	assert.IsType(t, &plugin.Identity{}, got[1])
+
+	_, err = parseIdentitiesFromReader(strings.NewReader("# comment\n"))
+	assert.ErrorContains(t, err, "no secret keys found")
+
+	_, err = parseIdentitiesFromReader(strings.NewReader(mockIdentity + "\ninvalid\n"))
+	assert.ErrorContains(t, err, "error at line 2")
+}
+
+func TestIsPluginRecipient(t *testing.T) {
+	assert.True(t, isPluginRecipient(plugin.EncodeRecipient(mockPluginName, []byte("recipient"))))
+	assert.False(t, isPluginRecipient(mockRecipient))
+	assert.False(t, isPluginRecipient(mockSshRecipient))
+}
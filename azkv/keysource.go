@@ -25,6 +25,10 @@ import (
 const (
 	// KeyTypeIdentifier is the string used to identify an Azure Key Vault MasterKey.
 	KeyTypeIdentifier = "azure_kv"
+	// LatestVersion can be used as the Version of a MasterKey to encrypt with
+	// the latest version of the key. Like an empty Version, it is resolved to
+	// the actual version at encryption time.
+	LatestVersion = "latest"
 )
 
 var (
@@ -46,7 +50,9 @@ type MasterKey struct {
 	VaultURL string
 	// Name of the Azure Key Vault key in the VaultURL.
 	Name string
-	// Version of the Azure Key Vault key. Can be empty.
+	// Version of the Azure Key Vault key. Can be empty or LatestVersion, in
+	// which case it is resolved to the latest enabled version of the key when
+	// encrypting.
 	Version string
 	// EncryptedKey contains the SOPS data key encrypted with the Azure Key
 	// Vault key.
@@ -60,6 +66,11 @@ type MasterKey struct {
 	// using TokenCredential.ApplyToMasterKey.
 	// If nil, azidentity.NewDefaultAzureCredential is used.
 	tokenCredential azcore.TokenCredential
+	// clientOptions are the options used to construct the Azure client. This
+	// is mostly used for testing purposes, as it can not be injected using
+	// e.g. an environment variable. The field is not publicly exposed, nor
+	// configurable.
+	clientOptions *azkeys.ClientOptions
 }
 
 // NewMasterKey creates a new MasterKey from a URL, key name and version,
@@ -74,12 +85,14 @@ func NewMasterKey(vaultURL string, keyName string, keyVersion string) *MasterKey
 }
 
 // NewMasterKeyFromURL takes an Azure Key Vault key URL, and returns a new
-// MasterKey. The URL format is {vaultUrl}/keys/{keyName}/{keyVersion}.
+// MasterKey. The URL format is {vaultUrl}/keys/{keyName}/{keyVersion}, where
+// the version can be omitted or "latest" to encrypt with the latest version of
+// the key.
 func NewMasterKeyFromURL(url string) (*MasterKey, error) {
 	url = strings.TrimSpace(url)
-	re := regexp.MustCompile("^(https://[^/]+)/keys/([^/]+)/([^/]+)$")
+	re := regexp.MustCompile("^(https://[^/]+)/keys/([^/]+)(?:/([^/]*))?$")
 	parts := re.FindStringSubmatch(url)
-	if parts == nil || len(parts) < 3 {
+	if parts == nil || len(parts) < 4 {
 		return nil, fmt.Errorf("could not parse %q into a valid Azure Key Vault MasterKey", url)
 	}
 	return NewMasterKey(parts[1], parts[2], parts[3]), nil
@@ -119,7 +132,8 @@ func (t TokenCredential) ApplyToMasterKey(key *MasterKey) {
 }
 
 // Encrypt takes a SOPS data key, encrypts it with Azure Key Vault, and stores
-// the result in the EncryptedKey field.
+// the result in the EncryptedKey field. If the Version of the key is not set,
+// it is set to the version of the key which encrypted the data key.
 func (key *MasterKey) Encrypt(dataKey []byte) error {
 	token, err := key.getTokenCredential()
 	if err != nil {
@@ -127,13 +141,13 @@ func (key *MasterKey) Encrypt(dataKey []byte) error {
 		return fmt.Errorf("failed to get Azure token credential to encrypt data: %w", err)
 	}
 
-	c, err := azkeys.NewClient(key.VaultURL, token, nil)
+	c, err := azkeys.NewClient(key.VaultURL, token, key.clientOptions)
 	if err != nil {
 		log.WithFields(logrus.Fields{"key": key.Name, "version": key.Version}).Info("Encryption failed")
 		return fmt.Errorf("failed to construct Azure Key Vault client to encrypt data: %w", err)
 	}
 
-	resp, err := c.Encrypt(context.Background(), key.Name, key.Version, azkeys.KeyOperationParameters{
+	resp, err := c.Encrypt(context.Background(), key.Name, key.apiVersion(), azkeys.KeyOperationParameters{
 		Algorithm: to.Ptr(azkeys.EncryptionAlgorithmRSAOAEP256),
 		Value:     dataKey,
 	}, nil)
@@ -142,6 +156,9 @@ func (key *MasterKey) Encrypt(dataKey []byte) error {
 		return fmt.Errorf("failed to encrypt sops data key with Azure Key Vault key '%s': %w", key.ToString(), err)
 	}
 
+	if key.apiVersion() == "" && resp.KID != nil {
+		key.Version = resp.KID.Version()
+	}
 	encodedEncryptedKey := base64.RawURLEncoding.EncodeToString(resp.KeyOperationResult.Result)
 	key.SetEncryptedDataKey([]byte(encodedEncryptedKey))
 	log.WithFields(logrus.Fields{"key": key.Name, "version": key.Version}).Info("Encryption succeeded")
@@ -182,13 +199,13 @@ func (key *MasterKey) Decrypt() ([]byte, error) {
 		return nil, fmt.Errorf("failed to base64 decode Azure Key Vault encrypted key: %w", err)
 	}
 
-	c, err := azkeys.NewClient(key.VaultURL, token, nil)
+	c, err := azkeys.NewClient(key.VaultURL, token, key.clientOptions)
 	if err != nil {
 		log.WithFields(logrus.Fields{"key": key.Name, "version": key.Version}).Info("Decryption failed")
 		return nil, fmt.Errorf("failed to construct Azure Key Vault client to decrypt data: %w", err)
 	}
 
-	resp, err := c.Decrypt(context.Background(), key.Name, key.Version, azkeys.KeyOperationParameters{
+	resp, err := c.Decrypt(context.Background(), key.Name, key.apiVersion(), azkeys.KeyOperationParameters{
 		Algorithm: to.Ptr(azkeys.EncryptionAlgorithmRSAOAEP256),
 		Value:     rawEncryptedKey,
 	}, nil)
@@ -201,8 +218,82 @@ func (key *MasterKey) Decrypt() ([]byte, error) {
 }
 
 // NeedsRotation returns whether the data key needs to be rotated or not.
+// This is the case if the MasterKey is older than the TTL, or if a newer
+// enabled version of the Azure Key Vault key than Version exists.
 func (key *MasterKey) NeedsRotation() bool {
-	return time.Since(key.CreationDate) > (azkvTTL)
+	if time.Since(key.CreationDate) > (azkvTTL) {
+		return true
+	}
+	if key.apiVersion() == "" {
+		return false
+	}
+	latest, err := key.latestVersion()
+	if err != nil {
+		log.WithFields(logrus.Fields{"key": key.Name, "version": key.Version}).Warnf("Failed to determine latest key version: %s", err)
+		return false
+	}
+	return latest != key.Version
+}
+
+// ResolveVersion sets the Version of the MasterKey to the latest enabled
+// version of the Azure Key Vault key if it is not set, or if latest is true.
+func (key *MasterKey) ResolveVersion(latest bool) error {
+	if !latest && key.apiVersion() != "" {
+		return nil
+	}
+	version, err := key.latestVersion()
+	if err != nil {
+		return fmt.Errorf("failed to resolve latest version of Azure Key Vault key '%s': %w", key.ToString(), err)
+	}
+	key.Version = version
+	return nil
+}
+
+// SetVersion sets the Version of the MasterKey to the version the data key
+// was encrypted with by a key service.
+func (key *MasterKey) SetVersion(version string) {
+	key.Version = version
+}
+
+// latestVersion returns the most recently created enabled version of the
+// Azure Key Vault key.
+func (key *MasterKey) latestVersion() (string, error) {
+	token, err := key.getTokenCredential()
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure token credential: %w", err)
+	}
+	c, err := azkeys.NewClient(key.VaultURL, token, key.clientOptions)
+	if err != nil {
+		return "", fmt.Errorf("failed to construct Azure Key Vault client: %w", err)
+	}
+
+	var (
+		version string
+		created time.Time
+	)
+	pager := c.NewListKeyPropertiesVersionsPager(key.Name, nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return "", fmt.Errorf("failed to list key versions: %w", err)
+		}
+		for _, props := range page.Value {
+			if props == nil || props.KID == nil || props.Attributes == nil || props.Attributes.Created == nil {
+				continue
+			}
+			if props.Attributes.Enabled != nil && !*props.Attributes.Enabled {
+				continue
+			}
+			if version == "" || props.Attributes.Created.After(created) {
+				version = props.KID.Version()
+				created = *props.Attributes.Created
+			}
+		}
+	}
+	if version == "" {
+		return "", fmt.Errorf("no enabled key version found")
+	}
+	return version, nil
 }
 
 // ToString converts the key to a string representation.
@@ -226,6 +317,15 @@ func (key *MasterKey) TypeToIdentifier() string {
 	return KeyTypeIdentifier
 }
 
+// apiVersion returns the Version of the MasterKey as used by the Azure Key
+// Vault API, where the latest version of a key is an empty version.
+func (key *MasterKey) apiVersion() string {
+	if key.Version == LatestVersion {
+		return ""
+	}
+	return key.Version
+}
+
 // getTokenCredential returns the tokenCredential of the MasterKey, or
 // azidentity.NewDefaultAzureCredential.
 func (key *MasterKey) getTokenCredential() (azcore.TokenCredential, error) {
@@ -1,10 +1,19 @@
 package azkv
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +37,24 @@ func TestNewMasterKeyFromURL(t *testing.T) {
 				Version:  "a2a690a4fcc04166b739da342a912c90",
 			},
 		},
+		{
+			name: "URL without version",
+			url:  "https://test.vault.azure.net/keys/test-key",
+			expectKey: MasterKey{
+				VaultURL: "https://test.vault.azure.net",
+				Name:     "test-key",
+				Version:  "",
+			},
+		},
+		{
+			name: "URL with latest version",
+			url:  "https://test.vault.azure.net/keys/test-key/latest",
+			expectKey: MasterKey{
+				VaultURL: "https://test.vault.azure.net",
+				Name:     "test-key",
+				Version:  LatestVersion,
+			},
+		},
 		{
 			name:      "malformed URL",
 			url:       "https://test.vault.azure.net/no-keys-here/test-key/a2a690a4fcc04166b739da342a912c90",
@@ -188,6 +215,69 @@ func TestMasterKey_NeedsRotation(t *testing.T) {
 	assert.True(t, key.NeedsRotation())
 }
 
+func TestMasterKey_Encrypt(t *testing.T) {
+	server := newMockKeyVault(t)
+
+	t.Run("version", func(t *testing.T) {
+		key := server.masterKey("v1")
+		assert.NoError(t, key.Encrypt([]byte("some data")))
+		assert.Equal(t, "v1", key.Version)
+
+		got, err := key.Decrypt()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("some data"), got)
+	})
+
+	for _, version := range []string{"", LatestVersion} {
+		t.Run("resolve version "+version, func(t *testing.T) {
+			key := server.masterKey(version)
+			assert.NoError(t, key.Encrypt([]byte("some data")))
+			assert.Equal(t, "v2", key.Version)
+
+			got, err := key.Decrypt()
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("some data"), got)
+		})
+	}
+}
+
+func TestMasterKey_NeedsRotation_NewerVersion(t *testing.T) {
+	server := newMockKeyVault(t)
+
+	assert.True(t, server.masterKey("v1").NeedsRotation())
+	// The more recent, but disabled, version is ignored.
+	assert.False(t, server.masterKey("v2").NeedsRotation())
+	assert.False(t, server.masterKey(LatestVersion).NeedsRotation())
+
+	// Errors are not reported as a need for rotation.
+	key := server.masterKey("v1")
+	key.Name = "unknown-key"
+	assert.False(t, key.NeedsRotation())
+}
+
+func TestMasterKey_ResolveVersion(t *testing.T) {
+	server := newMockKeyVault(t)
+
+	key := server.masterKey("")
+	assert.NoError(t, key.ResolveVersion(false))
+	assert.Equal(t, "v2", key.Version)
+
+	key = server.masterKey(LatestVersion)
+	assert.NoError(t, key.ResolveVersion(false))
+	assert.Equal(t, "v2", key.Version)
+
+	key = server.masterKey("v1")
+	assert.NoError(t, key.ResolveVersion(false))
+	assert.Equal(t, "v1", key.Version)
+	assert.NoError(t, key.ResolveVersion(true))
+	assert.Equal(t, "v2", key.Version)
+
+	key = server.masterKey("")
+	key.Name = "unknown-key"
+	assert.ErrorContains(t, key.ResolveVersion(false), "failed to resolve latest version of Azure Key Vault key")
+	assert.Empty(t, key.Version)
+}
+
 func TestMasterKey_ToString(t *testing.T) {
 	key := NewMasterKey("https://test.vault.azure.net", "key-name", "key-version")
 	assert.Equal(t, "https://test.vault.azure.net/keys/key-name/key-version", key.ToString())
@@ -231,3 +321,114 @@ func TestMasterKey_getTokenCredential(t *testing.T) {
 		assert.IsType(t, &azidentity.DefaultAzureCredential{}, got)
 	})
 }
+
+// mockKeyVault is a stub Azure Key Vault with a "test-key" key, of which
+// version "v1" and "v2" are enabled, and a more recent "v3" is disabled. It
+// "encrypts" data by prefixing it with the key version.
+type mockKeyVault struct {
+	*httptest.Server
+}
+
+func newMockKeyVault(t *testing.T) *mockKeyVault {
+	s := &mockKeyVault{}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// masterKey returns a MasterKey for the given version of "test-key".
+func (s *mockKeyVault) masterKey(version string) *MasterKey {
+	key := NewMasterKey(s.URL, "test-key", version)
+	key.tokenCredential = mockTokenCredential{}
+	key.clientOptions = &azkeys.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: s.Client(),
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+		DisableChallengeResourceVerification: true,
+	}
+	return key
+}
+
+func (s *mockKeyVault) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Paths are /keys/{name}/versions, or /keys/{name}/{version}/{operation}
+	// where the version can be empty.
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/keys/"), "/")
+	if len(parts) < 2 || parts[0] != "test-key" {
+		writeError(w, http.StatusNotFound, "KeyNotFound")
+		return
+	}
+	if len(parts) == 2 && parts[1] == "versions" {
+		created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		var versions []map[string]interface{}
+		for i, version := range []string{"v1", "v2", "v3"} {
+			versions = append(versions, map[string]interface{}{
+				"kid": s.URL + "/keys/test-key/" + version,
+				"attributes": map[string]interface{}{
+					"enabled": version != "v3",
+					"created": created.AddDate(0, i, 0).Unix(),
+				},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"value": versions})
+		return
+	}
+
+	version, operation := "", parts[len(parts)-1]
+	if len(parts) == 3 {
+		version = parts[1]
+	}
+	if version == "" {
+		version = "v2"
+	}
+	var params struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter")
+		return
+	}
+	value, err := base64.RawURLEncoding.DecodeString(params.Value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter")
+		return
+	}
+	prefix := version + ":"
+	switch operation {
+	case "encrypt":
+		value = append([]byte(prefix), value...)
+	case "decrypt":
+		if !strings.HasPrefix(string(value), prefix) {
+			writeError(w, http.StatusBadRequest, "BadParameter")
+			return
+		}
+		value = value[len(prefix):]
+	default:
+		writeError(w, http.StatusNotFound, "NotFound")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"kid":   s.URL + "/keys/test-key/" + version,
+		"value": base64.RawURLEncoding.EncodeToString(value),
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": code},
+	})
+}
+
+// mockTokenCredential is an azcore.TokenCredential returning a static token.
+type mockTokenCredential struct{}
+
+func (mockTokenCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
@@ -0,0 +1,71 @@
+/*
+Package ciphers defines a Cipher that decrypts values encrypted with any of the ciphers SOPS supports, and encrypts
+values with the cipher recorded in the metadata of the file.
+*/
+package ciphers //import "github.com/getsops/sops/v3/ciphers"
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/xchacha20poly1305"
+)
+
+// Names are the names of the supported ciphers.
+var Names = []string{sops.CipherAES256GCM, sops.CipherXChaCha20Poly1305}
+
+// Cipher decrypts values with the cipher they were encrypted with, which is
+// told by their prefix, and encrypts values with AES256_GCM unless another
+// cipher is selected with Select.
+type Cipher struct {
+	aes       aes.Cipher
+	xchacha   xchacha20poly1305.Cipher
+	encrypter sops.Cipher
+}
+
+// NewCipher is the constructor for a new Cipher object
+func NewCipher() Cipher {
+	c := Cipher{
+		aes:     aes.NewCipher(),
+		xchacha: xchacha20poly1305.NewCipher(),
+	}
+	c.encrypter = c.aes
+	return c
+}
+
+// Select returns a copy of the cipher which encrypts values with the named
+// cipher. The copy shares the values decrypted by the cipher, so that values
+// which are encrypted again with the cipher they were decrypted with keep
+// their ciphertext.
+func (c Cipher) Select(name string) (sops.Cipher, error) {
+	switch name {
+	case "", sops.CipherAES256GCM:
+		c.encrypter = c.aes
+	case sops.CipherXChaCha20Poly1305:
+		c.encrypter = c.xchacha
+	default:
+		return nil, fmt.Errorf("unknown cipher %q, supported ciphers are %s", name, strings.Join(Names, ", "))
+	}
+	return c, nil
+}
+
+// Check returns an error if name is not the name of a supported cipher.
+func Check(name string) error {
+	_, err := NewCipher().Select(name)
+	return err
+}
+
+// Encrypt encrypts the value with the selected cipher.
+func (c Cipher) Encrypt(plaintext interface{}, key []byte, additionalData string) (ciphertext string, err error) {
+	return c.encrypter.Encrypt(plaintext, key, additionalData)
+}
+
+// Decrypt decrypts the value with the cipher it was encrypted with.
+func (c Cipher) Decrypt(ciphertext string, key []byte, additionalData string) (plaintext interface{}, err error) {
+	if strings.HasPrefix(ciphertext, xchacha20poly1305.Prefix) {
+		return c.xchacha.Decrypt(ciphertext, key, additionalData)
+	}
+	return c.aes.Decrypt(ciphertext, key, additionalData)
+}
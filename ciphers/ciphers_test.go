@@ -0,0 +1,88 @@
+package ciphers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/xchacha20poly1305"
+	"github.com/stretchr/testify/assert"
+)
+
+var key = []byte(strings.Repeat("f", 32))
+
+func TestDecryptDispatchesOnPrefix(t *testing.T) {
+	aesValue, err := aes.NewCipher().Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	xchachaValue, err := xchacha20poly1305.NewCipher().Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	for _, value := range []string{aesValue, xchachaValue} {
+		d, err := NewCipher().Decrypt(value, key, "bar:")
+		assert.Nil(t, err)
+		assert.Equal(t, "foo", d)
+	}
+}
+
+func TestEncryptsWithAESByDefault(t *testing.T) {
+	s, err := NewCipher().Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(s, aes.Prefix), s)
+}
+
+func TestSelect(t *testing.T) {
+	c, err := NewCipher().Select(sops.CipherXChaCha20Poly1305)
+	assert.Nil(t, err)
+	s, err := c.Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(s, xchacha20poly1305.Prefix), s)
+
+	c, err = NewCipher().Select(sops.CipherAES256GCM)
+	assert.Nil(t, err)
+	s, err = c.Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(s, aes.Prefix), s)
+
+	_, err = NewCipher().Select("rot13")
+	assert.ErrorContains(t, err, `unknown cipher "rot13"`)
+	assert.Error(t, Check("rot13"))
+	assert.Nil(t, Check(""))
+}
+
+func TestSelectKeepsStash(t *testing.T) {
+	c := NewCipher()
+	s, err := xchacha20poly1305.NewCipher().Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	_, err = c.Decrypt(s, key, "bar:")
+	assert.Nil(t, err)
+	selected, err := c.Select(sops.CipherXChaCha20Poly1305)
+	assert.Nil(t, err)
+	again, err := selected.Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	assert.Equal(t, s, again)
+}
+
+func TestTreeEncryptUsesMetadataCipher(t *testing.T) {
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{
+			sops.TreeBranch{
+				sops.TreeItem{Key: "foo", Value: "bar"},
+				sops.TreeItem{Key: "baz", Value: 5},
+			},
+		},
+		Metadata: sops.Metadata{Cipher: sops.CipherXChaCha20Poly1305},
+	}
+	_, err := tree.Encrypt(key, NewCipher())
+	assert.Nil(t, err)
+	for _, item := range tree.Branches[0] {
+		assert.True(t, strings.HasPrefix(item.Value.(string), xchacha20poly1305.Prefix), item.Value)
+	}
+	_, err = tree.Decrypt(key, NewCipher())
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", tree.Branches[0][0].Value)
+	assert.Equal(t, 5, tree.Branches[0][1].Value)
+
+	tree.Metadata.Cipher = "rot13"
+	_, err = tree.Encrypt(key, NewCipher())
+	assert.Error(t, err)
+}
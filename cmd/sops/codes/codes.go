@@ -18,6 +18,7 @@ const (
 	CannotChangeKeysFromNonExistentFile    int = 49
 	MacMismatch                            int = 51
 	MacNotFound                            int = 52
+	MetadataMacMismatch                    int = 53
 	ConfigFileNotFound                     int = 61
 	KeyboardInterrupt                      int = 85
 	InvalidTreePathFormat                  int = 91
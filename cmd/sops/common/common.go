@@ -1,7 +1,10 @@
 package common
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -15,8 +18,14 @@ import (
 	"github.com/getsops/sops/v3/keyservice"
 	"github.com/getsops/sops/v3/kms"
 	"github.com/getsops/sops/v3/stores/dotenv"
+	"github.com/getsops/sops/v3/stores/hcl"
 	"github.com/getsops/sops/v3/stores/ini"
 	"github.com/getsops/sops/v3/stores/json"
+	"github.com/getsops/sops/v3/stores/jsonc"
+	"github.com/getsops/sops/v3/stores/kubernetes"
+	"github.com/getsops/sops/v3/stores/properties"
+	"github.com/getsops/sops/v3/stores/stream"
+	"github.com/getsops/sops/v3/stores/toml"
 	"github.com/getsops/sops/v3/stores/yaml"
 	"github.com/getsops/sops/v3/version"
 	"github.com/mitchellh/go-wordwrap"
@@ -42,10 +51,18 @@ func newBinaryStore(c *config.StoresConfig) Store {
 	return json.NewBinaryStore(&c.JSONBinary)
 }
 
+func newBinaryStreamStore(c *config.StoresConfig) Store {
+	return stream.NewStore(&c.BinaryStream)
+}
+
 func newDotenvStore(c *config.StoresConfig) Store {
 	return dotenv.NewStore(&c.Dotenv)
 }
 
+func newHclStore(c *config.StoresConfig) Store {
+	return hcl.NewStore(&c.HCL)
+}
+
 func newIniStore(c *config.StoresConfig) Store {
 	return ini.NewStore(&c.INI)
 }
@@ -54,16 +71,38 @@ func newJsonStore(c *config.StoresConfig) Store {
 	return json.NewStore(&c.JSON)
 }
 
+func newJsoncStore(c *config.StoresConfig) Store {
+	return jsonc.NewStore(&c.JSONC)
+}
+
+func newKubernetesStore(c *config.StoresConfig) Store {
+	return kubernetes.NewStore(&c.YAML)
+}
+
+func newPropertiesStore(c *config.StoresConfig) Store {
+	return properties.NewStore(&c.Properties)
+}
+
+func newTomlStore(c *config.StoresConfig) Store {
+	return toml.NewStore(&c.TOML)
+}
+
 func newYamlStore(c *config.StoresConfig) Store {
 	return yaml.NewStore(&c.YAML)
 }
 
 var storeConstructors = map[Format]storeConstructor{
-	Binary: newBinaryStore,
-	Dotenv: newDotenvStore,
-	Ini:    newIniStore,
-	Json:   newJsonStore,
-	Yaml:   newYamlStore,
+	Binary:       newBinaryStore,
+	BinaryStream: newBinaryStreamStore,
+	Dotenv:       newDotenvStore,
+	Hcl:          newHclStore,
+	Ini:          newIniStore,
+	Json:         newJsonStore,
+	Jsonc:        newJsoncStore,
+	Kubernetes:   newKubernetesStore,
+	Properties:   newPropertiesStore,
+	Toml:         newTomlStore,
+	Yaml:         newYamlStore,
 }
 
 // DecryptTreeOpts are the options needed to decrypt a tree
@@ -78,6 +117,10 @@ type DecryptTreeOpts struct {
 	IgnoreMac bool
 	// Cipher is the cryptographic cipher to use to decrypt the values inside the tree
 	Cipher sops.Cipher
+	// Paths restricts decryption to the values at these paths, if the tree
+	// has a MAC of its encrypted values to verify it with. Otherwise, all
+	// values are decrypted.
+	Paths [][]interface{}
 }
 
 // DecryptTree decrypts the tree passed in through the DecryptTreeOpts and additionally returns the decrypted data key
@@ -86,14 +129,30 @@ func DecryptTree(opts DecryptTreeOpts) (dataKey []byte, err error) {
 	if err != nil {
 		return nil, NewExitError(err, codes.CouldNotRetrieveKey)
 	}
-	computedMac, err := opts.Tree.Decrypt(dataKey, opts.Cipher)
+	opts.Tree.Metadata.UnlockZonesWithKeyServices(opts.KeyServices, opts.DecryptionOrder)
+	decryptMac := opts.Tree.Metadata.DecryptMAC
+	decrypt := func() (string, error) {
+		return opts.Tree.Decrypt(dataKey, opts.Cipher)
+	}
+	if len(opts.Paths) > 0 && opts.Tree.Metadata.CiphertextMAC != "" {
+		decryptMac = opts.Tree.Metadata.DecryptCiphertextMAC
+		decrypt = func() (string, error) {
+			return opts.Tree.DecryptPaths(dataKey, opts.Cipher, opts.Paths)
+		}
+	}
+	// The metadata tells which values are encrypted, so it is verified
+	// before the tree is decrypted.
+	fileMac, macErr := decryptMac(dataKey, opts.Cipher)
+	if !opts.IgnoreMac && errors.Is(macErr, sops.MetadataMacMismatch) {
+		return nil, NewExitError(fmt.Sprintf("Cannot decrypt MAC, the metadata of the file has been changed: %s", macErr), codes.MetadataMacMismatch)
+	}
+	computedMac, err := decrypt()
 	if err != nil {
 		return nil, NewExitError(fmt.Sprintf("Error decrypting tree: %s", err), codes.ErrorDecryptingTree)
 	}
-	fileMac, err := opts.Cipher.Decrypt(opts.Tree.Metadata.MessageAuthenticationCode, dataKey, opts.Tree.Metadata.LastModified.Format(time.RFC3339))
 	if !opts.IgnoreMac {
-		if err != nil {
-			return nil, NewExitError(fmt.Sprintf("Cannot decrypt MAC: %s", err), codes.MacMismatch)
+		if macErr != nil {
+			return nil, NewExitError(fmt.Sprintf("Cannot decrypt MAC: %s", macErr), codes.MacMismatch)
 		}
 		if fileMac != computedMac {
 			// If the file has an empty MAC, display "no MAC" instead of not displaying anything
@@ -122,16 +181,86 @@ func EncryptTree(opts EncryptTreeOpts) error {
 	if err != nil {
 		return NewExitError(fmt.Sprintf("Error encrypting tree: %s", err), codes.ErrorEncryptingTree)
 	}
+	ciphertextMac, err := opts.Tree.ComputeCiphertextMAC()
+	if err != nil {
+		return NewExitError(fmt.Sprintf("Error encrypting tree: %s", err), codes.ErrorEncryptingTree)
+	}
 	opts.Tree.Metadata.LastModified = time.Now().UTC()
-	opts.Tree.Metadata.MessageAuthenticationCode, err = opts.Cipher.Encrypt(unencryptedMac, opts.DataKey, opts.Tree.Metadata.LastModified.Format(time.RFC3339))
+	opts.Tree.Metadata.MessageAuthenticationCode, err = opts.Tree.Metadata.EncryptMAC(unencryptedMac, opts.DataKey, opts.Cipher)
+	if err != nil {
+		return NewExitError(fmt.Sprintf("Could not encrypt MAC: %s", err), codes.ErrorEncryptingMac)
+	}
+	opts.Tree.Metadata.CiphertextMAC, err = opts.Tree.Metadata.EncryptMAC(ciphertextMac, opts.DataKey, opts.Cipher)
+	if err != nil {
+		return NewExitError(fmt.Sprintf("Could not encrypt MAC: %s", err), codes.ErrorEncryptingMac)
+	}
+	return nil
+}
+
+// UpdateMetadata applies update to the metadata of a tree whose values stay
+// encrypted, such as when its master keys change. If the MAC of the tree
+// covers its metadata, the MAC is verified before the update and encrypted
+// again after it, so that it matches the updated metadata.
+func UpdateMetadata(tree *sops.Tree, dataKey []byte, cipher sops.Cipher, update func() error) error {
+	if tree.Metadata.MACVersion < sops.MACVersionMetadata {
+		return update()
+	}
+	mac, err := tree.Metadata.DecryptMAC(dataKey, cipher)
+	if errors.Is(err, sops.MetadataMacMismatch) {
+		return NewExitError(fmt.Sprintf("Cannot decrypt MAC, the metadata of the file has been changed: %s", err), codes.MetadataMacMismatch)
+	}
+	if err != nil {
+		return NewExitError(fmt.Sprintf("Cannot decrypt MAC: %s", err), codes.MacMismatch)
+	}
+	var ciphertextMac string
+	if tree.Metadata.CiphertextMAC != "" {
+		ciphertextMac, err = tree.Metadata.DecryptCiphertextMAC(dataKey, cipher)
+		if err != nil {
+			return NewExitError(fmt.Sprintf("Cannot decrypt MAC: %s", err), codes.MacMismatch)
+		}
+	}
+	if err := update(); err != nil {
+		return err
+	}
+	tree.Metadata.MessageAuthenticationCode, err = tree.Metadata.EncryptMAC(mac, dataKey, cipher)
 	if err != nil {
 		return NewExitError(fmt.Sprintf("Could not encrypt MAC: %s", err), codes.ErrorEncryptingMac)
 	}
+	if ciphertextMac != "" {
+		tree.Metadata.CiphertextMAC, err = tree.Metadata.EncryptMAC(ciphertextMac, dataKey, cipher)
+		if err != nil {
+			return NewExitError(fmt.Sprintf("Could not encrypt MAC: %s", err), codes.ErrorEncryptingMac)
+		}
+	}
 	return nil
 }
 
+// ExpandAliasesFor expands the aliases of the branches, unless the store they
+// are emitted with supports them. Styles are kept for stores which emit them.
+func ExpandAliasesFor(store interface{}, branches sops.TreeBranches) (sops.TreeBranches, error) {
+	if emitter, ok := store.(sops.AliasEmitter); ok && emitter.EmitsAliases() {
+		return branches, nil
+	}
+	expand := sops.TreeBranch.ExpandAliases
+	if emitter, ok := store.(sops.StyleEmitter); ok && emitter.EmitsStyles() {
+		expand = sops.TreeBranch.ExpandAliasesKeepingStyles
+	}
+	expanded := make(sops.TreeBranches, 0, len(branches))
+	for _, branch := range branches {
+		branch, err := expand(branch)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, branch)
+	}
+	return expanded, nil
+}
+
 // LoadEncryptedFile loads an encrypted SOPS file, returning a SOPS tree
 func LoadEncryptedFile(loader sops.EncryptedFileLoader, inputPath string) (*sops.Tree, error) {
+	if streamStore, ok := loader.(*stream.Store); ok {
+		return loadEncryptedStreamHeader(streamStore, inputPath)
+	}
 	fileBytes, err := os.ReadFile(inputPath)
 	if err != nil {
 		return nil, NewExitError(fmt.Sprintf("Error reading file: %s", err), codes.CouldNotReadInputFile)
@@ -145,6 +274,70 @@ func LoadEncryptedFile(loader sops.EncryptedFileLoader, inputPath string) (*sops
 	return &tree, err
 }
 
+// loadEncryptedStreamHeader loads the header of an encrypted binary_stream
+// file, without reading its payload.
+func loadEncryptedStreamHeader(store *stream.Store, inputPath string) (*sops.Tree, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, NewExitError(fmt.Sprintf("Error reading file: %s", err), codes.CouldNotReadInputFile)
+	}
+	defer file.Close()
+	path, err := filepath.Abs(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := store.LoadEncryptedHeader(bufio.NewReader(file))
+	tree.FilePath = path
+	return &tree, err
+}
+
+// EmitEncryptedStream writes a binary_stream file with the header of the tree,
+// followed by the encrypted payload of the file at inputPath, which is copied
+// as is.
+func EmitEncryptedStream(store *stream.Store, tree sops.Tree, inputPath string, w io.Writer) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return NewExitError(fmt.Sprintf("Error reading file: %s", err), codes.CouldNotReadInputFile)
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	if _, err := store.LoadEncryptedHeader(r); err != nil {
+		return NewExitError(fmt.Sprintf("Error reading file: %s", err), codes.CouldNotReadInputFile)
+	}
+	if err := store.EmitEncryptedHeader(w, tree); err != nil {
+		return NewExitError(fmt.Sprintf("Could not marshal tree: %s", err), codes.ErrorDumpingTree)
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// ReplaceFile writes a file through a temporary file in the same directory,
+// which replaces it once it has been written. This allows writing a file
+// which is still being read, and leaves it untouched if writing fails.
+func ReplaceFile(path string, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return NewExitError(fmt.Sprintf("Could not open file for writing: %s", err), codes.CouldNotWriteOutputFile)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if info, err := os.Stat(path); err == nil {
+		if err := file.Chmod(info.Mode().Perm()); err != nil {
+			return NewExitError(fmt.Sprintf("Could not open file for writing: %s", err), codes.CouldNotWriteOutputFile)
+		}
+	}
+	if err := write(file); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return NewExitError(fmt.Sprintf("Could not write file: %s", err), codes.CouldNotWriteOutputFile)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return NewExitError(fmt.Sprintf("Could not write file: %s", err), codes.CouldNotWriteOutputFile)
+	}
+	return nil
+}
+
 // NewExitError returns a cli.ExitError given an error (wrapped in a generic interface{})
 // and an exit code to represent the failure
 func NewExitError(i interface{}, exitCode int) *cli.ExitError {
@@ -397,6 +590,29 @@ func max(a, b int) int {
 	return b
 }
 
+// MasterKeysToRewrap returns the master keys of the key groups which
+// encrypted the data key with an outdated version of their key, along with
+// the errors of the master keys for which this could not be determined.
+func MasterKeysToRewrap(groups []sops.KeyGroup) (rewraps []keys.MasterKey, errs []error) {
+	for _, group := range groups {
+		for _, k := range group {
+			r, ok := k.(keys.Rewrapper)
+			if !ok {
+				continue
+			}
+			needsRewrap, err := r.NeedsRewrap()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Could not determine whether master key %s needs to be rewrapped: %s", k.ToString(), err))
+				continue
+			}
+			if needsRewrap {
+				rewraps = append(rewraps, k)
+			}
+		}
+	}
+	return rewraps, errs
+}
+
 // DiffKeyGroups returns the list of diffs found in two sops.keyGroup slices
 func DiffKeyGroups(ours, theirs []sops.KeyGroup) []Diff {
 	var diffs []Diff
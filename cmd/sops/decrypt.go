@@ -37,12 +37,18 @@ func decryptTree(opts decryptOpts) (tree *sops.Tree, err error) {
 		return nil, err
 	}
 
+	var paths [][]interface{}
+	if len(opts.Extract) > 0 {
+		// Only the extracted value needs to be decrypted.
+		paths = [][]interface{}{opts.Extract}
+	}
 	_, err = common.DecryptTree(common.DecryptTreeOpts{
 		Cipher:          opts.Cipher,
 		IgnoreMac:       opts.IgnoreMAC,
 		Tree:            tree,
 		KeyServices:     opts.KeyServices,
 		DecryptionOrder: opts.DecryptionOrder,
+		Paths:           paths,
 	})
 	if err != nil {
 		return nil, err
@@ -60,7 +66,10 @@ func decrypt(opts decryptOpts) (decryptedFile []byte, err error) {
 	if len(opts.Extract) > 0 {
 		return extract(tree, opts.Extract, opts.OutputStore)
 	}
-	decryptedFile, err = opts.OutputStore.EmitPlainFile(tree.Branches)
+	tree.Branches, err = common.ExpandAliasesFor(opts.OutputStore, tree.Branches)
+	if err == nil {
+		decryptedFile, err = opts.OutputStore.EmitPlainFile(tree.Branches)
+	}
 	if errors.Is(err, json.BinaryStoreEmitPlainError) {
 		err = fmt.Errorf("%s\n\n%s", err.Error(), notBinaryHint)
 	}
@@ -71,7 +80,12 @@ func decrypt(opts decryptOpts) (decryptedFile []byte, err error) {
 }
 
 func extract(tree *sops.Tree, path []interface{}, outputStore sops.Store) (output []byte, err error) {
-	v, err := tree.Branches[0].Truncate(path)
+	// The extracted value can contain aliases to anchors outside of it.
+	branch, err := tree.Branches[0].ExpandAliases()
+	if err != nil {
+		return nil, common.NewExitError(fmt.Sprintf("Error dumping file: %s", err), codes.ErrorDumpingTree)
+	}
+	v, err := branch.Truncate(path)
 	if err != nil {
 		return nil, fmt.Errorf("error truncating tree: %s", err)
 	}
@@ -64,6 +64,9 @@ func editExample(opts editExampleOpts) ([]byte, error) {
 	if len(errs) > 0 {
 		return nil, common.NewExitError(fmt.Sprintf("Error encrypting the data key with one or more master keys: %s", errs), codes.CouldNotRetrieveKey)
 	}
+	if err := generateZoneDataKeys(&tree, opts.KeyServices); err != nil {
+		return nil, common.NewExitError(err, codes.CouldNotRetrieveKey)
+	}
 
 	return editTree(opts.editOpts, &tree, dataKey)
 }
@@ -112,6 +115,11 @@ func editTree(opts editOpts, tree *sops.Tree, dataKey []byte) ([]byte, error) {
 
 	tmpfileName := tmpfile.Name()
 
+	tree.Branches, err = common.ExpandAliasesFor(opts.OutputStore, tree.Branches)
+	if err != nil {
+		return nil, common.NewExitError(fmt.Sprintf("Could not marshal tree: %s", err), codes.ErrorDumpingTree)
+	}
+
 	// Write to temporary file
 	var out []byte
 	if opts.ShowMasterKeys {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/cmd/sops/codes"
@@ -21,9 +22,14 @@ type encryptConfig struct {
 	EncryptedRegex          string
 	UnencryptedCommentRegex string
 	EncryptedCommentRegex   string
+	EncryptedPaths          []string
+	UnencryptedPaths        []string
 	MACOnlyEncrypted        bool
+	Cipher                  string
+	MACVersion              int
 	KeyGroups               []sops.KeyGroup
 	GroupThreshold          int
+	Zones                   []sops.Zone
 }
 
 type encryptOpts struct {
@@ -70,12 +76,27 @@ func metadataFromEncryptionConfig(config encryptConfig) sops.Metadata {
 		EncryptedRegex:          config.EncryptedRegex,
 		UnencryptedCommentRegex: config.UnencryptedCommentRegex,
 		EncryptedCommentRegex:   config.EncryptedCommentRegex,
+		EncryptedPaths:          config.EncryptedPaths,
+		UnencryptedPaths:        config.UnencryptedPaths,
 		MACOnlyEncrypted:        config.MACOnlyEncrypted,
+		Cipher:                  config.Cipher,
+		MACVersion:              config.MACVersion,
 		Version:                 version.Version,
 		ShamirThreshold:         config.GroupThreshold,
+		Zones:                   config.Zones,
 	}
 }
 
+// generateZoneDataKeys generates a new data key for each zone of a new tree.
+func generateZoneDataKeys(tree *sops.Tree, svcs []keyservice.KeyServiceClient) error {
+	for i := range tree.Metadata.Zones {
+		if errs := tree.Metadata.Zones[i].GenerateDataKeyWithKeyServices(svcs); len(errs) > 0 {
+			return fmt.Errorf("Could not generate data key of zone %s: %s", strings.Join(tree.Metadata.Zones[i].Paths, ", "), errs)
+		}
+	}
+	return nil
+}
+
 func encrypt(opts encryptOpts) (encryptedFile []byte, err error) {
 	// Load the file
 	fileBytes, err := os.ReadFile(opts.InputPath)
@@ -92,6 +113,10 @@ func encrypt(opts encryptOpts) (encryptedFile []byte, err error) {
 	if err := ensureNoMetadata(opts, branches[0]); err != nil {
 		return nil, common.NewExitError(err, codes.FileAlreadyEncrypted)
 	}
+	branches, err = common.ExpandAliasesFor(opts.OutputStore, branches)
+	if err != nil {
+		return nil, common.NewExitError(fmt.Sprintf("Error unmarshalling file: %s", err), codes.CouldNotReadInputFile)
+	}
 	path, err := filepath.Abs(opts.InputPath)
 	if err != nil {
 		return nil, err
@@ -106,6 +131,9 @@ func encrypt(opts encryptOpts) (encryptedFile []byte, err error) {
 		err = fmt.Errorf("Could not generate data key: %s", errs)
 		return nil, err
 	}
+	if err := generateZoneDataKeys(&tree, opts.KeyServices); err != nil {
+		return nil, err
+	}
 
 	err = common.EncryptTree(common.EncryptTreeOpts{
 		DataKey: dataKey,
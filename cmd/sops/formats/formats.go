@@ -5,20 +5,35 @@ import "strings"
 // Format is an enum type
 type Format int
 
+// New formats are appended, so that the values of existing formats do not
+// change.
 const (
 	Binary Format = iota
 	Dotenv
 	Ini
 	Json
 	Yaml
+	Toml
+	Jsonc
+	Kubernetes
+	Properties
+	Hcl
+	BinaryStream
 )
 
 var stringToFormat = map[string]Format{
-	"binary": Binary,
-	"dotenv": Dotenv,
-	"ini":    Ini,
-	"json":   Json,
-	"yaml":   Yaml,
+	"binary":        Binary,
+	"binary_stream": BinaryStream,
+	"dotenv":        Dotenv,
+	"hcl":           Hcl,
+	"ini":           Ini,
+	"json":          Json,
+	"jsonc":         Jsonc,
+	"json5":         Jsonc,
+	"kubernetes":    Kubernetes,
+	"properties":    Properties,
+	"toml":          Toml,
+	"yaml":          Yaml,
 }
 
 // FormatFromString returns a Format from a string.
@@ -41,16 +56,39 @@ func IsJSONFile(path string) bool {
 	return strings.HasSuffix(path, ".json")
 }
 
+// IsJSONCFile returns true if a given file path corresponds to a JSON file
+// with comments, or a JSON5 file
+func IsJSONCFile(path string) bool {
+	return strings.HasSuffix(path, ".jsonc") || strings.HasSuffix(path, ".json5")
+}
+
 // IsEnvFile returns true if a given file path corresponds to a .env file
 func IsEnvFile(path string) bool {
 	return strings.HasSuffix(path, ".env")
 }
 
+// IsHCLFile returns true if a given file path corresponds to an HCL file, such
+// as a Terraform variable definitions file
+func IsHCLFile(path string) bool {
+	return strings.HasSuffix(path, ".tfvars") || strings.HasSuffix(path, ".hcl")
+}
+
 // IsIniFile returns true if a given file path corresponds to a INI file
 func IsIniFile(path string) bool {
 	return strings.HasSuffix(path, ".ini")
 }
 
+// IsPropertiesFile returns true if a given file path corresponds to a Java
+// .properties file
+func IsPropertiesFile(path string) bool {
+	return strings.HasSuffix(path, ".properties")
+}
+
+// IsTOMLFile returns true if a given file path corresponds to a TOML file
+func IsTOMLFile(path string) bool {
+	return strings.HasSuffix(path, ".toml")
+}
+
 // FormatForPath returns the correct format given the path to a file
 func FormatForPath(path string) Format {
 	format := Binary // default
@@ -58,10 +96,18 @@ func FormatForPath(path string) Format {
 		format = Yaml
 	} else if IsJSONFile(path) {
 		format = Json
+	} else if IsJSONCFile(path) {
+		format = Jsonc
 	} else if IsEnvFile(path) {
 		format = Dotenv
+	} else if IsHCLFile(path) {
+		format = Hcl
 	} else if IsIniFile(path) {
 		format = Ini
+	} else if IsPropertiesFile(path) {
+		format = Properties
+	} else if IsTOMLFile(path) {
+		format = Toml
 	}
 	return format
 }
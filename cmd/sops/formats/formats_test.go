@@ -8,17 +8,41 @@ import (
 
 func TestFormatFromString(t *testing.T) {
 	assert.Equal(t, Binary, FormatFromString("foobar"))
+	assert.Equal(t, BinaryStream, FormatFromString("binary_stream"))
 	assert.Equal(t, Dotenv, FormatFromString("dotenv"))
+	assert.Equal(t, Hcl, FormatFromString("hcl"))
 	assert.Equal(t, Ini, FormatFromString("ini"))
 	assert.Equal(t, Yaml, FormatFromString("yaml"))
 	assert.Equal(t, Json, FormatFromString("json"))
+	assert.Equal(t, Jsonc, FormatFromString("jsonc"))
+	assert.Equal(t, Jsonc, FormatFromString("json5"))
+	assert.Equal(t, Kubernetes, FormatFromString("kubernetes"))
+	assert.Equal(t, Properties, FormatFromString("properties"))
+	assert.Equal(t, Toml, FormatFromString("toml"))
+}
+
+func TestFormatValuesAreStable(t *testing.T) {
+	// Formats are re-exported by the decrypt package, so their values are
+	// part of its stable API.
+	assert.Equal(t, Format(0), Binary)
+	assert.Equal(t, Format(1), Dotenv)
+	assert.Equal(t, Format(2), Ini)
+	assert.Equal(t, Format(3), Json)
+	assert.Equal(t, Format(4), Yaml)
 }
 
 func TestFormatForPath(t *testing.T) {
 	assert.Equal(t, Binary, FormatForPath("/path/to/foobar"))
 	assert.Equal(t, Dotenv, FormatForPath("/path/to/foobar.env"))
+	assert.Equal(t, Hcl, FormatForPath("/path/to/foobar.tfvars"))
+	assert.Equal(t, Hcl, FormatForPath("/path/to/foobar.auto.tfvars"))
+	assert.Equal(t, Hcl, FormatForPath("/path/to/foobar.hcl"))
 	assert.Equal(t, Ini, FormatForPath("/path/to/foobar.ini"))
 	assert.Equal(t, Json, FormatForPath("/path/to/foobar.json"))
+	assert.Equal(t, Jsonc, FormatForPath("/path/to/foobar.jsonc"))
+	assert.Equal(t, Jsonc, FormatForPath("/path/to/foobar.json5"))
+	assert.Equal(t, Properties, FormatForPath("/path/to/foobar.properties"))
+	assert.Equal(t, Toml, FormatForPath("/path/to/foobar.toml"))
 	assert.Equal(t, Yaml, FormatForPath("/path/to/foobar.yml"))
 	assert.Equal(t, Yaml, FormatForPath("/path/to/foobar.yaml"))
 }
@@ -27,10 +51,19 @@ func TestFormatForPathOrString(t *testing.T) {
 	assert.Equal(t, Binary, FormatForPathOrString("/path/to/foobar", ""))
 	assert.Equal(t, Dotenv, FormatForPathOrString("/path/to/foobar", "dotenv"))
 	assert.Equal(t, Dotenv, FormatForPathOrString("/path/to/foobar.env", ""))
+	assert.Equal(t, Hcl, FormatForPathOrString("/path/to/foobar", "hcl"))
+	assert.Equal(t, Hcl, FormatForPathOrString("/path/to/foobar.tfvars", ""))
 	assert.Equal(t, Ini, FormatForPathOrString("/path/to/foobar", "ini"))
 	assert.Equal(t, Ini, FormatForPathOrString("/path/to/foobar.ini", ""))
 	assert.Equal(t, Json, FormatForPathOrString("/path/to/foobar", "json"))
 	assert.Equal(t, Json, FormatForPathOrString("/path/to/foobar.json", ""))
+	assert.Equal(t, Jsonc, FormatForPathOrString("/path/to/foobar.json", "jsonc"))
+	assert.Equal(t, Jsonc, FormatForPathOrString("/path/to/foobar.jsonc", ""))
+	assert.Equal(t, Kubernetes, FormatForPathOrString("/path/to/foobar.yaml", "kubernetes"))
+	assert.Equal(t, Properties, FormatForPathOrString("/path/to/foobar", "properties"))
+	assert.Equal(t, Properties, FormatForPathOrString("/path/to/foobar.properties", ""))
+	assert.Equal(t, Toml, FormatForPathOrString("/path/to/foobar", "toml"))
+	assert.Equal(t, Toml, FormatForPathOrString("/path/to/foobar.toml", ""))
 	assert.Equal(t, Yaml, FormatForPathOrString("/path/to/foobar", "yaml"))
 	assert.Equal(t, Yaml, FormatForPathOrString("/path/to/foobar.yml", ""))
 
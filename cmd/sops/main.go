@@ -4,6 +4,7 @@ import (
 	"context"
 	encodingjson "encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
@@ -19,12 +20,13 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/getsops/sops/v3"
-	"github.com/getsops/sops/v3/aes"
 	"github.com/getsops/sops/v3/age"
 	_ "github.com/getsops/sops/v3/audit"
 	"github.com/getsops/sops/v3/azkv"
+	"github.com/getsops/sops/v3/ciphers"
 	"github.com/getsops/sops/v3/cmd/sops/codes"
 	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
 	"github.com/getsops/sops/v3/cmd/sops/subcommand/exec"
 	filestatuscmd "github.com/getsops/sops/v3/cmd/sops/subcommand/filestatus"
 	"github.com/getsops/sops/v3/cmd/sops/subcommand/groups"
@@ -39,8 +41,10 @@ import (
 	"github.com/getsops/sops/v3/kms"
 	"github.com/getsops/sops/v3/logging"
 	"github.com/getsops/sops/v3/pgp"
+	"github.com/getsops/sops/v3/pkcs11"
 	"github.com/getsops/sops/v3/stores/dotenv"
 	"github.com/getsops/sops/v3/stores/json"
+	"github.com/getsops/sops/v3/stores/kubernetes"
 	"github.com/getsops/sops/v3/version"
 )
 
@@ -78,6 +82,16 @@ func main() {
 			Name:  "keyservice",
 			Usage: "Specify the key services to use in addition to the local one. Can be specified more than once. Syntax: protocol://address. Example: tcp://myserver.com:5000",
 		},
+		cli.BoolFlag{
+			Name:   "allow-exec-plugins",
+			Usage:  "allow the local key service to run the exec plugins named by key groups",
+			EnvVar: "SOPS_ALLOW_EXEC_PLUGINS",
+		},
+		cli.BoolFlag{
+			Name:   "allow-pkcs11-module-paths",
+			Usage:  "allow the local key service to load the PKCS#11 modules named by keys, instead of the one set in SOPS_PKCS11_MODULE",
+			EnvVar: "SOPS_ALLOW_PKCS11_MODULE_PATHS",
+		},
 	}
 	app.Name = "sops"
 	app.Usage = "sops - encrypted file editor with AWS KMS, GCP KMS, Azure Key Vault, age, and GPG support"
@@ -124,6 +138,14 @@ func main() {
    To encrypt or decrypt using PGP, specify the PGP fingerprint in the
    -p flag or in the SOPS_PGP_FP environment variable.
 
+   To encrypt or decrypt using a key stored in a PKCS#11 token (such as
+   an HSM), specify the PKCS#11 URI of the key in the --pkcs11 flag or
+   in the SOPS_PKCS11_URIS environment variable (for example
+   'pkcs11:token=sops;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so').
+   The module path of a key is only used with --allow-pkcs11-module-paths,
+   otherwise the module is read from the SOPS_PKCS11_MODULE environment
+   variable. The PIN is read from the SOPS_PKCS11_PIN environment variable.
+
    To use multiple KMS or PGP keys, separate them by commas. For example:
        $ sops -p "10F2...0A, 85D...B3F21" file.yaml
 
@@ -131,8 +153,8 @@ func main() {
    used to encrypt new documents. Editing or decrypting existing documents
    can be done with "sops file" or "sops decrypt file" respectively. The KMS and
    PGP keys listed in the encrypted documents are used then. To manage master
-   keys in existing documents, use the "add-{kms,pgp,gcp-kms,azure-kv,hc-vault-transit}"
-   and "rm-{kms,pgp,gcp-kms,azure-kv,hc-vault-transit}" flags with --rotate
+   keys in existing documents, use the "add-{kms,pgp,gcp-kms,azure-kv,hc-vault-transit,pkcs11}"
+   and "rm-{kms,pgp,gcp-kms,azure-kv,hc-vault-transit,pkcs11}" flags with --rotate
    or the updatekeys command.
 
    To use a different GPG binary than the one in your PATH, set SOPS_GPG_EXEC.
@@ -191,7 +213,7 @@ func main() {
 					OutputStore:     &dotenv.Store{},
 					InputStore:      inputStore,
 					InputPath:       fileName,
-					Cipher:          aes.NewCipher(),
+					Cipher:          ciphers.NewCipher(),
 					KeyServices:     svcs,
 					DecryptionOrder: order,
 					IgnoreMAC:       c.Bool("ignore-mac"),
@@ -210,8 +232,13 @@ func main() {
 					return toExitError(err)
 				}
 
+				branch, err := tree.Branches[0].ExpandAliases()
+				if err != nil {
+					return toExitError(err)
+				}
+
 				var env []string
-				for _, item := range tree.Branches[0] {
+				for _, item := range branch {
 					if dotenv.IsComplexValue(item.Value) {
 						return cli.NewExitError(fmt.Errorf("cannot use complex value in environment: %s", item.Value), codes.ErrorGeneric)
 					}
@@ -266,11 +293,11 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, ini, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 				cli.StringFlag{
 					Name:  "output-type",
-					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+					Usage: "currently hcl, ini, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 				},
 				cli.StringFlag{
 					Name:  "filename",
@@ -304,7 +331,7 @@ func main() {
 					OutputStore:     outputStore,
 					InputStore:      inputStore,
 					InputPath:       fileName,
-					Cipher:          aes.NewCipher(),
+					Cipher:          ciphers.NewCipher(),
 					KeyServices:     svcs,
 					DecryptionOrder: order,
 					IgnoreMAC:       c.Bool("ignore-mac"),
@@ -391,7 +418,7 @@ func main() {
 						err = publishcmd.Run(publishcmd.Opts{
 							ConfigPath:      configPath,
 							InputPath:       subPath,
-							Cipher:          aes.NewCipher(),
+							Cipher:          ciphers.NewCipher(),
 							KeyServices:     keyservices(c),
 							DecryptionOrder: order,
 							InputStore:      inputStore,
@@ -431,6 +458,14 @@ func main() {
 					Name:  "prompt",
 					Usage: "Prompt user to confirm every incoming request",
 				},
+				cli.BoolFlag{
+					Name:  "allow-exec-plugins",
+					Usage: "Allow requests using exec plugin keys, which run any plugin installed on this server that the client names. Only use with trusted clients",
+				},
+				cli.BoolFlag{
+					Name:  "allow-pkcs11-module-paths",
+					Usage: "Allow requests using PKCS#11 keys to load the module the client names, instead of the one set in SOPS_PKCS11_MODULE. Only use with trusted clients",
+				},
 				cli.BoolFlag{
 					Name:  "verbose",
 					Usage: "Enable verbose logging output",
@@ -441,9 +476,11 @@ func main() {
 					logging.SetLevel(logrus.DebugLevel)
 				}
 				err := keyservicecmd.Run(keyservicecmd.Opts{
-					Network: c.String("network"),
-					Address: c.String("address"),
-					Prompt:  c.Bool("prompt"),
+					Network:          c.String("network"),
+					Address:          c.String("address"),
+					Prompt:           c.Bool("prompt"),
+					AllowExecPlugins:       c.Bool("allow-exec-plugins"),
+					AllowPkcs11ModulePaths: c.Bool("allow-pkcs11-module-paths"),
 				})
 				if err != nil {
 					log.Errorf("Error running keyservice: %s", err)
@@ -459,7 +496,7 @@ func main() {
 			Flags:     []cli.Flag{
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, ini, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 			},
 			Action: func(c *cli.Context) error {
@@ -532,6 +569,10 @@ func main() {
 							Name:  "age",
 							Usage: "the age recipient the new group should contain. Can be specified more than once",
 						},
+						cli.StringSliceFlag{
+							Name:  "pkcs11",
+							Usage: "the PKCS#11 URI of the key the new group should contain. Can be specified more than once",
+						},
 						cli.BoolFlag{
 							Name:  "in-place, i",
 							Usage: "write output back to the same file instead of stdout",
@@ -552,6 +593,7 @@ func main() {
 						vaultURIs := c.StringSlice("hc-vault-transit")
 						azkvs := c.StringSlice("azure-kv")
 						ageRecipients := c.StringSlice("age")
+						pkcs11URIs := c.StringSlice("pkcs11")
 						if c.NArg() != 0 {
 							return common.NewExitError(fmt.Errorf("error: no positional arguments allowed"), codes.ErrorGeneric)
 						}
@@ -591,6 +633,14 @@ func main() {
 								group = append(group, key)
 							}
 						}
+						for _, uri := range pkcs11URIs {
+							k, err := pkcs11.NewMasterKeyFromURI(uri)
+							if err != nil {
+								log.WithError(err).Error("Failed to add key")
+								continue
+							}
+							group = append(group, k)
+						}
 						inputStore, err := inputStore(c, c.String("file"))
 						if err != nil {
 							return toExitError(err)
@@ -670,7 +720,7 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently ini, json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, ini, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 			}, keyserviceFlags...),
 			Action: func(c *cli.Context) error {
@@ -739,11 +789,11 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 				cli.StringFlag{
 					Name:  "output-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 				},
 				cli.BoolFlag{
 					Name:  "ignore-mac",
@@ -802,16 +852,22 @@ func main() {
 				if err != nil {
 					return common.NewExitError(fmt.Errorf("error parsing --extract path: %s", err), codes.InvalidTreePathFormat)
 				}
-				output, err := decrypt(decryptOpts{
+				opts := decryptOpts{
 					OutputStore:     outputStore,
 					InputStore:      inputStore,
 					InputPath:       fileName,
-					Cipher:          aes.NewCipher(),
+					Cipher:          ciphers.NewCipher(),
 					Extract:         extract,
 					KeyServices:     svcs,
 					DecryptionOrder: order,
 					IgnoreMAC:       c.Bool("ignore-mac"),
-				})
+				}
+				if isStream(inputStore) {
+					return writeStream(c, fileName, func(w io.Writer) error {
+						return decryptStream(opts, w)
+					})
+				}
+				output, err := decrypt(opts)
 				if err != nil {
 					return toExitError(err)
 				}
@@ -892,13 +948,18 @@ func main() {
 					Usage:  "comma separated list of age recipients",
 					EnvVar: "SOPS_AGE_RECIPIENTS",
 				},
+				cli.StringFlag{
+					Name:   "pkcs11",
+					Usage:  "comma separated list of PKCS#11 key URIs (e.g. 'pkcs11:token=sops;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so')",
+					EnvVar: "SOPS_PKCS11_URIS",
+				},
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 				cli.StringFlag{
 					Name:  "output-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 				},
 				cli.StringFlag{
 					Name:  "unencrypted-suffix",
@@ -916,6 +977,14 @@ func main() {
 					Name:  "encrypted-regex",
 					Usage: "set the encrypted key regex. When specified, only keys matching the regex will be encrypted.",
 				},
+				cli.StringSliceFlag{
+					Name:  "encrypted-path",
+					Usage: "the path of values to encrypt, such as 'spec.**.env[*].value'. When specified, only values at these paths will be encrypted. Can be specified more than once",
+				},
+				cli.StringSliceFlag{
+					Name:  "unencrypted-path",
+					Usage: "the path of values to leave unencrypted, even if they are at an encrypted path. Can be specified more than once",
+				},
 				cli.StringFlag{
 					Name:  "encryption-context",
 					Usage: "comma separated list of KMS encryption context key:value pairs",
@@ -924,6 +993,14 @@ func main() {
 					Name:  "shamir-secret-sharing-threshold",
 					Usage: "the number of master keys required to retrieve the data key with shamir",
 				},
+				cli.StringFlag{
+					Name:  "cipher",
+					Usage: "the cipher to encrypt values with, aes256_gcm (the default) or xchacha20_poly1305",
+				},
+				cli.IntFlag{
+					Name:  "mac-version",
+					Usage: "the version of the MAC, 1 (the default) to only authenticate values or 2 to also authenticate the metadata",
+				},
 				cli.StringFlag{
 					Name:  "filename-override",
 					Usage: "Use this filename instead of the provided argument for loading configuration, and for determining input type and output type",
@@ -966,14 +1043,20 @@ func main() {
 				if err != nil {
 					return toExitError(err)
 				}
-				output, err := encrypt(encryptOpts{
+				opts := encryptOpts{
 					OutputStore:   outputStore,
 					InputStore:    inputStore,
 					InputPath:     fileName,
-					Cipher:        aes.NewCipher(),
+					Cipher:        ciphers.NewCipher(),
 					KeyServices:   svcs,
 					encryptConfig: encConfig,
-				})
+				}
+				if isStream(outputStore) {
+					return writeStream(c, fileName, func(w io.Writer) error {
+						return encryptStream(opts, w)
+					})
+				}
+				output, err := encrypt(opts)
 
 				if err != nil {
 					return toExitError(err)
@@ -1023,16 +1106,24 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 				cli.StringFlag{
 					Name:  "output-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 				},
 				cli.StringFlag{
 					Name:  "encryption-context",
 					Usage: "comma separated list of KMS encryption context key:value pairs",
 				},
+				cli.StringFlag{
+					Name:  "cipher",
+					Usage: "encrypt the values of the file again with the cipher, aes256_gcm or xchacha20_poly1305",
+				},
+				cli.IntFlag{
+					Name:  "mac-version",
+					Usage: "compute the MAC of the file again with the version, 1 to only authenticate values or 2 to also authenticate the metadata",
+				},
 				cli.StringFlag{
 					Name:  "add-gcp-kms",
 					Usage: "add the provided comma-separated list of GCP KMS key resource IDs to the list of master keys on the given file",
@@ -1073,6 +1164,14 @@ func main() {
 					Name:  "rm-age",
 					Usage: "remove the provided comma-separated list of age recipients from the list of master keys on the given file",
 				},
+				cli.StringFlag{
+					Name:  "add-pkcs11",
+					Usage: "add the provided comma-separated list of PKCS#11 key URIs to the list of master keys on the given file",
+				},
+				cli.StringFlag{
+					Name:  "rm-pkcs11",
+					Usage: "remove the provided comma-separated list of PKCS#11 key URIs from the list of master keys on the given file",
+				},
 				cli.StringFlag{
 					Name:  "add-pgp",
 					Usage: "add the provided comma-separated list of PGP fingerprints to the list of master keys on the given file",
@@ -1107,8 +1206,8 @@ func main() {
 					return toExitError(err)
 				}
 				if _, err := os.Stat(fileName); os.IsNotExist(err) {
-					if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" ||
-						c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" {
+					if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" || c.String("add-pkcs11") != "" ||
+						c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" || c.String("rm-pkcs11") != "" {
 						return common.NewExitError(fmt.Sprintf("Error: cannot add or remove keys on non-existent file %q, use the `edit` subcommand instead.", fileName), codes.CannotChangeKeysFromNonExistentFile)
 					}
 				}
@@ -1136,6 +1235,11 @@ func main() {
 				if err != nil {
 					return toExitError(err)
 				}
+				if isStream(inputStore) {
+					return writeStream(c, fileName, func(w io.Writer) error {
+						return rotateStream(rotateOpts, w)
+					})
+				}
 				output, err := rotate(rotateOpts)
 				if err != nil {
 					return toExitError(err)
@@ -1209,13 +1313,18 @@ func main() {
 					Usage:  "comma separated list of age recipients",
 					EnvVar: "SOPS_AGE_RECIPIENTS",
 				},
+				cli.StringFlag{
+					Name:   "pkcs11",
+					Usage:  "comma separated list of PKCS#11 key URIs (e.g. 'pkcs11:token=sops;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so')",
+					EnvVar: "SOPS_PKCS11_URIS",
+				},
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 				cli.StringFlag{
 					Name:  "output-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 				},
 				cli.StringFlag{
 					Name:  "unencrypted-suffix",
@@ -1233,6 +1342,14 @@ func main() {
 					Name:  "encrypted-regex",
 					Usage: "set the encrypted key regex. When specified, only keys matching the regex will be encrypted.",
 				},
+				cli.StringSliceFlag{
+					Name:  "encrypted-path",
+					Usage: "the path of values to encrypt, such as 'spec.**.env[*].value'. When specified, only values at these paths will be encrypted. Can be specified more than once",
+				},
+				cli.StringSliceFlag{
+					Name:  "unencrypted-path",
+					Usage: "the path of values to leave unencrypted, even if they are at an encrypted path. Can be specified more than once",
+				},
 				cli.StringFlag{
 					Name:  "encryption-context",
 					Usage: "comma separated list of KMS encryption context key:value pairs",
@@ -1289,7 +1406,7 @@ func main() {
 					OutputStore:     outputStore,
 					InputStore:      inputStore,
 					InputPath:       fileName,
-					Cipher:          aes.NewCipher(),
+					Cipher:          ciphers.NewCipher(),
 					KeyServices:     svcs,
 					DecryptionOrder: order,
 					IgnoreMAC:       c.Bool("ignore-mac"),
@@ -1337,11 +1454,11 @@ func main() {
 			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 				cli.StringFlag{
 					Name:  "output-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 				},
 				cli.IntFlag{
 					Name:  "shamir-secret-sharing-threshold",
@@ -1401,7 +1518,7 @@ func main() {
 					OutputStore:     outputStore,
 					InputStore:      inputStore,
 					InputPath:       fileName,
-					Cipher:          aes.NewCipher(),
+					Cipher:          ciphers.NewCipher(),
 					KeyServices:     svcs,
 					DecryptionOrder: order,
 					IgnoreMAC:       c.Bool("ignore-mac"),
@@ -1439,11 +1556,11 @@ func main() {
 			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "input-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 				},
 				cli.StringFlag{
 					Name:  "output-type",
-					Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+					Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 				},
 				cli.IntFlag{
 					Name:  "shamir-secret-sharing-threshold",
@@ -1498,7 +1615,7 @@ func main() {
 					OutputStore:     outputStore,
 					InputStore:      inputStore,
 					InputPath:       fileName,
-					Cipher:          aes.NewCipher(),
+					Cipher:          ciphers.NewCipher(),
 					KeyServices:     svcs,
 					DecryptionOrder: order,
 					IgnoreMAC:       c.Bool("ignore-mac"),
@@ -1579,6 +1696,11 @@ func main() {
 			Usage:  "comma separated list of age recipients",
 			EnvVar: "SOPS_AGE_RECIPIENTS",
 		},
+		cli.StringFlag{
+			Name:   "pkcs11",
+			Usage:  "comma separated list of PKCS#11 key URIs (e.g. 'pkcs11:token=sops;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so')",
+			EnvVar: "SOPS_PKCS11_URIS",
+		},
 		cli.BoolFlag{
 			Name:  "in-place, i",
 			Usage: "write output back to the same file instead of stdout",
@@ -1589,11 +1711,11 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:  "input-type",
-			Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the file's extension to determine the type",
+			Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the file's extension to determine the type",
 		},
 		cli.StringFlag{
 			Name:  "output-type",
-			Usage: "currently json, yaml, dotenv and binary are supported. If not set, sops will use the input file's extension to determine the output format",
+			Usage: "currently hcl, json, jsonc, yaml, kubernetes, toml, dotenv, properties, binary and binary_stream are supported. If not set, sops will use the input file's extension to determine the output format",
 		},
 		cli.BoolFlag{
 			Name:  "show-master-keys, s",
@@ -1639,6 +1761,14 @@ func main() {
 			Name:  "rm-age",
 			Usage: "remove the provided comma-separated list of age recipients from the list of master keys on the given file",
 		},
+		cli.StringFlag{
+			Name:  "add-pkcs11",
+			Usage: "add the provided comma-separated list of PKCS#11 key URIs to the list of master keys on the given file",
+		},
+		cli.StringFlag{
+			Name:  "rm-pkcs11",
+			Usage: "remove the provided comma-separated list of PKCS#11 key URIs from the list of master keys on the given file",
+		},
 		cli.StringFlag{
 			Name:  "add-pgp",
 			Usage: "add the provided comma-separated list of PGP fingerprints to the list of master keys on the given file",
@@ -1655,6 +1785,14 @@ func main() {
 			Name:  "mac-only-encrypted",
 			Usage: "compute MAC only over values which end up encrypted",
 		},
+		cli.StringFlag{
+			Name:  "cipher",
+			Usage: "the cipher to encrypt values with, aes256_gcm (the default) or xchacha20_poly1305. With --rotate, encrypt the values of the file again with the cipher",
+		},
+		cli.IntFlag{
+			Name:  "mac-version",
+			Usage: "the version of the MAC, 1 (the default) to only authenticate values or 2 to also authenticate the metadata. With --rotate, compute the MAC of the file again with the version",
+		},
 		cli.StringFlag{
 			Name:  "unencrypted-suffix",
 			Usage: "override the unencrypted key suffix.",
@@ -1671,6 +1809,14 @@ func main() {
 			Name:  "encrypted-regex",
 			Usage: "set the encrypted key regex. When specified, only keys matching the regex will be encrypted.",
 		},
+		cli.StringSliceFlag{
+			Name:  "encrypted-path",
+			Usage: "the path of values to encrypt, such as 'spec.**.env[*].value'. When specified, only values at these paths will be encrypted. Can be specified more than once",
+		},
+		cli.StringSliceFlag{
+			Name:  "unencrypted-path",
+			Usage: "the path of values to leave unencrypted, even if they are at an encrypted path. Can be specified more than once",
+		},
 		cli.StringFlag{
 			Name:  "unencrypted-comment-regex",
 			Usage: "set the unencrypted comment suffix. When specified, only keys that have comment matching the regex will be left unencrypted.",
@@ -1740,8 +1886,8 @@ func main() {
 			return toExitError(err)
 		}
 		if _, err := os.Stat(fileName); os.IsNotExist(err) {
-			if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" ||
-				c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" {
+			if c.String("add-kms") != "" || c.String("add-pgp") != "" || c.String("add-gcp-kms") != "" || c.String("add-hc-vault-transit") != "" || c.String("add-azure-kv") != "" || c.String("add-age") != "" || c.String("add-pkcs11") != "" ||
+				c.String("rm-kms") != "" || c.String("rm-pgp") != "" || c.String("rm-gcp-kms") != "" || c.String("rm-hc-vault-transit") != "" || c.String("rm-azure-kv") != "" || c.String("rm-age") != "" || c.String("rm-pkcs11") != "" {
 				return common.NewExitError(fmt.Sprintf("Error: cannot add or remove keys on non-existent file %q, use `--kms` and `--pgp` instead.", fileName), codes.CannotChangeKeysFromNonExistentFile)
 			}
 			if isEncryptMode || isDecryptMode || isRotateMode {
@@ -1788,6 +1934,9 @@ func main() {
 		if err != nil {
 			return toExitError(err)
 		}
+		if isStream(inputStore) || isStream(outputStore) {
+			return common.NewExitError("Error: binary_stream files can only be used with the encrypt, decrypt, rotate and updatekeys subcommands", codes.ErrorGeneric)
+		}
 		svcs := keyservices(c)
 
 		order, err := decryptionOrder(c.String("decryption-order"))
@@ -1804,7 +1953,7 @@ func main() {
 				OutputStore:   outputStore,
 				InputStore:    inputStore,
 				InputPath:     fileName,
-				Cipher:        aes.NewCipher(),
+				Cipher:        ciphers.NewCipher(),
 				KeyServices:   svcs,
 				encryptConfig: encConfig,
 			})
@@ -1826,7 +1975,7 @@ func main() {
 				OutputStore:     outputStore,
 				InputStore:      inputStore,
 				InputPath:       fileName,
-				Cipher:          aes.NewCipher(),
+				Cipher:          ciphers.NewCipher(),
 				Extract:         extract,
 				KeyServices:     svcs,
 				DecryptionOrder: order,
@@ -1858,7 +2007,7 @@ func main() {
 				OutputStore:     outputStore,
 				InputStore:      inputStore,
 				InputPath:       fileName,
-				Cipher:          aes.NewCipher(),
+				Cipher:          ciphers.NewCipher(),
 				KeyServices:     svcs,
 				DecryptionOrder: order,
 				IgnoreMAC:       c.Bool("ignore-mac"),
@@ -1874,7 +2023,7 @@ func main() {
 				OutputStore:     outputStore,
 				InputStore:      inputStore,
 				InputPath:       fileName,
-				Cipher:          aes.NewCipher(),
+				Cipher:          ciphers.NewCipher(),
 				KeyServices:     svcs,
 				DecryptionOrder: order,
 				IgnoreMAC:       c.Bool("ignore-mac"),
@@ -1946,11 +2095,18 @@ func getEncryptConfig(c *cli.Context, fileName string) (encryptConfig, error) {
 	encryptedCommentRegex := c.String("encrypted-comment-regex")
 	unencryptedCommentRegex := c.String("unencrypted-comment-regex")
 	macOnlyEncrypted := c.Bool("mac-only-encrypted")
+	cipher := c.String("cipher")
+	encryptedPaths := c.StringSlice("encrypted-path")
+	unencryptedPaths := c.StringSlice("unencrypted-path")
+	macVersion := c.Int("mac-version")
+	var zones []sops.Zone
 	conf, err := loadConfig(c, fileName, nil)
 	if err != nil {
 		return encryptConfig{}, toExitError(err)
 	}
 	if conf != nil {
+		// zones can only be configured in the config file
+		zones = conf.Zones
 		// command line options have precedence
 		if unencryptedSuffix == "" {
 			unencryptedSuffix = conf.UnencryptedSuffix
@@ -1970,9 +2126,33 @@ func getEncryptConfig(c *cli.Context, fileName string) (encryptConfig, error) {
 		if unencryptedCommentRegex == "" {
 			unencryptedCommentRegex = conf.UnencryptedCommentRegex
 		}
+		if len(encryptedPaths) == 0 && len(unencryptedPaths) == 0 {
+			encryptedPaths = conf.EncryptedPaths
+			unencryptedPaths = conf.UnencryptedPaths
+		}
 		if !macOnlyEncrypted {
 			macOnlyEncrypted = conf.MACOnlyEncrypted
 		}
+		if cipher == "" {
+			cipher = conf.Cipher
+		}
+		if macVersion == 0 {
+			macVersion = conf.MACVersion
+		}
+	}
+	if err := ciphers.Check(cipher); err != nil {
+		return encryptConfig{}, common.NewExitError(fmt.Sprintf("Error: %s", err), codes.ErrorConflictingParameters)
+	}
+	if cipher == sops.CipherAES256GCM {
+		// Files encrypted with the default cipher do not record it.
+		cipher = ""
+	}
+	if err := checkMACVersion(macVersion); err != nil {
+		return encryptConfig{}, err
+	}
+	if macVersion == sops.MACVersionValues {
+		// Files with the default MAC version do not record it.
+		macVersion = 0
 	}
 
 	cryptRuleCount := 0
@@ -1994,14 +2174,27 @@ func getEncryptConfig(c *cli.Context, fileName string) (encryptConfig, error) {
 	if unencryptedCommentRegex != "" {
 		cryptRuleCount++
 	}
+	if len(encryptedPaths) > 0 || len(unencryptedPaths) > 0 {
+		cryptRuleCount++
+	}
 
 	if cryptRuleCount > 1 {
-		return encryptConfig{}, common.NewExitError("Error: cannot use more than one of encrypted_suffix, unencrypted_suffix, encrypted_regex, unencrypted_regex, encrypted_comment_regex, or unencrypted_comment_regex in the same file", codes.ErrorConflictingParameters)
+		return encryptConfig{}, common.NewExitError("Error: cannot use more than one of encrypted_suffix, unencrypted_suffix, encrypted_regex, unencrypted_regex, encrypted_comment_regex, unencrypted_comment_regex, or encrypted_paths and unencrypted_paths in the same file", codes.ErrorConflictingParameters)
+	}
+	for _, paths := range [][]string{encryptedPaths, unencryptedPaths} {
+		if err := sops.CheckPathGlobs(paths); err != nil {
+			return encryptConfig{}, common.NewExitError(fmt.Sprintf("Error: %s", err), codes.ErrorConflictingParameters)
+		}
 	}
 
 	// only supply the default UnencryptedSuffix when EncryptedSuffix, EncryptedRegex, and others are not provided
 	if cryptRuleCount == 0 {
-		unencryptedSuffix = sops.DefaultUnencryptedSuffix
+		// Kubernetes manifests only have their data encrypted by default
+		if formats.FormatForPathOrString(fileName, c.String("input-type")) == formats.Kubernetes {
+			encryptedRegex = kubernetes.DefaultEncryptedRegex
+		} else {
+			unencryptedSuffix = sops.DefaultUnencryptedSuffix
+		}
 	}
 
 	var groups []sops.KeyGroup
@@ -2023,13 +2216,27 @@ func getEncryptConfig(c *cli.Context, fileName string) (encryptConfig, error) {
 		EncryptedRegex:          encryptedRegex,
 		UnencryptedCommentRegex: unencryptedCommentRegex,
 		EncryptedCommentRegex:   encryptedCommentRegex,
+		EncryptedPaths:          encryptedPaths,
+		UnencryptedPaths:        unencryptedPaths,
 		MACOnlyEncrypted:        macOnlyEncrypted,
+		Cipher:                  cipher,
+		MACVersion:              macVersion,
 		KeyGroups:               groups,
 		GroupThreshold:          threshold,
+		Zones:                   zones,
 	}, nil
 }
 
-func getMasterKeys(c *cli.Context, kmsEncryptionContext map[string]*string, kmsOptionName string, pgpOptionName string, gcpKmsOptionName string, azureKvOptionName string, hcVaultTransitOptionName string, ageOptionName string) ([]keys.MasterKey, error) {
+// checkMACVersion returns an error if version is neither zero, which stands
+// for the default, nor a supported MAC version.
+func checkMACVersion(version int) error {
+	if version != 0 && version != sops.MACVersionValues && version != sops.MACVersionMetadata {
+		return common.NewExitError(fmt.Sprintf("Error: unknown MAC version %d, supported versions are %d and %d", version, sops.MACVersionValues, sops.MACVersionMetadata), codes.ErrorConflictingParameters)
+	}
+	return nil
+}
+
+func getMasterKeys(c *cli.Context, kmsEncryptionContext map[string]*string, kmsOptionName string, pgpOptionName string, gcpKmsOptionName string, azureKvOptionName string, hcVaultTransitOptionName string, ageOptionName string, pkcs11OptionName string) ([]keys.MasterKey, error) {
 	var masterKeys []keys.MasterKey
 	for _, k := range kms.MasterKeysFromArnString(c.String(kmsOptionName), kmsEncryptionContext, c.String("aws-profile")) {
 		masterKeys = append(masterKeys, k)
@@ -2061,24 +2268,43 @@ func getMasterKeys(c *cli.Context, kmsEncryptionContext map[string]*string, kmsO
 	for _, k := range ageKeys {
 		masterKeys = append(masterKeys, k)
 	}
+	pkcs11Keys, err := pkcs11.NewMasterKeysFromURIs(c.String(pkcs11OptionName))
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range pkcs11Keys {
+		masterKeys = append(masterKeys, k)
+	}
 	return masterKeys, nil
 }
 
 func getRotateOpts(c *cli.Context, fileName string, inputStore common.Store, outputStore common.Store, svcs []keyservice.KeyServiceClient, decryptionOrder []string) (rotateOpts, error) {
 	kmsEncryptionContext := kms.ParseKMSContext(c.String("encryption-context"))
-	addMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "add-kms", "add-pgp", "add-gcp-kms", "add-azure-kv", "add-hc-vault-transit", "add-age")
+	addMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "add-kms", "add-pgp", "add-gcp-kms", "add-azure-kv", "add-hc-vault-transit", "add-age", "add-pkcs11")
 	if err != nil {
 		return rotateOpts{}, err
 	}
-	rmMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "rm-kms", "rm-pgp", "rm-gcp-kms", "rm-azure-kv", "rm-hc-vault-transit", "rm-age")
+	rmMasterKeys, err := getMasterKeys(c, kmsEncryptionContext, "rm-kms", "rm-pgp", "rm-gcp-kms", "rm-azure-kv", "rm-hc-vault-transit", "rm-age", "rm-pkcs11")
 	if err != nil {
 		return rotateOpts{}, err
 	}
+	cipher := c.String("cipher")
+	if cipher != "" {
+		if err := ciphers.Check(cipher); err != nil {
+			return rotateOpts{}, common.NewExitError(fmt.Sprintf("Error: %s", err), codes.ErrorConflictingParameters)
+		}
+	}
+	macVersion := c.Int("mac-version")
+	if err := checkMACVersion(macVersion); err != nil {
+		return rotateOpts{}, err
+	}
 	return rotateOpts{
+		ValueCipher:      cipher,
+		MACVersion:       macVersion,
 		OutputStore:      outputStore,
 		InputStore:       inputStore,
 		InputPath:        fileName,
-		Cipher:           aes.NewCipher(),
+		Cipher:           ciphers.NewCipher(),
 		KeyServices:      svcs,
 		DecryptionOrder:  decryptionOrder,
 		IgnoreMAC:        c.Bool("ignore-mac"),
@@ -2100,7 +2326,10 @@ func toExitError(err error) error {
 
 func keyservices(c *cli.Context) (svcs []keyservice.KeyServiceClient) {
 	if c.Bool("enable-local-keyservice") {
-		svcs = append(svcs, keyservice.NewLocalClient())
+		svcs = append(svcs, keyservice.NewCustomLocalClient(keyservice.Server{
+			AllowExecPlugins:       c.Bool("allow-exec-plugins"),
+			AllowPkcs11ModulePaths: c.Bool("allow-pkcs11-module-paths"),
+		}))
 	}
 	uris := c.StringSlice("keyservice")
 	for _, uri := range uris {
@@ -2165,10 +2394,21 @@ func outputStore(context *cli.Context, path string) (common.Store, error) {
 		indent := context.Int("indent")
 		storesConf.YAML.Indent = indent
 		storesConf.JSON.Indent = indent
+		storesConf.JSONC.Indent = indent
 		storesConf.JSONBinary.Indent = indent
 	}
 
-	return common.DefaultStoreForPathOrFormat(storesConf, path, context.String("output-type")), nil
+	outputType := context.String("output-type")
+	// Kubernetes manifests are written as Kubernetes manifests by default, so
+	// that the data of Secrets is encoded again when decrypting them, and
+	// binary_stream files are only written as binary_stream files
+	if outputType == "" {
+		switch formats.FormatFromString(context.String("input-type")) {
+		case formats.Kubernetes, formats.BinaryStream:
+			outputType = context.String("input-type")
+		}
+	}
+	return common.DefaultStoreForPathOrFormat(storesConf, path, outputType), nil
 }
 
 func parseTreePath(arg string) ([]interface{}, error) {
@@ -2205,6 +2445,7 @@ func keyGroups(c *cli.Context, file string) ([]sops.KeyGroup, error) {
 	var azkvKeys []keys.MasterKey
 	var hcVaultMkKeys []keys.MasterKey
 	var ageMasterKeys []keys.MasterKey
+	var pkcs11MasterKeys []keys.MasterKey
 	kmsEncryptionContext := kms.ParseKMSContext(c.String("encryption-context"))
 	if c.String("encryption-context") != "" && kmsEncryptionContext == nil {
 		return nil, common.NewExitError("Invalid KMS encryption context format", codes.ErrorInvalidKMSEncryptionContextFormat)
@@ -2251,7 +2492,16 @@ func keyGroups(c *cli.Context, file string) ([]sops.KeyGroup, error) {
 			ageMasterKeys = append(ageMasterKeys, k)
 		}
 	}
-	if c.String("kms") == "" && c.String("pgp") == "" && c.String("gcp-kms") == "" && c.String("azure-kv") == "" && c.String("hc-vault-transit") == "" && c.String("age") == "" {
+	if c.String("pkcs11") != "" {
+		pkcs11Keys, err := pkcs11.NewMasterKeysFromURIs(c.String("pkcs11"))
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range pkcs11Keys {
+			pkcs11MasterKeys = append(pkcs11MasterKeys, k)
+		}
+	}
+	if c.String("kms") == "" && c.String("pgp") == "" && c.String("gcp-kms") == "" && c.String("azure-kv") == "" && c.String("hc-vault-transit") == "" && c.String("age") == "" && c.String("pkcs11") == "" {
 		conf, err := loadConfig(c, file, kmsEncryptionContext)
 		// config file might just not be supplied, without any error
 		if conf == nil {
@@ -2270,6 +2520,7 @@ func keyGroups(c *cli.Context, file string) ([]sops.KeyGroup, error) {
 	group = append(group, pgpKeys...)
 	group = append(group, hcVaultMkKeys...)
 	group = append(group, ageMasterKeys...)
+	group = append(group, pkcs11MasterKeys...)
 	log.Debugf("Master keys available:  %+v", group)
 	return []sops.KeyGroup{group}, nil
 }
@@ -2314,6 +2565,12 @@ func jsonValueToTreeInsertableValue(jsonValue string) (interface{}, error) {
 	if err != nil {
 		return nil, common.NewExitError("Value for --set is not valid JSON", codes.ErrorInvalidSetFormat)
 	}
+	if _, ok := valueToInsert.(float64); ok {
+		// Keep numbers which a float64 cannot hold exactly as they are written.
+		if n, err := sops.ParseFloat(strings.TrimSpace(jsonValue)); err == nil {
+			valueToInsert = n
+		}
+	}
 	// Check if decoding it as json we find a single value
 	// and not a map or slice, in which case we can't marshal
 	// it to a sops.TreeBranch
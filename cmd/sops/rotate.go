@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/audit"
@@ -12,7 +13,13 @@ import (
 )
 
 type rotateOpts struct {
-	Cipher           sops.Cipher
+	Cipher sops.Cipher
+	// ValueCipher is the name of the cipher to encrypt the values of the
+	// file with, if it is to be changed.
+	ValueCipher string
+	// MACVersion is the version of the MAC of the file, if it is to be
+	// changed.
+	MACVersion       int
 	InputStore       sops.Store
 	OutputStore      sops.Store
 	InputPath        string
@@ -24,6 +31,21 @@ type rotateOpts struct {
 }
 
 func rotate(opts rotateOpts) ([]byte, error) {
+	tree, err := rotateTree(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedFile, err := opts.OutputStore.EmitEncryptedFile(*tree)
+	if err != nil {
+		return nil, common.NewExitError(fmt.Sprintf("Could not marshal tree: %s", err), codes.ErrorDumpingTree)
+	}
+	return encryptedFile, nil
+}
+
+// rotateTree loads and decrypts the file, updates its master keys, and
+// encrypts it again with a new data key.
+func rotateTree(opts rotateOpts) (*sops.Tree, error) {
 	tree, err := common.LoadEncryptedFileWithBugFixes(common.GenericDecryptOpts{
 		Cipher:          opts.Cipher,
 		InputStore:      opts.InputStore,
@@ -66,12 +88,67 @@ func rotate(opts rotateOpts) ([]byte, error) {
 		}
 	}
 
+	// Encrypt with the latest version of master keys with a newer version,
+	// including those of zones whose data key is rotated
+	var groups []sops.KeyGroup
+	groups = append(groups, tree.Metadata.KeyGroups...)
+	for _, zone := range tree.Metadata.Zones {
+		if zone.DataKey != nil {
+			groups = append(groups, zone.KeyGroups...)
+		}
+	}
+	for _, group := range groups {
+		for _, key := range group {
+			if r, ok := key.(keys.VersionResolver); ok && key.NeedsRotation() {
+				if err := r.ResolveVersion(true); err != nil {
+					log.WithField("key", key.ToString()).Warnf("Could not resolve latest key version: %s", err)
+					continue
+				}
+				log.WithField("key", key.ToString()).Info("Encrypting with the latest key version")
+			}
+		}
+	}
+
+	if opts.MACVersion == sops.MACVersionValues {
+		tree.Metadata.MACVersion = 0
+	} else if opts.MACVersion != 0 {
+		tree.Metadata.MACVersion = opts.MACVersion
+	}
+	if opts.ValueCipher == sops.CipherAES256GCM {
+		tree.Metadata.Cipher = ""
+	} else if opts.ValueCipher != "" {
+		tree.Metadata.Cipher = opts.ValueCipher
+	}
+
 	// Create a new data key
 	dataKey, errs := tree.GenerateDataKeyWithKeyServices(opts.KeyServices)
 	if len(errs) > 0 {
 		err = fmt.Errorf("Could not generate data key: %s", errs)
 		return nil, err
 	}
+	// Zones which could not be unlocked keep their data key, as their
+	// values could not be decrypted
+	for i := range tree.Metadata.Zones {
+		zone := &tree.Metadata.Zones[i]
+		if zone.DataKey == nil {
+			log.WithField("paths", strings.Join(zone.Paths, ", ")).Warn("Could not rotate the data key of zone")
+			// Its master keys which support it are still rewrapped with
+			// the latest version of their key
+			rewraps, errs := common.MasterKeysToRewrap(zone.KeyGroups)
+			for _, err := range errs {
+				log.Warn(err)
+			}
+			for _, k := range rewraps {
+				if err := k.(keys.Rewrapper).Rewrap(); err != nil {
+					return nil, fmt.Errorf("Could not rewrap master key %s of zone %s: %s", k.ToString(), strings.Join(zone.Paths, ", "), err)
+				}
+			}
+			continue
+		}
+		if errs := zone.GenerateDataKeyWithKeyServices(opts.KeyServices); len(errs) > 0 {
+			return nil, fmt.Errorf("Could not generate data key of zone %s: %s", strings.Join(zone.Paths, ", "), errs)
+		}
+	}
 
 	// Reencrypt the file with the new key
 	err = common.EncryptTree(common.EncryptTreeOpts{
@@ -80,10 +157,5 @@ func rotate(opts rotateOpts) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	encryptedFile, err := opts.OutputStore.EmitEncryptedFile(*tree)
-	if err != nil {
-		return nil, common.NewExitError(fmt.Sprintf("Could not marshal tree: %s", err), codes.ErrorDumpingTree)
-	}
-	return encryptedFile, nil
+	return tree, nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/cmd/sops/codes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/stores/stream"
+	"github.com/urfave/cli"
+)
+
+// isStream returns whether the store is the one of the binary_stream format,
+// whose files are encrypted and decrypted as streams instead of trees.
+func isStream(store sops.Store) bool {
+	_, ok := store.(*stream.Store)
+	return ok
+}
+
+// encryptStream encrypts the input file as a binary_stream file, holding at
+// most one chunk of it in memory.
+func encryptStream(opts encryptOpts, w io.Writer) error {
+	store := opts.OutputStore.(*stream.Store)
+	file, err := os.Open(opts.InputPath)
+	if err != nil {
+		return common.NewExitError(fmt.Sprintf("Error reading file: %s", err), codes.CouldNotReadInputFile)
+	}
+	defer file.Close()
+	path, err := filepath.Abs(opts.InputPath)
+	if err != nil {
+		return err
+	}
+	key, err := stream.NewKey()
+	if err != nil {
+		return err
+	}
+	tree := sops.Tree{
+		Branches: stream.NewBranches(key),
+		Metadata: metadataFromEncryptionConfig(opts.encryptConfig),
+		FilePath: path,
+	}
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices(opts.KeyServices)
+	if len(errs) > 0 {
+		return fmt.Errorf("Could not generate data key: %s", errs)
+	}
+	if err := generateZoneDataKeys(&tree, opts.KeyServices); err != nil {
+		return err
+	}
+	err = common.EncryptTree(common.EncryptTreeOpts{
+		DataKey: dataKey,
+		Tree:    &tree,
+		Cipher:  opts.Cipher,
+	})
+	if err != nil {
+		return err
+	}
+	// The encryption rules apply to the header as to any other file, and
+	// must not leave the stream key in plain text.
+	if plainKey, err := stream.Key(tree); err == nil && bytes.Equal(plainKey, key) {
+		return common.NewExitError(fmt.Sprintf("Error encrypting tree: the encryption rules of the file leave the %s unencrypted", stream.KeyName), codes.ErrorEncryptingTree)
+	}
+
+	if err := store.EmitEncryptedHeader(w, tree); err != nil {
+		return common.NewExitError(fmt.Sprintf("Could not marshal tree: %s", err), codes.ErrorDumpingTree)
+	}
+	sw, err := stream.NewWriter(w, key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(sw, file); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// decryptStream decrypts a binary_stream file, holding at most one chunk of
+// it in memory. Each chunk is authenticated before it is written, but the
+// chunks before a corrupted one have already been written when it is found.
+func decryptStream(opts decryptOpts, w io.Writer) error {
+	if len(opts.Extract) > 0 {
+		return common.NewExitError("Error: binary_stream files are not structured and extracting a single value is not possible", codes.ErrorGeneric)
+	}
+	store := opts.InputStore.(*stream.Store)
+	tree, err := decryptTree(opts)
+	if err != nil {
+		return err
+	}
+	key, err := stream.Key(*tree)
+	if err != nil {
+		return common.NewExitError(fmt.Sprintf("Error decrypting tree: %s", err), codes.ErrorDecryptingTree)
+	}
+
+	file, err := os.Open(opts.InputPath)
+	if err != nil {
+		return common.NewExitError(fmt.Sprintf("Error reading file: %s", err), codes.CouldNotReadInputFile)
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	if _, err := store.LoadEncryptedHeader(r); err != nil {
+		return common.NewExitError(fmt.Sprintf("Error reading file: %s", err), codes.CouldNotReadInputFile)
+	}
+	sr, err := stream.NewReader(r, key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, sr); err != nil {
+		return common.NewExitError(fmt.Sprintf("Error decrypting stream: %s", err), codes.ErrorDecryptingTree)
+	}
+	return nil
+}
+
+// rotateStream rotates the data key of a binary_stream file. Only its header
+// changes, the encrypted payload is copied as is.
+func rotateStream(opts rotateOpts, w io.Writer) error {
+	tree, err := rotateTree(opts)
+	if err != nil {
+		return err
+	}
+	return common.EmitEncryptedStream(opts.InputStore.(*stream.Store), *tree, opts.InputPath, w)
+}
+
+// writeStream writes the output of an operation on a binary_stream file to
+// the input file with --in-place, to the file given with --output, or to
+// stdout. Files are only replaced once the whole output has been written.
+func writeStream(c *cli.Context, fileName string, write func(io.Writer) error) error {
+	path := c.String("output")
+	if c.Bool("in-place") {
+		path = fileName
+	}
+	if path != "" {
+		if err := common.ReplaceFile(path, func(w io.Writer) error {
+			bw := bufio.NewWriter(w)
+			if err := write(bw); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}); err != nil {
+			return toExitError(err)
+		}
+		if c.Bool("in-place") {
+			log.Info("File written successfully")
+		}
+		return nil
+	}
+	bw := bufio.NewWriter(os.Stdout)
+	if err := write(bw); err != nil {
+		bw.Flush()
+		return toExitError(err)
+	}
+	return toExitError(bw.Flush())
+}
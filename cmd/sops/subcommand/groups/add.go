@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/ciphers"
 	"github.com/getsops/sops/v3/cmd/sops/common"
 	"github.com/getsops/sops/v3/keyservice"
 )
@@ -30,12 +31,18 @@ func Add(opts AddOpts) error {
 	if err != nil {
 		return err
 	}
-	tree.Metadata.KeyGroups = append(tree.Metadata.KeyGroups, opts.Group)
+	err = common.UpdateMetadata(tree, dataKey, ciphers.NewCipher(), func() error {
+		tree.Metadata.KeyGroups = append(tree.Metadata.KeyGroups, opts.Group)
 
-	if opts.GroupThreshold != 0 {
-		tree.Metadata.ShamirThreshold = opts.GroupThreshold
+		if opts.GroupThreshold != 0 {
+			tree.Metadata.ShamirThreshold = opts.GroupThreshold
+		}
+		tree.Metadata.UpdateMasterKeysWithKeyServices(dataKey, opts.KeyServices)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	tree.Metadata.UpdateMasterKeysWithKeyServices(dataKey, opts.KeyServices)
 	output, err := opts.OutputStore.EmitEncryptedFile(*tree)
 	if err != nil {
 		return err
@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/ciphers"
 	"github.com/getsops/sops/v3/cmd/sops/common"
 	"github.com/getsops/sops/v3/keyservice"
 )
@@ -32,19 +33,25 @@ func Delete(opts DeleteOpts) error {
 	if err != nil {
 		return err
 	}
-	tree.Metadata.KeyGroups = append(tree.Metadata.KeyGroups[:opts.Group], tree.Metadata.KeyGroups[opts.Group+1:]...)
+	err = common.UpdateMetadata(tree, dataKey, ciphers.NewCipher(), func() error {
+		tree.Metadata.KeyGroups = append(tree.Metadata.KeyGroups[:opts.Group], tree.Metadata.KeyGroups[opts.Group+1:]...)
 
-	if opts.GroupThreshold != 0 {
-		tree.Metadata.ShamirThreshold = opts.GroupThreshold
-	}
+		if opts.GroupThreshold != 0 {
+			tree.Metadata.ShamirThreshold = opts.GroupThreshold
+		}
 
-	if len(tree.Metadata.KeyGroups) < tree.Metadata.ShamirThreshold {
-		return fmt.Errorf("removing this key group will make the Shamir threshold impossible to satisfy: "+
-			"Shamir threshold is %d, but we only have %d key groups", tree.Metadata.ShamirThreshold,
-			len(tree.Metadata.KeyGroups))
-	}
+		if len(tree.Metadata.KeyGroups) < tree.Metadata.ShamirThreshold {
+			return fmt.Errorf("removing this key group will make the Shamir threshold impossible to satisfy: "+
+				"Shamir threshold is %d, but we only have %d key groups", tree.Metadata.ShamirThreshold,
+				len(tree.Metadata.KeyGroups))
+		}
 
-	tree.Metadata.UpdateMasterKeysWithKeyServices(dataKey, opts.KeyServices)
+		tree.Metadata.UpdateMasterKeysWithKeyServices(dataKey, opts.KeyServices)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 	output, err := opts.OutputStore.EmitEncryptedFile(*tree)
 	if err != nil {
 		return err
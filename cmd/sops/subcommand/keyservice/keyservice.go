@@ -24,6 +24,11 @@ type Opts struct {
 	Network string
 	Address string
 	Prompt  bool
+	// AllowExecPlugins allows clients to have exec plugin keys run
+	AllowExecPlugins bool
+	// AllowPkcs11ModulePaths allows clients to have the PKCS#11 modules named
+	// by keys loaded
+	AllowPkcs11ModulePaths bool
 }
 
 // Run runs a SOPS key service server
@@ -35,7 +40,9 @@ func Run(opts Opts) error {
 	defer lis.Close()
 	grpcServer := grpc.NewServer()
 	keyservice.RegisterKeyServiceServer(grpcServer, keyservice.Server{
-		Prompt: opts.Prompt,
+		Prompt:                 opts.Prompt,
+		AllowExecPlugins:       opts.AllowExecPlugins,
+		AllowPkcs11ModulePaths: opts.AllowPkcs11ModulePaths,
 	})
 	log.Infof("Listening on %s://%s", opts.Network, opts.Address)
 
@@ -2,14 +2,21 @@ package updatekeys
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/ciphers"
 	"github.com/getsops/sops/v3/cmd/sops/codes"
 	"github.com/getsops/sops/v3/cmd/sops/common"
 	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keys"
 	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/stores/stream"
 )
 
 // Opts represents key operation options and config
@@ -60,12 +67,7 @@ func updateFile(opts Opts) error {
 	}
 
 	diffs := common.DiffKeyGroups(tree.Metadata.KeyGroups, conf.KeyGroups)
-	keysWillChange := false
-	for _, diff := range diffs {
-		if len(diff.Added) > 0 || len(diff.Removed) > 0 {
-			keysWillChange = true
-		}
-	}
+	keysWillChange := diffsChangeKeys(diffs)
 
 	// TODO: use conf.ShamirThreshold instead of tree.Metadata.ShamirThreshold in the next line?
 	//       Or make this configurable?
@@ -76,13 +78,54 @@ func updateFile(opts Opts) error {
 	shamirThreshold = min(shamirThreshold, len(conf.KeyGroups))
 	var shamirThresholdWillChange = tree.Metadata.ShamirThreshold != shamirThreshold
 
-	if !keysWillChange && !shamirThresholdWillChange {
-		log.Printf("File %s already up to date", opts.InputPath)
-		return nil
+	zoneUpdates, err := diffZones(tree.Metadata.Zones, conf.Zones)
+	if err != nil {
+		return fmt.Errorf("cannot update the keys of file %s: %w", opts.InputPath, err)
+	}
+	zonesWillChange := false
+	for _, update := range zoneUpdates {
+		if update.willChange() {
+			zonesWillChange = true
+		}
+	}
+	fileWillChange := keysWillChange || shamirThresholdWillChange
+
+	// When the keys change, the data key is encrypted again with all of
+	// them, so only rewrap master keys if they do not.
+	var rewraps []keys.MasterKey
+	if !fileWillChange && !zonesWillChange {
+		var groups []sops.KeyGroup
+		groups = append(groups, tree.Metadata.KeyGroups...)
+		for _, zone := range tree.Metadata.Zones {
+			groups = append(groups, zone.KeyGroups...)
+		}
+		var errs []error
+		rewraps, errs = common.MasterKeysToRewrap(groups)
+		for _, err := range errs {
+			log.Print(err)
+		}
+		if len(rewraps) == 0 {
+			log.Printf("File %s already up to date", opts.InputPath)
+			return nil
+		}
+		fmt.Printf("The following master keys will be rewrapped with the latest version of their key:\n")
+		for _, k := range rewraps {
+			fmt.Printf("    %s\n", k.ToString())
+		}
+	} else {
+		if fileWillChange {
+			fmt.Printf("The following changes will be made to the file's groups:\n")
+			common.PrettyPrintShamirDiff(tree.Metadata.ShamirThreshold, shamirThreshold)
+			common.PrettyPrintDiffs(diffs)
+		}
+		for i, update := range zoneUpdates {
+			if update.willChange() {
+				fmt.Printf("The following changes will be made to the groups of zone %s:\n", strings.Join(tree.Metadata.Zones[i].Paths, ", "))
+				common.PrettyPrintShamirDiff(tree.Metadata.Zones[i].ShamirThreshold, update.shamirThreshold)
+				common.PrettyPrintDiffs(update.diffs)
+			}
+		}
 	}
-	fmt.Printf("The following changes will be made to the file's groups:\n")
-	common.PrettyPrintShamirDiff(tree.Metadata.ShamirThreshold, shamirThreshold)
-	common.PrettyPrintDiffs(diffs)
 
 	if opts.Interactive {
 		var response string
@@ -98,15 +141,66 @@ func updateFile(opts Opts) error {
 			return nil
 		}
 	}
-	key, err := tree.Metadata.GetDataKeyWithKeyServices(opts.KeyServices, opts.DecryptionOrder)
-	if err != nil {
-		return common.NewExitError(err, codes.CouldNotRetrieveKey)
+	if len(rewraps) > 0 {
+		for _, k := range rewraps {
+			if err := k.(keys.Rewrapper).Rewrap(); err != nil {
+				return fmt.Errorf("error rewrapping master key %s: %s", k.ToString(), err)
+			}
+		}
+	} else {
+		// The data key of the file is also needed to update the MAC when
+		// only zones change.
+		key, err := tree.Metadata.GetDataKeyWithKeyServices(opts.KeyServices, opts.DecryptionOrder)
+		if err != nil {
+			return common.NewExitError(err, codes.CouldNotRetrieveKey)
+		}
+		for i, update := range zoneUpdates {
+			if !update.willChange() {
+				continue
+			}
+			zone := &tree.Metadata.Zones[i]
+			if _, err := zone.GetDataKeyWithKeyServices(opts.KeyServices, opts.DecryptionOrder); err != nil {
+				return common.NewExitError(fmt.Sprintf("Could not retrieve the data key of zone %s: %s", strings.Join(zone.Paths, ", "), err), codes.CouldNotRetrieveKey)
+			}
+		}
+		err = common.UpdateMetadata(tree, key, ciphers.NewCipher(), func() error {
+			if fileWillChange {
+				tree.Metadata.KeyGroups = conf.KeyGroups
+				tree.Metadata.ShamirThreshold = shamirThreshold
+				errs := tree.Metadata.UpdateMasterKeysWithKeyServices(key, opts.KeyServices)
+				if len(errs) > 0 {
+					return fmt.Errorf("error updating one or more master keys: %s", errs)
+				}
+			}
+			for i, update := range zoneUpdates {
+				if !update.willChange() {
+					continue
+				}
+				zone := &tree.Metadata.Zones[i]
+				zone.KeyGroups = conf.Zones[i].KeyGroups
+				zone.ShamirThreshold = update.shamirThreshold
+				errs := zone.UpdateMasterKeysWithKeyServices(zone.DataKey, opts.KeyServices)
+				if len(errs) > 0 {
+					return fmt.Errorf("error updating one or more master keys of zone %s: %s", strings.Join(zone.Paths, ", "), errs)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
-	tree.Metadata.KeyGroups = conf.KeyGroups
-	tree.Metadata.ShamirThreshold = shamirThreshold
-	errs := tree.Metadata.UpdateMasterKeysWithKeyServices(key, opts.KeyServices)
-	if len(errs) > 0 {
-		return fmt.Errorf("error updating one or more master keys: %s", errs)
+	if streamStore, ok := store.(*stream.Store); ok {
+		// Only the header of binary_stream files changes, so the payload is
+		// copied as is, without holding it in memory.
+		err = common.ReplaceFile(opts.InputPath, func(w io.Writer) error {
+			return common.EmitEncryptedStream(streamStore, *tree, opts.InputPath, w)
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("File %s synced with new keys", opts.InputPath)
+		return nil
 	}
 	output, err := store.EmitEncryptedFile(*tree)
 	if err != nil {
@@ -125,6 +219,54 @@ func updateFile(opts Opts) error {
 	return nil
 }
 
+// zoneUpdate holds the changes to the key groups of a zone.
+type zoneUpdate struct {
+	diffs           []common.Diff
+	shamirThreshold int
+	changed         bool
+}
+
+func (u zoneUpdate) willChange() bool {
+	return u.changed || diffsChangeKeys(u.diffs)
+}
+
+// diffZones compares the key groups of the zones of a file with the ones of
+// its creation rule. The zones must select the same paths, as moving values
+// between zones requires encrypting them again.
+func diffZones(ours, theirs []sops.Zone) ([]zoneUpdate, error) {
+	if len(ours) != len(theirs) {
+		return nil, fmt.Errorf("the file has %d zones, but its creation rule %d", len(ours), len(theirs))
+	}
+	var updates []zoneUpdate
+	for i := range ours {
+		if !slices.Equal(ours[i].Paths, theirs[i].Paths) {
+			return nil, fmt.Errorf("zone %d selects paths %s, but the one of the creation rule %s", i+1,
+				strings.Join(ours[i].Paths, ", "), strings.Join(theirs[i].Paths, ", "))
+		}
+		shamirThreshold := ours[i].ShamirThreshold
+		if theirs[i].ShamirThreshold != 0 {
+			shamirThreshold = theirs[i].ShamirThreshold
+		}
+		shamirThreshold = min(shamirThreshold, len(theirs[i].KeyGroups))
+		updates = append(updates, zoneUpdate{
+			diffs:           common.DiffKeyGroups(ours[i].KeyGroups, theirs[i].KeyGroups),
+			shamirThreshold: shamirThreshold,
+			changed:         ours[i].ShamirThreshold != shamirThreshold,
+		})
+	}
+	return updates, nil
+}
+
+// diffsChangeKeys returns whether keys are added or removed by the diffs.
+func diffsChangeKeys(diffs []common.Diff) bool {
+	for _, diff := range diffs {
+		if len(diff.Added) > 0 || len(diff.Removed) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -14,10 +14,13 @@ import (
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/age"
 	"github.com/getsops/sops/v3/azkv"
+	"github.com/getsops/sops/v3/execplugin"
 	"github.com/getsops/sops/v3/gcpkms"
 	"github.com/getsops/sops/v3/hcvault"
 	"github.com/getsops/sops/v3/kms"
+	"github.com/getsops/sops/v3/passphrase"
 	"github.com/getsops/sops/v3/pgp"
+	"github.com/getsops/sops/v3/pkcs11"
 	"github.com/getsops/sops/v3/publish"
 	"gopkg.in/yaml.v3"
 )
@@ -55,14 +58,28 @@ func FindConfigFile(start string) (string, error) {
 	return "", fmt.Errorf("Config file not found")
 }
 
+type BinaryStreamStoreConfig struct{}
+
 type DotenvStoreConfig struct{}
 
-type INIStoreConfig struct{}
+type HCLStoreConfig struct{}
+
+type INIStoreConfig struct {
+	NestedSections bool `yaml:"nested_sections"`
+}
+
+type PropertiesStoreConfig struct{}
+
+type TOMLStoreConfig struct{}
 
 type JSONStoreConfig struct {
 	Indent int `yaml:"indent"`
 }
 
+type JSONCStoreConfig struct {
+	Indent int `yaml:"indent"`
+}
+
 type JSONBinaryStoreConfig struct {
 	Indent int `yaml:"indent"`
 }
@@ -72,11 +89,16 @@ type YAMLStoreConfig struct {
 }
 
 type StoresConfig struct {
-	Dotenv     DotenvStoreConfig     `yaml:"dotenv"`
-	INI        INIStoreConfig        `yaml:"ini"`
-	JSONBinary JSONBinaryStoreConfig `yaml:"json_binary"`
-	JSON       JSONStoreConfig       `yaml:"json"`
-	YAML       YAMLStoreConfig       `yaml:"yaml"`
+	BinaryStream BinaryStreamStoreConfig `yaml:"binary_stream"`
+	Dotenv       DotenvStoreConfig       `yaml:"dotenv"`
+	HCL          HCLStoreConfig          `yaml:"hcl"`
+	INI          INIStoreConfig          `yaml:"ini"`
+	JSONBinary   JSONBinaryStoreConfig   `yaml:"json_binary"`
+	JSON         JSONStoreConfig         `yaml:"json"`
+	JSONC        JSONCStoreConfig        `yaml:"jsonc"`
+	Properties   PropertiesStoreConfig   `yaml:"properties"`
+	TOML         TOMLStoreConfig         `yaml:"toml"`
+	YAML         YAMLStoreConfig         `yaml:"yaml"`
 }
 
 type configFile struct {
@@ -86,13 +108,16 @@ type configFile struct {
 }
 
 type keyGroup struct {
-	Merge   []keyGroup
-	KMS     []kmsKey
-	GCPKMS  []gcpKmsKey  `yaml:"gcp_kms"`
-	AzureKV []azureKVKey `yaml:"azure_keyvault"`
-	Vault   []string     `yaml:"hc_vault"`
-	Age     []string     `yaml:"age"`
-	PGP     []string
+	Merge      []keyGroup
+	KMS        []kmsKey
+	GCPKMS     []gcpKmsKey  `yaml:"gcp_kms"`
+	AzureKV    []azureKVKey `yaml:"azure_keyvault"`
+	Vault      []string     `yaml:"hc_vault"`
+	Age        []string     `yaml:"age"`
+	PGP        []string
+	Passphrase []string        `yaml:"passphrase"`
+	PKCS11     []string        `yaml:"pkcs11"`
+	ExecPlugin []execPluginKey `yaml:"exec_plugin"`
 }
 
 type gcpKmsKey struct {
@@ -100,10 +125,16 @@ type gcpKmsKey struct {
 }
 
 type kmsKey struct {
-	Arn        string             `yaml:"arn"`
-	Role       string             `yaml:"role,omitempty"`
-	Context    map[string]*string `yaml:"context"`
-	AwsProfile string             `yaml:"aws_profile"`
+	Arn                 string             `yaml:"arn"`
+	Role                string             `yaml:"role,omitempty"`
+	Context             map[string]*string `yaml:"context"`
+	AwsProfile          string             `yaml:"aws_profile"`
+	EncryptionAlgorithm string             `yaml:"encryption_algorithm"`
+}
+
+type execPluginKey struct {
+	Name  string `yaml:"name"`
+	KeyID string `yaml:"key_id"`
 }
 
 type azureKVKey struct {
@@ -130,11 +161,13 @@ type creationRule struct {
 	PathRegex               string `yaml:"path_regex"`
 	KMS                     string
 	AwsProfile              string `yaml:"aws_profile"`
+	KMSEncryptionAlgorithm  string `yaml:"kms_encryption_algorithm"`
 	Age                     string `yaml:"age"`
 	PGP                     string
 	GCPKMS                  string     `yaml:"gcp_kms"`
 	AzureKeyVault           string     `yaml:"azure_keyvault"`
 	VaultURI                string     `yaml:"hc_vault_transit_uri"`
+	PKCS11                  string     `yaml:"pkcs11"`
 	KeyGroups               []keyGroup `yaml:"key_groups"`
 	ShamirThreshold         int        `yaml:"shamir_threshold"`
 	UnencryptedSuffix       string     `yaml:"unencrypted_suffix"`
@@ -143,12 +176,24 @@ type creationRule struct {
 	EncryptedRegex          string     `yaml:"encrypted_regex"`
 	UnencryptedCommentRegex string     `yaml:"unencrypted_comment_regex"`
 	EncryptedCommentRegex   string     `yaml:"encrypted_comment_regex"`
+	EncryptedPaths          []string   `yaml:"encrypted_paths"`
+	UnencryptedPaths        []string   `yaml:"unencrypted_paths"`
 	MACOnlyEncrypted        bool       `yaml:"mac_only_encrypted"`
+	Cipher                  string     `yaml:"cipher"`
+	MACVersion              int        `yaml:"mac_version"`
+	Zones                   []zone     `yaml:"zones"`
+}
+
+type zone struct {
+	Paths           []string   `yaml:"paths"`
+	KeyGroups       []keyGroup `yaml:"key_groups"`
+	ShamirThreshold int        `yaml:"shamir_threshold"`
 }
 
 func NewStoresConfig() *StoresConfig {
 	storesConfig := &StoresConfig{}
 	storesConfig.JSON.Indent = -1
+	storesConfig.JSONC.Indent = -1
 	storesConfig.JSONBinary.Indent = -1
 	return storesConfig
 }
@@ -172,7 +217,12 @@ type Config struct {
 	EncryptedRegex          string
 	UnencryptedCommentRegex string
 	EncryptedCommentRegex   string
+	EncryptedPaths          []string
+	UnencryptedPaths        []string
 	MACOnlyEncrypted        bool
+	Cipher                  string
+	MACVersion              int
+	Zones                   []sops.Zone
 	Destination             publish.Destination
 	OmitExtensions          bool
 }
@@ -218,7 +268,9 @@ func extractMasterKeys(group keyGroup) (sops.KeyGroup, error) {
 		keyGroup = append(keyGroup, pgp.NewMasterKeyFromFingerprint(k))
 	}
 	for _, k := range group.KMS {
-		keyGroup = append(keyGroup, kms.NewMasterKeyWithProfile(k.Arn, k.Role, k.Context, k.AwsProfile))
+		key := kms.NewMasterKeyWithProfile(k.Arn, k.Role, k.Context, k.AwsProfile)
+		key.EncryptionAlgorithm = k.EncryptionAlgorithm
+		keyGroup = append(keyGroup, key)
 	}
 	for _, k := range group.GCPKMS {
 		keyGroup = append(keyGroup, gcpkms.NewMasterKeyFromResourceID(k.ResourceID))
@@ -233,6 +285,25 @@ func extractMasterKeys(group keyGroup) (sops.KeyGroup, error) {
 			return nil, err
 		}
 	}
+	for _, k := range group.Passphrase {
+		if k == "" {
+			return nil, fmt.Errorf("passphrase key name can not be empty")
+		}
+		keyGroup = append(keyGroup, passphrase.NewMasterKey(k))
+	}
+	for _, k := range group.PKCS11 {
+		if masterKey, err := pkcs11.NewMasterKeyFromURI(k); err == nil {
+			keyGroup = append(keyGroup, masterKey)
+		} else {
+			return nil, err
+		}
+	}
+	for _, k := range group.ExecPlugin {
+		if err := execplugin.CheckName(k.Name); err != nil {
+			return nil, err
+		}
+		keyGroup = append(keyGroup, execplugin.NewMasterKey(k.Name, k.KeyID))
+	}
 	return deduplicateKeygroup(keyGroup), nil
 }
 
@@ -262,6 +333,7 @@ func getKeyGroupsFromCreationRule(cRule *creationRule, kmsEncryptionContext map[
 			keyGroup = append(keyGroup, k)
 		}
 		for _, k := range kms.MasterKeysFromArnString(cRule.KMS, kmsEncryptionContext, cRule.AwsProfile) {
+			k.EncryptionAlgorithm = cRule.KMSEncryptionAlgorithm
 			keyGroup = append(keyGroup, k)
 		}
 		for _, k := range gcpkms.MasterKeysFromResourceIDString(cRule.GCPKMS) {
@@ -281,6 +353,13 @@ func getKeyGroupsFromCreationRule(cRule *creationRule, kmsEncryptionContext map[
 		for _, k := range vaultKeys {
 			keyGroup = append(keyGroup, k)
 		}
+		pkcs11Keys, err := pkcs11.NewMasterKeysFromURIs(cRule.PKCS11)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range pkcs11Keys {
+			keyGroup = append(keyGroup, k)
+		}
 		groups = append(groups, keyGroup)
 	}
 	return groups, nil
@@ -320,9 +399,17 @@ func configFromRule(rule *creationRule, kmsEncryptionContext map[string]*string)
 	if rule.EncryptedCommentRegex != "" {
 		cryptRuleCount++
 	}
+	if len(rule.EncryptedPaths) > 0 || len(rule.UnencryptedPaths) > 0 {
+		cryptRuleCount++
+	}
 
 	if cryptRuleCount > 1 {
-		return nil, fmt.Errorf("error loading config: cannot use more than one of encrypted_suffix, unencrypted_suffix, encrypted_regex, unencrypted_regex, encrypted_comment_regex, or unencrypted_comment_regex for the same rule")
+		return nil, fmt.Errorf("error loading config: cannot use more than one of encrypted_suffix, unencrypted_suffix, encrypted_regex, unencrypted_regex, encrypted_comment_regex, unencrypted_comment_regex, or encrypted_paths and unencrypted_paths for the same rule")
+	}
+	for _, paths := range [][]string{rule.EncryptedPaths, rule.UnencryptedPaths} {
+		if err := sops.CheckPathGlobs(paths); err != nil {
+			return nil, fmt.Errorf("error loading config: %s", err)
+		}
 	}
 
 	groups, err := getKeyGroupsFromCreationRule(rule, kmsEncryptionContext)
@@ -330,6 +417,31 @@ func configFromRule(rule *creationRule, kmsEncryptionContext map[string]*string)
 		return nil, err
 	}
 
+	var zones []sops.Zone
+	for _, z := range rule.Zones {
+		if len(z.Paths) == 0 {
+			return nil, fmt.Errorf("error loading config: zones must have at least one path")
+		}
+		if len(z.KeyGroups) == 0 {
+			return nil, fmt.Errorf("error loading config: zones must have at least one key group")
+		}
+		if err := sops.CheckPathGlobs(z.Paths); err != nil {
+			return nil, fmt.Errorf("error loading config: %s", err)
+		}
+		zone := sops.Zone{
+			Paths:           z.Paths,
+			ShamirThreshold: z.ShamirThreshold,
+		}
+		for _, group := range z.KeyGroups {
+			keyGroup, err := extractMasterKeys(group)
+			if err != nil {
+				return nil, err
+			}
+			zone.KeyGroups = append(zone.KeyGroups, keyGroup)
+		}
+		zones = append(zones, zone)
+	}
+
 	return &Config{
 		KeyGroups:               groups,
 		ShamirThreshold:         rule.ShamirThreshold,
@@ -339,7 +451,12 @@ func configFromRule(rule *creationRule, kmsEncryptionContext map[string]*string)
 		EncryptedRegex:          rule.EncryptedRegex,
 		UnencryptedCommentRegex: rule.UnencryptedCommentRegex,
 		EncryptedCommentRegex:   rule.EncryptedCommentRegex,
+		EncryptedPaths:          rule.EncryptedPaths,
+		UnencryptedPaths:        rule.UnencryptedPaths,
 		MACOnlyEncrypted:        rule.MACOnlyEncrypted,
+		Cipher:                  rule.Cipher,
+		MACVersion:              rule.MACVersion,
+		Zones:                   zones,
 	}, nil
 }
 
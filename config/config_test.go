@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/getsops/sops/v3/keys"
+	"github.com/getsops/sops/v3/kms"
+	"github.com/getsops/sops/v3/pkcs11"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -86,6 +88,74 @@ creation_rules:
     hc_vault_uris: http://4:8200/v1/4/keys/4
 `)
 
+var sampleConfigWithPassphraseGroups = []byte(`
+creation_rules:
+  - path_regex: ""
+    key_groups:
+    - age:
+      - age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw
+    - passphrase:
+      - break-glass
+`)
+
+var sampleConfigWithEmptyPassphrase = []byte(`
+creation_rules:
+  - path_regex: ""
+    key_groups:
+    - passphrase:
+      - ""
+`)
+
+var sampleConfigWithPKCS11Groups = []byte(`
+creation_rules:
+  - path_regex: rule
+    pkcs11: pkcs11:slot-id=1;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so
+  - path_regex: ""
+    key_groups:
+    - pkcs11:
+      - pkcs11:token=sops;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so
+`)
+
+var sampleConfigWithInvalidPKCS11 = []byte(`
+creation_rules:
+  - path_regex: ""
+    key_groups:
+    - pkcs11:
+      - pkcs11:token=sops
+`)
+
+var sampleConfigWithAsymmetricKMS = []byte(`
+creation_rules:
+  - path_regex: rule
+    kms: arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab
+    kms_encryption_algorithm: RSAES_OAEP_SHA_1
+  - path_regex: ""
+    key_groups:
+    - kms:
+      - arn: arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab
+        encryption_algorithm: RSAES_OAEP_SHA_256
+      - arn: arn:aws:kms:us-east-1:111122223333:key/0987dcba-09fe-87dc-65ba-ab0987654321
+`)
+
+var sampleConfigWithExecPluginGroups = []byte(`
+creation_rules:
+  - path_regex: ""
+    key_groups:
+    - exec_plugin:
+      - name: my-custodian
+        key_id: my-key
+      - name: other-custodian
+`)
+
+var sampleConfigWithInvalidExecPluginName = []byte(`
+creation_rules:
+  - path_regex: ""
+    key_groups:
+    - exec_plugin:
+      - name: my-custodian --region eu
+        key_id: my-key
+`)
+
 var sampleConfigWithGroups = []byte(`
 creation_rules:
   - path_regex: foobar*
@@ -312,6 +382,83 @@ creation_rules:
     mac_only_encrypted: true
     `)
 
+var sampleConfigWithCipher = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    kms: "1"
+    pgp: "2"
+    cipher: xchacha20_poly1305
+    `)
+
+var sampleConfigWithMACVersion = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    kms: "1"
+    pgp: "2"
+    mac_version: 2
+    `)
+
+var sampleConfigWithEncryptedPaths = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    kms: "1"
+    pgp: "2"
+    encrypted_paths: ["spec.template.**.env[*]"]
+    unencrypted_paths: ["spec.template.**.env[*].name"]
+    `)
+
+var sampleConfigWithEncryptedPathsAndRegex = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    kms: "1"
+    pgp: "2"
+    encrypted_paths: ["data"]
+    encrypted_regex: "^data$"
+    `)
+
+var sampleConfigWithInvalidEncryptedPaths = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    kms: "1"
+    pgp: "2"
+    encrypted_paths: ["env[x]"]
+    `)
+
+var sampleConfigWithZones = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    pgp: "1"
+    zones:
+      - paths: ["db", "payments.*"]
+        key_groups:
+          - pgp:
+            - "2"
+          - pgp:
+            - "3"
+        shamir_threshold: 1
+    `)
+
+var sampleConfigWithZoneWithInvalidPath = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    pgp: "1"
+    zones:
+      - paths: ["db[x]"]
+        key_groups:
+          - pgp:
+            - "2"
+    `)
+
+var sampleConfigWithZoneWithoutPaths = []byte(`
+creation_rules:
+  - path_regex: barbar*
+    pgp: "1"
+    zones:
+      - key_groups:
+          - pgp:
+            - "2"
+    `)
+
 var sampleConfigWithEncryptedCommentRegexParameters = []byte(`
 creation_rules:
   - path_regex: barbar*
@@ -645,6 +792,65 @@ func TestKeyGroupsForFileWithGroups(t *testing.T) {
 	assert.Equal(t, "baz||foo", conf.KeyGroups[1][1].ToString())
 }
 
+func TestKeyGroupsForFileWithPassphraseGroups(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithPassphraseGroups, t), "/conf/path", "whatever", nil)
+	assert.Nil(t, err)
+	assert.Len(t, conf.KeyGroups, 2)
+	assert.Equal(t, "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw", conf.KeyGroups[0][0].ToString())
+	assert.Equal(t, "passphrase: break-glass", id(conf.KeyGroups[1][0]))
+}
+
+func TestKeyGroupsForFileWithEmptyPassphrase(t *testing.T) {
+	_, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithEmptyPassphrase, t), "/conf/path", "whatever", nil)
+	assert.NotNil(t, err)
+}
+
+func TestKeyGroupsForFileWithPKCS11Groups(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithPKCS11Groups, t), "/conf/path", "whatever", nil)
+	assert.Nil(t, err)
+	assert.Len(t, conf.KeyGroups, 1)
+	assert.Equal(t, "pkcs11:token=sops;object=data-key", conf.KeyGroups[0][0].ToString())
+	assert.Equal(t, "/usr/lib/softhsm/libsofthsm2.so", conf.KeyGroups[0][0].(*pkcs11.MasterKey).ModulePath)
+
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithPKCS11Groups, t), "/conf/path", "rule", nil)
+	assert.Nil(t, err)
+	assert.Len(t, conf.KeyGroups, 1)
+	assert.Equal(t, "pkcs11:slot-id=1;object=data-key", conf.KeyGroups[0][0].ToString())
+}
+
+func TestKeyGroupsForFileWithInvalidPKCS11(t *testing.T) {
+	_, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithInvalidPKCS11, t), "/conf/path", "whatever", nil)
+	assert.NotNil(t, err)
+}
+
+func TestKeyGroupsForFileWithAsymmetricKMS(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithAsymmetricKMS, t), "/conf/path", "whatever", nil)
+	assert.Nil(t, err)
+	assert.Len(t, conf.KeyGroups, 1)
+	assert.Len(t, conf.KeyGroups[0], 2)
+	assert.Equal(t, "RSAES_OAEP_SHA_256", conf.KeyGroups[0][0].(*kms.MasterKey).EncryptionAlgorithm)
+	assert.Empty(t, conf.KeyGroups[0][1].(*kms.MasterKey).EncryptionAlgorithm)
+
+	conf, err = parseCreationRuleForFile(parseConfigFile(sampleConfigWithAsymmetricKMS, t), "/conf/path", "rule", nil)
+	assert.Nil(t, err)
+	assert.Len(t, conf.KeyGroups, 1)
+	assert.Equal(t, "RSAES_OAEP_SHA_1", conf.KeyGroups[0][0].(*kms.MasterKey).EncryptionAlgorithm)
+}
+
+func TestKeyGroupsForFileWithExecPluginGroups(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithExecPluginGroups, t), "/conf/path", "whatever", nil)
+	assert.Nil(t, err)
+	assert.Len(t, conf.KeyGroups, 1)
+	assert.Len(t, conf.KeyGroups[0], 2)
+	assert.Equal(t, "exec_plugin: my-custodian|my-key", id(conf.KeyGroups[0][0]))
+	assert.Equal(t, "exec_plugin: other-custodian", id(conf.KeyGroups[0][1]))
+}
+
+func TestKeyGroupsForFileWithInvalidExecPluginName(t *testing.T) {
+	_, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithInvalidExecPluginName, t), "/conf/path", "whatever", nil)
+	assert.NotNil(t, err)
+}
+
 func TestLoadConfigFileWithUnencryptedSuffix(t *testing.T) {
 	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithSuffixParameters, t), "/conf/path", "foobar", nil)
 	assert.Nil(t, err)
@@ -675,6 +881,57 @@ func TestLoadConfigFileWithMACOnlyEncrypted(t *testing.T) {
 	assert.Equal(t, true, conf.MACOnlyEncrypted)
 }
 
+func TestLoadConfigFileWithCipher(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithCipher, t), "/conf/path", "barbar", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "xchacha20_poly1305", conf.Cipher)
+}
+
+func TestLoadConfigFileWithMACVersion(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithMACVersion, t), "/conf/path", "barbar", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, conf.MACVersion)
+}
+
+func TestLoadConfigFileWithEncryptedPaths(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithEncryptedPaths, t), "/conf/path", "barbar", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"spec.template.**.env[*]"}, conf.EncryptedPaths)
+	assert.Equal(t, []string{"spec.template.**.env[*].name"}, conf.UnencryptedPaths)
+}
+
+func TestLoadConfigFileWithEncryptedPathsAndRegex(t *testing.T) {
+	_, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithEncryptedPathsAndRegex, t), "/conf/path", "barbar", nil)
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigFileWithInvalidEncryptedPaths(t *testing.T) {
+	_, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithInvalidEncryptedPaths, t), "/conf/path", "barbar", nil)
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigFileWithZones(t *testing.T) {
+	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithZones, t), "/conf/path", "barbar", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(conf.KeyGroups))
+	assert.Equal(t, 1, len(conf.Zones))
+	assert.Equal(t, []string{"db", "payments.*"}, conf.Zones[0].Paths)
+	assert.Equal(t, 1, conf.Zones[0].ShamirThreshold)
+	assert.Equal(t, 2, len(conf.Zones[0].KeyGroups))
+	assert.Equal(t, "2", conf.Zones[0].KeyGroups[0][0].ToString())
+	assert.Equal(t, "3", conf.Zones[0].KeyGroups[1][0].ToString())
+}
+
+func TestLoadConfigFileWithZoneWithInvalidPath(t *testing.T) {
+	_, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithZoneWithInvalidPath, t), "/conf/path", "barbar", nil)
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigFileWithZoneWithoutPaths(t *testing.T) {
+	_, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithZoneWithoutPaths, t), "/conf/path", "barbar", nil)
+	assert.NotNil(t, err)
+}
+
 func TestLoadConfigFileWithUnencryptedCommentRegex(t *testing.T) {
 	conf, err := parseCreationRuleForFile(parseConfigFile(sampleConfigWithUnencryptedCommentRegexParameters, t), "/conf/path", "barbar", nil)
 	assert.Equal(t, nil, err)
@@ -7,9 +7,9 @@ package decrypt // import "github.com/getsops/sops/v3/decrypt"
 import (
 	"fmt"
 	"os"
-	"time"
 
-	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/ciphers"
 	"github.com/getsops/sops/v3/cmd/sops/common"
 	. "github.com/getsops/sops/v3/cmd/sops/formats" // Re-export
 	"github.com/getsops/sops/v3/config"
@@ -35,44 +35,120 @@ func DataWithFormat(data []byte, format Format) (cleartext []byte, err error) {
 
 	store := common.StoreForFormat(format, config.NewStoresConfig())
 
-	// Load SOPS file and access the data key
+	// Load SOPS file
 	tree, err := store.LoadEncryptedFile(data)
 	if err != nil {
 		return nil, err
 	}
-	key, err := tree.Metadata.GetDataKey()
+	if err := decryptTree(&tree, nil); err != nil {
+		return nil, err
+	}
+
+	return store.EmitPlainFile(tree.Branches)
+}
+
+// PathsWithFormat is a helper that takes encrypted data, a format enum value
+// and paths into the data, and returns the cleartext of the values at these
+// paths, in the same order. Paths are lists of string keys and int indices,
+// such as []interface{}{"db", "hosts", 0}. Only these values are decrypted if
+// the data has a MAC of its encrypted values to verify its integrity with,
+// which is the case for files written by recent versions of SOPS.
+// Strings are returned as is, other values as they are written in the format.
+func PathsWithFormat(data []byte, format Format, paths ...[]interface{}) (cleartexts [][]byte, err error) {
+
+	store := common.StoreForFormat(format, config.NewStoresConfig())
+
+	tree, err := store.LoadEncryptedFile(data)
 	if err != nil {
 		return nil, err
 	}
+	if err := decryptTree(&tree, paths); err != nil {
+		return nil, err
+	}
 
-	// Decrypt the tree
-	cipher := aes.NewCipher()
-	mac, err := tree.Decrypt(key, cipher)
+	branch, err := tree.Branches[0].ExpandAliases()
 	if err != nil {
 		return nil, err
 	}
+	for _, path := range paths {
+		v, err := branch.Truncate(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find %v: %w", path, err)
+		}
+		var cleartext []byte
+		switch v := v.(type) {
+		case string:
+			cleartext = []byte(v)
+		case sops.TreeBranch:
+			cleartext, err = store.EmitPlainFile(sops.TreeBranches{v})
+		default:
+			cleartext, err = store.EmitValue(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cleartexts = append(cleartexts, cleartext)
+	}
+	return cleartexts, nil
+}
+
+// Paths is a helper that takes encrypted data, a format string and paths
+// into the data, and returns the cleartext of the values at these paths.
+// The format string is handled as in Data, and paths as in PathsWithFormat.
+func Paths(data []byte, format string, paths ...[]interface{}) (cleartexts [][]byte, err error) {
+	formatFmt := FormatFromString(format)
+	return PathsWithFormat(data, formatFmt, paths...)
+}
+
+// decryptTree decrypts the tree and verifies its integrity. If paths are
+// given and the tree has a MAC of its encrypted values, only the values at
+// these paths are decrypted.
+func decryptTree(tree *sops.Tree, paths [][]interface{}) error {
+	key, err := tree.Metadata.GetDataKey()
+	if err != nil {
+		return err
+	}
+	tree.Metadata.UnlockZones()
+
+	decryptMac := tree.Metadata.DecryptMAC
+	decrypt := func(cipher sops.Cipher) (string, error) {
+		return tree.Decrypt(key, cipher)
+	}
+	if len(paths) > 0 && tree.Metadata.CiphertextMAC != "" {
+		// The MAC of the encrypted values is verified without
+		// decrypting them
+		decryptMac = tree.Metadata.DecryptCiphertextMAC
+		decrypt = func(cipher sops.Cipher) (string, error) {
+			return tree.DecryptPaths(key, cipher, paths)
+		}
+	}
+
+	// Decrypt the original mac first, as it also authenticates the
+	// metadata of files with a MAC version which covers it
+	cipher := ciphers.NewCipher()
+	originalMac, err := decryptMac(key, cipher)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt original mac: %w", err)
+	}
+
+	// Decrypt the tree
+	mac, err := decrypt(cipher)
+	if err != nil {
+		return err
+	}
 
 	// Compute the hash of the cleartext tree and compare it with
 	// the one that was stored in the document. If they match,
 	// integrity was preserved
-	originalMac, err := cipher.Decrypt(
-		tree.Metadata.MessageAuthenticationCode,
-		key,
-		tree.Metadata.LastModified.Format(time.RFC3339),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to decrypt original mac: %w", err)
-	}
 	if originalMac != mac {
-		return nil, fmt.Errorf("Failed to verify data integrity. expected mac %q, got %q", originalMac, mac)
+		return fmt.Errorf("Failed to verify data integrity. expected mac %q, got %q", originalMac, mac)
 	}
-
-	return store.EmitPlainFile(tree.Branches)
+	return nil
 }
 
 // Data is a helper that takes encrypted data and a format string,
 // decrypts the data and returns its cleartext in an []byte.
-// The format string can be `json`, `yaml`, `ini`, `dotenv` or `binary`.
+// The format string can be `json`, `jsonc`, `yaml`, `kubernetes`, `toml`, `hcl`, `ini`, `dotenv`, `properties` or `binary`.
 // If the format string is empty, binary format is assumed.
 func Data(data []byte, format string) (cleartext []byte, err error) {
 	formatFmt := FormatFromString(format)
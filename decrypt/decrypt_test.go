@@ -0,0 +1,119 @@
+package decrypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/ciphers"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/config"
+)
+
+const (
+	testAgeRecipient = "age1lzd99uklcjnc0e7d860axevet2cz99ce9pq6tzuzd05l5nr28ams36nvun"
+	testAgeKey       = "AGE-SECRET-KEY-1G0Q5K9TV4REQ3ZSQRMTMG8NSWQGYT0T7TZ33RAZEE0GZYVZN0APSU24RK7"
+)
+
+const plainYAML = `base: &base
+  user: admin
+db:
+  password: s3cret
+  hosts:
+    - a.example
+    - b.example
+  credentials: *base
+other: value
+`
+
+// encryptYAML encrypts plain with the test age key, and passes the tree to
+// change before it is emitted.
+func encryptYAML(t *testing.T, plain string, change func(tree *sops.Tree)) []byte {
+	t.Setenv(age.SopsAgeKeyEnv, testAgeKey)
+	store := common.StoreForFormat(formats.Yaml, config.NewStoresConfig())
+	branches, err := store.LoadPlainFile([]byte(plain))
+	assert.NoError(t, err)
+	keys, err := age.MasterKeysFromRecipients(testAgeRecipient)
+	assert.NoError(t, err)
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			UnencryptedSuffix: sops.DefaultUnencryptedSuffix,
+			KeyGroups:         []sops.KeyGroup{{keys[0]}},
+			Version:           "3.9.0",
+		},
+	}
+	dataKey, errs := tree.GenerateDataKey()
+	assert.Empty(t, errs)
+	err = common.EncryptTree(common.EncryptTreeOpts{DataKey: dataKey, Tree: &tree, Cipher: ciphers.NewCipher()})
+	assert.NoError(t, err)
+	if change != nil {
+		change(&tree)
+	}
+	out, err := store.EmitEncryptedFile(tree)
+	assert.NoError(t, err)
+	return out
+}
+
+func TestPaths(t *testing.T) {
+	data := encryptYAML(t, plainYAML, nil)
+	cleartexts, err := Paths(data, "yaml",
+		[]interface{}{"db", "password"},
+		[]interface{}{"db", "hosts", 1},
+		[]interface{}{"db", "credentials"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{
+		[]byte("s3cret"),
+		[]byte("b.example"),
+		[]byte("user: admin\n"),
+	}, cleartexts)
+
+	_, err = Paths(data, "yaml", []interface{}{"missing"})
+	assert.ErrorContains(t, err, "component ['missing'] not found")
+}
+
+func TestPathsWithMergeKeys(t *testing.T) {
+	data := encryptYAML(t, `defaults: &defaults
+  image: nginx
+  replicas: 1
+app:
+  <<: *defaults
+  replicas: 3
+`, nil)
+	// Only the merged value is requested, so the mapping it is merged from
+	// must be decrypted as well.
+	cleartexts, err := Paths(data, "yaml", []interface{}{"app", "image"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("nginx")}, cleartexts)
+
+	cleartexts, err = Paths(data, "yaml",
+		[]interface{}{"app", "replicas"},
+		[]interface{}{"app"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{
+		[]byte("3\n"),
+		[]byte("image: nginx\nreplicas: 3\n"),
+	}, cleartexts)
+}
+
+func TestPathsVerifiesOtherValues(t *testing.T) {
+	data := encryptYAML(t, plainYAML, nil)
+	tampered := strings.Replace(string(data), "other: ENC[AES256_GCM,data:", "other: ENC[AES256_GCM,data:AA", 1)
+	_, err := Paths([]byte(tampered), "yaml", []interface{}{"db", "password"})
+	assert.ErrorContains(t, err, "Failed to verify data integrity")
+}
+
+func TestPathsWithoutCiphertextMAC(t *testing.T) {
+	data := encryptYAML(t, plainYAML, func(tree *sops.Tree) {
+		tree.Metadata.CiphertextMAC = ""
+	})
+	cleartexts, err := Paths(data, "yaml", []interface{}{"db", "password"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("s3cret")}, cleartexts)
+}
@@ -0,0 +1,270 @@
+/*
+Package execplugin contains an implementation of the github.com/getsops/sops/v3/keys.MasterKey
+interface that encrypts and decrypts the data key by running an external
+command, an "exec plugin". This allows any key custodian to be used with SOPS,
+without it being supported natively.
+
+Files only record the name of the plugin. The command run for a plugin named
+"my-custodian" is read from the SOPS_EXEC_PLUGIN_MY_CUSTODIAN environment
+variable, or is otherwise the "sops-plugin-my-custodian" executable found in
+the PATH, so that opening a file never runs a command it chooses.
+
+Similar to Git credential helpers, the command is run with the operation
+("encrypt" or "decrypt") appended to its arguments. It receives a JSON request
+on its standard input, and must write a JSON response to its standard output:
+
+	$ sops-plugin-my-custodian encrypt
+	{"version":1,"key_id":"my-key","plaintext":"<base64>"}
+	{"ciphertext":"<base64>"}
+
+	$ sops-plugin-my-custodian decrypt
+	{"version":1,"key_id":"my-key","ciphertext":"<base64>"}
+	{"plaintext":"<base64>"}
+
+A plugin can report a failure by exiting with a non-zero status, or by
+responding with an "error" field. Its standard error is forwarded to the one of
+SOPS.
+*/
+package execplugin // import "github.com/getsops/sops/v3/execplugin"
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/getsops/sops/v3/logging"
+)
+
+const (
+	// KeyTypeIdentifier is the string used to identify an exec plugin
+	// MasterKey.
+	KeyTypeIdentifier = "exec_plugin"
+	// ProtocolVersion is the version of the protocol spoken with the plugin,
+	// sent with every request.
+	ProtocolVersion = 1
+
+	// operationEncrypt is the operation argument used to Encrypt.
+	operationEncrypt = "encrypt"
+	// operationDecrypt is the operation argument used to Decrypt.
+	operationDecrypt = "decrypt"
+
+	// SopsExecPluginEnvPrefix is the prefix of the environment variables
+	// which set the command of a plugin, followed by its upper-cased name in
+	// which dashes are replaced with underscores.
+	SopsExecPluginEnvPrefix = "SOPS_EXEC_PLUGIN_"
+	// executablePrefix is the prefix of the name of the executable of a
+	// plugin whose command is not set.
+	executablePrefix = "sops-plugin-"
+)
+
+// nameRegex matches valid plugin names, which can not name executables
+// outside of the PATH.
+var nameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// log is the global logger for any exec plugin MasterKey.
+var log *logrus.Logger
+
+func init() {
+	log = logging.NewLogger("EXEC_PLUGIN")
+}
+
+// MasterKey is an external command used to Encrypt and Decrypt SOPS' data
+// key.
+type MasterKey struct {
+	// Name is the name of the plugin, from which its command is resolved.
+	Name string
+	// KeyID is an opaque identifier passed to the plugin with every request,
+	// allowing a single plugin to manage several keys. Can be empty.
+	KeyID string
+	// EncryptedKey contains the SOPS data key encrypted by the plugin.
+	EncryptedKey string
+	// CreationDate of the MasterKey.
+	CreationDate time.Time
+}
+
+// request is the JSON request written to the standard input of the plugin.
+type request struct {
+	Version    int    `json:"version"`
+	KeyID      string `json:"key_id,omitempty"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+// response is the JSON response read from the standard output of the plugin.
+type response struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// NewMasterKey creates a new MasterKey from a plugin name and key ID, setting
+// the creation date to the current date.
+func NewMasterKey(name, keyID string) *MasterKey {
+	return &MasterKey{
+		Name:         name,
+		KeyID:        keyID,
+		CreationDate: time.Now().UTC(),
+	}
+}
+
+// Encrypt takes a SOPS data key, encrypts it with the plugin, and stores the
+// base64 encoded result in the EncryptedKey field.
+func (key *MasterKey) Encrypt(dataKey []byte) error {
+	resp, err := key.run(operationEncrypt, request{
+		Version:   ProtocolVersion,
+		KeyID:     key.KeyID,
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		log.WithField("plugin", key.Name).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with exec plugin %q: %w", key.ToString(), err)
+	}
+	if len(resp.Ciphertext) == 0 {
+		log.WithField("plugin", key.Name).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with exec plugin %q: response contains no ciphertext", key.ToString())
+	}
+	key.EncryptedKey = base64.StdEncoding.EncodeToString(resp.Ciphertext)
+	log.WithField("plugin", key.Name).Info("Encryption succeeded")
+	return nil
+}
+
+// EncryptIfNeeded encrypts the provided SOPS data key, if it has not been
+// encrypted yet.
+func (key *MasterKey) EncryptIfNeeded(dataKey []byte) error {
+	if key.EncryptedKey == "" {
+		return key.Encrypt(dataKey)
+	}
+	return nil
+}
+
+// EncryptedDataKey returns the encrypted SOPS data key this master key holds.
+func (key *MasterKey) EncryptedDataKey() []byte {
+	return []byte(key.EncryptedKey)
+}
+
+// SetEncryptedDataKey sets the encrypted SOPS data key for this master key.
+func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
+	key.EncryptedKey = string(enc)
+}
+
+// Decrypt decrypts the EncryptedKey with the plugin, and returns the result.
+func (key *MasterKey) Decrypt() ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(key.EncryptedKey)
+	if err != nil {
+		log.WithField("plugin", key.Name).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to base64 decode sops data key: %w", err)
+	}
+	resp, err := key.run(operationDecrypt, request{
+		Version:    ProtocolVersion,
+		KeyID:      key.KeyID,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		log.WithField("plugin", key.Name).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to decrypt sops data key with exec plugin %q: %w", key.ToString(), err)
+	}
+	if len(resp.Plaintext) == 0 {
+		log.WithField("plugin", key.Name).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to decrypt sops data key with exec plugin %q: response contains no plaintext", key.ToString())
+	}
+	log.WithField("plugin", key.Name).Info("Decryption succeeded")
+	return resp.Plaintext, nil
+}
+
+// NeedsRotation returns whether the data key needs to be rotated or not.
+func (key *MasterKey) NeedsRotation() bool {
+	return false
+}
+
+// ToString converts the key to a string representation.
+func (key *MasterKey) ToString() string {
+	if key.KeyID == "" {
+		return key.Name
+	}
+	return key.Name + "|" + key.KeyID
+}
+
+// ToMap converts the MasterKey to a map for serialization purposes.
+func (key *MasterKey) ToMap() map[string]interface{} {
+	out := make(map[string]interface{})
+	out["name"] = key.Name
+	if key.KeyID != "" {
+		out["key_id"] = key.KeyID
+	}
+	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
+	out["enc"] = key.EncryptedKey
+	return out
+}
+
+// TypeToIdentifier returns the string identifier for the MasterKey type.
+func (key *MasterKey) TypeToIdentifier() string {
+	return KeyTypeIdentifier
+}
+
+// CheckName returns an error if name is not a valid plugin name.
+func CheckName(name string) error {
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid exec plugin name %q: it must only contain letters, digits, dashes and underscores", name)
+	}
+	return nil
+}
+
+// command returns the command line of the plugin, split on white space: the
+// one of its environment variable if set, or else its executable.
+func (key *MasterKey) command() ([]string, error) {
+	if err := CheckName(key.Name); err != nil {
+		return nil, err
+	}
+	env := SopsExecPluginEnvPrefix + strings.ToUpper(strings.ReplaceAll(key.Name, "-", "_"))
+	if command, ok := os.LookupEnv(env); ok {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("exec plugin command in %s can not be empty", env)
+		}
+		return args, nil
+	}
+	path, err := exec.LookPath(executablePrefix + key.Name)
+	if err != nil {
+		return nil, fmt.Errorf("exec plugin %q not found: set %s or install %s%s: %w", key.Name, env, executablePrefix, key.Name, err)
+	}
+	return []string{path}, nil
+}
+
+// run runs the plugin for the given operation, writing req to its standard
+// input, and returns the response read from its standard output.
+func (key *MasterKey) run(operation string, req request) (*response, error) {
+	args, err := key.command()
+	if err != nil {
+		return nil, err
+	}
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(args[0], append(args[1:], operation)...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run command: %w", err)
+	}
+
+	var resp response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin returned an error: %s", resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,198 @@
+package execplugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPluginEnv is set when the test binary is run as a stub exec plugin.
+const mockPluginEnv = "SOPS_TEST_EXEC_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mockPluginEnv) != "" {
+		runMockPlugin(os.Args[len(os.Args)-1])
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMockPlugin implements a stub exec plugin. It "encrypts" the data key by
+// prefixing it with the key ID, and "decrypts" it by removing that prefix.
+// The key IDs "error", "exit" and "garbage" make it fail in various ways.
+func runMockPlugin(operation string) {
+	var req request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		panic(err)
+	}
+	var resp response
+	switch req.KeyID {
+	case "error":
+		resp.Error = "key custodian unavailable"
+	case "exit":
+		fmt.Fprintln(os.Stderr, "mock plugin failure")
+		os.Exit(1)
+	case "garbage":
+		fmt.Print("not json")
+		return
+	}
+	prefix := []byte(req.KeyID + ":")
+	switch operation {
+	case operationEncrypt:
+		resp.Ciphertext = append(prefix, req.Plaintext...)
+	case operationDecrypt:
+		if !bytes.HasPrefix(req.Ciphertext, prefix) {
+			resp.Error = "ciphertext was not encrypted with this key"
+		} else {
+			resp.Plaintext = bytes.TrimPrefix(req.Ciphertext, prefix)
+		}
+	default:
+		resp.Error = "unknown operation " + operation
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
+		panic(err)
+	}
+}
+
+// mockPlugin sets the command of the plugin named "mock-plugin" to run the
+// test binary as a stub exec plugin, and returns that name.
+func mockPlugin(t *testing.T) string {
+	t.Setenv(mockPluginEnv, "1")
+	t.Setenv(SopsExecPluginEnvPrefix+"MOCK_PLUGIN", os.Args[0]+" --some-flag")
+	return "mock-plugin"
+}
+
+func TestNewMasterKey(t *testing.T) {
+	key := NewMasterKey("my-custodian", "my-key")
+	assert.Equal(t, "my-custodian", key.Name)
+	assert.Equal(t, "my-key", key.KeyID)
+	assert.NotZero(t, key.CreationDate)
+}
+
+func TestMasterKey_EncryptDecrypt_RoundTrip(t *testing.T) {
+	name := mockPlugin(t)
+	data := []byte("some secret data")
+
+	encryptKey := NewMasterKey(name, "my-key")
+	assert.NoError(t, encryptKey.Encrypt(data))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("my-key:some secret data")), encryptKey.EncryptedKey)
+
+	decryptKey := &MasterKey{Name: name, KeyID: "my-key", EncryptedKey: encryptKey.EncryptedKey}
+	got, err := decryptKey.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestMasterKey_Encrypt(t *testing.T) {
+	name := mockPlugin(t)
+
+	t.Run("error response", func(t *testing.T) {
+		err := NewMasterKey(name, "error").Encrypt([]byte("data"))
+		assert.ErrorContains(t, err, "plugin returned an error: key custodian unavailable")
+	})
+
+	t.Run("non-zero exit status", func(t *testing.T) {
+		err := NewMasterKey(name, "exit").Encrypt([]byte("data"))
+		assert.ErrorContains(t, err, "failed to run command")
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		err := NewMasterKey(name, "garbage").Encrypt([]byte("data"))
+		assert.ErrorContains(t, err, "failed to unmarshal response")
+	})
+
+	t.Run("missing plugin", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		err := NewMasterKey("does-not-exist", "").Encrypt([]byte("data"))
+		assert.ErrorContains(t, err, `exec plugin "does-not-exist" not found`)
+	})
+
+	t.Run("empty command", func(t *testing.T) {
+		t.Setenv(SopsExecPluginEnvPrefix+"EMPTY", " ")
+		err := NewMasterKey("empty", "").Encrypt([]byte("data"))
+		assert.ErrorContains(t, err, "exec plugin command in SOPS_EXEC_PLUGIN_EMPTY can not be empty")
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		err := NewMasterKey("../bin/sh", "").Encrypt([]byte("data"))
+		assert.ErrorContains(t, err, `invalid exec plugin name "../bin/sh"`)
+	})
+}
+
+func TestMasterKey_ExecutableInPath(t *testing.T) {
+	t.Setenv(mockPluginEnv, "1")
+	dir := t.TempDir()
+	executable, err := os.Executable()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Symlink(executable, filepath.Join(dir, "sops-plugin-in-path")))
+	t.Setenv("PATH", dir)
+
+	key := NewMasterKey("in-path", "my-key")
+	assert.NoError(t, key.Encrypt([]byte("data")))
+	got, err := key.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), got)
+}
+
+func TestMasterKey_Decrypt(t *testing.T) {
+	name := mockPlugin(t)
+
+	t.Run("wrong key", func(t *testing.T) {
+		key := &MasterKey{Name: name, KeyID: "other-key", EncryptedKey: base64.StdEncoding.EncodeToString([]byte("my-key:data"))}
+		got, err := key.Decrypt()
+		assert.ErrorContains(t, err, "ciphertext was not encrypted with this key")
+		assert.Nil(t, got)
+	})
+
+	t.Run("invalid encrypted key", func(t *testing.T) {
+		key := &MasterKey{Name: name, KeyID: "my-key", EncryptedKey: "invalid"}
+		got, err := key.Decrypt()
+		assert.ErrorContains(t, err, "failed to base64 decode sops data key")
+		assert.Nil(t, got)
+	})
+}
+
+func TestMasterKey_EncryptIfNeeded(t *testing.T) {
+	key := NewMasterKey(mockPlugin(t), "my-key")
+	assert.NoError(t, key.EncryptIfNeeded([]byte("data")))
+
+	encryptedKey := key.EncryptedKey
+	assert.NotEmpty(t, encryptedKey)
+
+	assert.NoError(t, key.EncryptIfNeeded([]byte("some other data")))
+	assert.Equal(t, encryptedKey, key.EncryptedKey)
+}
+
+func TestMasterKey_NeedsRotation(t *testing.T) {
+	assert.False(t, NewMasterKey("my-custodian", "").NeedsRotation())
+}
+
+func TestMasterKey_ToString(t *testing.T) {
+	assert.Equal(t, "my-custodian", NewMasterKey("my-custodian", "").ToString())
+	assert.Equal(t, "my-custodian|my-key", NewMasterKey("my-custodian", "my-key").ToString())
+}
+
+func TestMasterKey_ToMap(t *testing.T) {
+	key := &MasterKey{
+		Name:         "my-custodian",
+		KeyID:        "my-key",
+		EncryptedKey: "some-encrypted-key",
+		CreationDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	assert.Equal(t, map[string]interface{}{
+		"name":       "my-custodian",
+		"key_id":     "my-key",
+		"created_at": "2024-01-02T03:04:05Z",
+		"enc":        "some-encrypted-key",
+	}, key.ToMap())
+
+	key.KeyID = ""
+	assert.NotContains(t, key.ToMap(), "key_id")
+}
@@ -0,0 +1,327 @@
+package hcvault
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// SopsVaultAuthMethodEnv can be set as an environment variable with the
+	// auth method used to log in to Vault, for keys which do not specify
+	// one.
+	SopsVaultAuthMethodEnv = "SOPS_VAULT_AUTH_METHOD"
+	// SopsVaultAuthMountEnv can be set as an environment variable with the
+	// path the auth method is mounted on, for keys which do not specify one.
+	// It defaults to the name of the auth method.
+	SopsVaultAuthMountEnv = "SOPS_VAULT_AUTH_MOUNT"
+	// SopsVaultAuthRoleEnv can be set as an environment variable with the
+	// role used to log in, for keys which do not specify one. For AppRole,
+	// this is the role ID.
+	SopsVaultAuthRoleEnv = "SOPS_VAULT_AUTH_ROLE"
+	// SopsVaultAppRoleSecretIDEnv can be set as an environment variable with
+	// the secret ID used to log in with AppRole.
+	SopsVaultAppRoleSecretIDEnv = "SOPS_VAULT_APPROLE_SECRET_ID"
+	// SopsVaultAppRoleSecretIDFileEnv can be set as an environment variable
+	// with the path to a file containing the secret ID used to log in with
+	// AppRole.
+	SopsVaultAppRoleSecretIDFileEnv = "SOPS_VAULT_APPROLE_SECRET_ID_FILE"
+	// SopsVaultKubernetesTokenFileEnv can be set as an environment variable
+	// with the path to the service account token used to log in with
+	// Kubernetes. It defaults to defaultKubernetesTokenFile.
+	SopsVaultKubernetesTokenFileEnv = "SOPS_VAULT_KUBERNETES_TOKEN_FILE"
+	// SopsVaultJWTEnv can be set as an environment variable with the JWT
+	// used to log in with JWT.
+	SopsVaultJWTEnv = "SOPS_VAULT_JWT"
+	// SopsVaultJWTFileEnv can be set as an environment variable with the
+	// path to a file containing the JWT used to log in with JWT.
+	SopsVaultJWTFileEnv = "SOPS_VAULT_JWT_FILE"
+
+	// AuthMethodToken uses a token from the environment or defaultTokenFile.
+	AuthMethodToken = "token"
+	// AuthMethodAppRole logs in with a role ID and secret ID.
+	AuthMethodAppRole = "approle"
+	// AuthMethodKubernetes logs in with a Kubernetes service account token.
+	AuthMethodKubernetes = "kubernetes"
+	// AuthMethodJWT logs in with a JWT.
+	AuthMethodJWT = "jwt"
+)
+
+var (
+	// defaultKubernetesTokenFile is the path of the service account token
+	// mounted in Kubernetes pods.
+	defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// tokenCache holds the tokens obtained by logging in, for the duration of
+	// the process.
+	tokenCache = make(map[string]*cachedToken)
+	// tokenCacheMu guards tokenCache.
+	tokenCacheMu sync.Mutex
+)
+
+// AuthMethod is a Vault auth method, used to log in to obtain a token.
+type AuthMethod interface {
+	// Login logs in to the Vault server of the client, and returns the
+	// secret holding the token.
+	Login(ctx context.Context, client *api.Client) (*api.Secret, error)
+	// String returns a representation of the auth method, without any
+	// credentials. It identifies the cached token.
+	String() string
+}
+
+// AppRoleAuth logs in to Vault with the AppRole auth method.
+type AppRoleAuth struct {
+	// MountPath is the path the auth method is mounted on.
+	MountPath string
+	// RoleID is the ID of the role to log in with.
+	RoleID string
+	// SecretID is the secret ID of the role. Can be empty, if the role does
+	// not require one.
+	SecretID string
+}
+
+// Login logs in to the Vault server of the client with AppRole.
+func (a *AppRoleAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	data := map[string]interface{}{
+		"role_id": a.RoleID,
+	}
+	if a.SecretID != "" {
+		data["secret_id"] = a.SecretID
+	}
+	return login(ctx, client, a.MountPath, data)
+}
+
+// String returns a representation of the auth method.
+func (a *AppRoleAuth) String() string {
+	return fmt.Sprintf("%s@%s:%s", AuthMethodAppRole, a.MountPath, a.RoleID)
+}
+
+// KubernetesAuth logs in to Vault with the Kubernetes auth method.
+type KubernetesAuth struct {
+	// MountPath is the path the auth method is mounted on.
+	MountPath string
+	// Role is the name of the role to log in with.
+	Role string
+	// ServiceAccountToken is the service account token of the pod.
+	ServiceAccountToken string
+}
+
+// Login logs in to the Vault server of the client with Kubernetes.
+func (a *KubernetesAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	return login(ctx, client, a.MountPath, map[string]interface{}{
+		"role": a.Role,
+		"jwt":  a.ServiceAccountToken,
+	})
+}
+
+// String returns a representation of the auth method.
+func (a *KubernetesAuth) String() string {
+	return fmt.Sprintf("%s@%s:%s", AuthMethodKubernetes, a.MountPath, a.Role)
+}
+
+// JWTAuth logs in to Vault with the JWT auth method.
+type JWTAuth struct {
+	// MountPath is the path the auth method is mounted on.
+	MountPath string
+	// Role is the name of the role to log in with. Can be empty, to use the
+	// default role of the auth method.
+	Role string
+	// JWT is the signed JSON Web Token to log in with.
+	JWT string
+}
+
+// Login logs in to the Vault server of the client with JWT.
+func (a *JWTAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	data := map[string]interface{}{
+		"jwt": a.JWT,
+	}
+	if a.Role != "" {
+		data["role"] = a.Role
+	}
+	return login(ctx, client, a.MountPath, data)
+}
+
+// String returns a representation of the auth method.
+func (a *JWTAuth) String() string {
+	return fmt.Sprintf("%s@%s:%s", AuthMethodJWT, a.MountPath, a.Role)
+}
+
+// NewAuthMethod returns the AuthMethod with the given name, mounted on mount
+// (or the default path if empty), logging in with role. Credentials are read
+// from the environment. It returns nil for AuthMethodToken.
+func NewAuthMethod(method, mount, role string) (AuthMethod, error) {
+	if err := validateAuthMethod(method); err != nil {
+		return nil, err
+	}
+	if mount == "" {
+		mount = method
+	}
+	switch method {
+	case AuthMethodAppRole:
+		if role == "" {
+			return nil, fmt.Errorf("a role ID is required for Vault AppRole auth: set it on the key or with %s", SopsVaultAuthRoleEnv)
+		}
+		secretID, err := secretFromEnv(SopsVaultAppRoleSecretIDEnv, SopsVaultAppRoleSecretIDFileEnv)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read Vault AppRole secret ID: %w", err)
+		}
+		return &AppRoleAuth{MountPath: mount, RoleID: role, SecretID: secretID}, nil
+	case AuthMethodKubernetes:
+		if role == "" {
+			return nil, fmt.Errorf("a role is required for Vault Kubernetes auth: set it on the key or with %s", SopsVaultAuthRoleEnv)
+		}
+		tokenFile := os.Getenv(SopsVaultKubernetesTokenFileEnv)
+		if tokenFile == "" {
+			tokenFile = defaultKubernetesTokenFile
+		}
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read Kubernetes service account token: %w", err)
+		}
+		return &KubernetesAuth{MountPath: mount, Role: role, ServiceAccountToken: strings.TrimSpace(string(token))}, nil
+	case AuthMethodJWT:
+		jwt, err := secretFromEnv(SopsVaultJWTEnv, SopsVaultJWTFileEnv)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read JWT: %w", err)
+		}
+		if jwt == "" {
+			return nil, fmt.Errorf("a JWT is required for Vault JWT auth: set %s or %s", SopsVaultJWTEnv, SopsVaultJWTFileEnv)
+		}
+		return &JWTAuth{MountPath: mount, Role: role, JWT: jwt}, nil
+	}
+	return nil, nil
+}
+
+// validateAuthMethod returns an error if method is not a known auth method.
+// An empty method is valid, and defaults to AuthMethodToken.
+func validateAuthMethod(method string) error {
+	switch method {
+	case "", AuthMethodToken, AuthMethodAppRole, AuthMethodKubernetes, AuthMethodJWT:
+		return nil
+	}
+	return fmt.Errorf("unsupported Vault auth method %q (supported: %s, %s, %s, %s)",
+		method, AuthMethodToken, AuthMethodAppRole, AuthMethodKubernetes, AuthMethodJWT)
+}
+
+// secretFromEnv returns the value of the env environment variable, or else
+// the content of the file at the path in the fileEnv environment variable.
+// It returns an empty string if neither is set.
+func secretFromEnv(env, fileEnv string) (string, error) {
+	if v := os.Getenv(env); v != "" {
+		return v, nil
+	}
+	if p := os.Getenv(fileEnv); p != "" {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", nil
+}
+
+// login writes data to the login endpoint of the auth method mounted on
+// mount.
+func login(ctx context.Context, client *api.Client, mount string, data map[string]interface{}) (*api.Secret, error) {
+	loginPath := path.Join("auth", strings.Trim(mount, "/"), "login")
+	secret, err := client.Logical().WriteWithContext(ctx, loginPath, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("no token returned by '%s'", loginPath)
+	}
+	return secret, nil
+}
+
+// cachedToken is a token obtained by logging in to Vault.
+type cachedToken struct {
+	token         string
+	renewable     bool
+	leaseDuration time.Duration
+	expiresAt     time.Time
+}
+
+// newCachedToken returns a cachedToken for the auth information of a login
+// or renewal.
+func newCachedToken(auth *api.SecretAuth) *cachedToken {
+	t := &cachedToken{
+		token:         auth.ClientToken,
+		renewable:     auth.Renewable,
+		leaseDuration: time.Duration(auth.LeaseDuration) * time.Second,
+	}
+	if t.leaseDuration > 0 {
+		t.expiresAt = time.Now().Add(t.leaseDuration)
+	}
+	return t
+}
+
+// needsRenewal returns whether less than a third of the lease of the token
+// remains. Tokens without lease never need renewal.
+func (t *cachedToken) needsRenewal() bool {
+	if t.leaseDuration == 0 {
+		return false
+	}
+	return time.Until(t.expiresAt) < t.leaseDuration/3
+}
+
+// expired returns whether the lease of the token has expired.
+func (t *cachedToken) expired() bool {
+	return t.leaseDuration != 0 && !time.Now().Before(t.expiresAt)
+}
+
+// loginToken returns a token for the Vault server at address, obtained by
+// logging in with auth in namespace. The token is cached, and renewed when it
+// is about to expire. If it can not be renewed, it logs in again.
+func loginToken(address, namespace string, auth AuthMethod) (string, error) {
+	tokenCacheMu.Lock()
+	defer tokenCacheMu.Unlock()
+
+	ctx := context.Background()
+	cacheKey := address + "|" + namespace + "|" + auth.String()
+	cached, ok := tokenCache[cacheKey]
+	if ok && !cached.needsRenewal() {
+		return cached.token, nil
+	}
+
+	cfg := api.DefaultConfig()
+	cfg.Address = address
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return "", fmt.Errorf("cannot create Vault client: %w", err)
+	}
+	if namespace != "" {
+		client.SetNamespace(namespace)
+	}
+
+	if ok && cached.renewable && !cached.expired() {
+		client.SetToken(cached.token)
+		secret, err := client.Auth().Token().RenewSelfWithContext(ctx, int(cached.leaseDuration.Seconds()))
+		if err == nil && secret != nil && secret.Auth != nil {
+			renewed := newCachedToken(secret.Auth)
+			if renewed.token == "" {
+				renewed.token = cached.token
+			}
+			tokenCache[cacheKey] = renewed
+			log.WithField("auth", auth.String()).Debug("Renewed Vault token")
+			return renewed.token, nil
+		}
+		log.WithField("auth", auth.String()).WithError(err).Debug("Failed to renew Vault token, logging in again")
+	}
+
+	// Do not send any token picked up from the environment.
+	client.ClearToken()
+	secret, err := auth.Login(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("cannot log in to Vault with %s: %w", auth.String(), err)
+	}
+	token := newCachedToken(secret.Auth)
+	tokenCache[cacheKey] = token
+	log.WithField("auth", auth.String()).Debug("Logged in to Vault")
+	return token.token, nil
+}
@@ -0,0 +1,256 @@
+package hcvault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockAuthServer is a stub Vault server implementing the login endpoint of
+// an AppRole auth method mounted on "approle", and token renewal.
+type mockAuthServer struct {
+	*httptest.Server
+	logins    atomic.Int32
+	renewals  atomic.Int32
+	renewable bool
+}
+
+func newMockAuthServer(t *testing.T, renewable bool) *mockAuthServer {
+	s := &mockAuthServer{renewable: renewable}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body["role_id"] != "role-id" || body["secret_id"] != "secret-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != "" {
+			http.Error(w, "unexpected token", http.StatusBadRequest)
+			return
+		}
+		s.logins.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   "login-token",
+				"lease_duration": 3600,
+				"renewable":      s.renewable,
+			},
+		})
+	})
+	mux.HandleFunc("/v1/auth/token/renew-self", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "login-token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		s.renewals.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   "login-token",
+				"lease_duration": 3600,
+				"renewable":      true,
+			},
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// resetTokenCache empties tokenCache before and after the test.
+func resetTokenCache(t *testing.T) {
+	reset := func() {
+		tokenCacheMu.Lock()
+		defer tokenCacheMu.Unlock()
+		tokenCache = make(map[string]*cachedToken)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestNewAuthMethod(t *testing.T) {
+	t.Run("token", func(t *testing.T) {
+		got, err := NewAuthMethod(AuthMethodToken, "", "")
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := NewAuthMethod("userpass", "", "")
+		assert.ErrorContains(t, err, `unsupported Vault auth method "userpass"`)
+	})
+
+	t.Run("approle", func(t *testing.T) {
+		t.Setenv(SopsVaultAppRoleSecretIDEnv, "secret-id")
+		got, err := NewAuthMethod(AuthMethodAppRole, "", "role-id")
+		assert.NoError(t, err)
+		assert.Equal(t, &AppRoleAuth{MountPath: "approle", RoleID: "role-id", SecretID: "secret-id"}, got)
+	})
+
+	t.Run("approle with secret ID file", func(t *testing.T) {
+		secretIDFile := filepath.Join(t.TempDir(), "secret-id")
+		assert.NoError(t, os.WriteFile(secretIDFile, []byte("secret-id\n"), 0600))
+		t.Setenv(SopsVaultAppRoleSecretIDEnv, "")
+		t.Setenv(SopsVaultAppRoleSecretIDFileEnv, secretIDFile)
+
+		got, err := NewAuthMethod(AuthMethodAppRole, "ci", "role-id")
+		assert.NoError(t, err)
+		assert.Equal(t, &AppRoleAuth{MountPath: "ci", RoleID: "role-id", SecretID: "secret-id"}, got)
+	})
+
+	t.Run("approle without role ID", func(t *testing.T) {
+		_, err := NewAuthMethod(AuthMethodAppRole, "", "")
+		assert.ErrorContains(t, err, "a role ID is required")
+	})
+
+	t.Run("kubernetes", func(t *testing.T) {
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		assert.NoError(t, os.WriteFile(tokenFile, []byte("service-account-token"), 0600))
+		t.Setenv(SopsVaultKubernetesTokenFileEnv, tokenFile)
+
+		got, err := NewAuthMethod(AuthMethodKubernetes, "k8s", "sops")
+		assert.NoError(t, err)
+		assert.Equal(t, &KubernetesAuth{MountPath: "k8s", Role: "sops", ServiceAccountToken: "service-account-token"}, got)
+	})
+
+	t.Run("kubernetes without token", func(t *testing.T) {
+		t.Setenv(SopsVaultKubernetesTokenFileEnv, filepath.Join(t.TempDir(), "missing"))
+		_, err := NewAuthMethod(AuthMethodKubernetes, "", "sops")
+		assert.ErrorContains(t, err, "cannot read Kubernetes service account token")
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		t.Setenv(SopsVaultJWTEnv, "header.payload.signature")
+		got, err := NewAuthMethod(AuthMethodJWT, "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, &JWTAuth{MountPath: "jwt", JWT: "header.payload.signature"}, got)
+	})
+
+	t.Run("jwt without token", func(t *testing.T) {
+		t.Setenv(SopsVaultJWTEnv, "")
+		t.Setenv(SopsVaultJWTFileEnv, "")
+		_, err := NewAuthMethod(AuthMethodJWT, "", "")
+		assert.ErrorContains(t, err, "a JWT is required")
+	})
+}
+
+func TestMasterKey_authMethod(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv(SopsVaultAuthMethodEnv, "")
+		got, err := NewMasterKey("https://example.com", "transit", "key").authMethod()
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv(SopsVaultAuthMethodEnv, AuthMethodAppRole)
+		t.Setenv(SopsVaultAuthMountEnv, "ci")
+		t.Setenv(SopsVaultAuthRoleEnv, "role-id")
+		t.Setenv(SopsVaultAppRoleSecretIDEnv, "secret-id")
+		got, err := NewMasterKey("https://example.com", "transit", "key").authMethod()
+		assert.NoError(t, err)
+		assert.Equal(t, &AppRoleAuth{MountPath: "ci", RoleID: "role-id", SecretID: "secret-id"}, got)
+	})
+
+	t.Run("key takes precedence", func(t *testing.T) {
+		t.Setenv(SopsVaultAuthMethodEnv, AuthMethodKubernetes)
+		t.Setenv(SopsVaultAuthMountEnv, "")
+		t.Setenv(SopsVaultAuthRoleEnv, "other-role-id")
+		t.Setenv(SopsVaultAppRoleSecretIDEnv, "secret-id")
+		key := NewMasterKey("https://example.com", "transit", "key")
+		key.AuthMethod = AuthMethodAppRole
+		key.AuthRole = "role-id"
+		got, err := key.authMethod()
+		assert.NoError(t, err)
+		assert.Equal(t, &AppRoleAuth{MountPath: "approle", RoleID: "role-id", SecretID: "secret-id"}, got)
+	})
+}
+
+func Test_loginToken(t *testing.T) {
+	auth := &AppRoleAuth{MountPath: "approle", RoleID: "role-id", SecretID: "secret-id"}
+
+	t.Run("logs in once", func(t *testing.T) {
+		resetTokenCache(t)
+		t.Setenv("VAULT_TOKEN", "ignored")
+		server := newMockAuthServer(t, true)
+
+		for i := 0; i < 3; i++ {
+			got, err := loginToken(server.URL, "", auth)
+			assert.NoError(t, err)
+			assert.Equal(t, "login-token", got)
+		}
+		assert.EqualValues(t, 1, server.logins.Load())
+		assert.EqualValues(t, 0, server.renewals.Load())
+	})
+
+	t.Run("renews token about to expire", func(t *testing.T) {
+		resetTokenCache(t)
+		server := newMockAuthServer(t, true)
+
+		_, err := loginToken(server.URL, "", auth)
+		assert.NoError(t, err)
+		tokenCache[server.URL+"||"+auth.String()].expiresAt = time.Now().Add(time.Minute)
+
+		got, err := loginToken(server.URL, "", auth)
+		assert.NoError(t, err)
+		assert.Equal(t, "login-token", got)
+		assert.EqualValues(t, 1, server.logins.Load())
+		assert.EqualValues(t, 1, server.renewals.Load())
+		assert.False(t, tokenCache[server.URL+"||"+auth.String()].needsRenewal())
+	})
+
+	t.Run("logs in again when token can not be renewed", func(t *testing.T) {
+		resetTokenCache(t)
+		server := newMockAuthServer(t, false)
+
+		_, err := loginToken(server.URL, "", auth)
+		assert.NoError(t, err)
+		tokenCache[server.URL+"||"+auth.String()].expiresAt = time.Now().Add(-time.Second)
+
+		got, err := loginToken(server.URL, "", auth)
+		assert.NoError(t, err)
+		assert.Equal(t, "login-token", got)
+		assert.EqualValues(t, 2, server.logins.Load())
+		assert.EqualValues(t, 0, server.renewals.Load())
+	})
+
+	t.Run("login error", func(t *testing.T) {
+		resetTokenCache(t)
+		server := newMockAuthServer(t, true)
+
+		_, err := loginToken(server.URL, "", &AppRoleAuth{MountPath: "approle", RoleID: "wrong"})
+		assert.ErrorContains(t, err, "cannot log in to Vault with approle@approle:wrong")
+		assert.Empty(t, tokenCache)
+	})
+}
+
+func TestMasterKey_client(t *testing.T) {
+	resetTokenCache(t)
+	server := newMockAuthServer(t, true)
+	t.Setenv(SopsVaultAppRoleSecretIDEnv, "secret-id")
+
+	key := NewMasterKey(server.URL, "transit", "key")
+	key.AuthMethod = AuthMethodAppRole
+	key.AuthRole = "role-id"
+	client, err := key.client()
+	assert.NoError(t, err)
+	assert.Equal(t, "login-token", client.Token())
+
+	// An injected token takes precedence over logging in.
+	Token("injected-token").ApplyToMasterKey(key)
+	client, err = key.client()
+	assert.NoError(t, err)
+	assert.Equal(t, "injected-token", client.Token())
+	assert.EqualValues(t, 1, server.logins.Load())
+}
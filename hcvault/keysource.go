@@ -3,6 +3,7 @@ package hcvault
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -58,17 +60,34 @@ type MasterKey struct {
 	EnginePath string
 	// KeyName is the name of the key in the Vault Transit engine.
 	KeyName string
+	// Namespace is the Vault Enterprise namespace of the Vault Transit
+	// engine, and the auth method. If empty, the default client
+	// configuration is used.
+	Namespace string
 	// EncryptedKey contains the SOPS data key encrypted with the Vault Transit
 	// key.
 	EncryptedKey string
 	// CreationDate of the MasterKey, used to determine if the EncryptedKey
 	// needs rotation.
 	CreationDate time.Time
+	// AuthMethod is the auth method used to log in to obtain a token, e.g.
+	// AuthMethodAppRole. If empty, SopsVaultAuthMethodEnv is used, before
+	// falling back to AuthMethodToken.
+	AuthMethod string
+	// AuthMount is the path the AuthMethod is mounted on. If empty,
+	// SopsVaultAuthMountEnv is used, before falling back to the name of the
+	// AuthMethod.
+	AuthMount string
+	// AuthRole is the role used to log in with the AuthMethod. If empty,
+	// SopsVaultAuthRoleEnv is used.
+	AuthRole string
 
 	// token is the token used for authenticating against the VaultAddress
 	// server. It can be injected by a (local) keyservice.KeyServiceServer
-	// Token.ApplyToMasterKey. If empty, the default client configuration
-	// is used, before falling back to the token stored in defaultTokenFile.
+	// Token.ApplyToMasterKey. If empty, a token is obtained by logging in
+	// with the configured auth method, or else the default client
+	// configuration is used, before falling back to the token stored in
+	// defaultTokenFile.
 	token string
 }
 
@@ -93,7 +112,10 @@ func NewMasterKeysFromURIs(uris string) ([]*MasterKey, error) {
 }
 
 // NewMasterKeyFromURI obtains the Vault address, Transit backend path and the
-// key name from the full URI of the key.
+// key name from the full URI of the key. The namespace can be configured with
+// the "namespace" query parameter, and the auth method with the
+// "auth_method", "auth_mount" and "auth_role" query parameters, e.g.
+// https://vault.example.com:8200/v1/transit/keys/keyName?namespace=team&auth_method=approle&auth_role=role-id.
 func NewMasterKeyFromURI(uri string) (*MasterKey, error) {
 	var key *MasterKey
 	if uri == "" {
@@ -107,12 +129,22 @@ func NewMasterKeyFromURI(uri string) (*MasterKey, error) {
 		return nil, fmt.Errorf("missing scheme in Vault URL (should be like this: +"+
 			"https://vault.example.com:8200/v1/transit/keys/keyName), got: %v", uri)
 	}
+	query := u.Query()
+	u.RawQuery = ""
 	enginePath, keyName, err := engineAndKeyFromPath(u.RequestURI())
 	if err != nil {
 		return nil, err
 	}
 	u.Path = ""
-	return NewMasterKey(u.String(), enginePath, keyName), nil
+	key = NewMasterKey(u.String(), enginePath, keyName)
+	key.Namespace = query.Get("namespace")
+	key.AuthMethod = query.Get("auth_method")
+	key.AuthMount = query.Get("auth_mount")
+	key.AuthRole = query.Get("auth_role")
+	if err := validateAuthMethod(key.AuthMethod); err != nil {
+		return nil, err
+	}
+	return key, nil
 
 }
 
@@ -133,7 +165,7 @@ func NewMasterKey(address, enginePath, keyName string) *MasterKey {
 func (key *MasterKey) Encrypt(dataKey []byte) error {
 	fullPath := key.encryptPath()
 
-	client, err := vaultClient(key.VaultAddress, key.token)
+	client, err := key.client()
 	if err != nil {
 		log.WithField("Path", fullPath).Info("Encryption failed")
 		return err
@@ -178,7 +210,7 @@ func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
 func (key *MasterKey) Decrypt() ([]byte, error) {
 	fullPath := key.decryptPath()
 
-	client, err := vaultClient(key.VaultAddress, key.token)
+	client, err := key.client()
 	if err != nil {
 		log.WithField("Path", fullPath).Info("Decryption failed")
 		return nil, err
@@ -200,14 +232,95 @@ func (key *MasterKey) Decrypt() ([]byte, error) {
 }
 
 // NeedsRotation returns whether the data key needs to be rotated or not.
+// This is the case when the MasterKey is older than vaultTTL, or when the
+// data key was encrypted with an outdated version of the Vault Transit key
+// (see NeedsRewrap).
 func (key *MasterKey) NeedsRotation() bool {
-	// TODO: manage rewrapping https://www.vaultproject.io/api/secret/transit/index.html#rewrap-data
-	return time.Since(key.CreationDate) > (vaultTTL)
+	if time.Since(key.CreationDate) > (vaultTTL) {
+		return true
+	}
+	needsRewrap, err := key.NeedsRewrap()
+	if err != nil {
+		log.WithField("Path", key.keyPath()).WithError(err).Info("Failed to determine key version")
+		return false
+	}
+	return needsRewrap
+}
+
+// NeedsRewrap returns whether the data key was encrypted with a version of
+// the Vault Transit key older than its minimum encryption version or, if
+// that is not set, its latest version.
+func (key *MasterKey) NeedsRewrap() (bool, error) {
+	if key.EncryptedKey == "" {
+		return false, nil
+	}
+	version, err := ciphertextVersion(key.EncryptedKey)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := key.client()
+	if err != nil {
+		return false, err
+	}
+	secret, err := client.Logical().Read(key.keyPath())
+	if err != nil {
+		return false, fmt.Errorf("failed to read Vault transit key '%s': %w", key.keyPath(), err)
+	}
+	wantVersion, err := keyVersionFromSecret(secret)
+	if err != nil {
+		return false, fmt.Errorf("failed to read Vault transit key '%s': %w", key.keyPath(), err)
+	}
+	return version < wantVersion, nil
+}
+
+// Rewrap re-encrypts the EncryptedKey with the latest version of the Vault
+// Transit key, without decrypting it.
+func (key *MasterKey) Rewrap() error {
+	fullPath := key.rewrapPath()
+
+	client, err := key.client()
+	if err != nil {
+		log.WithField("Path", fullPath).Info("Rewrap failed")
+		return err
+	}
+
+	secret, err := client.Logical().Write(fullPath, decryptPayload(key.EncryptedKey))
+	if err != nil {
+		log.WithField("Path", fullPath).Info("Rewrap failed")
+		return fmt.Errorf("failed to rewrap sops data key with Vault transit backend '%s': %w", fullPath, err)
+	}
+	encryptedKey, err := encryptedKeyFromSecret(secret)
+	if err != nil {
+		log.WithField("Path", fullPath).Info("Rewrap failed")
+		return fmt.Errorf("failed to rewrap sops data key with Vault transit backend '%s': %w", fullPath, err)
+	}
+
+	key.EncryptedKey = encryptedKey
+	log.WithField("Path", fullPath).Info("Rewrap successful")
+	return nil
 }
 
 // ToString converts the key to a string representation.
 func (key *MasterKey) ToString() string {
-	return fmt.Sprintf("%s/v1/%s/keys/%s", key.VaultAddress, key.EnginePath, key.KeyName)
+	s := fmt.Sprintf("%s/v1/%s/keys/%s", key.VaultAddress, key.EnginePath, key.KeyName)
+	query := url.Values{}
+	if key.Namespace != "" {
+		query.Set("namespace", key.Namespace)
+	}
+	if key.AuthMethod != "" {
+		query.Set("auth_method", key.AuthMethod)
+	}
+	if key.AuthMount != "" {
+		query.Set("auth_mount", key.AuthMount)
+	}
+	if key.AuthRole != "" {
+		query.Set("auth_role", key.AuthRole)
+	}
+	if len(query) > 0 {
+		s += "?" + query.Encode()
+	}
+	return s
 }
 
 // ToMap converts the MasterKey to a map for serialization purposes.
@@ -216,6 +329,18 @@ func (key MasterKey) ToMap() map[string]interface{} {
 	out["vault_address"] = key.VaultAddress
 	out["key_name"] = key.KeyName
 	out["engine_path"] = key.EnginePath
+	if key.Namespace != "" {
+		out["namespace"] = key.Namespace
+	}
+	if key.AuthMethod != "" {
+		out["auth_method"] = key.AuthMethod
+	}
+	if key.AuthMount != "" {
+		out["auth_mount"] = key.AuthMount
+	}
+	if key.AuthRole != "" {
+		out["auth_role"] = key.AuthRole
+	}
 	out["enc"] = key.EncryptedKey
 	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
 	return out
@@ -226,6 +351,51 @@ func (key *MasterKey) TypeToIdentifier() string {
 	return KeyTypeIdentifier
 }
 
+// client returns a Vault client for the key. If no token has been injected
+// and an auth method is configured, it logs in with it before the client is
+// built.
+func (key *MasterKey) client() (*api.Client, error) {
+	token := key.token
+	if token == "" {
+		auth, err := key.authMethod()
+		if err != nil {
+			return nil, err
+		}
+		if auth != nil {
+			if token, err = loginToken(key.VaultAddress, key.Namespace, auth); err != nil {
+				return nil, err
+			}
+		}
+	}
+	client, err := vaultClient(key.VaultAddress, token)
+	if err != nil {
+		return nil, err
+	}
+	if key.Namespace != "" {
+		client.SetNamespace(key.Namespace)
+	}
+	return client, nil
+}
+
+// authMethod returns the AuthMethod configured on the key, or through the
+// environment. It returns nil if a token should be used instead.
+func (key *MasterKey) authMethod() (AuthMethod, error) {
+	method, mount, role := key.AuthMethod, key.AuthMount, key.AuthRole
+	if method == "" {
+		method = os.Getenv(SopsVaultAuthMethodEnv)
+	}
+	if method == "" || method == AuthMethodToken {
+		return nil, nil
+	}
+	if mount == "" {
+		mount = os.Getenv(SopsVaultAuthMountEnv)
+	}
+	if role == "" {
+		role = os.Getenv(SopsVaultAuthRoleEnv)
+	}
+	return NewAuthMethod(method, mount, role)
+}
+
 // encryptPath returns the path for Encrypt requests.
 func (key *MasterKey) encryptPath() string {
 	return path.Join(key.EnginePath, "encrypt", key.KeyName)
@@ -236,6 +406,16 @@ func (key *MasterKey) decryptPath() string {
 	return path.Join(key.EnginePath, "decrypt", key.KeyName)
 }
 
+// rewrapPath returns the path for Rewrap requests.
+func (key *MasterKey) rewrapPath() string {
+	return path.Join(key.EnginePath, "rewrap", key.KeyName)
+}
+
+// keyPath returns the path to read the Vault Transit key.
+func (key *MasterKey) keyPath() string {
+	return path.Join(key.EnginePath, "keys", key.KeyName)
+}
+
 // encryptPayload returns the payload for an encrypt request of the dataKey.
 func encryptPayload(dataKey []byte) map[string]interface{} {
 	encoded := base64.StdEncoding.EncodeToString(dataKey)
@@ -261,6 +441,60 @@ func encryptedKeyFromSecret(secret *api.Secret) (string, error) {
 	return encryptedKey, nil
 }
 
+// ciphertextVersion returns the version N of the Vault Transit key an
+// encrypted key of the form "vault:vN:..." was encrypted with.
+func ciphertextVersion(encryptedKey string) (int, error) {
+	parts := strings.SplitN(encryptedKey, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0, fmt.Errorf("encrypted data key is not a Vault transit ciphertext")
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Vault transit ciphertext version %q", parts[1])
+	}
+	return version, nil
+}
+
+// keyVersionFromSecret returns the minimum version of the Vault Transit key
+// data keys should be encrypted with, from the data of the provided secret.
+// This is the minimum encryption version of the key if set, or else its
+// latest version.
+func keyVersionFromSecret(secret *api.Secret) (int, error) {
+	if secret == nil || secret.Data == nil {
+		return 0, fmt.Errorf("transit backend is empty")
+	}
+	minVersion, err := intFromData(secret.Data, "min_encryption_version")
+	if err != nil {
+		return 0, err
+	}
+	if minVersion > 0 {
+		return minVersion, nil
+	}
+	return intFromData(secret.Data, "latest_version")
+}
+
+// intFromData returns the integer value of name in data. It returns 0 if
+// data does not contain name.
+func intFromData(data map[string]interface{}, name string) (int, error) {
+	v, ok := data[name]
+	if !ok || v == nil {
+		return 0, nil
+	}
+	switch v := v.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("%s cannot be cast to integer: %w", name, err)
+		}
+		return int(i), nil
+	case float64:
+		return int(v), nil
+	case int:
+		return v, nil
+	}
+	return 0, fmt.Errorf("%s cannot be cast to integer", name)
+}
+
 // decryptPayload returns the payload for a decrypt request of the
 // encryptedKey.
 func decryptPayload(encryptedKey string) map[string]interface{} {
@@ -1,8 +1,11 @@
 package hcvault
 
 import (
+	"encoding/json"
 	"fmt"
 	logger "log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
@@ -146,6 +149,31 @@ func TestNewMasterKeyFromURI(t *testing.T) {
 				KeyName:      "dev",
 			},
 		},
+		{
+			url: "https://vault.example.com:8200/v1/transit/keys/keyName?auth_method=approle&auth_mount=ci&auth_role=role-id",
+			want: &MasterKey{
+				VaultAddress: "https://vault.example.com:8200",
+				EnginePath:   "transit",
+				KeyName:      "keyName",
+				AuthMethod:   "approle",
+				AuthMount:    "ci",
+				AuthRole:     "role-id",
+			},
+		},
+		{
+			url: "https://vault.example.com:8200/v1/transit/keys/keyName?namespace=team%2Fsub",
+			want: &MasterKey{
+				VaultAddress: "https://vault.example.com:8200",
+				EnginePath:   "transit",
+				KeyName:      "keyName",
+				Namespace:    "team/sub",
+			},
+		},
+		{
+			url:     "https://vault.example.com:8200/v1/transit/keys/keyName?auth_method=unknown",
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			url:     "vault.me/keys/dev/mykey",
 			want:    nil,
@@ -284,6 +312,15 @@ func TestMasterKey_NeedsRotation(t *testing.T) {
 func TestMasterKey_ToString(t *testing.T) {
 	key := NewMasterKey("https://example.com", "engine", "key-name")
 	assert.Equal(t, "https://example.com/v1/engine/keys/key-name", key.ToString())
+
+	key.Namespace = "team"
+	key.AuthMethod = AuthMethodKubernetes
+	key.AuthRole = "sops"
+	assert.Equal(t, "https://example.com/v1/engine/keys/key-name?auth_method=kubernetes&auth_role=sops&namespace=team", key.ToString())
+
+	got, err := NewMasterKeyFromURI(key.ToString())
+	assert.NoError(t, err)
+	assert.Equal(t, key.ToString(), got.ToString())
 }
 
 func TestMasterKey_ToMap(t *testing.T) {
@@ -300,6 +337,14 @@ func TestMasterKey_ToMap(t *testing.T) {
 		"enc":           key.EncryptedKey,
 		"created_at":    "0001-01-01T00:00:00Z",
 	}, key.ToMap())
+
+	key.Namespace = "team"
+	key.AuthMethod = AuthMethodAppRole
+	key.AuthRole = "role-id"
+	assert.Equal(t, "team", key.ToMap()["namespace"])
+	assert.Equal(t, AuthMethodAppRole, key.ToMap()["auth_method"])
+	assert.Equal(t, "role-id", key.ToMap()["auth_role"])
+	assert.NotContains(t, key.ToMap(), "auth_mount")
 }
 
 func Test_encryptedKeyFromSecret(t *testing.T) {
@@ -357,6 +402,155 @@ func Test_dataKeyFromSecret(t *testing.T) {
 	}
 }
 
+func Test_ciphertextVersion(t *testing.T) {
+	got, err := ciphertextVersion("vault:v12:c29tZSBjaXBoZXJ0ZXh0")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, got)
+
+	for _, invalid := range []string{"", "some-encrypted-key", "vault:12:data", "vault:vX:data", "other:v1:data"} {
+		_, err := ciphertextVersion(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func Test_keyVersionFromSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  *api.Secret
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "nil secret",
+			secret:  nil,
+			wantErr: true,
+		},
+		{
+			name:   "latest version",
+			secret: &api.Secret{Data: map[string]interface{}{"latest_version": json.Number("3"), "min_encryption_version": json.Number("0")}},
+			want:   3,
+		},
+		{
+			name:   "minimum encryption version",
+			secret: &api.Secret{Data: map[string]interface{}{"latest_version": json.Number("3"), "min_encryption_version": json.Number("2")}},
+			want:   2,
+		},
+		{
+			name:    "invalid version",
+			secret:  &api.Secret{Data: map[string]interface{}{"latest_version": "three"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keyVersionFromSecret(tt.secret)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// newMockTransitServer returns a stub Vault server serving the Transit key
+// "key" mounted on "transit" in the namespace "team", with the given latest
+// and minimum encryption versions. It rewraps any ciphertext to the latest
+// version.
+func newMockTransitServer(t *testing.T, latestVersion, minEncryptionVersion int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/transit/keys/key", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"latest_version":         latestVersion,
+				"min_encryption_version": minEncryptionVersion,
+			},
+		})
+	})
+	mux.HandleFunc("/v1/transit/rewrap/key", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parts := strings.SplitN(body["ciphertext"], ":", 3)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"ciphertext": fmt.Sprintf("vault:v%d:%s", latestVersion, parts[2]),
+			},
+		})
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Namespace") != "team" {
+			http.Error(w, "namespace not found", http.StatusNotFound)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMasterKey_NeedsRewrap(t *testing.T) {
+	t.Setenv(SopsVaultAuthMethodEnv, "")
+	t.Setenv("VAULT_TOKEN", "test-token")
+
+	tests := []struct {
+		name                 string
+		encryptedKey         string
+		latestVersion        int
+		minEncryptionVersion int
+		want                 bool
+	}{
+		{name: "latest version", encryptedKey: "vault:v3:data", latestVersion: 3, want: false},
+		{name: "outdated version", encryptedKey: "vault:v2:data", latestVersion: 3, want: true},
+		{name: "pinned version", encryptedKey: "vault:v2:data", latestVersion: 3, minEncryptionVersion: 2, want: false},
+		{name: "older than pinned version", encryptedKey: "vault:v1:data", latestVersion: 3, minEncryptionVersion: 2, want: true},
+		{name: "not encrypted", encryptedKey: "", latestVersion: 3, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockTransitServer(t, tt.latestVersion, tt.minEncryptionVersion)
+			key := NewMasterKey(server.URL, "transit", "key")
+			key.Namespace = "team"
+			key.EncryptedKey = tt.encryptedKey
+
+			got, err := key.NeedsRewrap()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, key.NeedsRotation())
+		})
+	}
+
+	t.Run("wrong namespace", func(t *testing.T) {
+		server := newMockTransitServer(t, 3, 0)
+		key := NewMasterKey(server.URL, "transit", "key")
+		key.EncryptedKey = "vault:v1:data"
+
+		_, err := key.NeedsRewrap()
+		assert.Error(t, err)
+		assert.False(t, key.NeedsRotation())
+	})
+}
+
+func TestMasterKey_Rewrap(t *testing.T) {
+	t.Setenv(SopsVaultAuthMethodEnv, "")
+	t.Setenv("VAULT_TOKEN", "test-token")
+
+	server := newMockTransitServer(t, 3, 0)
+	key := NewMasterKey(server.URL, "transit", "key")
+	key.Namespace = "team"
+	key.EncryptedKey = "vault:v1:data"
+
+	assert.NoError(t, key.Rewrap())
+	assert.Equal(t, "vault:v3:data", key.EncryptedKey)
+
+	needsRewrap, err := key.NeedsRewrap()
+	assert.NoError(t, err)
+	assert.False(t, needsRewrap)
+}
+
 func Test_vaultClient(t *testing.T) {
 	t.Run("client", func(t *testing.T) {
 		tmpDir := t.TempDir()
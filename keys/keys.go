@@ -12,3 +12,28 @@ type MasterKey interface {
 	ToMap() map[string]interface{}
 	TypeToIdentifier() string
 }
+
+// Rewrapper is implemented by MasterKeys whose encrypted data key can be re-encrypted with the latest version of the
+// key, without the data key being exposed.
+type Rewrapper interface {
+	// NeedsRewrap returns whether the data key was encrypted with an outdated version of the key.
+	NeedsRewrap() (bool, error)
+	// Rewrap re-encrypts the encrypted data key with the latest version of the key.
+	Rewrap() error
+}
+
+// VersionResolver is implemented by MasterKeys which encrypt the data key with a specific version of a key, which can be
+// left unset to use the latest version of the key.
+type VersionResolver interface {
+	// ResolveVersion sets the version of the key to its latest version if it is not set, or if latest is true.
+	ResolveVersion(latest bool) error
+	// SetVersion sets the version of the key, as reported by a key service which encrypted the data key with it.
+	SetVersion(version string)
+}
+
+// DigestAttributer is implemented by MasterKeys with attributes which are not part of ToString, but change how the
+// data key is encrypted, so that they are covered by the MAC of the metadata.
+type DigestAttributer interface {
+	// DigestAttributes returns the attributes which are set, by name.
+	DigestAttributes() map[string]string
+}
@@ -9,11 +9,14 @@ import (
 
 	"github.com/getsops/sops/v3/age"
 	"github.com/getsops/sops/v3/azkv"
+	"github.com/getsops/sops/v3/execplugin"
 	"github.com/getsops/sops/v3/gcpkms"
 	"github.com/getsops/sops/v3/hcvault"
 	"github.com/getsops/sops/v3/keys"
 	"github.com/getsops/sops/v3/kms"
+	"github.com/getsops/sops/v3/passphrase"
 	"github.com/getsops/sops/v3/pgp"
+	"github.com/getsops/sops/v3/pkcs11"
 )
 
 // KeyFromMasterKey converts a SOPS internal MasterKey to an RPC Key that can be serialized with Protocol Buffers
@@ -42,6 +45,10 @@ func KeyFromMasterKey(mk keys.MasterKey) Key {
 					VaultAddress: mk.VaultAddress,
 					EnginePath:   mk.EnginePath,
 					KeyName:      mk.KeyName,
+					Namespace:    mk.Namespace,
+					AuthMethod:   mk.AuthMethod,
+					AuthMount:    mk.AuthMount,
+					AuthRole:     mk.AuthRole,
 				},
 			},
 		}
@@ -53,10 +60,11 @@ func KeyFromMasterKey(mk keys.MasterKey) Key {
 		return Key{
 			KeyType: &Key_KmsKey{
 				KmsKey: &KmsKey{
-					Arn:        mk.Arn,
-					Role:       mk.Role,
-					Context:    ctx,
-					AwsProfile: mk.AwsProfile,
+					Arn:                 mk.Arn,
+					Role:                mk.Role,
+					Context:             ctx,
+					AwsProfile:          mk.AwsProfile,
+					EncryptionAlgorithm: mk.EncryptionAlgorithm,
 				},
 			},
 		}
@@ -78,6 +86,34 @@ func KeyFromMasterKey(mk keys.MasterKey) Key {
 				},
 			},
 		}
+	case *passphrase.MasterKey:
+		return Key{
+			KeyType: &Key_PassphraseKey{
+				PassphraseKey: &PassphraseKey{
+					Name: mk.Name,
+				},
+			},
+		}
+	case *pkcs11.MasterKey:
+		return Key{
+			KeyType: &Key_Pkcs11Key{
+				Pkcs11Key: &Pkcs11Key{
+					ModulePath: mk.ModulePath,
+					TokenLabel: mk.TokenLabel,
+					SlotId:     mk.SlotID,
+					KeyLabel:   mk.KeyLabel,
+				},
+			},
+		}
+	case *execplugin.MasterKey:
+		return Key{
+			KeyType: &Key_ExecPluginKey{
+				ExecPluginKey: &ExecPluginKey{
+					Name:  mk.Name,
+					KeyId: mk.KeyID,
+				},
+			},
+		}
 	default:
 		panic(fmt.Sprintf("Tried to convert unknown MasterKey type %T to keyservice.Key", mk))
 	}
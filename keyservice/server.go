@@ -2,13 +2,17 @@ package keyservice
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/getsops/sops/v3/age"
 	"github.com/getsops/sops/v3/azkv"
+	"github.com/getsops/sops/v3/execplugin"
 	"github.com/getsops/sops/v3/gcpkms"
 	"github.com/getsops/sops/v3/hcvault"
 	"github.com/getsops/sops/v3/kms"
+	"github.com/getsops/sops/v3/passphrase"
 	"github.com/getsops/sops/v3/pgp"
+	"github.com/getsops/sops/v3/pkcs11"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,6 +22,14 @@ import (
 type Server struct {
 	// Prompt indicates whether the server should prompt before decrypting or encrypting data
 	Prompt bool
+	// AllowExecPlugins indicates whether the server may run exec plugins. Clients only name the plugin, whose command
+	// is resolved by the server, but they can still make it run any installed plugin, so it should only be enabled for
+	// trusted clients
+	AllowExecPlugins bool
+	// AllowPkcs11ModulePaths indicates whether the server may load the PKCS#11 module named by a key. As loading a
+	// module runs its code, and module paths are stored in files, it should only be enabled for trusted clients and
+	// files. Otherwise, the module set in the SOPS_PKCS11_MODULE environment variable of the server is used
+	AllowPkcs11ModulePaths bool
 }
 
 func (ks *Server) encryptWithPgp(key *PgpKey, plaintext []byte) ([]byte, error) {
@@ -49,7 +61,7 @@ func (ks *Server) encryptWithGcpKms(key *GcpKmsKey, plaintext []byte) ([]byte, e
 	return []byte(gcpKmsKey.EncryptedKey), nil
 }
 
-func (ks *Server) encryptWithAzureKeyVault(key *AzureKeyVaultKey, plaintext []byte) ([]byte, error) {
+func (ks *Server) encryptWithAzureKeyVault(key *AzureKeyVaultKey, plaintext []byte) ([]byte, string, error) {
 	azkvKey := azkv.MasterKey{
 		VaultURL: key.VaultUrl,
 		Name:     key.Name,
@@ -57,9 +69,9 @@ func (ks *Server) encryptWithAzureKeyVault(key *AzureKeyVaultKey, plaintext []by
 	}
 	err := azkvKey.Encrypt(plaintext)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return []byte(azkvKey.EncryptedKey), nil
+	return []byte(azkvKey.EncryptedKey), azkvKey.Version, nil
 }
 
 func (ks *Server) encryptWithVault(key *VaultKey, plaintext []byte) ([]byte, error) {
@@ -67,6 +79,10 @@ func (ks *Server) encryptWithVault(key *VaultKey, plaintext []byte) ([]byte, err
 		VaultAddress: key.VaultAddress,
 		EnginePath:   key.EnginePath,
 		KeyName:      key.KeyName,
+		Namespace:    key.Namespace,
+		AuthMethod:   key.AuthMethod,
+		AuthMount:    key.AuthMount,
+		AuthRole:     key.AuthRole,
 	}
 	err := vaultKey.Encrypt(plaintext)
 	if err != nil {
@@ -87,6 +103,27 @@ func (ks *Server) encryptWithAge(key *AgeKey, plaintext []byte) ([]byte, error)
 	return []byte(ageKey.EncryptedKey), nil
 }
 
+func (ks *Server) encryptWithPassphrase(key *PassphraseKey, plaintext []byte) ([]byte, error) {
+	passphraseKey := passphrase.MasterKey{
+		Name: key.Name,
+	}
+	if err := passphraseKey.Encrypt(plaintext); err != nil {
+		return nil, err
+	}
+	return []byte(passphraseKey.EncryptedKey), nil
+}
+
+func (ks *Server) encryptWithPkcs11(key *Pkcs11Key, plaintext []byte) ([]byte, error) {
+	pkcs11Key, err := ks.pkcs11KeyToMasterKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := pkcs11Key.Encrypt(plaintext); err != nil {
+		return nil, err
+	}
+	return []byte(pkcs11Key.EncryptedKey), nil
+}
+
 func (ks *Server) decryptWithPgp(key *PgpKey, ciphertext []byte) ([]byte, error) {
 	pgpKey := pgp.NewMasterKeyFromFingerprint(key.Fingerprint)
 	pgpKey.EncryptedKey = string(ciphertext)
@@ -126,6 +163,10 @@ func (ks *Server) decryptWithVault(key *VaultKey, ciphertext []byte) ([]byte, er
 		VaultAddress: key.VaultAddress,
 		EnginePath:   key.EnginePath,
 		KeyName:      key.KeyName,
+		Namespace:    key.Namespace,
+		AuthMethod:   key.AuthMethod,
+		AuthMount:    key.AuthMount,
+		AuthRole:     key.AuthRole,
 	}
 	vaultKey.EncryptedKey = string(ciphertext)
 	plaintext, err := vaultKey.Decrypt()
@@ -141,6 +182,52 @@ func (ks *Server) decryptWithAge(key *AgeKey, ciphertext []byte) ([]byte, error)
 	return []byte(plaintext), err
 }
 
+func (ks *Server) decryptWithPassphrase(key *PassphraseKey, ciphertext []byte) ([]byte, error) {
+	passphraseKey := passphrase.MasterKey{
+		Name: key.Name,
+	}
+	passphraseKey.EncryptedKey = string(ciphertext)
+	plaintext, err := passphraseKey.Decrypt()
+	return []byte(plaintext), err
+}
+
+func (ks *Server) decryptWithPkcs11(key *Pkcs11Key, ciphertext []byte) ([]byte, error) {
+	pkcs11Key, err := ks.pkcs11KeyToMasterKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pkcs11Key.EncryptedKey = string(ciphertext)
+	plaintext, err := pkcs11Key.Decrypt()
+	return []byte(plaintext), err
+}
+
+func (ks *Server) encryptWithExecPlugin(key *ExecPluginKey, plaintext []byte) ([]byte, error) {
+	if !ks.AllowExecPlugins {
+		return nil, status.Errorf(codes.PermissionDenied, "Exec plugin keys are not allowed by this key service, unless it runs with --allow-exec-plugins")
+	}
+	execPluginKey := execplugin.MasterKey{
+		Name:  key.Name,
+		KeyID: key.KeyId,
+	}
+	if err := execPluginKey.Encrypt(plaintext); err != nil {
+		return nil, err
+	}
+	return []byte(execPluginKey.EncryptedKey), nil
+}
+
+func (ks *Server) decryptWithExecPlugin(key *ExecPluginKey, ciphertext []byte) ([]byte, error) {
+	if !ks.AllowExecPlugins {
+		return nil, status.Errorf(codes.PermissionDenied, "Exec plugin keys are not allowed by this key service, unless it runs with --allow-exec-plugins")
+	}
+	execPluginKey := execplugin.MasterKey{
+		Name:  key.Name,
+		KeyID: key.KeyId,
+	}
+	execPluginKey.EncryptedKey = string(ciphertext)
+	plaintext, err := execPluginKey.Decrypt()
+	return []byte(plaintext), err
+}
+
 // Encrypt takes an encrypt request and encrypts the provided plaintext with the provided key, returning the encrypted
 // result
 func (ks Server) Encrypt(ctx context.Context,
@@ -173,12 +260,13 @@ func (ks Server) Encrypt(ctx context.Context,
 			Ciphertext: ciphertext,
 		}
 	case *Key_AzureKeyvaultKey:
-		ciphertext, err := ks.encryptWithAzureKeyVault(k.AzureKeyvaultKey, req.Plaintext)
+		ciphertext, version, err := ks.encryptWithAzureKeyVault(k.AzureKeyvaultKey, req.Plaintext)
 		if err != nil {
 			return nil, err
 		}
 		response = &EncryptResponse{
 			Ciphertext: ciphertext,
+			KeyVersion: version,
 		}
 	case *Key_VaultKey:
 		ciphertext, err := ks.encryptWithVault(k.VaultKey, req.Plaintext)
@@ -196,6 +284,30 @@ func (ks Server) Encrypt(ctx context.Context,
 		response = &EncryptResponse{
 			Ciphertext: ciphertext,
 		}
+	case *Key_PassphraseKey:
+		ciphertext, err := ks.encryptWithPassphrase(k.PassphraseKey, req.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		response = &EncryptResponse{
+			Ciphertext: ciphertext,
+		}
+	case *Key_Pkcs11Key:
+		ciphertext, err := ks.encryptWithPkcs11(k.Pkcs11Key, req.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		response = &EncryptResponse{
+			Ciphertext: ciphertext,
+		}
+	case *Key_ExecPluginKey:
+		ciphertext, err := ks.encryptWithExecPlugin(k.ExecPluginKey, req.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		response = &EncryptResponse{
+			Ciphertext: ciphertext,
+		}
 	case nil:
 		return nil, status.Errorf(codes.NotFound, "Must provide a key")
 	default:
@@ -222,6 +334,12 @@ func keyToString(key *Key) string {
 		return fmt.Sprintf("Azure Key Vault key with URL %s/keys/%s/%s", k.AzureKeyvaultKey.VaultUrl, k.AzureKeyvaultKey.Name, k.AzureKeyvaultKey.Version)
 	case *Key_VaultKey:
 		return fmt.Sprintf("Hashicorp Vault key with URI %s/v1/%s/keys/%s", k.VaultKey.VaultAddress, k.VaultKey.EnginePath, k.VaultKey.KeyName)
+	case *Key_PassphraseKey:
+		return fmt.Sprintf("passphrase key with name %s", k.PassphraseKey.Name)
+	case *Key_Pkcs11Key:
+		return fmt.Sprintf("PKCS#11 key with label %s", k.Pkcs11Key.KeyLabel)
+	case *Key_ExecPluginKey:
+		return fmt.Sprintf("exec plugin key with name %s", k.ExecPluginKey.Name)
 	default:
 		return "Unknown key type"
 	}
@@ -298,6 +416,30 @@ func (ks Server) Decrypt(ctx context.Context,
 		response = &DecryptResponse{
 			Plaintext: plaintext,
 		}
+	case *Key_PassphraseKey:
+		plaintext, err := ks.decryptWithPassphrase(k.PassphraseKey, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		response = &DecryptResponse{
+			Plaintext: plaintext,
+		}
+	case *Key_Pkcs11Key:
+		plaintext, err := ks.decryptWithPkcs11(k.Pkcs11Key, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		response = &DecryptResponse{
+			Plaintext: plaintext,
+		}
+	case *Key_ExecPluginKey:
+		plaintext, err := ks.decryptWithExecPlugin(k.ExecPluginKey, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		response = &DecryptResponse{
+			Plaintext: plaintext,
+		}
 	case nil:
 		return nil, status.Errorf(codes.NotFound, "Must provide a key")
 	default:
@@ -319,9 +461,26 @@ func kmsKeyToMasterKey(key *KmsKey) kms.MasterKey {
 		ctx[k] = &value
 	}
 	return kms.MasterKey{
-		Arn:               key.Arn,
-		Role:              key.Role,
-		EncryptionContext: ctx,
-		AwsProfile:        key.AwsProfile,
+		Arn:                 key.Arn,
+		Role:                key.Role,
+		EncryptionContext:   ctx,
+		AwsProfile:          key.AwsProfile,
+		EncryptionAlgorithm: key.EncryptionAlgorithm,
+	}
+}
+
+func (ks *Server) pkcs11KeyToMasterKey(key *Pkcs11Key) (pkcs11.MasterKey, error) {
+	modulePath := key.ModulePath
+	if modulePath != "" && !ks.AllowPkcs11ModulePaths {
+		if os.Getenv(pkcs11.SopsPkcs11ModuleEnv) == "" {
+			return pkcs11.MasterKey{}, status.Errorf(codes.PermissionDenied, "PKCS#11 module path %q is not allowed by this key service: set %s, or allow module paths", modulePath, pkcs11.SopsPkcs11ModuleEnv)
+		}
+		modulePath = ""
 	}
+	return pkcs11.MasterKey{
+		ModulePath: modulePath,
+		TokenLabel: key.TokenLabel,
+		SlotID:     key.SlotId,
+		KeyLabel:   key.KeyLabel,
+	}, nil
 }
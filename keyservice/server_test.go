@@ -4,6 +4,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
+
+	"github.com/getsops/sops/v3/pkcs11"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestKmsKeyToMasterKey(t *testing.T) {
@@ -14,6 +18,7 @@ func TestKmsKeyToMasterKey(t *testing.T) {
 		expectedRole       string
 		expectedCtx        map[string]string
 		expectedAwsProfile string
+		expectedAlgorithm  string
 	}{
 		{
 			description:        "empty context",
@@ -42,6 +47,14 @@ func TestKmsKeyToMasterKey(t *testing.T) {
 			},
 			expectedAwsProfile: "",
 		},
+		{
+			description:        "asymmetric key",
+			expectedArn:        "arn:aws:kms:eu-west-1:123456789012:key/d5c90a06-f824-4628-922b-12424571ed4d",
+			expectedRole:       "",
+			expectedCtx:        map[string]string{},
+			expectedAwsProfile: "",
+			expectedAlgorithm:  "RSAES_OAEP_SHA_256",
+		},
 	}
 
 	for _, c := range cases {
@@ -54,10 +67,11 @@ func TestKmsKeyToMasterKey(t *testing.T) {
 			}
 
 			key := &KmsKey{
-				Arn:        c.expectedArn,
-				Role:       c.expectedRole,
-				Context:    inputCtx,
-				AwsProfile: c.expectedAwsProfile,
+				Arn:                 c.expectedArn,
+				Role:                c.expectedRole,
+				Context:             inputCtx,
+				AwsProfile:          c.expectedAwsProfile,
+				EncryptionAlgorithm: c.expectedAlgorithm,
 			}
 
 			masterKey := kmsKeyToMasterKey(key)
@@ -76,6 +90,82 @@ func TestKmsKeyToMasterKey(t *testing.T) {
 			assert.Equalf(t, c.expectedArn, masterKey.Arn, "Expected ARN to be '%s', but found '%s'", c.expectedArn, masterKey.Arn)
 			assert.Equalf(t, c.expectedRole, masterKey.Role, "Expected Role to be '%s', but found '%s'", c.expectedRole, masterKey.Role)
 			assert.Equalf(t, c.expectedAwsProfile, masterKey.AwsProfile, "Expected AWS profile to be '%s', but found '%s'", c.expectedAwsProfile, masterKey.AwsProfile)
+			assert.Equalf(t, c.expectedAlgorithm, masterKey.EncryptionAlgorithm, "Expected encryption algorithm to be '%s', but found '%s'", c.expectedAlgorithm, masterKey.EncryptionAlgorithm)
 		})
 	}
 }
+
+func TestPassphraseEncryptDecrypt(t *testing.T) {
+	t.Setenv("SOPS_PASSPHRASE", "correct horse battery staple")
+
+	ks := Server{}
+	key := &Key{
+		KeyType: &Key_PassphraseKey{
+			PassphraseKey: &PassphraseKey{Name: "break-glass"},
+		},
+	}
+	encrypted, err := ks.Encrypt(nil, &EncryptRequest{Key: key, Plaintext: []byte("data")})
+	require.NoError(t, err)
+	assert.NotEmpty(t, encrypted.Ciphertext)
+
+	decrypted, err := ks.Decrypt(nil, &DecryptRequest{Key: key, Ciphertext: encrypted.Ciphertext})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), decrypted.Plaintext)
+	assert.Equal(t, "passphrase key with name break-glass", keyToString(key))
+}
+
+func TestPkcs11KeyFromMasterKey(t *testing.T) {
+	mk, err := pkcs11.NewMasterKeyFromURI("pkcs11:token=sops;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so")
+	require.NoError(t, err)
+
+	key := KeyFromMasterKey(mk)
+	k, ok := key.KeyType.(*Key_Pkcs11Key)
+	require.True(t, ok)
+	assert.Equal(t, "/usr/lib/softhsm/libsofthsm2.so", k.Pkcs11Key.ModulePath)
+	assert.Equal(t, "sops", k.Pkcs11Key.TokenLabel)
+	assert.Empty(t, k.Pkcs11Key.SlotId)
+	assert.Equal(t, "data-key", k.Pkcs11Key.KeyLabel)
+	assert.Equal(t, "PKCS#11 key with label data-key", keyToString(&key))
+}
+
+func TestPkcs11ModulePathsNotAllowed(t *testing.T) {
+	ks := Server{}
+	key := &Key{
+		KeyType: &Key_Pkcs11Key{
+			Pkcs11Key: &Pkcs11Key{ModulePath: "/tmp/evil.so", TokenLabel: "sops", KeyLabel: "data-key"},
+		},
+	}
+	_, err := ks.Encrypt(nil, &EncryptRequest{Key: key, Plaintext: []byte("data")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = ks.Decrypt(nil, &DecryptRequest{Key: key, Ciphertext: []byte("ZGF0YQ==")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The module set in the environment of the server is used instead.
+	t.Setenv(pkcs11.SopsPkcs11ModuleEnv, "/usr/lib/softhsm/libsofthsm2.so")
+	mk, err := ks.pkcs11KeyToMasterKey(key.GetPkcs11Key())
+	assert.NoError(t, err)
+	assert.Empty(t, mk.ModulePath)
+
+	mk, err = (&Server{AllowPkcs11ModulePaths: true}).pkcs11KeyToMasterKey(key.GetPkcs11Key())
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/evil.so", mk.ModulePath)
+}
+
+func TestExecPluginKeysNotAllowed(t *testing.T) {
+	ks := Server{}
+	key := &Key{
+		KeyType: &Key_ExecPluginKey{
+			ExecPluginKey: &ExecPluginKey{Name: "my-custodian", KeyId: "my-key"},
+		},
+	}
+	_, err := ks.Encrypt(nil, &EncryptRequest{Key: key, Plaintext: []byte("data")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = ks.Decrypt(nil, &DecryptRequest{Key: key, Ciphertext: []byte("ZGF0YQ==")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, "exec plugin key with name my-custodian", keyToString(key))
+
+	_, err = NewLocalClient().Encrypt(nil, &EncryptRequest{Key: key, Plaintext: []byte("data")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -7,9 +7,17 @@ package kms // import "github.com/getsops/sops/v3/kms"
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"hash"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
@@ -19,6 +27,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/sirupsen/logrus"
 
@@ -38,6 +47,15 @@ const (
 	kmsTTL = time.Hour * 24 * 30 * 6
 	// KeyTypeIdentifier is the string used to identify an AWS KMS MasterKey.
 	KeyTypeIdentifier = "kms"
+	// SopsKMSMultiRegionReplicasEnv can be set to a comma separated list of
+	// AWS regions to attempt to decrypt with when decrypting with a
+	// multi-Region key fails in the region of its ARN. If not set, the region
+	// of the AWS configuration is attempted.
+	SopsKMSMultiRegionReplicasEnv = "SOPS_KMS_MRK_REPLICA_REGIONS"
+	// SopsKMSPublicKeyCacheDirEnv can be set to the directory in which the
+	// public keys of asymmetric AWS KMS keys are cached. If not set, a "sops/kms"
+	// directory in the user cache directory is used.
+	SopsKMSPublicKeyCacheDirEnv = "SOPS_KMS_PUBLIC_KEY_CACHE_DIR"
 )
 
 var (
@@ -45,6 +63,11 @@ var (
 	log *logrus.Logger
 	// osHostname returns the hostname as reported by the kernel.
 	osHostname = os.Hostname
+	// arnRe is the compiled arnRegex.
+	arnRe = regexp.MustCompile(arnRegex)
+	// cacheNameRe matches the characters which are replaced by '_' in the
+	// names of public key cache files.
+	cacheNameRe = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
 )
 
 func init() {
@@ -68,6 +91,12 @@ type MasterKey struct {
 	// AwsProfile is the profile to use for loading configuration and credentials.
 	// Ref: https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk/#specifying-profiles
 	AwsProfile string
+	// EncryptionAlgorithm is the AWS KMS encryption algorithm to use. When
+	// empty or "SYMMETRIC_DEFAULT", the key is a symmetric key. When
+	// "RSAES_OAEP_SHA_1" or "RSAES_OAEP_SHA_256", the key is an asymmetric RSA
+	// key, and the data key is encrypted locally with its (cached) public key.
+	// Ref: https://docs.aws.amazon.com/kms/latest/developerguide/asymmetric-key-specs.html#key-spec-rsa
+	EncryptionAlgorithm string
 
 	// credentialsProvider is used to configure the AWS client config with
 	// credentials. It can be injected by a (local) keyservice.KeyServiceServer
@@ -79,6 +108,10 @@ type MasterKey struct {
 	// injected using e.g. an environment variable. The field is not publicly
 	// exposed, nor configurable.
 	baseEndpoint string
+	// regionEndpoints can be used to override the endpoint the AWS client
+	// resolves to for specific regions, taking precedence over baseEndpoint.
+	// Like baseEndpoint, it is only used for testing purposes.
+	regionEndpoints map[string]string
 }
 
 // NewMasterKey creates a new MasterKey from an ARN, role and context, setting
@@ -235,7 +268,12 @@ func (c CredentialsProvider) ApplyToMasterKey(key *MasterKey) {
 
 // Encrypt takes a SOPS data key, encrypts it with KMS and stores the result
 // in the EncryptedKey field.
+// For an asymmetric key, the data key is encrypted locally with the public key
+// of the KMS key, which is retrieved from AWS KMS once and then cached.
 func (key *MasterKey) Encrypt(dataKey []byte) error {
+	if key.isAsymmetric() {
+		return key.encryptAsymmetric(dataKey)
+	}
 	cfg, err := key.createKMSConfig()
 	if err != nil {
 		log.WithField("arn", key.Arn).Info("Encryption failed")
@@ -257,6 +295,76 @@ func (key *MasterKey) Encrypt(dataKey []byte) error {
 	return nil
 }
 
+// encryptAsymmetric encrypts the SOPS data key with the public key of the
+// asymmetric KMS key, and stores the result in the EncryptedKey field.
+func (key *MasterKey) encryptAsymmetric(dataKey []byte) error {
+	if len(key.EncryptionContext) > 0 {
+		log.WithField("arn", key.Arn).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with AWS KMS: encryption context is not supported by asymmetric keys")
+	}
+	h, err := oaepHash(key.EncryptionAlgorithm)
+	if err != nil {
+		log.WithField("arn", key.Arn).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with AWS KMS: %w", err)
+	}
+	publicKey, err := key.publicKey()
+	if err != nil {
+		log.WithField("arn", key.Arn).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with AWS KMS: %w", err)
+	}
+	ciphertext, err := rsa.EncryptOAEP(h, rand.Reader, publicKey, dataKey, nil)
+	if err != nil {
+		log.WithField("arn", key.Arn).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with AWS KMS public key: %w", err)
+	}
+	key.EncryptedKey = base64.StdEncoding.EncodeToString(ciphertext)
+	log.WithField("arn", key.Arn).Info("Encryption succeeded")
+	return nil
+}
+
+// publicKey returns the RSA public key of the asymmetric KMS key. It is read
+// from the cache if present, or else retrieved from AWS KMS and written to the
+// cache. Public keys are cached per encryption algorithm, and only once AWS
+// KMS reported that the key supports it, so that cached keys need no further
+// validation.
+func (key *MasterKey) publicKey() (*rsa.PublicKey, error) {
+	cachePath, cacheErr := publicKeyCachePath(key.Arn, key.EncryptionAlgorithm)
+	if cacheErr == nil {
+		if der, err := os.ReadFile(cachePath); err == nil {
+			log.WithField("arn", key.Arn).Debug("Using cached public key")
+			return parseRSAPublicKey(der)
+		}
+	}
+
+	cfg, err := key.createKMSConfig()
+	if err != nil {
+		return nil, err
+	}
+	client := key.createClient(cfg)
+	out, err := client.GetPublicKey(context.TODO(), &kms.GetPublicKeyInput{KeyId: &key.Arn})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	if !supportsEncryptionAlgorithm(out.EncryptionAlgorithms, key.EncryptionAlgorithm) {
+		return nil, fmt.Errorf("key does not support encryption algorithm %q", key.EncryptionAlgorithm)
+	}
+	publicKey, err := parseRSAPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheErr == nil {
+		cacheErr = os.MkdirAll(filepath.Dir(cachePath), 0o700)
+		if cacheErr == nil {
+			cacheErr = os.WriteFile(cachePath, out.PublicKey, 0o600)
+		}
+	}
+	if cacheErr != nil {
+		log.WithField("arn", key.Arn).Warnf("Failed to cache public key: %s", cacheErr)
+	}
+	return publicKey, nil
+}
+
 // EncryptIfNeeded encrypts the provided SOPS data key, if it has not been
 // encrypted yet.
 func (key *MasterKey) EncryptIfNeeded(dataKey []byte) error {
@@ -278,32 +386,119 @@ func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
 
 // Decrypt decrypts the EncryptedKey with a newly created AWS KMS config, and
 // returns the result.
+// If the key is a multi-Region key and decryption fails in the region of its
+// ARN, decryption is attempted with the replicas of the key in the regions
+// returned by replicaRegions.
 func (key *MasterKey) Decrypt() ([]byte, error) {
 	k, err := base64.StdEncoding.DecodeString(key.EncryptedKey)
 	if err != nil {
 		log.WithField("arn", key.Arn).Info("Decryption failed")
 		return nil, fmt.Errorf("error base64-decoding encrypted data key: %s", err)
 	}
+	decrypted, err := key.decrypt(k)
+	if err == nil {
+		log.WithField("arn", key.Arn).Info("Decryption succeeded")
+		return decrypted, nil
+	}
+	log.WithField("arn", key.Arn).Info("Decryption failed")
+	if !key.isMultiRegion() {
+		return nil, err
+	}
+
+	errs := []error{err}
+	for _, region := range key.replicaRegions() {
+		replica := key.replica(region)
+		decrypted, err := replica.decrypt(k)
+		if err != nil {
+			log.WithField("arn", replica.Arn).Info("Decryption with multi-Region key replica failed")
+			errs = append(errs, err)
+			continue
+		}
+		log.WithField("arn", replica.Arn).Info("Decryption with multi-Region key replica succeeded")
+		return decrypted, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// decrypt decrypts the ciphertext with AWS KMS in the region of the ARN of the
+// key.
+func (key *MasterKey) decrypt(ciphertext []byte) ([]byte, error) {
 	cfg, err := key.createKMSConfig()
 	if err != nil {
-		log.WithField("arn", key.Arn).Info("Decryption failed")
 		return nil, err
 	}
 	client := key.createClient(cfg)
 	input := &kms.DecryptInput{
-		KeyId:             &key.Arn,
-		CiphertextBlob:    k,
-		EncryptionContext: stringPointerToStringMap(key.EncryptionContext),
+		KeyId:          &key.Arn,
+		CiphertextBlob: ciphertext,
+	}
+	if key.isAsymmetric() {
+		input.EncryptionAlgorithm = types.EncryptionAlgorithmSpec(key.EncryptionAlgorithm)
+	} else {
+		input.EncryptionContext = stringPointerToStringMap(key.EncryptionContext)
 	}
 	decrypted, err := client.Decrypt(context.TODO(), input)
 	if err != nil {
-		log.WithField("arn", key.Arn).Info("Decryption failed")
-		return nil, fmt.Errorf("failed to decrypt sops data key with AWS KMS: %w", err)
+		return nil, fmt.Errorf("failed to decrypt sops data key with AWS KMS key '%s': %w", key.Arn, err)
 	}
-	log.WithField("arn", key.Arn).Info("Decryption succeeded")
 	return decrypted.Plaintext, nil
 }
 
+// isAsymmetric returns whether the key is an asymmetric key, based on its
+// EncryptionAlgorithm.
+func (key *MasterKey) isAsymmetric() bool {
+	return key.EncryptionAlgorithm != "" &&
+		key.EncryptionAlgorithm != string(types.EncryptionAlgorithmSpecSymmetricDefault)
+}
+
+// isMultiRegion returns whether the ARN of the key refers to a multi-Region
+// key.
+func (key *MasterKey) isMultiRegion() bool {
+	return strings.Contains(key.Arn, ":key/mrk-")
+}
+
+// replicaRegions returns the regions in which decryption with a replica of a
+// multi-Region key is attempted. These are the regions configured with
+// SopsKMSMultiRegionReplicasEnv, or the region of the AWS configuration. The
+// region of the ARN of the key is never included.
+func (key *MasterKey) replicaRegions() []string {
+	var candidates []string
+	if env := os.Getenv(SopsKMSMultiRegionReplicasEnv); env != "" {
+		candidates = strings.Split(env, ",")
+	} else {
+		cfg, err := config.LoadDefaultConfig(context.TODO(), func(lo *config.LoadOptions) error {
+			if key.AwsProfile != "" {
+				lo.SharedConfigProfile = key.AwsProfile
+			}
+			return nil
+		})
+		if err == nil && cfg.Region != "" {
+			candidates = []string{cfg.Region}
+		}
+	}
+
+	primary := regionFromArn(key.Arn)
+	var regions []string
+	for _, region := range candidates {
+		region = strings.TrimSpace(region)
+		if region == "" || region == primary {
+			continue
+		}
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+// replica returns a copy of the key, with the region of its ARN replaced by
+// the given region.
+func (key *MasterKey) replica(region string) *MasterKey {
+	replica := *key
+	if primary := regionFromArn(key.Arn); primary != "" {
+		replica.Arn = strings.Replace(key.Arn, ":"+primary+":", ":"+region+":", 1)
+	}
+	return &replica
+}
+
 // NeedsRotation returns whether the data key needs to be rotated or not.
 func (key *MasterKey) NeedsRotation() bool {
 	return time.Since(key.CreationDate) > kmsTTL
@@ -334,6 +529,9 @@ func (key MasterKey) ToMap() map[string]interface{} {
 	}
 	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
 	out["enc"] = key.EncryptedKey
+	if key.EncryptionAlgorithm != "" {
+		out["encryption_algorithm"] = key.EncryptionAlgorithm
+	}
 	if key.EncryptionContext != nil {
 		outcontext := make(map[string]string)
 		for k, v := range key.EncryptionContext {
@@ -344,6 +542,16 @@ func (key MasterKey) ToMap() map[string]interface{} {
 	return out
 }
 
+// DigestAttributes returns the encryption algorithm of the key if it is set, as
+// it is not part of ToString.
+func (key *MasterKey) DigestAttributes() map[string]string {
+	attributes := make(map[string]string)
+	if key.EncryptionAlgorithm != "" {
+		attributes["encryption_algorithm"] = key.EncryptionAlgorithm
+	}
+	return attributes
+}
+
 // TypeToIdentifier returns the string identifier for the MasterKey type.
 func (key *MasterKey) TypeToIdentifier() string {
 	return KeyTypeIdentifier
@@ -352,12 +560,10 @@ func (key *MasterKey) TypeToIdentifier() string {
 // createKMSConfig returns an AWS config with the credentialsProvider of the
 // MasterKey, or the default configuration sources.
 func (key MasterKey) createKMSConfig() (*aws.Config, error) {
-	re := regexp.MustCompile(arnRegex)
-	matches := re.FindStringSubmatch(key.Arn)
-	if matches == nil {
+	region := regionFromArn(key.Arn)
+	if region == "" {
 		return nil, fmt.Errorf("no valid ARN found in '%s'", key.Arn)
 	}
-	region := matches[1]
 
 	cfg, err := config.LoadDefaultConfig(context.TODO(), func(lo *config.LoadOptions) error {
 		// Use the credentialsProvider if present, otherwise default to reading credentials
@@ -384,7 +590,9 @@ func (key MasterKey) createKMSConfig() (*aws.Config, error) {
 // createClient creates a new AWS KMS client with the provided config.
 func (key MasterKey) createClient(config *aws.Config) *kms.Client {
 	return kms.NewFromConfig(*config, func(o *kms.Options) {
-		if key.baseEndpoint != "" {
+		if endpoint, ok := key.regionEndpoints[config.Region]; ok {
+			o.BaseEndpoint = aws.String(endpoint)
+		} else if key.baseEndpoint != "" {
 			o.BaseEndpoint = aws.String(key.baseEndpoint)
 		}
 	})
@@ -444,3 +652,68 @@ func stringPointerToStringMap(in map[string]*string) map[string]string {
 	}
 	return out
 }
+
+// regionFromArn returns the region of an AWS KMS ARN, or an empty string if
+// the ARN is not valid.
+func regionFromArn(arn string) string {
+	matches := arnRe.FindStringSubmatch(arn)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
+
+// oaepHash returns the hash function used for RSA-OAEP by the given AWS KMS
+// encryption algorithm.
+func oaepHash(algorithm string) (hash.Hash, error) {
+	switch types.EncryptionAlgorithmSpec(algorithm) {
+	case types.EncryptionAlgorithmSpecRsaesOaepSha1:
+		return sha1.New(), nil
+	case types.EncryptionAlgorithmSpecRsaesOaepSha256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", algorithm)
+	}
+}
+
+// supportsEncryptionAlgorithm returns whether algorithm is in algorithms.
+func supportsEncryptionAlgorithm(algorithms []types.EncryptionAlgorithmSpec, algorithm string) bool {
+	for _, a := range algorithms {
+		if string(a) == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRSAPublicKey parses a DER encoded X.509 public key, as returned by AWS
+// KMS, and returns it if it is an RSA public key.
+func parseRSAPublicKey(der []byte) (*rsa.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an RSA public key")
+	}
+	return rsaPub, nil
+}
+
+// publicKeyCachePath returns the path of the file in which the public key of
+// the AWS KMS key with the given ARN is cached for the given encryption
+// algorithm. The name of the file is the ARN and the algorithm separated by a
+// '.', with any character other than letters, digits, '.', '_' and '-'
+// replaced by '_', and a ".der" extension.
+func publicKeyCachePath(arn, algorithm string) (string, error) {
+	dir := os.Getenv(SopsKMSPublicKeyCacheDirEnv)
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cacheDir, "sops", "kms")
+	}
+	name := cacheNameRe.ReplaceAllString(arn+"."+algorithm, "_")
+	return filepath.Join(dir, name+".der"), nil
+}
@@ -2,9 +2,14 @@ package kms
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	logger "log"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/ory/dockertest/v3"
 	"github.com/stretchr/testify/assert"
 )
@@ -274,6 +280,71 @@ func TestMasterKey_Encrypt(t *testing.T) {
 	})
 }
 
+func TestMasterKey_Encrypt_Asymmetric(t *testing.T) {
+	t.Run("encrypt", func(t *testing.T) {
+		t.Setenv(SopsKMSPublicKeyCacheDirEnv, t.TempDir())
+		key := createTestMasterKey(createTestAsymmetricKey(t))
+		key.EncryptionAlgorithm = string(types.EncryptionAlgorithmSpecRsaesOaepSha256)
+		dataKey := []byte("UFO sightings")
+		assert.NoError(t, key.Encrypt(dataKey))
+		assert.NotEmpty(t, key.EncryptedKey)
+
+		cachePath, err := publicKeyCachePath(key.Arn, key.EncryptionAlgorithm)
+		assert.NoError(t, err)
+		assert.FileExists(t, cachePath)
+
+		got, err := key.Decrypt()
+		assert.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+	})
+
+	t.Run("encrypt offline with cached public key", func(t *testing.T) {
+		t.Setenv(SopsKMSPublicKeyCacheDirEnv, t.TempDir())
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		assert.NoError(t, err)
+		cachePath, err := publicKeyCachePath(dummyARN, string(types.EncryptionAlgorithmSpecRsaesOaepSha256))
+		assert.NoError(t, err)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(cachePath), 0o700))
+		assert.NoError(t, os.WriteFile(cachePath, der, 0o600))
+
+		key := createTestMasterKey(dummyARN)
+		key.baseEndpoint = unreachableEndpoint()
+		key.EncryptionAlgorithm = string(types.EncryptionAlgorithmSpecRsaesOaepSha256)
+		dataKey := []byte("UFO sightings")
+		assert.NoError(t, key.Encrypt(dataKey))
+
+		ciphertext, err := base64.StdEncoding.DecodeString(key.EncryptedKey)
+		assert.NoError(t, err)
+		got, err := rsa.DecryptOAEP(sha256.New(), nil, privateKey, ciphertext, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+
+		// The key is not cached for the other algorithm, which it may not
+		// support, so it has to be retrieved from AWS KMS.
+		key.EncryptionAlgorithm = string(types.EncryptionAlgorithmSpecRsaesOaepSha1)
+		assert.ErrorContains(t, key.Encrypt(dataKey), "failed to get public key")
+	})
+
+	t.Run("encryption context error", func(t *testing.T) {
+		key := createTestMasterKey(dummyARN)
+		key.EncryptionAlgorithm = string(types.EncryptionAlgorithmSpecRsaesOaepSha256)
+		key.EncryptionContext = map[string]*string{"foo": aws.String("bar")}
+		err := key.Encrypt([]byte("UFO sightings"))
+		assert.ErrorContains(t, err, "encryption context is not supported by asymmetric keys")
+		assert.Empty(t, key.EncryptedKey)
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		key := createTestMasterKey(dummyARN)
+		key.EncryptionAlgorithm = string(types.EncryptionAlgorithmSpecSm2pke)
+		err := key.Encrypt([]byte("UFO sightings"))
+		assert.ErrorContains(t, err, `unsupported encryption algorithm "SM2PKE"`)
+		assert.Empty(t, key.EncryptedKey)
+	})
+}
+
 func TestMasterKey_EncryptIfNeeded(t *testing.T) {
 	key := createTestMasterKey(testKMSARN)
 	assert.NoError(t, key.EncryptIfNeeded([]byte("data")))
@@ -343,6 +414,83 @@ func TestMasterKey_Decrypt(t *testing.T) {
 	})
 }
 
+func TestMasterKey_Decrypt_MultiRegion(t *testing.T) {
+	kmsClient, err := createTestKMSClient(createTestMasterKey(testKMSARN))
+	assert.NoError(t, err)
+	out, err := kmsClient.CreateKey(context.TODO(), &kms.CreateKeyInput{MultiRegion: aws.Bool(true)})
+	assert.NoError(t, err)
+	replicaArn := *out.KeyMetadata.Arn
+	replicaRegion := regionFromArn(replicaArn)
+
+	dataKey := []byte("it's always DNS")
+	encrypted, err := kmsClient.Encrypt(context.TODO(), &kms.EncryptInput{KeyId: &replicaArn, Plaintext: dataKey})
+	assert.NoError(t, err)
+
+	// The "primary" key is in another region, which is unreachable.
+	key := createTestMasterKey(replicaArn)
+	key = *key.replica("antarctica-north-2")
+	key.regionEndpoints = map[string]string{"antarctica-north-2": unreachableEndpoint()}
+	key.EncryptedKey = base64.StdEncoding.EncodeToString(encrypted.CiphertextBlob)
+
+	t.Run("fallback to replica", func(t *testing.T) {
+		t.Setenv(SopsKMSMultiRegionReplicasEnv, "us-west-1,"+replicaRegion)
+		got, err := key.Decrypt()
+		assert.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+	})
+
+	t.Run("no replica", func(t *testing.T) {
+		t.Setenv(SopsKMSMultiRegionReplicasEnv, "antarctica-north-2")
+		got, err := key.Decrypt()
+		assert.ErrorContains(t, err, "failed to decrypt sops data key with AWS KMS")
+		assert.Nil(t, got)
+	})
+}
+
+func TestMasterKey_replicaRegions(t *testing.T) {
+	key := NewMasterKeyFromArn("arn:aws:kms:us-east-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab", nil, "")
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv(SopsKMSMultiRegionReplicasEnv, "eu-west-1, us-east-1,,ap-south-1")
+		assert.Equal(t, []string{"eu-west-1", "ap-south-1"}, key.replicaRegions())
+	})
+
+	t.Run("AWS config region", func(t *testing.T) {
+		t.Setenv(SopsKMSMultiRegionReplicasEnv, "")
+		t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+		t.Setenv("AWS_REGION", "eu-central-1")
+		assert.Equal(t, []string{"eu-central-1"}, key.replicaRegions())
+
+		t.Setenv("AWS_REGION", "us-east-1")
+		assert.Empty(t, key.replicaRegions())
+	})
+}
+
+func TestMasterKey_replica(t *testing.T) {
+	key := NewMasterKeyFromArn("arn:aws:kms:us-east-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab+arn:aws:iam::111122223333:role/sops", nil, "profile")
+	key.EncryptedKey = "encrypted"
+
+	replica := key.replica("eu-west-1")
+	assert.Equal(t, "arn:aws:kms:eu-west-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab", replica.Arn)
+	assert.Equal(t, key.Role, replica.Role)
+	assert.Equal(t, key.AwsProfile, replica.AwsProfile)
+	assert.Equal(t, key.EncryptedKey, replica.EncryptedKey)
+	assert.Equal(t, "arn:aws:kms:us-east-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab", key.Arn)
+}
+
+func TestMasterKey_isMultiRegion(t *testing.T) {
+	assert.False(t, NewMasterKeyFromArn(dummyARN, nil, "").isMultiRegion())
+	assert.True(t, NewMasterKeyFromArn("arn:aws:kms:us-east-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab", nil, "").isMultiRegion())
+}
+
+func Test_publicKeyCachePath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(SopsKMSPublicKeyCacheDirEnv, dir)
+	got, err := publicKeyCachePath(dummyARN, string(types.EncryptionAlgorithmSpecRsaesOaepSha256))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "arn_aws_kms_us-west-2_107501996527_key_612d5f0p-p1l3-45e6-aca6-a5b005693a48.RSAES_OAEP_SHA_256.der"), got)
+}
+
 func TestMasterKey_EncryptDecrypt_RoundTrip(t *testing.T) {
 	dataKey := []byte("the wheels on the bus go round and round")
 
@@ -424,6 +572,16 @@ func TestMasterKey_ToMap(t *testing.T) {
 			"key2": value2,
 		},
 	}, key.ToMap())
+
+	key.EncryptionContext = nil
+	key.EncryptionAlgorithm = string(types.EncryptionAlgorithmSpecRsaesOaepSha256)
+	assert.Equal(t, map[string]interface{}{
+		"arn":                  "foo",
+		"role":                 "bar",
+		"enc":                  "this is encrypted",
+		"created_at":           "2016-10-31T10:00:00Z",
+		"encryption_algorithm": "RSAES_OAEP_SHA_256",
+	}, key.ToMap())
 }
 
 func TestMasterKey_createKMSConfig(t *testing.T) {
@@ -637,3 +795,23 @@ func createTestKMSClient(key MasterKey) (*kms.Client, error) {
 		options.BaseEndpoint = aws.String(testKMSServerURL)
 	}), nil
 }
+
+// createTestAsymmetricKey creates an asymmetric RSA key on the test AWS KMS
+// server, and returns its ARN.
+func createTestAsymmetricKey(t *testing.T) string {
+	kmsClient, err := createTestKMSClient(createTestMasterKey(testKMSARN))
+	assert.NoError(t, err)
+	out, err := kmsClient.CreateKey(context.TODO(), &kms.CreateKeyInput{
+		KeySpec:  types.KeySpecRsa2048,
+		KeyUsage: types.KeyUsageTypeEncryptDecrypt,
+	})
+	assert.NoError(t, err)
+	return *out.KeyMetadata.Arn
+}
+
+// unreachableEndpoint returns the URL of a closed server.
+func unreachableEndpoint() string {
+	server := httptest.NewServer(nil)
+	server.Close()
+	return server.URL
+}
@@ -0,0 +1,261 @@
+/*
+Package passphrase contains an implementation of the github.com/getsops/sops/v3/keys.MasterKey
+interface that encrypts and decrypts the data key with a passphrase, using the
+scrypt recipient of age. It is meant for break-glass recovery, where no other
+key material is available.
+*/
+package passphrase // import "github.com/getsops/sops/v3/passphrase"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+
+	"github.com/getsops/sops/v3/logging"
+)
+
+const (
+	// SopsPassphraseEnv can be set as an environment variable with the
+	// passphrase used by all passphrase MasterKeys.
+	SopsPassphraseEnv = "SOPS_PASSPHRASE"
+	// KeyTypeIdentifier is the string used to identify a passphrase MasterKey.
+	KeyTypeIdentifier = "passphrase"
+)
+
+var (
+	// log is the global logger for any passphrase MasterKey.
+	log *logrus.Logger
+	// scryptWorkFactor is the scrypt work factor used to Encrypt. Decryption
+	// accepts any work factor up to the age default maximum.
+	scryptWorkFactor = 18
+)
+
+func init() {
+	log = logging.NewLogger("PASSPHRASE")
+}
+
+// MasterKey is a passphrase used to Encrypt and Decrypt SOPS' data key.
+type MasterKey struct {
+	// Name identifies the passphrase, so that it can be told apart from
+	// other passphrase MasterKeys, and looked up in the environment.
+	Name string
+	// EncryptedKey contains the SOPS data key encrypted with the passphrase.
+	EncryptedKey string
+	// CreationDate of the MasterKey.
+	CreationDate time.Time
+
+	// passphrase contains the passphrase used to Encrypt and Decrypt.
+	// It is used to read the passphrase at-most once.
+	passphrase string
+}
+
+// NewMasterKey creates a new MasterKey with the provided name, setting the
+// creation date to the current date.
+func NewMasterKey(name string) *MasterKey {
+	return &MasterKey{
+		Name:         name,
+		CreationDate: time.Now().UTC(),
+	}
+}
+
+// MasterKeysFromNames takes a comma separated list of passphrase names, and
+// returns a slice of new MasterKeys.
+func MasterKeysFromNames(names string) []*MasterKey {
+	var keys []*MasterKey
+	if names == "" {
+		return keys
+	}
+	for _, s := range strings.Split(names, ",") {
+		keys = append(keys, NewMasterKey(strings.TrimSpace(s)))
+	}
+	return keys
+}
+
+// Encrypt takes a SOPS data key, encrypts it with the passphrase, and stores
+// the result in the EncryptedKey field.
+func (key *MasterKey) Encrypt(dataKey []byte) error {
+	passphrase, err := key.getPassphrase(true)
+	if err != nil {
+		log.WithField("name", key.Name).Info("Encryption failed")
+		return err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		log.WithField("name", key.Name).Info("Encryption failed")
+		return fmt.Errorf("failed to create scrypt recipient: %w", err)
+	}
+	recipient.SetWorkFactor(scryptWorkFactor)
+
+	var buffer bytes.Buffer
+	aw := armor.NewWriter(&buffer)
+	w, err := age.Encrypt(aw, recipient)
+	if err != nil {
+		log.WithField("name", key.Name).Info("Encryption failed")
+		return fmt.Errorf("failed to create writer for encrypting sops data key with passphrase: %w", err)
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		log.WithField("name", key.Name).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with passphrase: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		log.WithField("name", key.Name).Info("Encryption failed")
+		return fmt.Errorf("failed to close writer for encrypting sops data key with passphrase: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		log.WithField("name", key.Name).Info("Encryption failed")
+		return fmt.Errorf("failed to close armored writer: %w", err)
+	}
+
+	key.SetEncryptedDataKey(buffer.Bytes())
+	log.WithField("name", key.Name).Info("Encryption succeeded")
+	return nil
+}
+
+// EncryptIfNeeded encrypts the provided SOPS data key, if it has not been
+// encrypted yet.
+func (key *MasterKey) EncryptIfNeeded(dataKey []byte) error {
+	if key.EncryptedKey == "" {
+		return key.Encrypt(dataKey)
+	}
+	return nil
+}
+
+// EncryptedDataKey returns the encrypted SOPS data key this master key holds.
+func (key *MasterKey) EncryptedDataKey() []byte {
+	return []byte(key.EncryptedKey)
+}
+
+// SetEncryptedDataKey sets the encrypted SOPS data key for this master key.
+func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
+	key.EncryptedKey = string(enc)
+}
+
+// Decrypt decrypts the EncryptedKey with the passphrase, and returns the
+// result.
+func (key *MasterKey) Decrypt() ([]byte, error) {
+	passphrase, err := key.getPassphrase(false)
+	if err != nil {
+		log.WithField("name", key.Name).Info("Decryption failed")
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		log.WithField("name", key.Name).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to create scrypt identity: %w", err)
+	}
+
+	src := bytes.NewReader([]byte(key.EncryptedKey))
+	ar := armor.NewReader(src)
+	r, err := age.Decrypt(ar, identity)
+	if err != nil {
+		log.WithField("name", key.Name).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to create reader for decrypting sops data key with passphrase: %w", err)
+	}
+
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, r); err != nil {
+		log.WithField("name", key.Name).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to copy decrypted data into bytes.Buffer: %w", err)
+	}
+
+	log.WithField("name", key.Name).Info("Decryption succeeded")
+	return b.Bytes(), nil
+}
+
+// NeedsRotation returns whether the data key needs to be rotated or not.
+func (key *MasterKey) NeedsRotation() bool {
+	return false
+}
+
+// ToString converts the key to a string representation.
+func (key *MasterKey) ToString() string {
+	return key.Name
+}
+
+// ToMap converts the MasterKey to a map for serialization purposes.
+func (key *MasterKey) ToMap() map[string]interface{} {
+	out := make(map[string]interface{})
+	out["name"] = key.Name
+	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
+	out["enc"] = key.EncryptedKey
+	return out
+}
+
+// TypeToIdentifier returns the string identifier for the MasterKey type.
+func (key *MasterKey) TypeToIdentifier() string {
+	return KeyTypeIdentifier
+}
+
+// EnvironmentVariable returns the name of the environment variable that
+// holds the passphrase of this specific key, e.g. "SOPS_PASSPHRASE_BREAK_GLASS"
+// for a key named "break-glass". It takes precedence over SopsPassphraseEnv.
+func (key *MasterKey) EnvironmentVariable() string {
+	if key.Name == "" {
+		return SopsPassphraseEnv
+	}
+	name := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, key.Name)
+	return SopsPassphraseEnv + "_" + name
+}
+
+// getPassphrase returns the passphrase of the MasterKey. It is looked up in
+// the passphrase read before, the key specific environment variable and
+// SopsPassphraseEnv, in that order, before prompting for it on the terminal.
+// When confirm is true, the prompt asks for the passphrase twice.
+func (key *MasterKey) getPassphrase(confirm bool) (string, error) {
+	if key.passphrase != "" {
+		return key.passphrase, nil
+	}
+	for _, env := range []string{key.EnvironmentVariable(), SopsPassphraseEnv} {
+		if passphrase, ok := os.LookupEnv(env); ok && passphrase != "" {
+			key.passphrase = passphrase
+			return passphrase, nil
+		}
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("no passphrase found for passphrase key %q: set %s or %s",
+			key.Name, key.EnvironmentVariable(), SopsPassphraseEnv)
+	}
+	passphrase, err := readPassphrase(fmt.Sprintf("Enter passphrase for passphrase key %q: ", key.Name))
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase for passphrase key %q can not be empty", key.Name)
+	}
+	if confirm {
+		confirmation, err := readPassphrase(fmt.Sprintf("Confirm passphrase for passphrase key %q: ", key.Name))
+		if err != nil {
+			return "", err
+		}
+		if confirmation != passphrase {
+			return "", fmt.Errorf("passphrases for passphrase key %q do not match", key.Name)
+		}
+	}
+	key.passphrase = passphrase
+	return passphrase, nil
+}
+
+// readPassphrase prompts for a passphrase on the terminal.
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
@@ -0,0 +1,147 @@
+package passphrase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// mockPassphrase is the passphrase mockEncryptedKey is encrypted with.
+	mockPassphrase = "correct horse battery staple"
+	// mockEncryptedKey equals to mockEncryptedKeyPlain when decrypted with
+	// mockPassphrase.
+	mockEncryptedKey = `-----BEGIN AGE ENCRYPTED FILE-----
+YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IHNjcnlwdCA2bnMxRnllOGZRYzhoWUor
+cUxGSjdnIDEwCnMyVjdsOUpsdmhmVFBYSXg2V2dMcXV6bE1lOWl2QmI3SERQbGNs
+b1lpZDQKLS0tIEkrUG1kTkx6TUdGOFd2Q1hDdGlTL2lOaFBCOVErK0RvQ3kwem9a
+ai90ZjgKgoC5tKQYDpR/M0fIm7HaVAa4zKYf8IrSTkxN58jmGAQthXCW
+-----END AGE ENCRYPTED FILE-----`
+	// mockEncryptedKeyPlain is the plain value of mockEncryptedKey.
+	mockEncryptedKeyPlain = "data"
+)
+
+func init() {
+	// Keep the tests fast.
+	scryptWorkFactor = 10
+}
+
+func TestNewMasterKey(t *testing.T) {
+	key := NewMasterKey("break-glass")
+	assert.Equal(t, "break-glass", key.Name)
+	assert.NotZero(t, key.CreationDate)
+}
+
+func TestMasterKeysFromNames(t *testing.T) {
+	keys := MasterKeysFromNames("break-glass, recovery")
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "break-glass", keys[0].Name)
+	assert.Equal(t, "recovery", keys[1].Name)
+
+	assert.Len(t, MasterKeysFromNames(""), 0)
+}
+
+func TestMasterKey_Decrypt(t *testing.T) {
+	t.Run("passphrase read before", func(t *testing.T) {
+		t.Setenv(SopsPassphraseEnv, "wrong passphrase")
+		key := &MasterKey{Name: "test", EncryptedKey: mockEncryptedKey, passphrase: mockPassphrase}
+
+		got, err := key.Decrypt()
+		assert.NoError(t, err)
+		assert.EqualValues(t, mockEncryptedKeyPlain, got)
+	})
+
+	t.Run(SopsPassphraseEnv, func(t *testing.T) {
+		t.Setenv(SopsPassphraseEnv, mockPassphrase)
+		key := &MasterKey{Name: "test", EncryptedKey: mockEncryptedKey}
+
+		got, err := key.Decrypt()
+		assert.NoError(t, err)
+		assert.EqualValues(t, mockEncryptedKeyPlain, got)
+	})
+
+	t.Run("key specific environment variable", func(t *testing.T) {
+		t.Setenv(SopsPassphraseEnv, "wrong passphrase")
+		t.Setenv("SOPS_PASSPHRASE_BREAK_GLASS", mockPassphrase)
+		key := &MasterKey{Name: "break-glass", EncryptedKey: mockEncryptedKey}
+
+		got, err := key.Decrypt()
+		assert.NoError(t, err)
+		assert.EqualValues(t, mockEncryptedKeyPlain, got)
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		t.Setenv(SopsPassphraseEnv, "wrong passphrase")
+		key := &MasterKey{Name: "test", EncryptedKey: mockEncryptedKey}
+
+		got, err := key.Decrypt()
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "failed to create reader for decrypting sops data key with passphrase")
+		assert.Nil(t, got)
+	})
+
+	t.Run("no passphrase", func(t *testing.T) {
+		t.Setenv(SopsPassphraseEnv, "")
+		key := &MasterKey{Name: "test", EncryptedKey: mockEncryptedKey}
+
+		got, err := key.Decrypt()
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "no passphrase found")
+		assert.Nil(t, got)
+	})
+}
+
+func TestMasterKey_EncryptDecrypt_RoundTrip(t *testing.T) {
+	t.Setenv(SopsPassphraseEnv, mockPassphrase)
+	encryptKey := NewMasterKey("test")
+
+	data := []byte("some secret data")
+	assert.NoError(t, encryptKey.Encrypt(data))
+	assert.Contains(t, encryptKey.EncryptedKey, "AGE ENCRYPTED FILE")
+
+	decryptKey := &MasterKey{Name: "test", EncryptedKey: encryptKey.EncryptedKey}
+	got, err := decryptKey.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestMasterKey_EncryptIfNeeded(t *testing.T) {
+	t.Setenv(SopsPassphraseEnv, mockPassphrase)
+	key := NewMasterKey("test")
+
+	assert.NoError(t, key.EncryptIfNeeded([]byte(mockEncryptedKeyPlain)))
+
+	encryptedKey := key.EncryptedKey
+	assert.NotEmpty(t, encryptedKey)
+
+	assert.NoError(t, key.EncryptIfNeeded([]byte("some other data")))
+	assert.Equal(t, encryptedKey, key.EncryptedKey)
+}
+
+func TestMasterKey_EnvironmentVariable(t *testing.T) {
+	assert.Equal(t, "SOPS_PASSPHRASE_BREAK_GLASS", (&MasterKey{Name: "break-glass"}).EnvironmentVariable())
+	assert.Equal(t, "SOPS_PASSPHRASE_RECOVERY_2", (&MasterKey{Name: "recovery.2"}).EnvironmentVariable())
+	assert.Equal(t, SopsPassphraseEnv, (&MasterKey{}).EnvironmentVariable())
+}
+
+func TestMasterKey_NeedsRotation(t *testing.T) {
+	assert.False(t, NewMasterKey("test").NeedsRotation())
+}
+
+func TestMasterKey_ToString(t *testing.T) {
+	assert.Equal(t, "break-glass", NewMasterKey("break-glass").ToString())
+}
+
+func TestMasterKey_ToMap(t *testing.T) {
+	key := &MasterKey{
+		Name:         "break-glass",
+		EncryptedKey: "some-encrypted-key",
+		CreationDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	assert.Equal(t, map[string]interface{}{
+		"name":       "break-glass",
+		"created_at": "2024-01-02T03:04:05Z",
+		"enc":        "some-encrypted-key",
+	}, key.ToMap())
+}
@@ -0,0 +1,203 @@
+package sops
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathGlob selects values of a tree by their path. It is written as keys
+// separated by dots, in which "*" matches any characters of a key, such as in
+// "db_*", and "**" any number of keys and list items. List items follow their
+// list in brackets, either as their index or as "*" for any item, such as in
+// "env[0]" or "env[*].value". Keys without an index which follow a list
+// match in all of its items, so that "env.value" is the same as
+// "env[*].value". Dots, asterisks, brackets and backslashes in keys are
+// escaped with a backslash. A glob selects the values at the paths it matches
+// and all values below them.
+type PathGlob []globSegment
+
+type globSegment struct {
+	// anyDepth is set for "**", which matches any number of path elements.
+	anyDepth bool
+	// isIndex is set for list items, which match index, or any index if it
+	// is negative.
+	isIndex bool
+	index   int
+	// keyParts are the parts of a key pattern between its wildcards.
+	keyParts []string
+}
+
+// CompilePathGlob parses a path glob.
+func CompilePathGlob(glob string) (PathGlob, error) {
+	var segments PathGlob
+	// The current key pattern starts at offset start, and consists of
+	// parts, which are followed by wildcards, and of part.
+	var parts []string
+	var part strings.Builder
+	start := 0
+	inKey, afterIndex, afterDot := false, false, false
+	beginKey := func(i int) {
+		if !inKey {
+			start = i
+			inKey = true
+		}
+		afterIndex, afterDot = false, false
+	}
+	endKey := func(end int) {
+		if !inKey {
+			return
+		}
+		if glob[start:end] == "**" {
+			segments = append(segments, globSegment{anyDepth: true})
+		} else {
+			segments = append(segments, globSegment{keyParts: append(parts, part.String())})
+		}
+		parts = nil
+		part.Reset()
+		inKey = false
+	}
+	for i := 0; i < len(glob); i++ {
+		if afterIndex && glob[i] != '.' && glob[i] != '[' {
+			return nil, fmt.Errorf("invalid path %q: missing dot at offset %d", glob, i)
+		}
+		switch c := glob[i]; c {
+		case '\\':
+			if i+1 == len(glob) {
+				return nil, fmt.Errorf("invalid path %q: it ends with an escape", glob)
+			}
+			beginKey(i)
+			i++
+			part.WriteByte(glob[i])
+		case '*':
+			beginKey(i)
+			parts = append(parts, part.String())
+			part.Reset()
+		case '.':
+			if !inKey && !afterIndex {
+				return nil, fmt.Errorf("invalid path %q: empty key at offset %d", glob, i)
+			}
+			endKey(i)
+			afterIndex, afterDot = false, true
+		case '[':
+			if afterDot {
+				return nil, fmt.Errorf("invalid path %q: empty key at offset %d", glob, i)
+			}
+			endKey(i)
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated index at offset %d", glob, i)
+			}
+			segment := globSegment{isIndex: true, index: -1}
+			if index := glob[i+1 : i+end]; index != "*" {
+				n, err := strconv.Atoi(index)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid path %q: index %q is neither a number nor \"*\"", glob, index)
+				}
+				segment.index = n
+			}
+			segments = append(segments, segment)
+			i += end
+			afterIndex = true
+		default:
+			beginKey(i)
+			part.WriteByte(c)
+		}
+	}
+	if afterDot || len(glob) == 0 {
+		return nil, fmt.Errorf("invalid path %q: empty key at offset %d", glob, len(glob))
+	}
+	endKey(len(glob))
+	return segments, nil
+}
+
+// CheckPathGlobs returns an error if any of globs is not a valid PathGlob.
+func CheckPathGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := CompilePathGlob(glob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Selects reports whether the glob selects the value at path, that is
+// whether it matches path or one of its ancestors. Paths are lists of keys
+// and indices, as taken by TreeBranch.Truncate.
+func (g PathGlob) Selects(path []interface{}) bool {
+	if len(g) == 0 {
+		return true
+	}
+	if g[0].anyDepth {
+		for i := 0; i <= len(path); i++ {
+			if g[1:].Selects(path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if _, ok := path[0].(int); ok && !g[0].isIndex {
+		return g.Selects(path[1:])
+	}
+	if !g[0].matches(path[0]) {
+		return false
+	}
+	return g[1:].Selects(path[1:])
+}
+
+func (s globSegment) matches(element interface{}) bool {
+	switch element := element.(type) {
+	case int:
+		return s.isIndex && (s.index < 0 || s.index == element)
+	case string:
+		return !s.isIndex && matchKey(s.keyParts, element)
+	default:
+		return false
+	}
+}
+
+// matchKey reports whether key matches the parts of a key pattern, which are
+// separated by wildcards matching any characters.
+func matchKey(parts []string, key string) bool {
+	if len(parts) == 1 {
+		return key == parts[0]
+	}
+	if !strings.HasPrefix(key, parts[0]) {
+		return false
+	}
+	key = key[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(key, part)
+		if i < 0 {
+			return false
+		}
+		key = key[i+len(part):]
+	}
+	return len(key) >= len(last) && strings.HasSuffix(key, last)
+}
+
+// compilePathGlobs compiles globs, leaving out invalid ones, which stores
+// and configurations reject.
+func compilePathGlobs(globs []string) []PathGlob {
+	var compiled []PathGlob
+	for _, glob := range globs {
+		if g, err := CompilePathGlob(glob); err == nil {
+			compiled = append(compiled, g)
+		}
+	}
+	return compiled
+}
+
+// selectsPath reports whether any of globs selects the value at path.
+func selectsPath(globs []PathGlob, path []interface{}) bool {
+	for _, g := range globs {
+		if g.Selects(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package sops
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathGlobSelects(t *testing.T) {
+	tests := []struct {
+		glob string
+		path []interface{}
+		want bool
+	}{
+		{"db", []interface{}{"db"}, true},
+		{"db", []interface{}{"db", "password"}, true},
+		{"db", []interface{}{"dbx"}, false},
+		{"db", []interface{}{"app", "db"}, false},
+		{"db.password", []interface{}{"db", "password"}, true},
+		{"db.password", []interface{}{"db"}, false},
+		{"*.password", []interface{}{"db", "password"}, true},
+		{"*.password", []interface{}{"a", "b", "password"}, false},
+		{"**.password", []interface{}{"a", "b", "password"}, true},
+		{"**.password", []interface{}{"password"}, true},
+		{"**.password", []interface{}{"a", 0, "password"}, true},
+		{"db_*", []interface{}{"db_main", "user"}, true},
+		{"db_*", []interface{}{"app"}, false},
+		{"*_key_*", []interface{}{"api_key_prod"}, true},
+		{"*_key_*", []interface{}{"api_keys"}, false},
+		{"a*a", []interface{}{"a"}, false},
+		{"a*a", []interface{}{"aa"}, true},
+		{`a\.b`, []interface{}{"a.b"}, true},
+		{`a\.b`, []interface{}{"a", "b"}, false},
+		{`\*`, []interface{}{"*"}, true},
+		{`\*`, []interface{}{"x"}, false},
+		{`\[0\]`, []interface{}{"[0]"}, true},
+		{"env[*]", []interface{}{"env", 3}, true},
+		{"env[*]", []interface{}{"env"}, false},
+		{"env[1]", []interface{}{"env", 1, "name"}, true},
+		{"env[1]", []interface{}{"env", 0, "name"}, false},
+		{"env[*].value", []interface{}{"env", 0, "value"}, true},
+		{"env[*].value", []interface{}{"env", 0, "name"}, false},
+		{"matrix[*][1]", []interface{}{"matrix", 0, 1}, true},
+		{"matrix[*][1]", []interface{}{"matrix", 0, 0}, false},
+		{"spec.template.**.env[*].value", []interface{}{"spec", "template", "spec", "containers", 0, "env", 2, "value"}, true},
+		{"spec.template.**.env[*].value", []interface{}{"spec", "template", "spec", "containers", 0, "env", 2, "name"}, false},
+		{"**", []interface{}{"anything", 0}, true},
+		{"items.password", []interface{}{"items", 3, "password"}, true},
+		{"items.password", []interface{}{"items", 3, "user"}, false},
+		{"matrix.x", []interface{}{"matrix", 0, 1, "x"}, true},
+		{"items[0]", []interface{}{"items", 0, 1}, true},
+	}
+	for _, tt := range tests {
+		g, err := CompilePathGlob(tt.glob)
+		assert.Nil(t, err, tt.glob)
+		assert.Equal(t, tt.want, g.Selects(tt.path), "%s %v", tt.glob, tt.path)
+	}
+}
+
+func TestCompilePathGlobInvalid(t *testing.T) {
+	for _, glob := range []string{"", ".", "a.", ".a", "a..b", "a.[0]", "a[0]b", "a[", "a[x]", "a[-1]", `a\`} {
+		_, err := CompilePathGlob(glob)
+		assert.NotNil(t, err, glob)
+	}
+}
@@ -0,0 +1,336 @@
+/*
+Package pkcs11 contains an implementation of the github.com/getsops/sops/v3/keys.MasterKey
+interface that encrypts and decrypts the data key with a key stored in a
+PKCS#11 token, such as a Hardware Security Module (HSM).
+
+Keys are referred to with PKCS#11 URIs as defined in RFC 7512, for example:
+
+	pkcs11:token=sops;object=data-key-wrapper?module-path=/usr/lib/softhsm/libsofthsm2.so
+
+The "module-path" of a URI is stored in files along with the key. As loading
+a module runs its code, key services only load the module of a key when they
+are allowed to, and use the module set in the SOPS_PKCS11_MODULE environment
+variable otherwise, which is also used for keys without a module path.
+
+AES secret keys wrap the data key with AES-GCM, and RSA key pairs with
+RSA-OAEP.
+*/
+package pkcs11 // import "github.com/getsops/sops/v3/pkcs11"
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+
+	"github.com/getsops/sops/v3/logging"
+)
+
+const (
+	// SopsPkcs11PinEnv can be set as an environment variable with the user
+	// PIN used to log in to the PKCS#11 token.
+	SopsPkcs11PinEnv = "SOPS_PKCS11_PIN"
+	// SopsPkcs11ModuleEnv can be set as an environment variable with the path
+	// to the PKCS#11 module, for keys which do not specify a module path,
+	// such as the ones read from files.
+	SopsPkcs11ModuleEnv = "SOPS_PKCS11_MODULE"
+	// KeyTypeIdentifier is the string used to identify a PKCS#11 MasterKey.
+	KeyTypeIdentifier = "pkcs11"
+
+	// uriScheme is the scheme of PKCS#11 URIs.
+	uriScheme = "pkcs11:"
+)
+
+// log is the global logger for any PKCS#11 MasterKey.
+var log *logrus.Logger
+
+func init() {
+	log = logging.NewLogger("PKCS11")
+}
+
+// MasterKey is a PKCS#11 token key used to Encrypt and Decrypt SOPS' data
+// key.
+type MasterKey struct {
+	// ModulePath is the path to the PKCS#11 module (shared library) of the
+	// token, from the URI the key was created from. If empty,
+	// SopsPkcs11ModuleEnv is used.
+	ModulePath string
+	// TokenLabel is the label of the token holding the key. It is used to
+	// find the slot if SlotID is empty.
+	TokenLabel string
+	// SlotID is the ID of the slot holding the token. Can be empty.
+	SlotID string
+	// KeyLabel is the label (CKA_LABEL) of the key used to Encrypt and
+	// Decrypt.
+	KeyLabel string
+	// EncryptedKey contains the SOPS data key encrypted with the PKCS#11
+	// key.
+	EncryptedKey string
+	// CreationDate of the MasterKey.
+	CreationDate time.Time
+
+	// pin contains the user PIN used to log in to the token.
+	// It is used to read the PIN at-most once.
+	// It can also be injected by a (local) keyservice.KeyServiceServer using
+	// Pin.ApplyToMasterKey().
+	pin string
+}
+
+// NewMasterKey creates a new MasterKey from a module path, token label, slot
+// ID and key label, setting the creation date to the current date.
+func NewMasterKey(modulePath, tokenLabel, slotID, keyLabel string) *MasterKey {
+	return &MasterKey{
+		ModulePath:   modulePath,
+		TokenLabel:   tokenLabel,
+		SlotID:       slotID,
+		KeyLabel:     keyLabel,
+		CreationDate: time.Now().UTC(),
+	}
+}
+
+// NewMasterKeyFromURI takes a PKCS#11 URI, and returns a new MasterKey.
+// The "token" or "slot-id" and the "object" path attributes are used to find
+// the key, and the "module-path" query attribute to load the module.
+func NewMasterKeyFromURI(uri string) (*MasterKey, error) {
+	uri = strings.TrimSpace(uri)
+	if !strings.HasPrefix(uri, uriScheme) {
+		return nil, fmt.Errorf("PKCS#11 URI %q must start with %q", uri, uriScheme)
+	}
+	path, query, _ := strings.Cut(strings.TrimPrefix(uri, uriScheme), "?")
+	pathAttrs, err := parseURIAttributes(path, ";")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#11 URI %q: %w", uri, err)
+	}
+	queryAttrs, err := parseURIAttributes(query, "&")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#11 URI %q: %w", uri, err)
+	}
+
+	key := NewMasterKey(queryAttrs["module-path"], pathAttrs["token"], pathAttrs["slot-id"], pathAttrs["object"])
+	if key.KeyLabel == "" {
+		return nil, fmt.Errorf("PKCS#11 URI %q must contain an object", uri)
+	}
+	if key.TokenLabel == "" && key.SlotID == "" {
+		return nil, fmt.Errorf("PKCS#11 URI %q must contain a token or slot-id", uri)
+	}
+	if key.SlotID != "" {
+		if _, err := strconv.ParseUint(key.SlotID, 10, 64); err != nil {
+			return nil, fmt.Errorf("PKCS#11 URI %q contains an invalid slot-id: %w", uri, err)
+		}
+	}
+	return key, nil
+}
+
+// NewMasterKeysFromURIs takes a comma separated list of PKCS#11 URIs, and
+// returns a slice of new MasterKeys.
+func NewMasterKeysFromURIs(uris string) ([]*MasterKey, error) {
+	var keys []*MasterKey
+	if uris == "" {
+		return keys, nil
+	}
+	for _, uri := range strings.Split(uris, ",") {
+		key, err := NewMasterKeyFromURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseURIAttributes parses the sep separated, percent-encoded name=value
+// pairs of a PKCS#11 URI component.
+func parseURIAttributes(s, sep string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	if s == "" {
+		return attrs, nil
+	}
+	for _, attr := range strings.Split(s, sep) {
+		name, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed attribute %q", attr)
+		}
+		if _, ok := attrs[name]; ok {
+			return nil, fmt.Errorf("duplicate attribute %q", name)
+		}
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed attribute %q: %w", attr, err)
+		}
+		attrs[name] = unescaped
+	}
+	return attrs, nil
+}
+
+// escapeURIValue percent-encodes the characters of a PKCS#11 URI attribute
+// value which are not allowed as-is.
+func escapeURIValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("-._~:[]@!$'()*+,/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Pin is a PKCS#11 user PIN used to log in to the token.
+// It allows a (local) keyservice.KeyServiceServer to read the PIN once, and
+// inject it using ApplyToMasterKey() for all requests.
+type Pin string
+
+// ApplyToMasterKey configures the Pin on the provided key.
+func (p Pin) ApplyToMasterKey(key *MasterKey) {
+	key.pin = string(p)
+}
+
+// Encrypt takes a SOPS data key, encrypts it with the PKCS#11 key, and stores
+// the result in the EncryptedKey field.
+func (key *MasterKey) Encrypt(dataKey []byte) error {
+	modulePath, pin, err := key.sessionParameters()
+	if err != nil {
+		log.WithField("uri", key.ToString()).Info("Encryption failed")
+		return err
+	}
+	encryptedKey, err := encrypt(key, modulePath, pin, dataKey)
+	if err != nil {
+		log.WithField("uri", key.ToString()).Info("Encryption failed")
+		return fmt.Errorf("failed to encrypt sops data key with PKCS#11 key %q: %w", key.ToString(), err)
+	}
+	key.SetEncryptedDataKey([]byte(base64.StdEncoding.EncodeToString(encryptedKey)))
+	log.WithField("uri", key.ToString()).Info("Encryption succeeded")
+	return nil
+}
+
+// EncryptIfNeeded encrypts the provided SOPS data key, if it has not been
+// encrypted yet.
+func (key *MasterKey) EncryptIfNeeded(dataKey []byte) error {
+	if key.EncryptedKey == "" {
+		return key.Encrypt(dataKey)
+	}
+	return nil
+}
+
+// EncryptedDataKey returns the encrypted data key this master key holds.
+func (key *MasterKey) EncryptedDataKey() []byte {
+	return []byte(key.EncryptedKey)
+}
+
+// SetEncryptedDataKey sets the encrypted data key for this master key.
+func (key *MasterKey) SetEncryptedDataKey(enc []byte) {
+	key.EncryptedKey = string(enc)
+}
+
+// Decrypt decrypts the EncryptedKey field with the PKCS#11 key and returns
+// the result.
+func (key *MasterKey) Decrypt() ([]byte, error) {
+	encryptedKey, err := base64.StdEncoding.DecodeString(key.EncryptedKey)
+	if err != nil {
+		log.WithField("uri", key.ToString()).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to base64 decode data key: %w", err)
+	}
+	modulePath, pin, err := key.sessionParameters()
+	if err != nil {
+		log.WithField("uri", key.ToString()).Info("Decryption failed")
+		return nil, err
+	}
+	dataKey, err := decrypt(key, modulePath, pin, encryptedKey)
+	if err != nil {
+		log.WithField("uri", key.ToString()).Info("Decryption failed")
+		return nil, fmt.Errorf("failed to decrypt sops data key with PKCS#11 key %q: %w", key.ToString(), err)
+	}
+	log.WithField("uri", key.ToString()).Info("Decryption succeeded")
+	return dataKey, nil
+}
+
+// NeedsRotation returns whether the data key needs to be rotated or not.
+func (key *MasterKey) NeedsRotation() bool {
+	return false
+}
+
+// ToString converts the key to a string representation, which is a PKCS#11
+// URI without the module path, so that it identifies the same key whichever
+// module is used to access it.
+func (key *MasterKey) ToString() string {
+	var attrs []string
+	if key.TokenLabel != "" {
+		attrs = append(attrs, "token="+escapeURIValue(key.TokenLabel))
+	}
+	if key.SlotID != "" {
+		attrs = append(attrs, "slot-id="+escapeURIValue(key.SlotID))
+	}
+	attrs = append(attrs, "object="+escapeURIValue(key.KeyLabel))
+	return uriScheme + strings.Join(attrs, ";")
+}
+
+// ToMap converts the MasterKey to a map for serialization purposes.
+func (key *MasterKey) ToMap() map[string]interface{} {
+	out := make(map[string]interface{})
+	if key.ModulePath != "" {
+		out["module_path"] = key.ModulePath
+	}
+	if key.TokenLabel != "" {
+		out["token_label"] = key.TokenLabel
+	}
+	if key.SlotID != "" {
+		out["slot_id"] = key.SlotID
+	}
+	out["key_label"] = key.KeyLabel
+	out["created_at"] = key.CreationDate.UTC().Format(time.RFC3339)
+	out["enc"] = key.EncryptedKey
+	return out
+}
+
+// DigestAttributes returns the module path of the key if it is set, as it is
+// not part of ToString.
+func (key *MasterKey) DigestAttributes() map[string]string {
+	attributes := make(map[string]string)
+	if key.ModulePath != "" {
+		attributes["module_path"] = key.ModulePath
+	}
+	return attributes
+}
+
+// TypeToIdentifier returns the string identifier for the MasterKey type.
+func (key *MasterKey) TypeToIdentifier() string {
+	return KeyTypeIdentifier
+}
+
+// sessionParameters returns the module path and user PIN to open a session
+// with the token. The PIN is looked up in the injected PIN and
+// SopsPkcs11PinEnv, before prompting for it on the terminal.
+func (key *MasterKey) sessionParameters() (string, string, error) {
+	modulePath := key.ModulePath
+	if modulePath == "" {
+		modulePath = os.Getenv(SopsPkcs11ModuleEnv)
+	}
+	if modulePath == "" {
+		return "", "", fmt.Errorf("no module path for PKCS#11 key %q: set %s", key.ToString(), SopsPkcs11ModuleEnv)
+	}
+
+	if key.pin == "" {
+		if pin, ok := os.LookupEnv(SopsPkcs11PinEnv); ok {
+			key.pin = pin
+		} else if term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintf(os.Stderr, "Enter PIN for PKCS#11 key %q: ", key.ToString())
+			pin, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return "", "", fmt.Errorf("could not read PIN: %w", err)
+			}
+			key.pin = string(pin)
+		} else {
+			return "", "", fmt.Errorf("no PIN found for PKCS#11 key %q: set %s", key.ToString(), SopsPkcs11PinEnv)
+		}
+	}
+	return modulePath, key.pin, nil
+}
@@ -0,0 +1,44 @@
+//go:build integration
+
+package pkcs11
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The following values should point to a token with an AES secret key or an
+// RSA key pair with the given label, for example created with SoftHSMv2:
+//
+//	softhsm2-util --init-token --free --label sops --so-pin 0000 --pin 1234
+//	pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --login --pin 1234 \
+//	  --token-label sops --keygen --key-type AES:32 --label data-key
+var (
+	testModulePath = os.Getenv("SOPS_TEST_PKCS11_MODULE")
+	testTokenLabel = os.Getenv("SOPS_TEST_PKCS11_TOKEN_LABEL")
+	testKeyLabel   = os.Getenv("SOPS_TEST_PKCS11_KEY_LABEL")
+	testPin        = os.Getenv("SOPS_TEST_PKCS11_PIN")
+)
+
+func TestMasterKey_EncryptDecrypt_RoundTrip(t *testing.T) {
+	if testModulePath == "" {
+		t.Skip("SOPS_TEST_PKCS11_MODULE is not set")
+	}
+
+	key := NewMasterKey(testModulePath, testTokenLabel, "", testKeyLabel)
+	Pin(testPin).ApplyToMasterKey(key)
+
+	data := []byte("to be or not to be static bytes")
+	assert.NoError(t, key.Encrypt(data))
+	assert.NotEmpty(t, key.EncryptedDataKey())
+
+	decryptKey := NewMasterKey(testModulePath, testTokenLabel, "", testKeyLabel)
+	decryptKey.EncryptedKey = key.EncryptedKey
+	Pin(testPin).ApplyToMasterKey(decryptKey)
+
+	got, err := decryptKey.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
@@ -0,0 +1,199 @@
+package pkcs11
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	mockModulePath = "/usr/lib/softhsm/libsofthsm2.so"
+	mockURI        = "pkcs11:token=sops;object=data-key?module-path=/usr/lib/softhsm/libsofthsm2.so"
+)
+
+func TestNewMasterKeyFromURI(t *testing.T) {
+	tests := []struct {
+		name      string
+		uri       string
+		expectErr string
+		expectKey MasterKey
+	}{
+		{
+			name: "token label",
+			uri:  mockURI,
+			expectKey: MasterKey{
+				ModulePath: mockModulePath,
+				TokenLabel: "sops",
+				KeyLabel:   "data-key",
+			},
+		},
+		{
+			name: "slot ID without module path",
+			uri:  "pkcs11:slot-id=2;object=data-key",
+			expectKey: MasterKey{
+				SlotID:   "2",
+				KeyLabel: "data-key",
+			},
+		},
+		{
+			name: "percent-encoded values",
+			uri:  " pkcs11:token=my%20token;object=key%3B1?module-path=/opt/my%20hsm/lib.so ",
+			expectKey: MasterKey{
+				ModulePath: "/opt/my hsm/lib.so",
+				TokenLabel: "my token",
+				KeyLabel:   "key;1",
+			},
+		},
+		{
+			name:      "wrong scheme",
+			uri:       "https://example.com",
+			expectErr: "must start with",
+		},
+		{
+			name:      "no object",
+			uri:       "pkcs11:token=sops",
+			expectErr: "must contain an object",
+		},
+		{
+			name:      "no token or slot ID",
+			uri:       "pkcs11:object=data-key",
+			expectErr: "must contain a token or slot-id",
+		},
+		{
+			name:      "invalid slot ID",
+			uri:       "pkcs11:slot-id=two;object=data-key",
+			expectErr: "invalid slot-id",
+		},
+		{
+			name:      "malformed attribute",
+			uri:       "pkcs11:token;object=data-key",
+			expectErr: "malformed attribute",
+		},
+		{
+			name:      "duplicate attribute",
+			uri:       "pkcs11:token=a;token=b;object=data-key",
+			expectErr: "duplicate attribute",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := NewMasterKeyFromURI(tt.uri)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				assert.Nil(t, key)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectKey.ModulePath, key.ModulePath)
+			assert.Equal(t, tt.expectKey.TokenLabel, key.TokenLabel)
+			assert.Equal(t, tt.expectKey.SlotID, key.SlotID)
+			assert.Equal(t, tt.expectKey.KeyLabel, key.KeyLabel)
+			assert.NotZero(t, key.CreationDate)
+		})
+	}
+}
+
+func TestNewMasterKeysFromURIs(t *testing.T) {
+	keys, err := NewMasterKeysFromURIs(mockURI + ",pkcs11:slot-id=0;object=other")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "data-key", keys[0].KeyLabel)
+	assert.Equal(t, "other", keys[1].KeyLabel)
+
+	keys, err = NewMasterKeysFromURIs("")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 0)
+
+	keys, err = NewMasterKeysFromURIs(mockURI + ",invalid")
+	assert.Error(t, err)
+	assert.Nil(t, keys)
+}
+
+func TestMasterKey_ToString(t *testing.T) {
+	key, err := NewMasterKeyFromURI(mockURI)
+	assert.NoError(t, err)
+	assert.Equal(t, "pkcs11:token=sops;object=data-key", key.ToString())
+
+	key = NewMasterKey("/opt/my hsm/lib.so", "my token", "3", "key;1")
+	assert.Equal(t, "pkcs11:token=my%20token;slot-id=3;object=key%3B1", key.ToString())
+
+	parsed, err := NewMasterKeyFromURI(key.ToString())
+	assert.NoError(t, err)
+	assert.Empty(t, parsed.ModulePath)
+	assert.Equal(t, key.TokenLabel, parsed.TokenLabel)
+	assert.Equal(t, key.SlotID, parsed.SlotID)
+	assert.Equal(t, key.KeyLabel, parsed.KeyLabel)
+}
+
+func TestMasterKey_ToMap(t *testing.T) {
+	key := &MasterKey{
+		ModulePath:   mockModulePath,
+		TokenLabel:   "sops",
+		KeyLabel:     "data-key",
+		EncryptedKey: "some-encrypted-key",
+		CreationDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	assert.Equal(t, map[string]interface{}{
+		"module_path": mockModulePath,
+		"token_label": "sops",
+		"key_label":   "data-key",
+		"created_at":  "2024-01-02T03:04:05Z",
+		"enc":         "some-encrypted-key",
+	}, key.ToMap())
+}
+
+func TestMasterKey_EncryptIfNeeded(t *testing.T) {
+	key := &MasterKey{EncryptedKey: "some-encrypted-key"}
+	assert.NoError(t, key.EncryptIfNeeded([]byte("data")))
+	assert.Equal(t, "some-encrypted-key", key.EncryptedKey)
+}
+
+func TestMasterKey_sessionParameters(t *testing.T) {
+	t.Run("module path and PIN from environment", func(t *testing.T) {
+		t.Setenv(SopsPkcs11ModuleEnv, mockModulePath)
+		t.Setenv(SopsPkcs11PinEnv, "1234")
+
+		key := &MasterKey{TokenLabel: "sops", KeyLabel: "data-key"}
+		modulePath, pin, err := key.sessionParameters()
+		assert.NoError(t, err)
+		assert.Equal(t, mockModulePath, modulePath)
+		assert.Equal(t, "1234", pin)
+	})
+
+	t.Run("injected PIN", func(t *testing.T) {
+		t.Setenv(SopsPkcs11PinEnv, "1234")
+
+		key := &MasterKey{ModulePath: "/some/module.so", TokenLabel: "sops", KeyLabel: "data-key"}
+		Pin("5678").ApplyToMasterKey(key)
+		modulePath, pin, err := key.sessionParameters()
+		assert.NoError(t, err)
+		assert.Equal(t, "/some/module.so", modulePath)
+		assert.Equal(t, "5678", pin)
+	})
+
+	t.Run("no module path", func(t *testing.T) {
+		t.Setenv(SopsPkcs11ModuleEnv, "")
+
+		key := &MasterKey{TokenLabel: "sops", KeyLabel: "data-key"}
+		_, _, err := key.sessionParameters()
+		assert.ErrorContains(t, err, "no module path")
+	})
+}
+
+func TestMasterKey_Decrypt(t *testing.T) {
+	t.Run("invalid encrypted key", func(t *testing.T) {
+		key := &MasterKey{ModulePath: mockModulePath, TokenLabel: "sops", KeyLabel: "data-key", EncryptedKey: "%%%"}
+		got, err := key.Decrypt()
+		assert.ErrorContains(t, err, "failed to base64 decode data key")
+		assert.Nil(t, got)
+	})
+
+	t.Run("missing module", func(t *testing.T) {
+		key := &MasterKey{ModulePath: "/does/not/exist.so", TokenLabel: "sops", KeyLabel: "data-key", EncryptedKey: "ZGF0YQ=="}
+		Pin("1234").ApplyToMasterKey(key)
+		got, err := key.Decrypt()
+		assert.ErrorContains(t, err, "failed to decrypt sops data key with PKCS#11 key")
+		assert.Nil(t, got)
+	})
+}
@@ -0,0 +1,199 @@
+//go:build cgo
+
+package pkcs11
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	p11 "github.com/miekg/pkcs11"
+)
+
+const (
+	// gcmIVSize is the size of the IV used for AES-GCM.
+	gcmIVSize = 12
+	// gcmTagBits is the size of the tag used for AES-GCM, in bits.
+	gcmTagBits = 128
+)
+
+// encrypt encrypts the data key with the key in the token. AES secret keys
+// encrypt with AES-GCM, in which case the IV is prepended to the result.
+// Otherwise, the RSA public key encrypts with RSA-OAEP.
+func encrypt(key *MasterKey, modulePath, pin string, dataKey []byte) ([]byte, error) {
+	var out []byte
+	err := withSession(key, modulePath, pin, func(ctx *p11.Ctx, sh p11.SessionHandle) error {
+		if obj, ok, err := findObject(ctx, sh, p11.CKO_SECRET_KEY, key.KeyLabel); err != nil {
+			return err
+		} else if ok {
+			iv := make([]byte, gcmIVSize)
+			if _, err := rand.Read(iv); err != nil {
+				return fmt.Errorf("could not generate random bytes for IV: %w", err)
+			}
+			params := p11.NewGCMParams(iv, nil, gcmTagBits)
+			defer params.Free()
+			if err := ctx.EncryptInit(sh, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, obj); err != nil {
+				return fmt.Errorf("failed to initialize AES-GCM encryption: %w", err)
+			}
+			ciphertext, err := ctx.Encrypt(sh, dataKey)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt with AES-GCM: %w", err)
+			}
+			out = append(iv, ciphertext...)
+			return nil
+		}
+
+		obj, ok, err := findObject(ctx, sh, p11.CKO_PUBLIC_KEY, key.KeyLabel)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no secret or public key with label %q found", key.KeyLabel)
+		}
+		if err := ctx.EncryptInit(sh, oaepMechanism(), obj); err != nil {
+			return fmt.Errorf("failed to initialize RSA-OAEP encryption: %w", err)
+		}
+		ciphertext, err := ctx.Encrypt(sh, dataKey)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt with RSA-OAEP: %w", err)
+		}
+		out = ciphertext
+		return nil
+	})
+	return out, err
+}
+
+// decrypt decrypts the encrypted data key with the key in the token, with
+// AES-GCM for AES secret keys, and RSA-OAEP for RSA private keys.
+func decrypt(key *MasterKey, modulePath, pin string, encryptedKey []byte) ([]byte, error) {
+	var out []byte
+	err := withSession(key, modulePath, pin, func(ctx *p11.Ctx, sh p11.SessionHandle) error {
+		if obj, ok, err := findObject(ctx, sh, p11.CKO_SECRET_KEY, key.KeyLabel); err != nil {
+			return err
+		} else if ok {
+			if len(encryptedKey) < gcmIVSize {
+				return errors.New("encrypted data key is too short")
+			}
+			params := p11.NewGCMParams(encryptedKey[:gcmIVSize], nil, gcmTagBits)
+			defer params.Free()
+			if err := ctx.DecryptInit(sh, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, obj); err != nil {
+				return fmt.Errorf("failed to initialize AES-GCM decryption: %w", err)
+			}
+			plaintext, err := ctx.Decrypt(sh, encryptedKey[gcmIVSize:])
+			if err != nil {
+				return fmt.Errorf("failed to decrypt with AES-GCM: %w", err)
+			}
+			out = plaintext
+			return nil
+		}
+
+		obj, ok, err := findObject(ctx, sh, p11.CKO_PRIVATE_KEY, key.KeyLabel)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no secret or private key with label %q found", key.KeyLabel)
+		}
+		if err := ctx.DecryptInit(sh, oaepMechanism(), obj); err != nil {
+			return fmt.Errorf("failed to initialize RSA-OAEP decryption: %w", err)
+		}
+		plaintext, err := ctx.Decrypt(sh, encryptedKey)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt with RSA-OAEP: %w", err)
+		}
+		out = plaintext
+		return nil
+	})
+	return out, err
+}
+
+// oaepMechanism returns the RSA-OAEP mechanism. SHA-1 is used, as it is the
+// only hash function supported by all common tokens for OAEP.
+func oaepMechanism() []*p11.Mechanism {
+	params := p11.NewOAEPParams(p11.CKM_SHA_1, p11.CKG_MGF1_SHA1, p11.CKZ_DATA_SPECIFIED, nil)
+	return []*p11.Mechanism{p11.NewMechanism(p11.CKM_RSA_PKCS_OAEP, params)}
+}
+
+// withSession loads the module, and calls fn with a session on the token of
+// the key in which the user is logged in.
+func withSession(key *MasterKey, modulePath, pin string, fn func(*p11.Ctx, p11.SessionHandle) error) error {
+	ctx := p11.New(modulePath)
+	if ctx == nil {
+		return fmt.Errorf("failed to load PKCS#11 module %q", modulePath)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize PKCS#11 module %q: %w", modulePath, err)
+	}
+	defer ctx.Finalize()
+
+	slot, err := findSlot(ctx, key)
+	if err != nil {
+		return err
+	}
+	sh, err := ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("failed to open session on slot %d: %w", slot, err)
+	}
+	defer ctx.CloseSession(sh)
+	if err := ctx.Login(sh, p11.CKU_USER, pin); err != nil && !errors.Is(err, p11.Error(p11.CKR_USER_ALREADY_LOGGED_IN)) {
+		return fmt.Errorf("failed to log in to token: %w", err)
+	}
+	defer ctx.Logout(sh)
+
+	return fn(ctx, sh)
+}
+
+// findSlot returns the slot with the SlotID of the key, or else the slot
+// holding the token with the TokenLabel of the key.
+func findSlot(ctx *p11.Ctx, key *MasterKey) (uint, error) {
+	if key.SlotID != "" {
+		slot, err := strconv.ParseUint(key.SlotID, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid slot ID %q: %w", key.SlotID, err)
+		}
+		return uint(slot), nil
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get token info of slot %d: %w", slot, err)
+		}
+		if strings.TrimRight(info.Label, " \x00") == key.TokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no token with label %q found", key.TokenLabel)
+}
+
+// findObject returns the object of the given class with the given label.
+func findObject(ctx *p11.Ctx, sh p11.SessionHandle, class uint, label string) (p11.ObjectHandle, bool, error) {
+	template := []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_CLASS, class),
+		p11.NewAttribute(p11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(sh, template); err != nil {
+		return 0, false, fmt.Errorf("failed to find objects: %w", err)
+	}
+	objs, _, err := ctx.FindObjects(sh, 2)
+	if finalErr := ctx.FindObjectsFinal(sh); err == nil && finalErr != nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to find objects: %w", err)
+	}
+	switch len(objs) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return objs[0], true, nil
+	default:
+		return 0, false, fmt.Errorf("multiple keys with label %q found", label)
+	}
+}
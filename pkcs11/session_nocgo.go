@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package pkcs11
+
+import "errors"
+
+// errNoCgo is returned when SOPS is built without cgo, which is required to
+// load PKCS#11 modules.
+var errNoCgo = errors.New("PKCS#11 support requires SOPS to be built with cgo")
+
+func encrypt(key *MasterKey, modulePath, pin string, dataKey []byte) ([]byte, error) {
+	return nil, errNoCgo
+}
+
+func decrypt(key *MasterKey, modulePath, pin string, encryptedKey []byte) ([]byte, error) {
+	return nil, errNoCgo
+}
@@ -39,7 +39,9 @@ package sops // import "github.com/getsops/sops/v3"
 import (
 	"crypto/rand"
 	"crypto/sha512"
+	"errors"
 	"fmt"
+	"math/big"
 	"reflect"
 	"regexp"
 	"slices"
@@ -74,6 +76,9 @@ func (e sopsError) Error() string {
 // MacMismatch occurs when the computed MAC does not match the expected ones
 const MacMismatch = sopsError("MAC mismatch")
 
+// MetadataMacMismatch occurs when the MAC cannot be decrypted because the metadata it authenticates has been changed
+const MetadataMacMismatch = sopsError("metadata MAC mismatch")
+
 // MetadataNotFound occurs when the input file is malformed and doesn't have sops metadata in it
 const MetadataNotFound = sopsError("sops metadata not found")
 
@@ -92,12 +97,45 @@ func (e *SopsKeyNotFound) Error() string {
 // The following numbers are taken from the output of `echo -n sops | sha256sum` (shell) or `hashlib.sha256(b'sops').hexdigest()` (Python).
 var MACOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
 
+// Versions of the MAC, as recorded in Metadata.MACVersion. The MAC of files
+// with MACVersionValues only covers their values, the MAC of files with
+// MACVersionMetadata also covers the metadata which tells which values are
+// encrypted and who can decrypt them.
+const (
+	MACVersionValues   = 1
+	MACVersionMetadata = 2
+)
+
 var log *logrus.Logger
 
 func init() {
 	log = logging.NewLogger("SOPS")
 }
 
+// Names of the ciphers the values of a file can be encrypted with, as
+// recorded in Metadata.Cipher.
+const (
+	CipherAES256GCM         = "aes256_gcm"
+	CipherXChaCha20Poly1305 = "xchacha20_poly1305"
+)
+
+// CipherSelector is implemented by ciphers which decrypt values encrypted with
+// any of several ciphers, and encrypt them with the one named by the Cipher
+// field of the metadata of the file.
+type CipherSelector interface {
+	// Select returns the cipher to encrypt values with the named cipher.
+	Select(name string) (Cipher, error)
+}
+
+// SelectCipher returns the cipher to encrypt the values of a file with the
+// named cipher, which is cipher itself if it is not a CipherSelector.
+func SelectCipher(cipher Cipher, name string) (Cipher, error) {
+	if s, ok := cipher.(CipherSelector); ok {
+		return s.Select(name)
+	}
+	return cipher, nil
+}
+
 // Cipher provides a way to encrypt and decrypt the data key used to encrypt and decrypt sops files, so that the
 // data key can be stored alongside the encrypted content. A Cipher must be able to decrypt the values it encrypts.
 type Cipher interface {
@@ -112,6 +150,134 @@ type Cipher interface {
 // Comment represents a comment in the sops tree for the file formats that actually support them.
 type Comment struct {
 	Value string
+	// Inline is set for comments written after the value before them, on
+	// the same line.
+	Inline bool
+}
+
+// Number represents a number which neither an int nor a float64 can hold
+// exactly, such as a large account number or a decimal with many digits, as
+// the text it is written with. The text follows the syntax of JSON numbers.
+// Stores only use Number for such numbers, see ParseInt and ParseFloat.
+type Number string
+
+// MarshalJSON writes the number as it is written in its file.
+func (n Number) MarshalJSON() ([]byte, error) {
+	return []byte(n), nil
+}
+
+// Timestamp represents a date or a date and time as the text it is written
+// with, so that it is written back in the same format.
+type Timestamp string
+
+// timestampLayouts are the layouts of the timestamps which the YAML and TOML
+// stores used to load as time.Time values.
+var timestampLayouts = []string{
+	"2006-1-2T15:4:5.999999999Z07:00",
+	"2006-1-2t15:4:5.999999999Z07:00",
+	"2006-1-2 15:4:5.999999999Z07:00",
+	"2006-1-2 15:4:5.999999999",
+	"2006-1-2",
+}
+
+// parse returns the time of the timestamp if it is written with one of
+// timestampLayouts.
+func (t Timestamp) parse() (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, string(t)); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// numberRe matches the syntax of JSON numbers.
+var numberRe = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// ParseInt parses an integer, returning an int, or a Number if it is too
+// large for an int.
+func ParseInt(s string) (interface{}, error) {
+	i, err := strconv.Atoi(s)
+	if errors.Is(err, strconv.ErrRange) && numberRe.MatchString(s) {
+		return Number(s), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// ParseFloat parses a number, returning a float64, or a Number if a float64
+// cannot hold it exactly. A float64 holds a number exactly when its shortest
+// representation has the same value, so that 0.1 is a float64 while
+// 0.10000000000000000001 and 12345678901234567890 are Numbers.
+func ParseFloat(s string) (interface{}, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, err
+	}
+	if !numberRe.MatchString(s) {
+		// Other syntaxes, such as hexadecimal numbers, infinities or NaN,
+		// do not have a more exact representation.
+		return f, err
+	}
+	if err != nil {
+		return Number(s), nil
+	}
+	if f == 0 {
+		// Numbers too small for a float64 are not compared as rationals,
+		// as their exponent can be arbitrarily large.
+		mantissa, _, _ := strings.Cut(strings.ToLower(s), "e")
+		if strings.ContainsAny(mantissa, "123456789") {
+			return Number(s), nil
+		}
+		return f, nil
+	}
+	exact, _ := new(big.Rat).SetString(s)
+	shortest, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	if exact.Cmp(shortest) != 0 {
+		return Number(s), nil
+	}
+	return f, nil
+}
+
+// Anchored represents a value marked with an anchor, which Alias values can
+// refer to, for the file formats that actually support them.
+type Anchored struct {
+	Anchor string
+	Value  interface{}
+}
+
+// Alias represents a reference to the value of the last anchor with the same
+// name preceding it in the document. Aliases are not encrypted, as the value
+// they refer to is encrypted where its anchor is.
+type Alias struct {
+	Anchor string
+}
+
+// Styled represents a value with the style it is written with in its file,
+// such as the quoting of a YAML scalar, so that it is written back the same
+// way. The style is only meaningful to the store which loaded the value, and
+// is dropped by TreeBranch.ExpandAliases along with anchors, unless the
+// branch is emitted by a StyleEmitter.
+type Styled struct {
+	Style interface{}
+	Value interface{}
+}
+
+// StyleEmitter is the interface implemented by stores which emit the styles
+// of the values they load, so that TreeBranch.ExpandAliasesKeepingStyles is
+// used to expand aliases for them. These stores ignore the styles of values
+// loaded by other stores.
+type StyleEmitter interface {
+	EmitsStyles() bool
+}
+
+// AliasEmitter is the interface implemented by stores which are able to emit
+// Anchored and Alias values. Aliases must be expanded with
+// TreeBranch.ExpandAliases before emitting branches with other stores.
+type AliasEmitter interface {
+	EmitsAliases() bool
 }
 
 // TreeItem is an item inside sops's tree
@@ -157,7 +323,19 @@ func equals(oneBranch interface{}, otherBranch interface{}) bool {
 		if !ok {
 			return false
 		}
-		return oneBranch.Value == otherBranch.Value
+		return oneBranch == otherBranch
+	case Anchored:
+		otherBranch, ok := otherBranch.(Anchored)
+		if !ok {
+			return false
+		}
+		return oneBranch.Anchor == otherBranch.Anchor && equals(oneBranch.Value, otherBranch.Value)
+	case Styled:
+		otherBranch, ok := otherBranch.(Styled)
+		if !ok {
+			return false
+		}
+		return oneBranch.Style == otherBranch.Style && equals(oneBranch.Value, otherBranch.Value)
 	default:
 		// Unexpected type
 		return oneBranch == otherBranch
@@ -205,8 +383,8 @@ func set(branch interface{}, path []interface{}, value interface{}) (interface{}
 			if item.Key == path[0] {
 				var changed bool
 				if len(path) == 1 {
-					changed = !equals(branch[i].Value, value)
-					branch[i].Value = value
+					changed = !equals(unstyled(branch[i].Value), value)
+					branch[i].Value = withStyleOf(branch[i].Value, value)
 				} else {
 					branch[i].Value, changed = set(item.Value, path[1:], value)
 				}
@@ -226,8 +404,8 @@ func set(branch interface{}, path []interface{}, value interface{}) (interface{}
 			if position >= len(branch) {
 				return append(branch, value), true
 			}
-			changed = !equals(branch[position], value)
-			branch[position] = value
+			changed = !equals(unstyled(branch[position]), value)
+			branch[position] = withStyleOf(branch[position], value)
 		} else {
 			if position >= len(branch) {
 				branch = append(branch, valueFromPathAndLeaf(path[1:], value))
@@ -237,12 +415,36 @@ func set(branch interface{}, path []interface{}, value interface{}) (interface{}
 			}
 		}
 		return branch, changed
+	case Anchored:
+		var changed bool
+		branch.Value, changed = set(branch.Value, path, value)
+		return branch, changed
+	case Styled:
+		var changed bool
+		branch.Value, changed = set(branch.Value, path, value)
+		return branch, changed
 	default:
 		newValue := valueFromPathAndLeaf(path, value)
 		return newValue, !equals(branch, newValue)
 	}
 }
 
+func unstyled(v interface{}) interface{} {
+	if styled, ok := v.(Styled); ok {
+		return styled.Value
+	}
+	return v
+}
+
+// withStyleOf returns value with the style of old, so that setting a value
+// does not change how it is written.
+func withStyleOf(old interface{}, value interface{}) interface{} {
+	if styled, ok := old.(Styled); ok {
+		return Styled{Style: styled.Style, Value: value}
+	}
+	return value
+}
+
 // Set sets a value on a given tree for the specified path
 func (branch TreeBranch) Set(path []interface{}, value interface{}) (TreeBranch, bool) {
 	v, changed := set(branch, path, value)
@@ -282,6 +484,20 @@ func unset(branch interface{}, path []interface{}) (interface{}, error) {
 			branch[position] = v
 		}
 		return branch, nil
+	case Anchored:
+		v, err := unset(branch.Value, path)
+		if err != nil {
+			return nil, err
+		}
+		branch.Value = v
+		return branch, nil
+	case Styled:
+		v, err := unset(branch.Value, path)
+		if err != nil {
+			return nil, err
+		}
+		branch.Value = v
+		return branch, nil
 	default:
 		return nil, fmt.Errorf("Unsupported type: %T for item '%s'", branch, path[0])
 	}
@@ -311,8 +527,12 @@ func (branch TreeBranch) Truncate(path []interface{}) (interface{}, error) {
 	for _, component := range path {
 		switch component := component.(type) {
 		case string:
+			branch, ok := current.(TreeBranch)
+			if !ok {
+				return nil, fmt.Errorf("component ['%s'] is a key, but tree part is not a map", component)
+			}
 			found := false
-			for _, item := range current.(TreeBranch) {
+			for _, item := range branch {
 				if item.Key == component {
 					current = item.Value
 					found = true
@@ -335,12 +555,137 @@ func (branch TreeBranch) Truncate(path []interface{}) (interface{}, error) {
 	return current, nil
 }
 
-func (branch TreeBranch) walkValue(in interface{}, path []string, commentsStack [][]string, onLeaves func(in interface{}, path []string, commentsStack [][]string) (interface{}, error)) (interface{}, error) {
+// ExpandAliases returns a copy of the branch in which Alias values are
+// replaced by a copy of the value of their anchor, and Anchored and Styled
+// values by their value. Merge keys ("<<") whose value is a mapping or a
+// sequence of mappings are replaced by the keys of these mappings, following
+// the YAML merge key semantics: keys of the branch itself win over merged
+// keys, and earlier mappings of a sequence win over later ones.
+func (branch TreeBranch) ExpandAliases() (TreeBranch, error) {
+	v, err := expandAliases(branch, make(map[string]interface{}), false)
+	if err != nil {
+		return nil, err
+	}
+	return v.(TreeBranch), nil
+}
+
+// ExpandAliasesKeepingStyles is like ExpandAliases, but keeps Styled values,
+// for stores which emit the styles of the values they load.
+func (branch TreeBranch) ExpandAliasesKeepingStyles() (TreeBranch, error) {
+	v, err := expandAliases(branch, make(map[string]interface{}), true)
+	if err != nil {
+		return nil, err
+	}
+	return v.(TreeBranch), nil
+}
+
+func expandAliases(in interface{}, anchors map[string]interface{}, keepStyles bool) (interface{}, error) {
+	switch in := in.(type) {
+	case TreeBranch:
+		out := make(TreeBranch, 0, len(in))
+		// Explicit keys win over merged keys, wherever they appear.
+		explicit := make(map[interface{}]bool)
+		for _, item := range in {
+			if _, ok := item.Key.(Comment); !ok && item.Key != mergeKey {
+				explicit[item.Key] = true
+			}
+		}
+		merged := make(map[interface{}]bool)
+		for _, item := range in {
+			v, err := expandAliases(item.Value, anchors, keepStyles)
+			if err != nil {
+				return nil, err
+			}
+			if item.Key == mergeKey {
+				if sources, ok := mergeSources(v); ok {
+					for _, source := range sources {
+						for _, mergedItem := range source {
+							if _, ok := mergedItem.Key.(Comment); ok {
+								continue
+							}
+							if explicit[mergedItem.Key] || merged[mergedItem.Key] {
+								continue
+							}
+							merged[mergedItem.Key] = true
+							out = append(out, mergedItem)
+						}
+					}
+					continue
+				}
+			}
+			out = append(out, TreeItem{Key: item.Key, Value: v})
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, 0, len(in))
+		for _, item := range in {
+			v, err := expandAliases(item, anchors, keepStyles)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case Anchored:
+		v, err := expandAliases(in.Value, anchors, keepStyles)
+		if err != nil {
+			return nil, err
+		}
+		anchors[in.Anchor] = v
+		return v, nil
+	case Styled:
+		v, err := expandAliases(in.Value, anchors, keepStyles)
+		if err != nil || !keepStyles {
+			return v, err
+		}
+		return Styled{Style: in.Style, Value: v}, nil
+	case Alias:
+		v, ok := anchors[in.Anchor]
+		if !ok {
+			return nil, fmt.Errorf("unknown anchor %q", in.Anchor)
+		}
+		// Copy the value, so that changing one of its occurrences does not
+		// change the others.
+		return expandAliases(v, anchors, keepStyles)
+	default:
+		return in, nil
+	}
+}
+
+// mergeKey is the key of YAML merge keys
+const mergeKey = "<<"
+
+// mergeSources returns the mappings merged by a merge key with the given
+// (expanded) value, in order of precedence. It returns false if the value is
+// neither a mapping nor a sequence of mappings, in which case the key is not
+// a merge key and is kept as is.
+func mergeSources(value interface{}) ([]TreeBranch, bool) {
+	switch value := value.(type) {
+	case Styled:
+		return mergeSources(value.Value)
+	case TreeBranch:
+		return []TreeBranch{value}, true
+	case []interface{}:
+		sources := make([]TreeBranch, 0, len(value))
+		for _, v := range value {
+			source, ok := mergeSources(v)
+			if !ok || len(source) != 1 {
+				return nil, false
+			}
+			sources = append(sources, source[0])
+		}
+		return sources, true
+	default:
+		return nil, false
+	}
+}
+
+func (branch TreeBranch) walkValue(in interface{}, path []interface{}, commentsStack [][]string, onLeaves func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error)) (interface{}, error) {
 	switch in := in.(type) {
 	case string:
 		return onLeaves(in, path, commentsStack)
 	case []byte:
-		return onLeaves(string(in), path, commentsStack)
+		return onLeaves(in, path, commentsStack)
 	case int:
 		return onLeaves(in, path, commentsStack)
 	case bool:
@@ -349,22 +694,46 @@ func (branch TreeBranch) walkValue(in interface{}, path []string, commentsStack
 		return onLeaves(in, path, commentsStack)
 	case time.Time:
 		return onLeaves(in, path, commentsStack)
+	case Number:
+		return onLeaves(in, path, commentsStack)
+	case Timestamp:
+		return onLeaves(in, path, commentsStack)
 	case Comment:
 		return onLeaves(in, path, commentsStack)
+	case Anchored:
+		v, err := branch.walkValue(in.Value, path, commentsStack, onLeaves)
+		if err != nil {
+			return nil, err
+		}
+		return Anchored{Anchor: in.Anchor, Value: v}, nil
+	case Styled:
+		v, err := branch.walkValue(in.Value, path, commentsStack, onLeaves)
+		if err != nil {
+			return nil, err
+		}
+		return Styled{Style: in.Style, Value: v}, nil
+	case Alias:
+		// The value an alias refers to is walked where its anchor is.
+		return in, nil
+	case selected:
+		return branch.walkValue(in.Value, path, commentsStack, func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error) {
+			if _, ok := in.(selectedLeaf); !ok {
+				in = selectedLeaf{Value: in}
+			}
+			return onLeaves(in, path, commentsStack)
+		})
 	case TreeBranch:
 		return branch.walkBranch(in, path, commentsStack, onLeaves)
 	case []interface{}:
 		return branch.walkSlice(in, path, commentsStack, onLeaves)
 	case nil:
-		// the value returned remains the same since it doesn't make
-		// sense to encrypt or decrypt a nil value
-		return nil, nil
+		return onLeaves(in, path, commentsStack)
 	default:
 		return nil, fmt.Errorf("Cannot walk value, unknown type: %T", in)
 	}
 }
 
-func (branch TreeBranch) walkSlice(in []interface{}, path []string, commentsStack [][]string, onLeaves func(in interface{}, path []string, commentsStack [][]string) (interface{}, error)) ([]interface{}, error) {
+func (branch TreeBranch) walkSlice(in []interface{}, path []interface{}, commentsStack [][]string, onLeaves func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error)) ([]interface{}, error) {
 	// Because append returns a new slice, the original stack is not changed.
 	commentsStack = append(commentsStack, []string{})
 	for i, v := range in {
@@ -374,10 +743,25 @@ func (branch TreeBranch) walkSlice(in []interface{}, path []string, commentsStac
 			// This allows us to also encrypt comments themselves by enabling encryption in a prior comment.
 			commentsStack[len(commentsStack)-1] = append(commentsStack[len(commentsStack)-1], c.Value)
 		}
-		newV, err := branch.walkValue(v, path, commentsStack, onLeaves)
+		newV, err := branch.walkValue(v, append(path, i), commentsStack, onLeaves)
 		if err != nil {
 			return nil, err
 		}
+		if vIsComment && c.Value == "" {
+			// Empty comments are blank lines, which are not encrypted.
+			newV = c
+		}
+		if vIsComment && c.Inline {
+			// Inline comments stay comments once encrypted, so that they
+			// are still written after the item they refer to.
+			switch comment := newV.(type) {
+			case string:
+				newV = Comment{Value: comment, Inline: true}
+			case Comment:
+				comment.Inline = true
+				newV = comment
+			}
+		}
 		in[i] = newV
 		if !vIsComment {
 			// If v is not a comment, we clear the slice of active comments.
@@ -387,7 +771,7 @@ func (branch TreeBranch) walkSlice(in []interface{}, path []string, commentsStac
 	return in, nil
 }
 
-func (branch TreeBranch) walkBranch(in TreeBranch, path []string, commentsStack [][]string, onLeaves func(in interface{}, path []string, commentsStack [][]string) (interface{}, error)) (TreeBranch, error) {
+func (branch TreeBranch) walkBranch(in TreeBranch, path []interface{}, commentsStack [][]string, onLeaves func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error)) (TreeBranch, error) {
 	// Because append returns a new slice, the original stack is not changed.
 	commentsStack = append(commentsStack, []string{})
 	for i, item := range in {
@@ -400,10 +784,11 @@ func (branch TreeBranch) walkBranch(in TreeBranch, path []string, commentsStack
 				return nil, err
 			}
 			if encComment, ok := enc.(Comment); ok {
+				encComment.Inline = c.Inline
 				in[i].Key = encComment
 				continue
 			} else if comment, ok := enc.(string); ok {
-				in[i].Key = Comment{Value: comment}
+				in[i].Key = Comment{Value: comment, Inline: c.Inline}
 				continue
 			} else {
 				return nil, fmt.Errorf("walkValue of Comment should be either Comment or string, was %T", enc)
@@ -433,7 +818,47 @@ func (branch TreeBranch) walkBranch(in TreeBranch, path []string, commentsStack
 	return in, nil
 }
 
-func (tree Tree) shouldBeEncrypted(path []string, commentsStack [][]string, isComment bool) bool {
+// keysOf returns the keys of a path, without the indices of list items.
+func keysOf(path []interface{}) []string {
+	var keys []string
+	for _, p := range path {
+		if key, ok := p.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// additionalData returns the additional data values at path are encrypted
+// with. It only consists of the keys of the path, so that list items can be
+// reordered.
+func additionalData(path []interface{}) string {
+	return strings.Join(keysOf(path), ":") + ":"
+}
+
+// formatPath formats a path for error messages.
+func formatPath(path []interface{}) string {
+	var b strings.Builder
+	for _, p := range path {
+		switch p := p.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", p)
+		default:
+			fmt.Fprintf(&b, "[%q]", p)
+		}
+	}
+	return b.String()
+}
+
+// cryptPaths are the encrypted and unencrypted paths of a tree, as compiled
+// by Metadata.cryptPaths.
+type cryptPaths struct {
+	encrypted   []PathGlob
+	unencrypted []PathGlob
+}
+
+func (tree Tree) shouldBeEncrypted(paths cryptPaths, fullPath []interface{}, commentsStack [][]string, isComment bool) bool {
+	path := keysOf(fullPath)
 	encrypted := true
 	if tree.Metadata.UnencryptedSuffix != "" {
 		for _, v := range path {
@@ -471,6 +896,14 @@ func (tree Tree) shouldBeEncrypted(path []string, commentsStack [][]string, isCo
 			}
 		}
 	}
+	if len(paths.encrypted) > 0 {
+		encrypted = selectsPath(paths.encrypted, fullPath)
+	}
+	if selectsPath(paths.unencrypted, fullPath) {
+		// Unencrypted paths take precedence over encrypted paths, so
+		// that parts of the values they select can be left unencrypted.
+		encrypted = false
+	}
 	if tree.Metadata.UnencryptedCommentRegex != "" {
 	unencryptedComments:
 		for _, cs := range commentsStack {
@@ -519,21 +952,46 @@ func (tree Tree) shouldBeEncrypted(path []string, commentsStack [][]string, isCo
 // If encryption is successful, it returns the MAC for the encrypted tree
 // (all values if MACOnlyEncrypted is false, or only over values which end
 // up encrypted if MACOnlyEncrypted is true).
+// Values in a zone are encrypted with the data key of the zone instead of
+// key, and are part of the MAC as their ciphertext. Values of zones which are
+// locked must already be encrypted, and are kept as they are.
 func (tree Tree) Encrypt(key []byte, cipher Cipher) (string, error) {
 	audit.SubmitEvent(audit.EncryptEvent{
 		File: tree.FilePath,
 	})
+	cipher, err := SelectCipher(cipher, tree.Metadata.Cipher)
+	if err != nil {
+		return "", err
+	}
 	hash := sha512.New()
 	if tree.Metadata.MACOnlyEncrypted {
 		// We initialize with known set of bytes so that a MAC with this setting
 		// enabled is always different from a MAC with this setting disabled.
 		hash.Write(MACOnlyEncryptedInitialization)
 	}
+	zones := tree.Metadata.zoneGlobs()
+	paths := tree.Metadata.cryptPaths()
 	walk := func(branch TreeBranch) error {
-		_, err := branch.walkBranch(branch, make([]string, 0), make([][]string, 0), func(in interface{}, path []string, commentsStack [][]string) (interface{}, error) {
+		_, err := branch.walkBranch(branch, make([]interface{}, 0), make([][]string, 0), func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error) {
 			_, ok := in.(Comment)
-			encrypted := tree.shouldBeEncrypted(path, commentsStack, ok)
-			if !tree.Metadata.MACOnlyEncrypted || encrypted {
+			encrypted := tree.shouldBeEncrypted(paths, path, commentsStack, ok)
+			if in == nil && !encrypted {
+				// Null values were neither encrypted nor part of the MAC
+				// before they had a datatype, and still are not when they
+				// are left unencrypted.
+				return nil, nil
+			}
+			var zone *Zone
+			if encrypted {
+				zone = tree.Metadata.zoneOf(zones, path)
+			}
+			if zone != nil && zone.DataKey == nil {
+				// Values of zones whose data key could not be decrypted
+				// were not decrypted either, and are kept as they are.
+				if !isEncryptedOrEmpty(in) {
+					return nil, fmt.Errorf("Could not encrypt value at %s: the data key of its zone could not be decrypted", formatPath(path))
+				}
+			} else if zone == nil && (!tree.Metadata.MACOnlyEncrypted || encrypted) {
 				// Only add to MAC if not a comment
 				if !ok {
 					bytes, err := ToBytes(in)
@@ -543,10 +1001,14 @@ func (tree Tree) Encrypt(key []byte, cipher Cipher) (string, error) {
 					hash.Write(bytes)
 				}
 			}
-			if encrypted {
+			if encrypted && (zone == nil || zone.DataKey != nil) {
 				var err error
-				pathString := strings.Join(path, ":") + ":"
-				in, err = cipher.Encrypt(in, key, pathString)
+				leafKey := key
+				if zone != nil {
+					leafKey = zone.DataKey
+				}
+				pathString := additionalData(path)
+				in, err = cipher.Encrypt(in, leafKey, pathString)
 				if err != nil {
 					return nil, fmt.Errorf("Could not encrypt value: %s", err)
 				}
@@ -559,6 +1021,11 @@ func (tree Tree) Encrypt(key []byte, cipher Cipher) (string, error) {
 					}
 				}
 			}
+			if zone != nil && !ok {
+				// Values of zones are part of the MAC as their ciphertext,
+				// so that it can be verified without their data key.
+				hash.Write([]byte(in.(string)))
+			}
 			return in, nil
 		})
 		return err
@@ -581,6 +1048,9 @@ func (tree Tree) Encrypt(key []byte, cipher Cipher) (string, error) {
 // If decryption is successful, it returns the MAC for the decrypted tree
 // (all values if MACOnlyEncrypted is false, or only over values which end
 // up decrypted if MACOnlyEncrypted is true).
+// Values in a zone are decrypted with the data key of the zone instead of
+// key, and are part of the MAC as their ciphertext. Values of zones which are
+// locked are left encrypted.
 func (tree Tree) Decrypt(key []byte, cipher Cipher) (string, error) {
 	log.Debug("Decrypting tree")
 	audit.SubmitEvent(audit.DecryptEvent{
@@ -592,16 +1062,45 @@ func (tree Tree) Decrypt(key []byte, cipher Cipher) (string, error) {
 		// enabled is always different from a MAC with this setting disabled.
 		hash.Write(MACOnlyEncryptedInitialization)
 	}
+	zones := tree.Metadata.zoneGlobs()
+	paths := tree.Metadata.cryptPaths()
 	walk := func(branch TreeBranch) error {
-		_, err := branch.walkBranch(branch, make([]string, 0), make([][]string, 0), func(in interface{}, path []string, commentsStack [][]string) (interface{}, error) {
+		_, err := branch.walkBranch(branch, make([]interface{}, 0), make([][]string, 0), func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error) {
 			c, ok := in.(Comment)
-			encrypted := tree.shouldBeEncrypted(path, commentsStack, ok)
+			encrypted := tree.shouldBeEncrypted(paths, path, commentsStack, ok)
+			if in == nil {
+				// Encrypted null values are strings, so this one was
+				// left unencrypted.
+				return nil, nil
+			}
+			var zone *Zone
+			if encrypted {
+				zone = tree.Metadata.zoneOf(zones, path)
+			}
+			if zone != nil && !ok {
+				// Values of zones are part of the MAC as their ciphertext,
+				// so that it can be verified without their data key.
+				s, isString := in.(string)
+				if !isString {
+					return nil, fmt.Errorf("Could not decrypt value at %s: it is not encrypted", formatPath(path))
+				}
+				hash.Write([]byte(s))
+			}
+			if zone != nil && zone.DataKey == nil {
+				// The value is left encrypted, as the data key of its zone
+				// could not be decrypted.
+				return in, nil
+			}
 			var v interface{}
 			if encrypted {
 				var err error
-				pathString := strings.Join(path, ":") + ":"
+				leafKey := key
+				if zone != nil {
+					leafKey = zone.DataKey
+				}
+				pathString := additionalData(path)
 				if ok {
-					v, err = cipher.Decrypt(c.Value, key, pathString)
+					v, err = cipher.Decrypt(c.Value, leafKey, pathString)
 					if err != nil {
 						// Assume the comment was not encrypted in the first place
 						log.WithField("comment", c.Value).
@@ -612,7 +1111,7 @@ func (tree Tree) Decrypt(key []byte, cipher Cipher) (string, error) {
 						v = c
 					}
 				} else {
-					v, err = cipher.Decrypt(in.(string), key, pathString)
+					v, err = cipher.Decrypt(in.(string), leafKey, pathString)
 					if err != nil {
 						return nil, fmt.Errorf("Could not decrypt value: %s", err)
 					}
@@ -620,7 +1119,7 @@ func (tree Tree) Decrypt(key []byte, cipher Cipher) (string, error) {
 			} else {
 				v = in
 			}
-			if !tree.Metadata.MACOnlyEncrypted || encrypted {
+			if zone == nil && (!tree.Metadata.MACOnlyEncrypted || encrypted) {
 				// Only add to MAC if not a comment
 				if _, ok := v.(Comment); !ok {
 					bytes, err := ToBytes(v)
@@ -643,6 +1142,234 @@ func (tree Tree) Decrypt(key []byte, cipher Cipher) (string, error) {
 	return fmt.Sprintf("%X", hash.Sum(nil)), nil
 }
 
+// ComputeCiphertextMAC returns the MAC of an encrypted tree. Unlike the MAC
+// returned by Encrypt and Decrypt, it is computed over the values as they are
+// in the file, so that it can be verified without decrypting all of them. It
+// covers the same values as that MAC, and as each encrypted value is
+// authenticated by its cipher, it still covers their plaintext.
+func (tree Tree) ComputeCiphertextMAC() (string, error) {
+	hash := sha512.New()
+	if tree.Metadata.MACOnlyEncrypted {
+		hash.Write(MACOnlyEncryptedInitialization)
+	}
+	paths := tree.Metadata.cryptPaths()
+	for _, branch := range tree.Branches {
+		_, err := branch.walkBranch(branch, make([]interface{}, 0), make([][]string, 0), func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error) {
+			if _, ok := in.(Comment); ok || in == nil {
+				return in, nil
+			}
+			if tree.Metadata.MACOnlyEncrypted && !tree.shouldBeEncrypted(paths, path, commentsStack, false) {
+				return in, nil
+			}
+			bytes, err := ToBytes(in)
+			if err != nil {
+				return nil, fmt.Errorf("Could not convert %s to bytes: %s", in, err)
+			}
+			// Prefixing values with their length keeps their boundaries
+			// from being moved.
+			fmt.Fprintf(hash, "%d:", len(bytes))
+			hash.Write(bytes)
+			return in, nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("Error walking tree: %s", err)
+		}
+	}
+	return fmt.Sprintf("%X", hash.Sum(nil)), nil
+}
+
+// DecryptPaths decrypts the values at the given paths in the first branch of
+// the tree, and the values of the anchors aliases in them refer to, leaving
+// all other values encrypted. Paths are lists of keys and indices, as taken
+// by TreeBranch.Truncate; paths which are not in the tree are ignored.
+// It returns the MAC computed by ComputeCiphertextMAC before decryption,
+// which is to be compared with the decrypted Metadata.CiphertextMAC.
+func (tree Tree) DecryptPaths(key []byte, cipher Cipher, paths [][]interface{}) (string, error) {
+	log.Debug("Decrypting paths of tree")
+	audit.SubmitEvent(audit.DecryptEvent{
+		File: tree.FilePath,
+	})
+	mac, err := tree.ComputeCiphertextMAC()
+	if err != nil {
+		return "", err
+	}
+	if len(tree.Branches) == 0 {
+		return mac, nil
+	}
+	all := false
+	for _, path := range paths {
+		if len(path) == 0 {
+			all = true
+		}
+	}
+	if !all {
+		selectPaths(tree.Branches[0], paths)
+	}
+	zones := tree.Metadata.zoneGlobs()
+	globs := tree.Metadata.cryptPaths()
+	_, err = tree.Branches[0].walkBranch(tree.Branches[0], make([]interface{}, 0), make([][]string, 0), func(in interface{}, path []interface{}, commentsStack [][]string) (interface{}, error) {
+		leaf, ok := in.(selectedLeaf)
+		if !ok && !all {
+			return in, nil
+		}
+		if ok {
+			in = leaf.Value
+		}
+		c, isComment := in.(Comment)
+		if in == nil || !tree.shouldBeEncrypted(globs, path, commentsStack, isComment) {
+			return in, nil
+		}
+		leafKey := key
+		if zone := tree.Metadata.zoneOf(zones, path); zone != nil {
+			if zone.DataKey == nil {
+				return in, nil
+			}
+			leafKey = zone.DataKey
+		}
+		pathString := additionalData(path)
+		if isComment {
+			v, err := cipher.Decrypt(c.Value, leafKey, pathString)
+			if err != nil {
+				// Assume the comment was not encrypted in the first place
+				return c, nil
+			}
+			return v, nil
+		}
+		v, err := cipher.Decrypt(in.(string), leafKey, pathString)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decrypt value: %s", err)
+		}
+		return v, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error walking tree: %s", err)
+	}
+	return mac, nil
+}
+
+// selected marks a value whose leaves are to be decrypted by DecryptPaths.
+// walkValue passes its leaves to onLeaves as selectedLeaf values, and
+// removes the mark.
+type selected struct {
+	Value interface{}
+}
+
+// selectedLeaf is a leaf of a selected value.
+type selectedLeaf struct {
+	Value interface{}
+}
+
+// selectPaths marks the values at paths in branch as selected, as well as
+// the values of the anchors which aliases in selected values refer to.
+// Aliases refer to the last anchor with their name preceding them, but all
+// anchors with that name are selected for simplicity.
+func selectPaths(branch TreeBranch, paths [][]interface{}) {
+	aliases := make(map[string]bool)
+	for _, path := range paths {
+		selectPath(branch, path, aliases)
+	}
+	done := make(map[string]bool)
+	for {
+		anchors := make(map[string]bool)
+		for anchor := range aliases {
+			if !done[anchor] {
+				anchors[anchor] = true
+				done[anchor] = true
+			}
+		}
+		if len(anchors) == 0 {
+			return
+		}
+		selectAnchors(branch, anchors, aliases)
+	}
+}
+
+func selectPath(in interface{}, path []interface{}, aliases map[string]bool) interface{} {
+	switch in := in.(type) {
+	case Anchored:
+		return Anchored{Anchor: in.Anchor, Value: selectPath(in.Value, path, aliases)}
+	case Styled:
+		return Styled{Style: in.Style, Value: selectPath(in.Value, path, aliases)}
+	case Alias:
+		// The value of the alias is selected where its anchor is.
+		aliases[in.Anchor] = true
+		return in
+	}
+	if len(path) == 0 {
+		collectAliases(in, aliases)
+		return selected{Value: in}
+	}
+	switch in := in.(type) {
+	case TreeBranch:
+		found := false
+		for i, item := range in {
+			if item.Key == path[0] {
+				in[i].Value = selectPath(item.Value, path[1:], aliases)
+				found = true
+				break
+			}
+		}
+		if !found {
+			// The key can come from the mappings merged by a merge key,
+			// which are selected as a whole.
+			for i, item := range in {
+				if item.Key == mergeKey {
+					in[i].Value = selectPath(item.Value, nil, aliases)
+				}
+			}
+		}
+	case []interface{}:
+		if index, ok := path[0].(int); ok && index >= 0 && index < len(in) {
+			in[index] = selectPath(in[index], path[1:], aliases)
+		}
+	}
+	return in
+}
+
+func selectAnchors(in interface{}, anchors map[string]bool, aliases map[string]bool) interface{} {
+	switch in := in.(type) {
+	case Anchored:
+		if anchors[in.Anchor] {
+			collectAliases(in.Value, aliases)
+			return Anchored{Anchor: in.Anchor, Value: selected{Value: in.Value}}
+		}
+		return Anchored{Anchor: in.Anchor, Value: selectAnchors(in.Value, anchors, aliases)}
+	case Styled:
+		return Styled{Style: in.Style, Value: selectAnchors(in.Value, anchors, aliases)}
+	case TreeBranch:
+		for i, item := range in {
+			in[i].Value = selectAnchors(item.Value, anchors, aliases)
+		}
+	case []interface{}:
+		for i, item := range in {
+			in[i] = selectAnchors(item, anchors, aliases)
+		}
+	}
+	// Selected values are decrypted along with the anchors in them.
+	return in
+}
+
+func collectAliases(in interface{}, aliases map[string]bool) {
+	switch in := in.(type) {
+	case Alias:
+		aliases[in.Anchor] = true
+	case Anchored:
+		collectAliases(in.Value, aliases)
+	case Styled:
+		collectAliases(in.Value, aliases)
+	case selected:
+		collectAliases(in.Value, aliases)
+	case TreeBranch:
+		for _, item := range in {
+			collectAliases(item.Value, aliases)
+		}
+	case []interface{}:
+		for _, item := range in {
+			collectAliases(item, aliases)
+		}
+	}
+}
+
 // GenerateDataKey generates a new random data key and encrypts it with all MasterKeys.
 func (tree Tree) GenerateDataKey() ([]byte, []error) {
 	newKey := make([]byte, 32)
@@ -665,27 +1392,170 @@ func (tree *Tree) GenerateDataKeyWithKeyServices(svcs []keyservice.KeyServiceCli
 
 // Metadata holds information about a file encrypted by sops
 type Metadata struct {
-	LastModified              time.Time
-	UnencryptedSuffix         string
-	EncryptedSuffix           string
-	UnencryptedRegex          string
-	EncryptedRegex            string
-	UnencryptedCommentRegex   string
-	EncryptedCommentRegex     string
+	LastModified            time.Time
+	UnencryptedSuffix       string
+	EncryptedSuffix         string
+	UnencryptedRegex        string
+	EncryptedRegex          string
+	UnencryptedCommentRegex string
+	EncryptedCommentRegex   string
+	// EncryptedPaths are the paths, as PathGlob, of the only values to
+	// encrypt. UnencryptedPaths are the paths of values to leave
+	// unencrypted, even if they are in EncryptedPaths.
+	EncryptedPaths            []string
+	UnencryptedPaths          []string
 	MessageAuthenticationCode string
-	MACOnlyEncrypted          bool
-	Version                   string
-	KeyGroups                 []KeyGroup
+	// CiphertextMAC is the encrypted MAC computed by Tree.ComputeCiphertextMAC,
+	// which allows verifying the file when only some of its values are
+	// decrypted. It is empty for files created before it was introduced.
+	CiphertextMAC    string
+	MACOnlyEncrypted bool
+	// MACVersion is the version of the MAC. It is zero for files created
+	// before the MAC was versioned, which are handled as MACVersionValues.
+	MACVersion int
+	// Cipher is the name of the cipher values are encrypted with. It is
+	// empty for CipherAES256GCM, the default.
+	Cipher    string
+	Version   string
+	KeyGroups []KeyGroup
 	// ShamirThreshold is the number of key groups required to recover the
 	// original data key
 	ShamirThreshold int
 	// DataKey caches the decrypted data key so it doesn't have to be decrypted with a master key every time it's needed
 	DataKey []byte
+	// Zones are the parts of the tree whose values are encrypted with their
+	// own data key instead of DataKey.
+	Zones []Zone
 }
 
 // KeyGroup is a slice of SOPS MasterKeys that all encrypt the same part of the data key
 type KeyGroup []keys.MasterKey
 
+// Zone is a part of the tree whose values are encrypted with a data key of
+// its own, so that they can only be decrypted by the master keys of the zone.
+// Values outside of all zones are encrypted with the data key of the file.
+type Zone struct {
+	// Paths select the values of the zone, as PathGlob.
+	Paths     []string
+	KeyGroups []KeyGroup
+	// ShamirThreshold is the number of key groups required to recover the
+	// data key of the zone
+	ShamirThreshold int
+	// DataKey caches the decrypted data key of the zone. It is nil while
+	// the zone is locked, that is when none of its master keys could
+	// decrypt it.
+	DataKey []byte
+}
+
+func (z Zone) metadata() Metadata {
+	return Metadata{
+		KeyGroups:       z.KeyGroups,
+		ShamirThreshold: z.ShamirThreshold,
+		DataKey:         z.DataKey,
+	}
+}
+
+// GenerateDataKeyWithKeyServices generates a new random data key for the zone and encrypts it with the master keys of
+// the zone.
+func (z *Zone) GenerateDataKeyWithKeyServices(svcs []keyservice.KeyServiceClient) []error {
+	newKey := make([]byte, 32)
+	_, err := rand.Read(newKey)
+	if err != nil {
+		return []error{fmt.Errorf("Could not generate random key: %s", err)}
+	}
+	return z.UpdateMasterKeysWithKeyServices(newKey, svcs)
+}
+
+// UpdateMasterKeysWithKeyServices encrypts the data key of the zone with the master keys of the zone.
+func (z *Zone) UpdateMasterKeysWithKeyServices(dataKey []byte, svcs []keyservice.KeyServiceClient) []error {
+	m := z.metadata()
+	errs := m.UpdateMasterKeysWithKeyServices(dataKey, svcs)
+	if len(errs) > 0 {
+		return errs
+	}
+	z.ShamirThreshold = m.ShamirThreshold
+	z.DataKey = dataKey
+	return nil
+}
+
+// GetDataKeyWithKeyServices retrieves the data key of the zone, asking KeyServices to decrypt it with the master keys
+// of the zone, and caches it in DataKey.
+func (z *Zone) GetDataKeyWithKeyServices(svcs []keyservice.KeyServiceClient, decryptionOrder []string) ([]byte, error) {
+	dataKey, err := z.metadata().GetDataKeyWithKeyServices(svcs, decryptionOrder)
+	if err != nil {
+		return nil, err
+	}
+	z.DataKey = dataKey
+	return dataKey, nil
+}
+
+// UnlockZones retrieves the data keys of the zones with the local key service. See UnlockZonesWithKeyServices.
+func (m *Metadata) UnlockZones() {
+	m.UnlockZonesWithKeyServices([]keyservice.KeyServiceClient{
+		keyservice.NewLocalClient(),
+	}, nil)
+}
+
+// UnlockZonesWithKeyServices retrieves the data keys of the zones, asking KeyServices to decrypt them with the master
+// keys of each zone. Zones whose data key cannot be decrypted stay locked: their values are neither decrypted nor
+// encrypted again.
+func (m *Metadata) UnlockZonesWithKeyServices(svcs []keyservice.KeyServiceClient, decryptionOrder []string) {
+	for i := range m.Zones {
+		zone := &m.Zones[i]
+		if zone.DataKey != nil {
+			continue
+		}
+		dataKey, err := zone.metadata().GetDataKeyWithKeyServices(svcs, decryptionOrder)
+		if err != nil {
+			log.WithField("paths", strings.Join(zone.Paths, ", ")).
+				Info("Could not decrypt the data key of zone, leaving its values encrypted")
+			continue
+		}
+		zone.DataKey = dataKey
+	}
+}
+
+// zoneGlobs compiles the paths of each zone, so that they are compiled once
+// per walk of the tree rather than for each value.
+func (m Metadata) zoneGlobs() [][]PathGlob {
+	globs := make([][]PathGlob, len(m.Zones))
+	for i, zone := range m.Zones {
+		globs[i] = compilePathGlobs(zone.Paths)
+	}
+	return globs
+}
+
+// cryptPaths compiles the encrypted and unencrypted paths, so that they are
+// compiled once per walk of the tree rather than for each value.
+func (m Metadata) cryptPaths() cryptPaths {
+	return cryptPaths{
+		encrypted:   compilePathGlobs(m.EncryptedPaths),
+		unencrypted: compilePathGlobs(m.UnencryptedPaths),
+	}
+}
+
+// zoneOf returns the first zone whose paths, as compiled by zoneGlobs,
+// select the value at path, or nil if the value is in no zone.
+func (m Metadata) zoneOf(globs [][]PathGlob, path []interface{}) *Zone {
+	for i := range m.Zones {
+		if selectsPath(globs[i], path) {
+			return &m.Zones[i]
+		}
+	}
+	return nil
+}
+
+// isEncryptedOrEmpty reports whether a value, or the value of a comment, is
+// encrypted or empty, and therefore can be kept as it is in an encrypted
+// tree.
+func isEncryptedOrEmpty(in interface{}) bool {
+	if c, ok := in.(Comment); ok {
+		in = c.Value
+	}
+	s, ok := in.(string)
+	return ok && (s == "" || strings.HasPrefix(s, "ENC["))
+}
+
 // EncryptedFileLoader is the interface for loading of encrypted files. It provides a
 // way to load encrypted SOPS files into the internal SOPS representation. Because it
 // loads encrypted files, the returned data structure already contains all SOPS
@@ -802,6 +1672,11 @@ func (m *Metadata) UpdateMasterKeysWithKeyServices(dataKey []byte, svcs []keyser
 					continue
 				}
 				key.SetEncryptedDataKey(rsp.Ciphertext)
+				// Keys whose version was left unset record the version the
+				// key service encrypted the data key with.
+				if r, ok := key.(keys.VersionResolver); ok && rsp.KeyVersion != "" {
+					r.SetVersion(rsp.KeyVersion)
+				}
 				encrypted = true
 				// Only need to encrypt the key successfully with one service
 				break
@@ -953,7 +1828,149 @@ func (m Metadata) GetDataKey() ([]byte, error) {
 	}, nil)
 }
 
-// ToBytes converts a string, int, float or bool to a byte representation.
+// EncryptMAC encrypts a MAC computed by Tree.Encrypt or
+// Tree.ComputeCiphertextMAC with the data key, using the cipher recorded in
+// the metadata.
+func (m Metadata) EncryptMAC(mac string, key []byte, cipher Cipher) (string, error) {
+	additionalData, err := m.macAdditionalData()
+	if err != nil {
+		return "", err
+	}
+	cipher, err = SelectCipher(cipher, m.Cipher)
+	if err != nil {
+		return "", err
+	}
+	return cipher.Encrypt(mac, key, additionalData)
+}
+
+// DecryptMAC decrypts the MAC stored in the metadata with the data key. It
+// returns an error wrapping MetadataMacMismatch if the MAC authenticates the
+// metadata and the metadata has been changed since it was encrypted.
+func (m Metadata) DecryptMAC(key []byte, cipher Cipher) (string, error) {
+	return m.decryptMAC(m.MessageAuthenticationCode, key, cipher)
+}
+
+// DecryptCiphertextMAC decrypts the MAC of the encrypted values stored in the
+// metadata with the data key, like DecryptMAC.
+func (m Metadata) DecryptCiphertextMAC(key []byte, cipher Cipher) (string, error) {
+	return m.decryptMAC(m.CiphertextMAC, key, cipher)
+}
+
+func (m Metadata) decryptMAC(ciphertext string, key []byte, cipher Cipher) (string, error) {
+	additionalData, err := m.macAdditionalData()
+	if err != nil {
+		return "", err
+	}
+	mac, err := cipher.Decrypt(ciphertext, key, additionalData)
+	if err != nil {
+		if m.MACVersion >= MACVersionMetadata {
+			return "", fmt.Errorf("%w: %s", MetadataMacMismatch, err)
+		}
+		// Removing mac_version must not be a way of dropping the
+		// metadata from the MAC.
+		upgraded := m
+		upgraded.MACVersion = MACVersionMetadata
+		if additionalData, err := upgraded.macAdditionalData(); err == nil {
+			if _, err := cipher.Decrypt(ciphertext, key, additionalData); err == nil {
+				return "", fmt.Errorf("%w: mac_version has been removed", MetadataMacMismatch)
+			}
+		}
+		return "", err
+	}
+	s, _ := mac.(string)
+	return s, nil
+}
+
+// macAdditionalData returns the additional data the MAC is encrypted with.
+// It is the time the file was last modified and, from MACVersionMetadata
+// on, a digest of the security-relevant metadata, so that the MAC cannot be
+// decrypted anymore once it has been changed.
+func (m Metadata) macAdditionalData() (string, error) {
+	lastModified := m.LastModified.Format(time.RFC3339)
+	switch m.MACVersion {
+	case 0, MACVersionValues:
+		return lastModified, nil
+	case MACVersionMetadata:
+		return lastModified + ":" + m.metadataDigest(), nil
+	default:
+		return "", fmt.Errorf("unsupported MAC version %d, the file was likely encrypted with a newer version of SOPS", m.MACVersion)
+	}
+}
+
+// metadataDigest returns the hex-encoded SHA-512 digest of the metadata
+// covered by MACVersionMetadata: the encryption selectors, the MAC and
+// cipher settings, the Shamir threshold and the master keys of each key
+// group, and the paths and key groups of each zone. Master keys are sorted
+// within their group, as stores do not keep their order.
+func (m Metadata) metadataDigest() string {
+	hash := sha512.New()
+	field := func(name string, value string) {
+		fmt.Fprintf(hash, "%s=%s\n", name, strconv.Quote(value))
+	}
+	field("mac_version", strconv.Itoa(m.MACVersion))
+	field("mac_only_encrypted", strconv.FormatBool(m.MACOnlyEncrypted))
+	field("cipher", m.Cipher)
+	field("unencrypted_suffix", m.UnencryptedSuffix)
+	field("encrypted_suffix", m.EncryptedSuffix)
+	field("unencrypted_regex", m.UnencryptedRegex)
+	field("encrypted_regex", m.EncryptedRegex)
+	field("unencrypted_comment_regex", m.UnencryptedCommentRegex)
+	field("encrypted_comment_regex", m.EncryptedCommentRegex)
+	// Path selectors are only part of the digest when they are set, so
+	// that it does not change for files which predate them.
+	for _, path := range m.EncryptedPaths {
+		field("encrypted_path", path)
+	}
+	for _, path := range m.UnencryptedPaths {
+		field("unencrypted_path", path)
+	}
+	keyGroups := func(shamirThreshold int, groups []KeyGroup) {
+		field("shamir_threshold", strconv.Itoa(shamirThreshold))
+		for i, group := range groups {
+			field("key_group", strconv.Itoa(i))
+			var recipients []string
+			for _, key := range group {
+				recipients = append(recipients, key.TypeToIdentifier()+":"+key.ToString()+digestAttributes(key))
+			}
+			sort.Strings(recipients)
+			for _, recipient := range recipients {
+				field("key", recipient)
+			}
+		}
+	}
+	keyGroups(m.ShamirThreshold, m.KeyGroups)
+	for i, zone := range m.Zones {
+		field("zone", strconv.Itoa(i))
+		for _, path := range zone.Paths {
+			field("path", path)
+		}
+		keyGroups(zone.ShamirThreshold, zone.KeyGroups)
+	}
+	return fmt.Sprintf("%X", hash.Sum(nil))
+}
+
+// digestAttributes returns the attributes of a key which are part of the
+// digest of the metadata besides its ToString, or "" if it has none, so that
+// the digest does not change for keys without them.
+func digestAttributes(key keys.MasterKey) string {
+	attributer, ok := key.(keys.DigestAttributer)
+	if !ok {
+		return ""
+	}
+	attributes := attributer.DigestAttributes()
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString("|" + name + "=" + attributes[name])
+	}
+	return b.String()
+}
+
+// ToBytes converts a string, int, float, bool, number, timestamp or null to a byte representation.
 func ToBytes(in interface{}) ([]byte, error) {
 	switch in := in.(type) {
 	case string:
@@ -972,6 +1989,16 @@ func ToBytes(in interface{}) ([]byte, error) {
 		return in, nil
 	case time.Time:
 		return in.MarshalText()
+	case Number:
+		return []byte(in), nil
+	case Timestamp:
+		// Timestamps were held as time.Time before, keep their MAC.
+		if t, ok := in.parse(); ok {
+			return t.MarshalText()
+		}
+		return []byte(in), nil
+	case nil:
+		return []byte("null"), nil
 	case Comment:
 		return ToBytes(in.Value)
 	default:
@@ -979,6 +2006,78 @@ func ToBytes(in interface{}) ([]byte, error) {
 	}
 }
 
+// EncodeValue returns the bytes a Cipher encrypts for a value, along with its
+// datatype, which is recorded in the encrypted value so that DecodeValue can
+// restore the value from the decrypted bytes.
+func EncodeValue(v interface{}) (datatype string, plaintext []byte, err error) {
+	switch v := v.(type) {
+	case string:
+		return "str", []byte(v), nil
+	case []byte:
+		return "bytes", v, nil
+	case int:
+		return "int", []byte(strconv.Itoa(v)), nil
+	case float64:
+		// The Python version encodes floats without padding 0s after the decimal point.
+		return "float", []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			return "float", []byte(v), nil
+		}
+		return "int", []byte(v), nil
+	case bool:
+		// The Python version encodes booleans with Titlecase
+		if v {
+			return "bool", []byte("True"), nil
+		}
+		return "bool", []byte("False"), nil
+	case time.Time:
+		plaintext, err = v.MarshalText()
+		if err != nil {
+			return "", nil, fmt.Errorf("Error marshaling timestamp %q: %w", v, err)
+		}
+		return "time", plaintext, nil
+	case Timestamp:
+		return "timestamp", []byte(v), nil
+	case nil:
+		return "null", []byte("null"), nil
+	case Comment:
+		return "comment", []byte(v.Value), nil
+	default:
+		return "", nil, fmt.Errorf("Value to encrypt has unsupported type %T", v)
+	}
+}
+
+// DecodeValue returns the value of the decrypted bytes of an encrypted value
+// of the given datatype.
+func DecodeValue(datatype string, plaintext []byte) (interface{}, error) {
+	value := string(plaintext)
+	switch datatype {
+	case "str":
+		return value, nil
+	case "int":
+		return ParseInt(value)
+	case "float":
+		return ParseFloat(value)
+	case "bytes":
+		return plaintext, nil
+	case "bool":
+		return strconv.ParseBool(value)
+	case "time":
+		var t time.Time
+		err := t.UnmarshalText(plaintext)
+		return t, err
+	case "timestamp":
+		return Timestamp(value), nil
+	case "null":
+		return nil, nil
+	case "comment":
+		return Comment{Value: value}, nil
+	default:
+		return nil, fmt.Errorf("Unknown datatype: %s", datatype)
+	}
+}
+
 // EmitAsMap will emit the tree branches as a map. This is used by the publish
 // command for writing decrypted trees to various destinations. Should only be
 // used for outputting to data structures in code.
@@ -2,15 +2,22 @@ package sops
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
 
 	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/azkv"
 	"github.com/getsops/sops/v3/hcvault"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/kms"
 	"github.com/getsops/sops/v3/pgp"
 )
 
@@ -211,6 +218,78 @@ func TestEncryptedRegex(t *testing.T) {
 	}
 }
 
+func TestEncryptedPaths(t *testing.T) {
+	env := func(name, value string) TreeBranch {
+		return TreeBranch{
+			TreeItem{Key: "name", Value: name},
+			TreeItem{Key: "value", Value: value},
+		}
+	}
+	branches := TreeBranches{
+		TreeBranch{
+			TreeItem{Key: "spec", Value: TreeBranch{
+				TreeItem{Key: "template", Value: TreeBranch{
+					TreeItem{Key: "containers", Value: []interface{}{
+						TreeBranch{
+							TreeItem{Key: "env", Value: []interface{}{
+								env("USER", "admin"),
+								env("PASSWORD", "hunter2"),
+							}},
+						},
+					}},
+				}},
+			}},
+			TreeItem{Key: "kind", Value: "Deployment"},
+		},
+	}
+	tree := Tree{Branches: branches, Metadata: Metadata{
+		EncryptedPaths:   []string{"spec.template.**.env[*]"},
+		UnencryptedPaths: []string{"spec.template.**.env[*].name"},
+	}}
+	expected := TreeBranch{
+		TreeItem{Key: "spec", Value: TreeBranch{
+			TreeItem{Key: "template", Value: TreeBranch{
+				TreeItem{Key: "containers", Value: []interface{}{
+					TreeBranch{
+						TreeItem{Key: "env", Value: []interface{}{
+							env("USER", "nimda"),
+							env("PASSWORD", "2retnuh"),
+						}},
+					},
+				}},
+			}},
+		}},
+		TreeItem{Key: "kind", Value: "Deployment"},
+	}
+	cipher := reverseCipher{}
+	_, err := tree.Encrypt(bytes.Repeat([]byte("f"), 32), cipher)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, tree.Branches[0])
+	_, err = tree.Decrypt(bytes.Repeat([]byte("f"), 32), cipher)
+	assert.Nil(t, err)
+	value, err := tree.Branches[0].Truncate([]interface{}{"spec", "template", "containers", 0, "env", 0, "value"})
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", value)
+}
+
+func TestUnencryptedPathsOnly(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "hosts", Value: []interface{}{"a", "b"}},
+				TreeItem{Key: "password", Value: "secret"},
+			},
+		},
+		Metadata: Metadata{UnencryptedPaths: []string{"hosts[0]"}},
+	}
+	_, err := tree.Encrypt(bytes.Repeat([]byte("f"), 32), reverseCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "hosts", Value: []interface{}{"a", "b"}},
+		TreeItem{Key: "password", Value: "terces"},
+	}, tree.Branches[0])
+}
+
 func TestUnencryptedRegex(t *testing.T) {
 	branches := TreeBranches{
 		TreeBranch{
@@ -352,7 +431,7 @@ func TestEncryptedCommentRegex(t *testing.T) {
 	branches := TreeBranches{
 		TreeBranch{
 			TreeItem{
-				Key:   Comment{"sops:enc"},
+				Key:   Comment{Value: "sops:enc"},
 				Value: nil,
 			},
 			TreeItem{
@@ -367,11 +446,11 @@ func TestEncryptedCommentRegex(t *testing.T) {
 						Value: "bar",
 					},
 					TreeItem{
-						Key:   Comment{"before"},
+						Key:   Comment{Value: "before"},
 						Value: nil,
 					},
 					TreeItem{
-						Key:   Comment{"sops:enc"},
+						Key:   Comment{Value: "sops:enc"},
 						Value: nil,
 					},
 					TreeItem{
@@ -384,16 +463,16 @@ func TestEncryptedCommentRegex(t *testing.T) {
 				Key: "array",
 				Value: []interface{}{
 					"bar",
-					Comment{"sops:enc"},
+					Comment{Value: "sops:enc"},
 					"baz",
 				},
 			},
 			TreeItem{
-				Key:   Comment{"sops:enc"},
+				Key:   Comment{Value: "sops:enc"},
 				Value: nil,
 			},
 			TreeItem{
-				Key:   Comment{"after"},
+				Key:   Comment{Value: "after"},
 				Value: nil,
 			},
 			TreeItem{
@@ -408,7 +487,7 @@ func TestEncryptedCommentRegex(t *testing.T) {
 	tree := Tree{Branches: branches, Metadata: Metadata{EncryptedCommentRegex: "sops:enc"}}
 	expected := TreeBranch{
 		TreeItem{
-			Key:   Comment{"sops:enc"},
+			Key:   Comment{Value: "sops:enc"},
 			Value: nil,
 		},
 		TreeItem{
@@ -423,11 +502,11 @@ func TestEncryptedCommentRegex(t *testing.T) {
 					Value: "bar",
 				},
 				TreeItem{
-					Key:   Comment{"before"},
+					Key:   Comment{Value: "before"},
 					Value: nil,
 				},
 				TreeItem{
-					Key:   Comment{"sops:enc"},
+					Key:   Comment{Value: "sops:enc"},
 					Value: nil,
 				},
 				TreeItem{
@@ -440,16 +519,16 @@ func TestEncryptedCommentRegex(t *testing.T) {
 			Key: "array",
 			Value: []interface{}{
 				"bar",
-				Comment{"sops:enc"},
+				Comment{Value: "sops:enc"},
 				"zab",
 			},
 		},
 		TreeItem{
-			Key:   Comment{"sops:enc"},
+			Key:   Comment{Value: "sops:enc"},
 			Value: nil,
 		},
 		TreeItem{
-			Key:   Comment{"retfa"},
+			Key:   Comment{Value: "retfa"},
 			Value: nil,
 		},
 		TreeItem{
@@ -475,7 +554,7 @@ func TestEncryptedCommentRegex(t *testing.T) {
 	expected[1].Value = "bar"
 	expected[2].Value.(TreeBranch)[3].Value = "bar"
 	expected[3].Value.([]interface{})[2] = "baz"
-	expected[5].Key = Comment{"after"}
+	expected[5].Key = Comment{Value: "after"}
 	expected[6].Value = []interface{}{
 		"bar",
 		"baz",
@@ -489,7 +568,7 @@ func TestUnencryptedCommentRegex(t *testing.T) {
 	branches := TreeBranches{
 		TreeBranch{
 			TreeItem{
-				Key:   Comment{"sops:noenc"},
+				Key:   Comment{Value: "sops:noenc"},
 				Value: nil,
 			},
 			TreeItem{
@@ -504,11 +583,11 @@ func TestUnencryptedCommentRegex(t *testing.T) {
 						Value: "bar",
 					},
 					TreeItem{
-						Key:   Comment{"before"},
+						Key:   Comment{Value: "before"},
 						Value: nil,
 					},
 					TreeItem{
-						Key:   Comment{"sops:noenc"},
+						Key:   Comment{Value: "sops:noenc"},
 						Value: nil,
 					},
 					TreeItem{
@@ -521,16 +600,16 @@ func TestUnencryptedCommentRegex(t *testing.T) {
 				Key: "array",
 				Value: []interface{}{
 					"bar",
-					Comment{"sops:noenc"},
+					Comment{Value: "sops:noenc"},
 					"baz",
 				},
 			},
 			TreeItem{
-				Key:   Comment{"sops:noenc"},
+				Key:   Comment{Value: "sops:noenc"},
 				Value: nil,
 			},
 			TreeItem{
-				Key:   Comment{"after"},
+				Key:   Comment{Value: "after"},
 				Value: nil,
 			},
 			TreeItem{
@@ -545,7 +624,7 @@ func TestUnencryptedCommentRegex(t *testing.T) {
 	tree := Tree{Branches: branches, Metadata: Metadata{UnencryptedCommentRegex: "sops:noenc"}}
 	expected := TreeBranch{
 		TreeItem{
-			Key:   Comment{"sops:noenc"},
+			Key:   Comment{Value: "sops:noenc"},
 			Value: nil,
 		},
 		TreeItem{
@@ -560,11 +639,11 @@ func TestUnencryptedCommentRegex(t *testing.T) {
 					Value: "rab",
 				},
 				TreeItem{
-					Key:   Comment{"erofeb"},
+					Key:   Comment{Value: "erofeb"},
 					Value: nil,
 				},
 				TreeItem{
-					Key:   Comment{"sops:noenc"},
+					Key:   Comment{Value: "sops:noenc"},
 					Value: nil,
 				},
 				TreeItem{
@@ -577,16 +656,16 @@ func TestUnencryptedCommentRegex(t *testing.T) {
 			Key: "array",
 			Value: []interface{}{
 				"rab",
-				Comment{"sops:noenc"},
+				Comment{Value: "sops:noenc"},
 				"baz",
 			},
 		},
 		TreeItem{
-			Key:   Comment{"sops:noenc"},
+			Key:   Comment{Value: "sops:noenc"},
 			Value: nil,
 		},
 		TreeItem{
-			Key:   Comment{"after"},
+			Key:   Comment{Value: "after"},
 			Value: nil,
 		},
 		TreeItem{
@@ -610,7 +689,7 @@ func TestUnencryptedCommentRegex(t *testing.T) {
 		t.Errorf("Decrypting the tree failed: %s", err)
 	}
 	expected[2].Value.(TreeBranch)[0].Value = "bar"
-	expected[2].Value.(TreeBranch)[1].Key = Comment{"before"}
+	expected[2].Value.(TreeBranch)[1].Key = Comment{Value: "before"}
 	expected[3].Value.([]interface{})[0] = "bar"
 	if !reflect.DeepEqual(tree.Branches[0], expected) {
 		t.Errorf("Trees don't match: \ngot\t\t\t%+v,\nexpected\t\t%+v", tree.Branches[0], expected)
@@ -621,7 +700,7 @@ func TestUnencryptedCommentRegexFail(t *testing.T) {
 	branches := TreeBranches{
 		TreeBranch{
 			TreeItem{
-				Key:   Comment{"sops:noenc"},
+				Key:   Comment{Value: "sops:noenc"},
 				Value: nil,
 			},
 			TreeItem{
@@ -713,7 +792,7 @@ func TestEncrypt(t *testing.T) {
 			},
 			TreeItem{
 				Key:   "barfoo",
-				Value: nil,
+				Value: "a",
 			},
 		},
 		TreeBranch{
@@ -936,14 +1015,14 @@ func TestEncryptComments(t *testing.T) {
 		Branches: TreeBranches{
 			TreeBranch{
 				TreeItem{
-					Key:   Comment{"foo"},
+					Key:   Comment{Value: "foo"},
 					Value: nil,
 				},
 				TreeItem{
 					Key: "list",
 					Value: []interface{}{
 						"1",
-						Comment{"bar"},
+						Comment{Value: "bar"},
 						"2",
 					},
 				},
@@ -963,14 +1042,14 @@ func TestDecryptComments(t *testing.T) {
 		Branches: TreeBranches{
 			TreeBranch{
 				TreeItem{
-					Key:   Comment{"oof"},
+					Key:   Comment{Value: "oof"},
 					Value: nil,
 				},
 				TreeItem{
 					Key: "list",
 					Value: []interface{}{
 						"1",
-						Comment{"rab"},
+						Comment{Value: "rab"},
 						"2",
 					},
 				},
@@ -995,7 +1074,7 @@ func TestDecryptUnencryptedComments(t *testing.T) {
 			TreeBranch{
 				TreeItem{
 					// We use `error` to simulate an error decrypting, the fake cipher will error in this case
-					Key:   Comment{"error"},
+					Key:   Comment{Value: "error"},
 					Value: nil,
 				},
 			},
@@ -1006,6 +1085,208 @@ func TestDecryptUnencryptedComments(t *testing.T) {
 	assert.Equal(t, "error", tree.Branches[0][0].Key.(Comment).Value)
 }
 
+func TestEncryptAnchorsAndAliases(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{
+					Key: "defaults",
+					Value: Anchored{
+						Anchor: "defaults",
+						Value: TreeBranch{
+							TreeItem{Key: "password", Value: Anchored{Anchor: "password", Value: "secret"}},
+						},
+					},
+				},
+				TreeItem{
+					Key: "production",
+					Value: TreeBranch{
+						TreeItem{Key: "<<", Value: Alias{Anchor: "defaults"}},
+						TreeItem{Key: "admin_password", Value: Alias{Anchor: "password"}},
+					},
+				},
+			},
+		},
+		Metadata: Metadata{
+			UnencryptedSuffix: DefaultUnencryptedSuffix,
+		},
+	}
+	_, err := tree.Encrypt(bytes.Repeat([]byte{'f'}, 32), reverseCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{
+			Key: "defaults",
+			Value: Anchored{
+				Anchor: "defaults",
+				Value: TreeBranch{
+					TreeItem{Key: "password", Value: Anchored{Anchor: "password", Value: "terces"}},
+				},
+			},
+		},
+		TreeItem{
+			Key: "production",
+			Value: TreeBranch{
+				TreeItem{Key: "<<", Value: Alias{Anchor: "defaults"}},
+				TreeItem{Key: "admin_password", Value: Alias{Anchor: "password"}},
+			},
+		},
+	}, tree.Branches[0])
+
+	_, err = tree.Decrypt(bytes.Repeat([]byte{'f'}, 32), reverseCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, Anchored{Anchor: "password", Value: "secret"}, tree.Branches[0][0].Value.(Anchored).Value.(TreeBranch)[0].Value)
+}
+
+func TestExpandAliases(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "a", Value: Anchored{Anchor: "x", Value: []interface{}{"1", Anchored{Anchor: "y", Value: "2"}}}},
+		TreeItem{Key: "b", Value: Alias{Anchor: "x"}},
+		TreeItem{Key: "c", Value: Alias{Anchor: "y"}},
+		// Anchors can be redefined, aliases refer to the last definition.
+		TreeItem{Key: "d", Value: Anchored{Anchor: "x", Value: "3"}},
+		TreeItem{Key: "e", Value: Alias{Anchor: "x"}},
+	}
+	expanded, err := branch.ExpandAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "a", Value: []interface{}{"1", "2"}},
+		TreeItem{Key: "b", Value: []interface{}{"1", "2"}},
+		TreeItem{Key: "c", Value: "2"},
+		TreeItem{Key: "d", Value: "3"},
+		TreeItem{Key: "e", Value: "3"},
+	}, expanded)
+
+	// Expanded aliases are copies.
+	expanded[1].Value.([]interface{})[0] = "changed"
+	assert.Equal(t, "1", expanded[0].Value.([]interface{})[0])
+
+	_, err = TreeBranch{TreeItem{Key: "a", Value: Alias{Anchor: "unknown"}}}.ExpandAliases()
+	assert.EqualError(t, err, `unknown anchor "unknown"`)
+}
+
+func TestExpandMergeKeys(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "defaults", Value: Anchored{Anchor: "defaults", Value: TreeBranch{
+			TreeItem{Key: "image", Value: "nginx"},
+			TreeItem{Key: "replicas", Value: 1},
+		}}},
+		TreeItem{Key: "other", Value: Anchored{Anchor: "other", Value: TreeBranch{
+			TreeItem{Key: "image", Value: "httpd"},
+			TreeItem{Key: "port", Value: 80},
+		}}},
+		TreeItem{Key: "app", Value: TreeBranch{
+			TreeItem{Key: "<<", Value: Alias{Anchor: "defaults"}},
+			TreeItem{Key: "replicas", Value: 3},
+		}},
+		TreeItem{Key: "sequence", Value: TreeBranch{
+			TreeItem{Key: Comment{Value: "earlier mappings win"}, Value: nil},
+			TreeItem{Key: "<<", Value: []interface{}{Alias{Anchor: "other"}, Alias{Anchor: "defaults"}}},
+		}},
+		TreeItem{Key: "inline", Value: TreeBranch{
+			TreeItem{Key: "port", Value: 8080},
+			TreeItem{Key: "<<", Value: TreeBranch{TreeItem{Key: "port", Value: 80}, TreeItem{Key: "host", Value: "a"}}},
+		}},
+		// Keys whose value cannot be merged are kept as is.
+		TreeItem{Key: "scalar", Value: TreeBranch{TreeItem{Key: "<<", Value: "value"}}},
+	}
+	expanded, err := branch.ExpandAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "image", Value: "nginx"},
+		TreeItem{Key: "replicas", Value: 3},
+	}, expanded[2].Value)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: Comment{Value: "earlier mappings win"}, Value: nil},
+		TreeItem{Key: "image", Value: "httpd"},
+		TreeItem{Key: "port", Value: 80},
+		TreeItem{Key: "replicas", Value: 1},
+	}, expanded[3].Value)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "port", Value: 8080},
+		TreeItem{Key: "host", Value: "a"},
+	}, expanded[4].Value)
+	assert.Equal(t, TreeBranch{TreeItem{Key: "<<", Value: "value"}}, expanded[5].Value)
+
+	image, err := expanded.Truncate([]interface{}{"app", "image"})
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", image)
+}
+
+func TestSetAndUnsetAnchored(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{
+			Key: "a",
+			Value: Anchored{Anchor: "x", Value: TreeBranch{
+				TreeItem{Key: "b", Value: "c"},
+				TreeItem{Key: "d", Value: "e"},
+			}},
+		},
+	}
+	branch, changed := branch.Set([]interface{}{"a", "b"}, "f")
+	assert.True(t, changed)
+	branch, err := branch.Unset([]interface{}{"a", "d"})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{
+			Key: "a",
+			Value: Anchored{Anchor: "x", Value: TreeBranch{
+				TreeItem{Key: "b", Value: "f"},
+			}},
+		},
+	}, branch)
+}
+
+func TestEncryptStyledValues(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "a", Value: Styled{Style: "quoted", Value: "secret"}},
+				TreeItem{Key: "b", Value: Styled{Style: "flow", Value: []interface{}{"x", Comment{Value: ""}}}},
+			},
+		},
+		Metadata: Metadata{
+			UnencryptedSuffix: DefaultUnencryptedSuffix,
+		},
+	}
+	_, err := tree.Encrypt(bytes.Repeat([]byte{'f'}, 32), reverseCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "a", Value: Styled{Style: "quoted", Value: "terces"}},
+		TreeItem{Key: "b", Value: Styled{Style: "flow", Value: []interface{}{"x", Comment{Value: ""}}}},
+	}, tree.Branches[0])
+
+	expanded, err := tree.Branches[0].ExpandAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "a", Value: "terces"},
+		TreeItem{Key: "b", Value: []interface{}{"x", Comment{Value: ""}}},
+	}, expanded)
+}
+
+func TestSetKeepsStyle(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "a", Value: Styled{Style: "quoted", Value: "b"}},
+		TreeItem{Key: "c", Value: Styled{Style: "flow", Value: TreeBranch{
+			TreeItem{Key: "d", Value: "e"},
+		}}},
+	}
+	branch, changed := branch.Set([]interface{}{"a"}, "b")
+	assert.False(t, changed)
+	branch, changed = branch.Set([]interface{}{"a"}, "f")
+	assert.True(t, changed)
+	branch, changed = branch.Set([]interface{}{"c", "d"}, "g")
+	assert.True(t, changed)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "a", Value: Styled{Style: "quoted", Value: "f"}},
+		TreeItem{Key: "c", Value: Styled{Style: "flow", Value: TreeBranch{
+			TreeItem{Key: "d", Value: "g"},
+		}}},
+	}, branch)
+	branch, err := branch.Unset([]interface{}{"c", "d"})
+	assert.Nil(t, err)
+	assert.Equal(t, Styled{Style: "flow", Value: TreeBranch{}}, branch[1].Value)
+}
+
 func TestSetNewKey(t *testing.T) {
 	branch := TreeBranch{
 		TreeItem{
@@ -1525,7 +1806,7 @@ func TestEmitAsMap(t *testing.T) {
 				Value: 42,
 			},
 			TreeItem{
-				Key:   Comment{"comment"},
+				Key:   Comment{Value: "comment"},
 				Value: nil,
 			},
 		},
@@ -1610,3 +1891,479 @@ func TestSortKeyGroupIndices(t *testing.T) {
 		assert.Equal(t, expected, indices)
 	})
 }
+
+func TestParseNumbers(t *testing.T) {
+	ints := map[string]interface{}{
+		"42":                    42,
+		"-42":                   -42,
+		"12345678901234567890":  Number("12345678901234567890"),
+		"-12345678901234567890": Number("-12345678901234567890"),
+	}
+	for s, expected := range ints {
+		n, err := ParseInt(s)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n, s)
+	}
+	_, err := ParseInt("0x1F")
+	assert.NotNil(t, err)
+
+	floats := map[string]interface{}{
+		"42":                     42.0,
+		"0.1":                    0.1,
+		"1.10":                   1.1,
+		"1e3":                    1000.0,
+		"-0":                     math.Copysign(0, -1),
+		"0x1p-2":                 0.25,
+		"12345678901234567890":   Number("12345678901234567890"),
+		"0.10000000000000000001": Number("0.10000000000000000001"),
+		"1e400":                  Number("1e400"),
+		"1e-400":                 Number("1e-400"),
+		"0e-400":                 0.0,
+	}
+	for s, expected := range floats {
+		n, err := ParseFloat(s)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n, s)
+	}
+	_, err = ParseFloat("abc")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptNullValues(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "a", Value: nil},
+				TreeItem{Key: "b_unencrypted", Value: nil},
+			},
+		},
+		Metadata: Metadata{
+			UnencryptedSuffix: DefaultUnencryptedSuffix,
+		},
+	}
+	encryptedMac, err := tree.Encrypt(bytes.Repeat([]byte{'f'}, 32), reverseCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "a", Value: "llun"},
+		TreeItem{Key: "b_unencrypted", Value: nil},
+	}, tree.Branches[0])
+
+	decryptedMac, err := tree.Decrypt(bytes.Repeat([]byte{'f'}, 32), reverseCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, encryptedMac, decryptedMac)
+	assert.Nil(t, tree.Branches[0][1].Value)
+}
+
+func TestTimestampBytes(t *testing.T) {
+	// Timestamps which used to be loaded as time.Time keep their MAC.
+	for _, s := range []string{"2024-01-02", "2024-01-02T10:00:00+02:00", "1979-05-27 07:32:00Z"} {
+		b, err := ToBytes(Timestamp(s))
+		assert.Nil(t, err)
+		parsed, ok := Timestamp(s).parse()
+		assert.True(t, ok)
+		expected, err := parsed.MarshalText()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, b)
+	}
+	b, err := ToBytes(Timestamp("07:32:00"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("07:32:00"), b)
+}
+
+// additionalDataCipher encrypts values as their additional data followed by
+// their bytes, and fails to decrypt them with other additional data.
+type additionalDataCipher struct{}
+
+func (c additionalDataCipher) Encrypt(value interface{}, key []byte, additionalData string) (string, error) {
+	b, err := ToBytes(value)
+	if err != nil {
+		return "", err
+	}
+	return additionalData + "|" + string(b), nil
+}
+
+func (c additionalDataCipher) Decrypt(value string, key []byte, additionalData string) (interface{}, error) {
+	if !strings.HasPrefix(value, additionalData+"|") {
+		return nil, fmt.Errorf("authentication failed")
+	}
+	return strings.TrimPrefix(value, additionalData+"|"), nil
+}
+
+func macTestMetadata(version int) Metadata {
+	return Metadata{
+		LastModified:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		UnencryptedSuffix: DefaultUnencryptedSuffix,
+		MACVersion:        version,
+		KeyGroups: []KeyGroup{
+			{
+				&age.MasterKey{Recipient: "age1lzd99uklcjnc0e7d860axevet2cz99ce9pq6tzuzd05l5nr28ams36nvun"},
+				pgp.NewMasterKeyFromFingerprint("FBC7B9E2A4F9289AC0C1D4843D16CEE4A27381B4"),
+				&kms.MasterKey{Arn: "arn:aws:kms:us-east-1:123456789012:key/rsa"},
+			},
+		},
+	}
+}
+
+func TestMACRoundtrip(t *testing.T) {
+	for _, version := range []int{0, MACVersionValues, MACVersionMetadata} {
+		m := macTestMetadata(version)
+		var err error
+		m.MessageAuthenticationCode, err = m.EncryptMAC("ABCDEF", nil, additionalDataCipher{})
+		assert.Nil(t, err)
+		mac, err := m.DecryptMAC(nil, additionalDataCipher{})
+		assert.Nil(t, err)
+		assert.Equal(t, "ABCDEF", mac)
+	}
+}
+
+func TestMACVersionValuesIgnoresMetadata(t *testing.T) {
+	m := macTestMetadata(MACVersionValues)
+	var err error
+	m.MessageAuthenticationCode, err = m.EncryptMAC("ABCDEF", nil, additionalDataCipher{})
+	assert.Nil(t, err)
+	m.UnencryptedSuffix = "_plain"
+	m.KeyGroups = nil
+	mac, err := m.DecryptMAC(nil, additionalDataCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, "ABCDEF", mac)
+}
+
+func TestMACVersionMetadataDetectsChanges(t *testing.T) {
+	changes := map[string]func(m *Metadata){
+		"unencrypted_suffix":        func(m *Metadata) { m.UnencryptedSuffix = "_plain" },
+		"encrypted_suffix":          func(m *Metadata) { m.EncryptedSuffix = "_secret" },
+		"unencrypted_regex":         func(m *Metadata) { m.UnencryptedRegex = "^public" },
+		"encrypted_regex":           func(m *Metadata) { m.EncryptedRegex = "^data$" },
+		"unencrypted_comment_regex": func(m *Metadata) { m.UnencryptedCommentRegex = "sops:dec" },
+		"encrypted_comment_regex":   func(m *Metadata) { m.EncryptedCommentRegex = "sops:enc" },
+		"mac_only_encrypted":        func(m *Metadata) { m.MACOnlyEncrypted = true },
+		"cipher":                    func(m *Metadata) { m.Cipher = CipherXChaCha20Poly1305 },
+		"shamir_threshold":          func(m *Metadata) { m.ShamirThreshold = 2 },
+		"last_modified":             func(m *Metadata) { m.LastModified = m.LastModified.Add(time.Second) },
+		"removed key":               func(m *Metadata) { m.KeyGroups[0] = m.KeyGroups[0][:1] },
+		"added key": func(m *Metadata) {
+			m.KeyGroups[0] = append(m.KeyGroups[0], pgp.NewMasterKeyFromFingerprint("85D77543B3D624B63CEA9E6DBC17301B491B3F21"))
+		},
+		"added group": func(m *Metadata) {
+			m.KeyGroups = append(m.KeyGroups, KeyGroup{&age.MasterKey{Recipient: "age1lzd99uklcjnc0e7d860axevet2cz99ce9pq6tzuzd05l5nr28ams36nvun"}})
+		},
+		"encryption_algorithm": func(m *Metadata) {
+			m.KeyGroups[0][2].(*kms.MasterKey).EncryptionAlgorithm = "RSAES_OAEP_SHA_256"
+		},
+		"encrypted_paths":           func(m *Metadata) { m.EncryptedPaths = []string{"data"} },
+		"unencrypted_paths":         func(m *Metadata) { m.UnencryptedPaths = []string{"metadata"} },
+		"added zone": func(m *Metadata) {
+			m.Zones = append(m.Zones, Zone{Paths: []string{"db"}, KeyGroups: []KeyGroup{{pgp.NewMasterKeyFromFingerprint("85D77543B3D624B63CEA9E6DBC17301B491B3F21")}}})
+		},
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			m := macTestMetadata(MACVersionMetadata)
+			var err error
+			m.MessageAuthenticationCode, err = m.EncryptMAC("ABCDEF", nil, additionalDataCipher{})
+			assert.Nil(t, err)
+			change(&m)
+			_, err = m.DecryptMAC(nil, additionalDataCipher{})
+			assert.ErrorIs(t, err, MetadataMacMismatch)
+		})
+	}
+}
+
+func TestMACVersionMetadataIgnoresKeyOrder(t *testing.T) {
+	m := macTestMetadata(MACVersionMetadata)
+	var err error
+	m.MessageAuthenticationCode, err = m.EncryptMAC("ABCDEF", nil, additionalDataCipher{})
+	assert.Nil(t, err)
+	m.KeyGroups[0][0], m.KeyGroups[0][2] = m.KeyGroups[0][2], m.KeyGroups[0][0]
+	mac, err := m.DecryptMAC(nil, additionalDataCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, "ABCDEF", mac)
+}
+
+func TestDigestAttributes(t *testing.T) {
+	// Keys without attributes keep the digest of files which predate them.
+	assert.Equal(t, "", digestAttributes(&kms.MasterKey{Arn: "arn"}))
+	assert.Equal(t, "", digestAttributes(&age.MasterKey{Recipient: "age1"}))
+	assert.Equal(t, "|encryption_algorithm=RSAES_OAEP_SHA_256",
+		digestAttributes(&kms.MasterKey{Arn: "arn", EncryptionAlgorithm: "RSAES_OAEP_SHA_256"}))
+}
+
+func TestMACVersionRemoved(t *testing.T) {
+	m := macTestMetadata(MACVersionMetadata)
+	var err error
+	m.MessageAuthenticationCode, err = m.EncryptMAC("ABCDEF", nil, additionalDataCipher{})
+	assert.Nil(t, err)
+	m.MACVersion = 0
+	_, err = m.DecryptMAC(nil, additionalDataCipher{})
+	assert.ErrorIs(t, err, MetadataMacMismatch)
+
+	// A corrupted MAC of a file without a MAC version is not blamed on
+	// its metadata.
+	m.MessageAuthenticationCode = "corrupted"
+	_, err = m.DecryptMAC(nil, additionalDataCipher{})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, MetadataMacMismatch)
+}
+
+func TestUnsupportedMACVersion(t *testing.T) {
+	m := macTestMetadata(3)
+	_, err := m.EncryptMAC("ABCDEF", nil, additionalDataCipher{})
+	assert.ErrorContains(t, err, "unsupported MAC version 3")
+	_, err = m.DecryptMAC(nil, additionalDataCipher{})
+	assert.ErrorContains(t, err, "unsupported MAC version 3")
+}
+
+func TestDecryptPaths(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "foo", Value: "rab"},
+				TreeItem{Key: "baz", Value: TreeBranch{
+					TreeItem{Key: "bar", Value: "oof"},
+					TreeItem{Key: "qux", Value: "xuq"},
+				}},
+				TreeItem{Key: "list", Value: []interface{}{"eno", "owt"}},
+				TreeItem{Key: "anchored", Value: Anchored{Anchor: "a", Value: TreeBranch{
+					TreeItem{Key: "key", Value: "eulav"},
+				}}},
+				TreeItem{Key: "ref", Value: Alias{Anchor: "a"}},
+			},
+		},
+	}
+	expectedMac, err := tree.ComputeCiphertextMAC()
+	assert.Nil(t, err)
+	mac, err := tree.DecryptPaths(nil, reverseCipher{}, [][]interface{}{
+		{"baz", "bar"},
+		{"list", 1},
+		{"ref"},
+		{"missing"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, expectedMac, mac)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "foo", Value: "rab"},
+		TreeItem{Key: "baz", Value: TreeBranch{
+			TreeItem{Key: "bar", Value: "foo"},
+			TreeItem{Key: "qux", Value: "xuq"},
+		}},
+		TreeItem{Key: "list", Value: []interface{}{"eno", "two"}},
+		TreeItem{Key: "anchored", Value: Anchored{Anchor: "a", Value: TreeBranch{
+			TreeItem{Key: "key", Value: "value"},
+		}}},
+		TreeItem{Key: "ref", Value: Alias{Anchor: "a"}},
+	}, tree.Branches[0])
+}
+
+func TestDecryptPathsFollowsEncryptionRules(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "foo", Value: TreeBranch{
+					TreeItem{Key: Comment{Value: "sops:enc"}, Value: nil},
+					TreeItem{Key: "bar", Value: "zab"},
+					TreeItem{Key: "baz", Value: "plain"},
+				}},
+			},
+		},
+		Metadata: Metadata{EncryptedCommentRegex: "sops:enc"},
+	}
+	_, err := tree.DecryptPaths(nil, reverseCipher{}, [][]interface{}{{"foo"}})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: Comment{Value: "sops:enc"}, Value: nil},
+		TreeItem{Key: "bar", Value: "baz"},
+		TreeItem{Key: "baz", Value: "plain"},
+	}, tree.Branches[0][0].Value)
+}
+
+func TestDecryptPathsEmptyPathDecryptsAll(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "foo", Value: "rab"},
+				TreeItem{Key: "bar", Value: "zab"},
+			},
+		},
+	}
+	_, err := tree.DecryptPaths(nil, reverseCipher{}, [][]interface{}{{}})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "foo", Value: "bar"},
+		TreeItem{Key: "bar", Value: "baz"},
+	}, tree.Branches[0])
+}
+
+func TestComputeCiphertextMAC(t *testing.T) {
+	mac := func(values ...interface{}) string {
+		var branch TreeBranch
+		for i, v := range values {
+			branch = append(branch, TreeItem{Key: fmt.Sprintf("k%d", i), Value: v})
+		}
+		m, err := Tree{Branches: TreeBranches{branch}}.ComputeCiphertextMAC()
+		assert.Nil(t, err)
+		return m
+	}
+	assert.Equal(t, mac("ab", "c"), mac("ab", "c"))
+	assert.NotEqual(t, mac("ab", "c"), mac("a", "bc"))
+	assert.NotEqual(t, mac("ab", "c"), mac("ab", "d"))
+	assert.Equal(t, mac("ab", "c"), mac("ab", nil, "c", Comment{Value: "comment"}))
+}
+
+// keyCipher is a cipher whose ciphertexts tell the key they were encrypted
+// with, and which can only be decrypted with that key.
+type keyCipher struct{}
+
+func (c keyCipher) Encrypt(value interface{}, key []byte, additionalData string) (string, error) {
+	b, err := ToBytes(value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("ENC[%s:%s]", key, b), nil
+}
+
+func (c keyCipher) Decrypt(value string, key []byte, additionalData string) (interface{}, error) {
+	prefix := fmt.Sprintf("ENC[%s:", key)
+	if !strings.HasPrefix(value, prefix) || !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("authentication failed")
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(value, prefix), "]"), nil
+}
+
+func zonesTestTree() Tree {
+	return Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "db", Value: TreeBranch{
+					TreeItem{Key: "password", Value: "hunter2"},
+					TreeItem{Key: "host_unencrypted", Value: "db.local"},
+				}},
+				TreeItem{Key: "payments", Value: TreeBranch{
+					TreeItem{Key: "card", Value: "4242"},
+				}},
+				TreeItem{Key: "app", Value: "secret"},
+			},
+		},
+		Metadata: Metadata{
+			UnencryptedSuffix: DefaultUnencryptedSuffix,
+			Zones: []Zone{
+				{Paths: []string{"db"}, DataKey: []byte("dba")},
+				{Paths: []string{"payments.*"}, DataKey: []byte("payments")},
+			},
+		},
+	}
+}
+
+func TestZonesEncryptWithTheirDataKey(t *testing.T) {
+	tree := zonesTestTree()
+	encryptMac, err := tree.Encrypt([]byte("main"), keyCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "db", Value: TreeBranch{
+			TreeItem{Key: "password", Value: "ENC[dba:hunter2]"},
+			TreeItem{Key: "host_unencrypted", Value: "db.local"},
+		}},
+		TreeItem{Key: "payments", Value: TreeBranch{
+			TreeItem{Key: "card", Value: "ENC[payments:4242]"},
+		}},
+		TreeItem{Key: "app", Value: "ENC[main:secret]"},
+	}, tree.Branches[0])
+	decryptMac, err := tree.Decrypt([]byte("main"), keyCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, encryptMac, decryptMac)
+	assert.Equal(t, zonesTestTree().Branches[0], tree.Branches[0])
+}
+
+func TestZonesLockedAreLeftEncrypted(t *testing.T) {
+	tree := zonesTestTree()
+	encryptMac, err := tree.Encrypt([]byte("main"), keyCipher{})
+	assert.Nil(t, err)
+	tree.Metadata.Zones[1].DataKey = nil
+	decryptMac, err := tree.Decrypt([]byte("main"), keyCipher{})
+	assert.Nil(t, err)
+	// The MAC covers values of zones as their ciphertext, so it can be
+	// verified even if some zones are locked.
+	assert.Equal(t, encryptMac, decryptMac)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "db", Value: TreeBranch{
+			TreeItem{Key: "password", Value: "hunter2"},
+			TreeItem{Key: "host_unencrypted", Value: "db.local"},
+		}},
+		TreeItem{Key: "payments", Value: TreeBranch{
+			TreeItem{Key: "card", Value: "ENC[payments:4242]"},
+		}},
+		TreeItem{Key: "app", Value: "secret"},
+	}, tree.Branches[0])
+	encryptMac, err = tree.Encrypt([]byte("main"), keyCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, decryptMac, encryptMac)
+	assert.Equal(t, "ENC[payments:4242]", tree.Branches[0][1].Value.(TreeBranch)[0].Value)
+}
+
+func TestZonesLockedCannotEncryptPlaintext(t *testing.T) {
+	tree := zonesTestTree()
+	tree.Metadata.Zones[0].DataKey = nil
+	_, err := tree.Encrypt([]byte("main"), keyCipher{})
+	assert.ErrorContains(t, err, `["db"]["password"]`)
+}
+
+func TestZonesFirstMatchWins(t *testing.T) {
+	m := Metadata{
+		Zones: []Zone{
+			{Paths: []string{"db.password"}, DataKey: []byte("first")},
+			{Paths: []string{"db"}, DataKey: []byte("second")},
+		},
+	}
+	assert.Equal(t, []byte("first"), m.zoneOf(m.zoneGlobs(), []interface{}{"db", "password"}).DataKey)
+	assert.Equal(t, []byte("second"), m.zoneOf(m.zoneGlobs(), []interface{}{"db", "user"}).DataKey)
+	assert.Nil(t, m.zoneOf(m.zoneGlobs(), []interface{}{"app"}))
+}
+
+func TestZonesSelectListItems(t *testing.T) {
+	tree := Tree{
+		Branches: TreeBranches{
+			TreeBranch{
+				TreeItem{Key: "items", Value: []interface{}{
+					TreeBranch{
+						TreeItem{Key: "user", Value: "admin"},
+						TreeItem{Key: "password", Value: "hunter2"},
+					},
+				}},
+			},
+		},
+		Metadata: Metadata{
+			UnencryptedSuffix: DefaultUnencryptedSuffix,
+			Zones:             []Zone{{Paths: []string{"items.password"}, DataKey: []byte("zone")}},
+		},
+	}
+	_, err := tree.Encrypt([]byte("main"), keyCipher{})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		TreeBranch{
+			TreeItem{Key: "user", Value: "ENC[main:admin]"},
+			TreeItem{Key: "password", Value: "ENC[zone:hunter2]"},
+		},
+	}, tree.Branches[0][0].Value)
+}
+
+// versionReportingClient is a key service which reports the version of the
+// key it encrypted with, and does not encrypt.
+type versionReportingClient struct {
+	version string
+}
+
+func (c versionReportingClient) Encrypt(ctx context.Context, req *keyservice.EncryptRequest, opts ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	return &keyservice.EncryptResponse{Ciphertext: req.Plaintext, KeyVersion: c.version}, nil
+}
+
+func (c versionReportingClient) Decrypt(ctx context.Context, req *keyservice.DecryptRequest, opts ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	return &keyservice.DecryptResponse{Plaintext: req.Ciphertext}, nil
+}
+
+func TestUpdateMasterKeysRecordsVersionFromKeyService(t *testing.T) {
+	key := azkv.NewMasterKey("https://example.vault.azure.net", "key", "")
+	m := Metadata{KeyGroups: []KeyGroup{{key}}}
+	errs := m.UpdateMasterKeysWithKeyServices([]byte("data key"), []keyservice.KeyServiceClient{versionReportingClient{version: "v2"}})
+	assert.Empty(t, errs)
+	assert.Equal(t, "v2", key.Version)
+	assert.Equal(t, "data key", key.EncryptedKey)
+}
@@ -0,0 +1,308 @@
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+)
+
+// parser is a lexer for dotenv files, accepting the syntax accepted by
+// docker-compose and python-dotenv:
+//
+//   - "KEY=value" assignments, optionally prefixed with "export ".
+//   - Unquoted values, which end at the end of the line or at an inline
+//     comment, and have trailing white space removed. For compatibility with
+//     files written by earlier versions of SOPS, "\n" is replaced by a newline.
+//   - Single quoted values, which are taken literally and can span several
+//     lines.
+//   - Double quoted values, which can span several lines, and in which the
+//     escape sequences \n, \r, \t, \\, \", \', \$ and \` are replaced.
+//   - Comments, which start with '#' at the beginning of a line, or after
+//     white space following a value.
+//
+// Comments are loaded as sops.Comment items, and inline comments as inline
+// sops.Comment items following the item they are on the line of. Blank lines
+// are loaded as empty comments. The quoting of values, the "export " prefix
+// and the white space before inline comments are kept in sops.Styled values
+// when they differ from the ones the value is written with by default.
+type parser struct {
+	in   []byte
+	pos  int
+	line int
+}
+
+// parse parses dotenv data into a sops.TreeBranch.
+func parse(in []byte) (sops.TreeBranch, error) {
+	p := &parser{
+		in:   bytes.ReplaceAll(in, []byte("\r\n"), []byte("\n")),
+		line: 1,
+	}
+	branch := sops.TreeBranch{}
+	for !p.eof() {
+		p.skipBlanks()
+		switch {
+		case p.eof():
+		case p.peek() == '\n':
+			p.newline()
+			branch = append(branch, sops.TreeItem{Key: sops.Comment{Value: ""}, Value: nil})
+		case p.peek() == '#':
+			branch = append(branch, sops.TreeItem{Key: sops.Comment{Value: p.comment()}, Value: nil})
+			p.newline()
+		default:
+			items, err := p.assignment()
+			if err != nil {
+				return nil, fmt.Errorf("invalid dotenv input on line %d: %w", p.line, err)
+			}
+			branch = append(branch, items...)
+		}
+	}
+	return branch, nil
+}
+
+// assignment parses a "KEY=value" line, and returns it as a sops.TreeItem,
+// followed by its inline comment if it has one.
+func (p *parser) assignment() ([]sops.TreeItem, error) {
+	var s style
+	if bytes.HasPrefix(p.in[p.pos:], []byte("export")) && p.pos+6 < len(p.in) && isBlank(p.in[p.pos+6]) {
+		p.pos += 6
+		p.skipBlanks()
+		s.export = true
+	}
+
+	start := p.pos
+	for !p.eof() && p.peek() != '=' && p.peek() != '#' && p.peek() != '\n' && !isBlank(p.peek()) {
+		p.pos++
+	}
+	key := string(p.in[start:p.pos])
+	if key == "" {
+		return nil, fmt.Errorf("missing key")
+	}
+	p.skipBlanks()
+	if p.eof() || p.peek() != '=' {
+		return nil, fmt.Errorf("expected '=' after key %q", key)
+	}
+	p.pos++
+	p.skipBlanks()
+
+	var (
+		value   string
+		comment *string
+		err     error
+	)
+	if !p.eof() && (p.peek() == '\'' || p.peek() == '"') {
+		s.quote = p.peek()
+		if s.quote == '\'' {
+			value, err = p.singleQuoted()
+		} else {
+			value, err = p.doubleQuoted()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("value of key %q: %w", key, err)
+		}
+		end := p.pos
+		p.skipBlanks()
+		if !p.eof() && p.peek() == '#' {
+			s.space = string(p.in[end:p.pos])
+			c := p.comment()
+			comment = &c
+		}
+		if !p.eof() && p.peek() != '\n' {
+			return nil, fmt.Errorf("unexpected character %q after value of key %q", p.peek(), key)
+		}
+	} else {
+		value, comment, s.space = p.unquoted()
+	}
+	p.newline()
+
+	items := []sops.TreeItem{{Key: key, Value: s.apply(value, comment != nil)}}
+	if comment != nil {
+		items = append(items, sops.TreeItem{Key: sops.Comment{Value: *comment, Inline: true}, Value: nil})
+	}
+	return items, nil
+}
+
+// unquoted parses an unquoted value up to the end of the line, and returns it
+// with its inline comment, if any, and the white space before the comment.
+func (p *parser) unquoted() (string, *string, string) {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		if p.peek() == '#' && p.pos > start && isBlank(p.in[p.pos-1]) {
+			value := strings.TrimRight(string(p.in[start:p.pos]), " \t")
+			space := string(p.in[start+len(value) : p.pos])
+			comment := p.comment()
+			return strings.ReplaceAll(value, "\\n", "\n"), &comment, space
+		}
+		p.pos++
+	}
+	value := strings.TrimRight(string(p.in[start:p.pos]), " \t")
+	return strings.ReplaceAll(value, "\\n", "\n"), nil, ""
+}
+
+// singleQuoted parses a single quoted value, of which the content is taken
+// literally.
+func (p *parser) singleQuoted() (string, error) {
+	p.pos++
+	end := bytes.IndexByte(p.in[p.pos:], '\'')
+	if end == -1 {
+		return "", fmt.Errorf("unterminated single quoted value")
+	}
+	value := string(p.in[p.pos : p.pos+end])
+	p.line += strings.Count(value, "\n")
+	p.pos += end + 1
+	return value, nil
+}
+
+// doubleQuoted parses a double quoted value, replacing escape sequences.
+func (p *parser) doubleQuoted() (string, error) {
+	p.pos++
+	var value strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return value.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", fmt.Errorf("unterminated double quoted value")
+			}
+			e := p.peek()
+			p.pos++
+			switch e {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '\\', '"', '\'', '$', '`':
+				value.WriteByte(e)
+			default:
+				// Unknown escape sequences are kept as is.
+				value.WriteByte(c)
+				value.WriteByte(e)
+			}
+		case '\n':
+			p.line++
+			value.WriteByte(c)
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated double quoted value")
+}
+
+// comment parses a comment up to the end of the line, and returns its content
+// without the leading '#'.
+func (p *parser) comment() string {
+	p.pos++
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+	return string(p.in[start:p.pos])
+}
+
+// newline consumes the newline at the current position, if any.
+func (p *parser) newline() {
+	if !p.eof() && p.peek() == '\n' {
+		p.pos++
+		p.line++
+	}
+}
+
+// skipBlanks skips spaces and tabs.
+func (p *parser) skipBlanks() {
+	for !p.eof() && isBlank(p.peek()) {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	return p.in[p.pos]
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.in)
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// quote returns the value as it is written in a dotenv file, quoted if
+// needed so that dotenv parsers and POSIX shells read the same value:
+//
+//   - Values consisting only of characters without special meaning are not
+//     quoted.
+//   - Other values without single quotes, backslashes or newlines are single
+//     quoted.
+//   - All other values are double quoted, escaping backslashes, double
+//     quotes, dollar signs, backticks and carriage returns. Newlines are kept
+//     as is.
+func quote(value string) string {
+	if !needsQuoting(value) {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\\\n") {
+		return "'" + value + "'"
+	}
+	return doubleQuote(value)
+}
+
+// doubleQuote returns the value double quoted, escaping backslashes, double
+// quotes, dollar signs, backticks and carriage returns.
+func doubleQuote(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '"', '$', '`':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\r':
+			b.WriteString("\\r")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquote returns the value as it is written without quotes, replacing
+// newlines by "\n" as earlier versions of SOPS did, and whether it is read
+// back as is.
+func unquote(value string) (string, bool) {
+	if strings.Contains(value, "\\n") {
+		return "", false
+	}
+	value = strings.ReplaceAll(value, "\n", "\\n")
+	return value, isUnquotedSafe(value)
+}
+
+// needsQuoting returns whether the value contains characters which have a
+// special meaning in a dotenv file, or in an assignment in a POSIX shell.
+func needsQuoting(value string) bool {
+	if value == "" {
+		return false
+	}
+	if value[0] == '~' {
+		return true
+	}
+	return strings.ContainsAny(value, " \t\r\n#'\"\\$`;&|<>()!")
+}
+
+// isUnquotedSafe returns whether the value is read as is when written without
+// quotes in a dotenv file, ignoring the replacement of "\n".
+func isUnquotedSafe(value string) bool {
+	if value == "" {
+		return true
+	}
+	if strings.ContainsAny(value[:1], " \t'\"") || strings.ContainsAny(value[len(value)-1:], " \t") {
+		return false
+	}
+	return !strings.ContainsAny(value, "\r\n") && !strings.Contains(value, " #") && !strings.Contains(value, "\t#")
+}
@@ -23,6 +23,52 @@ func NewStore(c *config.DotenvStoreConfig) *Store {
 	return &Store{config: *c}
 }
 
+// style is the way a value is written in a dotenv file. It is kept in
+// sops.Styled values when it differs from the default one.
+type style struct {
+	// quote is the quote the value is written with, '\'' or '"', or 0 if the
+	// value is not quoted.
+	quote byte
+	// export is set for values of which the key is prefixed with "export ".
+	export bool
+	// space is the white space between the value and its inline comment.
+	space string
+}
+
+// defaultStyle returns the style a value is written with by default.
+func defaultStyle(value string, inlineComment bool) style {
+	s := style{}
+	if quoted := quote(value); quoted != value {
+		s.quote = quoted[0]
+	}
+	if inlineComment {
+		s.space = " "
+	}
+	return s
+}
+
+// apply returns the value, kept in a sops.Styled value if the style is not
+// the default one.
+func (s style) apply(value string, inlineComment bool) interface{} {
+	if s == defaultStyle(value, inlineComment) {
+		return value
+	}
+	return sops.Styled{Style: s, Value: value}
+}
+
+// format returns the value as it is written in the style, or false if the
+// value cannot be written in it, which happens when the value is changed.
+func (s style) format(value string) (string, bool) {
+	switch s.quote {
+	case '\'':
+		return "'" + value + "'", !strings.Contains(value, "'")
+	case '"':
+		return doubleQuote(value), true
+	default:
+		return unquote(value)
+	}
+}
+
 // LoadEncryptedFile loads an encrypted file's bytes onto a sops.Tree runtime object
 func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
 	branches, err := store.LoadPlainFile(in)
@@ -37,7 +83,11 @@ func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
 		case string:
 			if strings.HasPrefix(key, SopsPrefix) {
 				key = key[len(SopsPrefix):]
-				mdMap[key] = item.Value
+				value := item.Value
+				if styled, ok := value.(sops.Styled); ok {
+					value = styled.Value
+				}
+				mdMap[key] = value
 			} else {
 				resultBranch = append(resultBranch, item)
 			}
@@ -73,32 +123,11 @@ func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
 // LoadPlainFile returns the contents of a plaintext file loaded onto a
 // sops runtime object
 func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
-	var branches sops.TreeBranches
-	var branch sops.TreeBranch
-
-	for _, line := range bytes.Split(in, []byte("\n")) {
-		if len(line) == 0 {
-			continue
-		}
-		if line[0] == '#' {
-			branch = append(branch, sops.TreeItem{
-				Key:   sops.Comment{Value: string(line[1:])},
-				Value: nil,
-			})
-		} else {
-			pos := bytes.Index(line, []byte("="))
-			if pos == -1 {
-				return nil, fmt.Errorf("invalid dotenv input line: %s", line)
-			}
-			branch = append(branch, sops.TreeItem{
-				Key:   string(line[:pos]),
-				Value: strings.Replace(string(line[pos+1:]), "\\n", "\n", -1),
-			})
-		}
+	branch, err := parse(in)
+	if err != nil {
+		return nil, err
 	}
-
-	branches = append(branches, branch)
-	return branches, nil
+	return sops.TreeBranches{branch}, nil
 }
 
 // EmitEncryptedFile returns the encrypted file's bytes corresponding to a sops
@@ -119,34 +148,107 @@ func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
 	}
 	sort.Strings(keys)
 
+	var mdBranch sops.TreeBranch
 	for _, key := range keys {
 		var value = mdItems[key]
 		if value == nil {
 			continue
 		}
-		in.Branches[0] = append(in.Branches[0], sops.TreeItem{Key: SopsPrefix + key, Value: value})
+		mdBranch = append(mdBranch, sops.TreeItem{Key: SopsPrefix + key, Value: value})
+	}
+
+	buffer := bytes.Buffer{}
+	if err := store.emit(&buffer, in.Branches[0], quote); err != nil {
+		return nil, err
+	}
+	// Metadata values are written unquoted as they used to be, so that files
+	// stay readable by earlier versions of SOPS, unless they would not be read
+	// back as is.
+	if err := store.emit(&buffer, mdBranch, quoteMetadata); err != nil {
+		return nil, err
 	}
-	return store.EmitPlainFile(in.Branches)
+	return buffer.Bytes(), nil
+}
+
+// quoteMetadata returns a metadata value, in which newlines have already been
+// escaped, as it is written in a dotenv file.
+func quoteMetadata(value string) string {
+	if isUnquotedSafe(value) {
+		return value
+	}
+	return quote(strings.ReplaceAll(value, "\\n", "\n"))
 }
 
 // EmitPlainFile returns the plaintext file's bytes corresponding to a sops
 // runtime object
 func (store *Store) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
 	buffer := bytes.Buffer{}
-	for _, item := range in[0] {
+	if err := store.emit(&buffer, in[0], quote); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// emit writes the items of the branch to the buffer, one per line, formatting
+// values with their style, or with quoteValue if they have none. Empty
+// comments are written as blank lines, and inline comments on the line of the
+// value before them.
+func (store *Store) emit(buffer *bytes.Buffer, branch sops.TreeBranch, quoteValue func(string) string) error {
+	for i := 0; i < len(branch); i++ {
+		item := branch[i]
+		var s *style
+		if styled, ok := item.Value.(sops.Styled); ok {
+			// Styles of values loaded by other stores are ignored.
+			if styledStyle, ok := styled.Style.(style); ok {
+				s = &styledStyle
+			}
+			item.Value = styled.Value
+		}
 		if IsComplexValue(item.Value) {
-			return nil, fmt.Errorf("cannot use complex value in dotenv file: %s", item.Value)
+			return fmt.Errorf("cannot use complex value in dotenv file: %s", item.Value)
 		}
-		var line string
 		if comment, ok := item.Key.(sops.Comment); ok {
-			line = fmt.Sprintf("#%s\n", comment.Value)
-		} else {
-			value := strings.Replace(item.Value.(string), "\n", "\\n", -1)
-			line = fmt.Sprintf("%s=%s\n", item.Key, value)
+			if comment.Value == "" {
+				buffer.WriteString("\n")
+				continue
+			}
+			for _, line := range strings.Split(comment.Value, "\n") {
+				buffer.WriteString("#" + line + "\n")
+			}
+			continue
+		}
+		key, ok := item.Key.(string)
+		if !ok || key == "" || strings.ContainsAny(key, "=# \t\r\n") {
+			return fmt.Errorf("invalid key in dotenv file: %q", item.Key)
+		}
+		value, ok := item.Value.(string)
+		if !ok {
+			return fmt.Errorf("cannot use non-string value in dotenv file: %v", item.Value)
+		}
+		quoted, ok := "", false
+		if s != nil {
+			quoted, ok = s.format(value)
+			if s.export {
+				buffer.WriteString("export ")
+			}
 		}
-		buffer.WriteString(line)
+		if !ok {
+			quoted = quoteValue(value)
+		}
+		buffer.WriteString(key + "=" + quoted)
+		if i+1 < len(branch) {
+			if comment, ok := branch[i+1].Key.(sops.Comment); ok && comment.Inline && !strings.Contains(comment.Value, "\n") {
+				space := " "
+				if s != nil && s.space != "" {
+					space = s.space
+				}
+				buffer.WriteString(space + "#" + comment.Value)
+				i++
+			}
+		}
+		buffer.WriteString("\n")
 	}
-	return buffer.Bytes(), nil
+	return nil
 }
 
 // EmitValue returns a single value as bytes
@@ -176,6 +278,12 @@ func IsComplexValue(v interface{}) bool {
 	return false
 }
 
+// EmitsStyles returns true, as the quoting of values, the "export " prefix and
+// the white space before inline comments are kept in dotenv files.
+func (store *Store) EmitsStyles() bool {
+	return true
+}
+
 // HasSopsTopLevelKey checks whether a top-level "sops" key exists.
 func (store *Store) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
 	for _, b := range branch {
@@ -3,8 +3,10 @@ package dotenv
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,6 +18,15 @@ VAR3_unencrypted=val3
 VAR4=val4\nval4
 `, "\n"))
 
+var EMITTED = []byte(strings.TrimLeft(`
+VAR1=val1
+VAR2=val2
+#comment
+VAR3_unencrypted=val3
+VAR4="val4
+val4"
+`, "\n"))
+
 var BRANCH = sops.TreeBranch{
 	sops.TreeItem{
 		Key:   "VAR1",
@@ -39,10 +50,17 @@ var BRANCH = sops.TreeBranch{
 	},
 }
 
+// withoutStyles returns the branch without the styles of its values.
+func withoutStyles(t *testing.T, branch sops.TreeBranch) sops.TreeBranch {
+	expanded, err := branch.ExpandAliases()
+	assert.Nil(t, err)
+	return expanded
+}
+
 func TestLoadPlainFile(t *testing.T) {
 	branches, err := (&Store{}).LoadPlainFile(PLAIN)
 	assert.Nil(t, err)
-	assert.Equal(t, BRANCH, branches[0])
+	assert.Equal(t, BRANCH, withoutStyles(t, branches[0]))
 }
 func TestEmitPlainFile(t *testing.T) {
 	branches := sops.TreeBranches{
@@ -50,7 +68,181 @@ func TestEmitPlainFile(t *testing.T) {
 	}
 	bytes, err := (&Store{}).EmitPlainFile(branches)
 	assert.Nil(t, err)
-	assert.Equal(t, PLAIN, bytes)
+	assert.Equal(t, EMITTED, bytes)
+}
+
+func TestLoadPlainFileSyntax(t *testing.T) {
+	in := []byte(strings.Join([]string{
+		"# header",
+		"",
+		"export EXPORTED=value",
+		"  INDENTED = spaced  ",
+		"SINGLE='it is $literal\\n'",
+		`DOUBLE="tab\there \"quoted\" \$HOME \q"`,
+		"MULTI=\"first",
+		"second\"",
+		"MULTI_SINGLE='first",
+		"second'",
+		"INLINE=value # inline comment",
+		`QUOTED_INLINE="value # kept" # comment`,
+		"HASH=value#kept",
+		"EMPTY=",
+		"EMPTY_QUOTED=''",
+		"CRLF=value\r",
+		"",
+	}, "\n"))
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		{Key: sops.Comment{Value: " header"}},
+		{Key: sops.Comment{Value: ""}},
+		{Key: "EXPORTED", Value: "value"},
+		{Key: "INDENTED", Value: "spaced"},
+		{Key: "SINGLE", Value: "it is $literal\\n"},
+		{Key: "DOUBLE", Value: "tab\there \"quoted\" $HOME \\q"},
+		{Key: "MULTI", Value: "first\nsecond"},
+		{Key: "MULTI_SINGLE", Value: "first\nsecond"},
+		{Key: "INLINE", Value: "value"},
+		{Key: sops.Comment{Value: " inline comment", Inline: true}},
+		{Key: "QUOTED_INLINE", Value: "value # kept"},
+		{Key: sops.Comment{Value: " comment", Inline: true}},
+		{Key: "HASH", Value: "value#kept"},
+		{Key: "EMPTY", Value: ""},
+		{Key: "EMPTY_QUOTED", Value: ""},
+		{Key: "CRLF", Value: "value"},
+	}, withoutStyles(t, branches[0]))
+	assert.Equal(t, sops.Styled{Style: style{export: true}, Value: "value"}, branches[0][2].Value)
+	assert.Equal(t, sops.Styled{Style: style{quote: '"', space: " "}, Value: "value # kept"}, branches[0][10].Value)
+}
+
+var ROUND_TRIP = []byte(strings.TrimLeft(`
+# header
+A="x # not comment"
+B='single'
+C=plain
+D=plain value  # trailing
+E='quoted'	# tab before comment
+F="esc \" q"
+export G=exported
+export H="exported and quoted" # comment
+I=legacy\nnewline
+J=''
+`, "\n"))
+
+func TestRoundTrip(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(ROUND_TRIP)
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(ROUND_TRIP), string(bytes))
+}
+
+func TestStylesSurviveChanges(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(ROUND_TRIP)
+	assert.Nil(t, err)
+	branch, _ := branches[0].Set([]interface{}{"A"}, "ENC[AES256_GCM,data:abc=,type:str]")
+	// A value which cannot be written in its style is quoted as needed.
+	branch, _ = branch.Set([]interface{}{"B"}, "it's")
+	branch, _ = branch.Set([]interface{}{"D"}, "a #b")
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{branch})
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), "\nA=\"ENC[AES256_GCM,data:abc=,type:str]\"\n")
+	assert.Contains(t, string(bytes), "\nB=\"it's\"\n")
+	assert.Contains(t, string(bytes), "\nD='a #b'  # trailing\n")
+}
+
+func TestLoadPlainFileErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		err string
+	}{
+		{"VAR1=val1\nVAR2\n", "invalid dotenv input on line 2: expected '=' after key \"VAR2\""},
+		{"=value\n", "invalid dotenv input on line 1: missing key"},
+		{"VAR='value\n", "unterminated single quoted value"},
+		{"VAR=\"value\\\"\n", "unterminated double quoted value"},
+		{"VAR=\"value\"trailing\n", "unexpected character 't' after value of key \"VAR\""},
+	}
+	for _, tt := range tests {
+		_, err := (&Store{}).LoadPlainFile([]byte(tt.in))
+		assert.ErrorContains(t, err, tt.err, tt.in)
+	}
+}
+
+func TestEmitPlainFileQuoting(t *testing.T) {
+	branch := sops.TreeBranch{
+		{Key: sops.Comment{Value: " header"}},
+		{Key: sops.Comment{Value: ""}},
+		{Key: "PLAIN", Value: "ENC[AES256_GCM,data:abc=,type:str]"},
+		{Key: "EMPTY", Value: ""},
+		{Key: "SPACES", Value: "hello world"},
+		{Key: "DOLLAR", Value: "$HOME"},
+		{Key: "HASH", Value: "a #b"},
+		{Key: "SINGLE_QUOTE", Value: "it's"},
+		{Key: "BACKSLASH", Value: `C:\dir`},
+		{Key: "MULTILINE", Value: "first\n\"second\""},
+		{Key: "TILDE", Value: "~/path"},
+		{Key: sops.Comment{Value: " multi\n line"}},
+	}
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{branch})
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"# header",
+		"",
+		"PLAIN=ENC[AES256_GCM,data:abc=,type:str]",
+		"EMPTY=",
+		"SPACES='hello world'",
+		"DOLLAR='$HOME'",
+		"HASH='a #b'",
+		`SINGLE_QUOTE="it's"`,
+		`BACKSLASH="C:\\dir"`,
+		"MULTILINE=\"first",
+		`\"second\""`,
+		"TILDE='~/path'",
+		"# multi",
+		"# line",
+		"",
+	}, "\n"), string(bytes))
+
+	// Everything emitted is loaded back as is, except for multi-line
+	// comments which are split.
+	branches, err := (&Store{}).LoadPlainFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, branch[:len(branch)-1], branches[0][:len(branch)-1])
+}
+
+func TestEmitPlainFileInvalidKey(t *testing.T) {
+	for _, key := range []string{"", "A=B", "A B", "#A"} {
+		_, err := (&Store{}).EmitPlainFile(sops.TreeBranches{{{Key: key, Value: "value"}}})
+		assert.ErrorContains(t, err, "invalid key in dotenv file", key)
+	}
+}
+
+func TestEmitEncryptedFileRoundTrip(t *testing.T) {
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{{{Key: "VAR", Value: "ENC[AES256_GCM,data:abc=,type:str]"}}},
+		Metadata: sops.Metadata{
+			Version:                   "3.9.0",
+			LastModified:              time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			MessageAuthenticationCode: "ENC[AES256_GCM,data:mac=,type:str]",
+			UnencryptedCommentRegex:   " #leading space",
+			KeyGroups: []sops.KeyGroup{{&age.MasterKey{
+				Recipient:    "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw",
+				EncryptedKey: "-----BEGIN AGE ENCRYPTED FILE-----\nYWJj\n-----END AGE ENCRYPTED FILE-----\n",
+			}}},
+		},
+	}
+	bytes, err := (&Store{}).EmitEncryptedFile(tree)
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), "\nsops_mac=ENC[AES256_GCM,data:mac=,type:str]\n")
+	assert.Contains(t, string(bytes), "\nsops_unencrypted_comment_regex=' #leading space'\n")
+	assert.Contains(t, string(bytes), "\nsops_age__list_0__map_enc=-----BEGIN AGE ENCRYPTED FILE-----\\nYWJj\\n-----END AGE ENCRYPTED FILE-----\\n\n")
+
+	loaded, err := (&Store{}).LoadEncryptedFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, tree.Branches, loaded.Branches)
+	assert.Equal(t, tree.Metadata.UnencryptedCommentRegex, loaded.Metadata.UnencryptedCommentRegex)
+	assert.Equal(t, tree.Metadata.MessageAuthenticationCode, loaded.Metadata.MessageAuthenticationCode)
+	assert.Equal(t, tree.Metadata.KeyGroups[0][0].EncryptedDataKey(), loaded.Metadata.KeyGroups[0][0].EncryptedDataKey())
 }
 
 func TestEmitValueString(t *testing.T) {
@@ -167,6 +167,11 @@ func TestFlattenMetadataToUnflattenMetadata(t *testing.T) {
 		{Metadata{MACOnlyEncrypted: true}},
 		{Metadata{MACOnlyEncrypted: false}},
 		{Metadata{ShamirThreshold: 3}},
+		{Metadata{Cipher: "xchacha20_poly1305"}},
+		{Metadata{MACVersion: 2}},
+		{Metadata{EncryptedPaths: []string{"data", "env[*].value"}, UnencryptedPaths: []string{"env[*].value_plain"}}},
+		{Metadata{CiphertextMAC: "ENC[AES256_GCM,data:abc,iv:def,tag:ghi,type:str]"}},
+		{Metadata{Zones: []zone{{Paths: []string{"db", "payments.*"}, KeyGroups: []keygroup{{AgeKeys: []agekey{{Recipient: "age1abc", EncryptedDataKey: "enc"}}}}}}}},
 		{Metadata{MessageAuthenticationCode: "line1\nline2"}},
 		{Metadata{MessageAuthenticationCode: "line1\n\n\nline2\n\nline3"}},
 	}
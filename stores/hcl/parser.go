@@ -0,0 +1,626 @@
+package hcl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/getsops/sops/v3"
+)
+
+// parser is a parser for the subset of the HCL native syntax used by
+// Terraform variable definition files:
+//
+//   - Attributes, written "name = value" on their own line.
+//   - Literal values: strings, heredocs, numbers, true, false and null.
+//   - Objects, of which the items are separated by commas or newlines, and
+//     tuples, of which the items are separated by commas.
+//   - Comments starting with "#" or "//", and block comments enclosed in "/*"
+//     and "*/".
+//
+// Blocks are only accepted for the sops metadata, and expressions other than
+// literal values, such as references, function calls and template
+// interpolations, are not accepted, as they cannot be evaluated.
+//
+// Comments are loaded as sops.Comment items preceding the value they are
+// before, or that they are on the line of. Blank lines are loaded as empty
+// comments. Whole numbers are loaded as int values, and other numbers as
+// float64 values. Numbers which neither can hold exactly are loaded as
+// sops.Number values.
+type parser struct {
+	in   []byte
+	pos  int
+	line int
+	// lineStart is the position of the first byte of the current line.
+	lineStart int
+	// lineHasContent is whether a token or a comment was read on the current
+	// line, so that blank lines can be told apart.
+	lineHasContent bool
+}
+
+// parse parses an HCL document into a sops.TreeBranch.
+func parse(in []byte) (sops.TreeBranch, error) {
+	p := &parser{in: in, line: 1}
+	if strings.HasPrefix(string(in), "\uFEFF") {
+		p.pos = len("\uFEFF")
+		p.lineStart = p.pos
+	}
+	branch, err := p.body(false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HCL input on line %d, column %d: %w", p.line, p.pos-p.lineStart+1, err)
+	}
+	return branch, nil
+}
+
+// body parses the attributes and blocks of the document, or of a block if
+// closed is true, in which case it ends at the closing brace.
+func (p *parser) body(closed bool) (sops.TreeBranch, error) {
+	branch := sops.TreeBranch{}
+	for {
+		comments, err := p.skip()
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+		if p.eof() {
+			if closed {
+				return nil, fmt.Errorf("unterminated block")
+			}
+			return branch, nil
+		}
+		if closed && p.peek() == '}' {
+			p.pos++
+			return branch, nil
+		}
+
+		name := p.identifier()
+		if name == "" {
+			return nil, fmt.Errorf("expected an attribute name, got %q", p.peek())
+		}
+		p.skipBlanks()
+		if !p.eof() && (p.peek() == '{' || p.peek() == '"') {
+			if name != blockName || p.peek() != '{' {
+				return nil, fmt.Errorf("blocks are not supported, found block %q", name)
+			}
+			p.pos++
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+			value, err := p.body(true)
+			if err != nil {
+				return nil, err
+			}
+			branch = append(branch, sops.TreeItem{Key: name, Value: value})
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.eof() || p.peek() != '=' {
+			return nil, fmt.Errorf("expected '=' after attribute name %q", name)
+		}
+		p.pos++
+		p.skipBlanks()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		before, err := p.sameLineComments()
+		if err != nil {
+			return nil, err
+		}
+		if !p.eof() && p.peek() != '\n' && !(closed && p.peek() == '}') {
+			return nil, fmt.Errorf("expected a newline after the value of attribute %q, got %q", name, p.peek())
+		}
+		for _, comment := range before {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+		branch = append(branch, sops.TreeItem{Key: name, Value: value})
+	}
+}
+
+// endOfLine checks that only white space and comments follow on the current
+// line, which is the case after the braces of blocks.
+func (p *parser) endOfLine() error {
+	p.skipBlanks()
+	if p.eof() || p.peek() == '\n' || p.peek() == '\r' {
+		return nil
+	}
+	if p.peek() == '#' || p.peek() == '/' {
+		return nil
+	}
+	return fmt.Errorf("expected a newline, got %q", p.peek())
+}
+
+// object parses an object, starting at its opening brace.
+func (p *parser) object() (sops.TreeBranch, error) {
+	p.pos++
+	branch := sops.TreeBranch{}
+	for {
+		comments, err := p.skip()
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated object")
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return branch, nil
+		}
+
+		var key string
+		if p.peek() == '"' {
+			key, err = p.string()
+			if err != nil {
+				return nil, err
+			}
+		} else if key = p.identifier(); key == "" {
+			return nil, fmt.Errorf("expected an object key, got %q", p.peek())
+		}
+		p.skipBlanks()
+		if p.eof() || (p.peek() != '=' && p.peek() != ':') {
+			return nil, fmt.Errorf("expected '=' after object key %q", key)
+		}
+		p.pos++
+		p.skipBlanks()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		before, err := p.sameLineComments()
+		if err != nil {
+			return nil, err
+		}
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+			more, err := p.sameLineComments()
+			if err != nil {
+				return nil, err
+			}
+			before = append(before, more...)
+		} else if !p.eof() && p.peek() != '\n' && p.peek() != '}' {
+			return nil, fmt.Errorf("expected ',', a newline or '}' after the value of object key %q, got %q", key, p.peek())
+		}
+		for _, comment := range before {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+		branch = append(branch, sops.TreeItem{Key: key, Value: value})
+	}
+}
+
+// tuple parses a tuple, starting at its opening bracket.
+func (p *parser) tuple() ([]interface{}, error) {
+	p.pos++
+	tuple := []interface{}{}
+	for {
+		comments, err := p.skip()
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			tuple = append(tuple, comment)
+		}
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated tuple")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return tuple, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		before, err := p.sameLineComments()
+		if err != nil {
+			return nil, err
+		}
+		after, err := p.skip()
+		if err != nil {
+			return nil, err
+		}
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+			more, err := p.sameLineComments()
+			if err != nil {
+				return nil, err
+			}
+			if len(after) == 0 {
+				before = append(before, more...)
+			} else {
+				after = append(after, more...)
+			}
+		} else if p.eof() || p.peek() != ']' {
+			return nil, fmt.Errorf("expected ',' or ']'")
+		}
+		for _, comment := range before {
+			tuple = append(tuple, comment)
+		}
+		tuple = append(tuple, value)
+		for _, comment := range after {
+			tuple = append(tuple, comment)
+		}
+	}
+}
+
+// value parses a literal value.
+func (p *parser) value() (interface{}, error) {
+	if p.eof() || p.peek() == '\n' {
+		return nil, fmt.Errorf("expected a value")
+	}
+	switch c := p.peek(); {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.tuple()
+	case c == '"':
+		return p.string()
+	case c == '<':
+		return p.heredoc()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+	word := p.identifier()
+	if !p.eof() && (p.peek() == '.' || p.peek() == '(' || p.peek() == '[') {
+		return nil, fmt.Errorf("unsupported expression starting with %q, only literal values are supported", word+string(p.peek()))
+	}
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "":
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return nil, fmt.Errorf("unsupported expression %q, only literal values are supported", word)
+}
+
+// number parses a number, returning an int for whole numbers.
+func (p *parser) number() (interface{}, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	digits := func() int {
+		n := 0
+		for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+	isFloat := false
+	ok := digits() > 0
+	if ok && !p.eof() && p.peek() == '.' {
+		p.pos++
+		isFloat = true
+		ok = digits() > 0
+	}
+	if ok && !p.eof() && (p.peek() == 'e' || p.peek() == 'E') {
+		p.pos++
+		isFloat = true
+		if !p.eof() && (p.peek() == '+' || p.peek() == '-') {
+			p.pos++
+		}
+		ok = digits() > 0
+	}
+	literal := string(p.in[start:p.pos])
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", literal)
+	}
+	if !isFloat {
+		if n, err := sops.ParseInt(literal); err == nil {
+			return n, nil
+		}
+	}
+	n, err := sops.ParseFloat(literal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", literal)
+	}
+	return n, nil
+}
+
+// string parses a quoted string.
+func (p *parser) string() (string, error) {
+	p.pos++
+	var value strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == '"':
+			p.pos++
+			return value.String(), nil
+		case c == '\n':
+			return "", fmt.Errorf("unterminated string")
+		case c == '\\':
+			if err := p.escape(&value); err != nil {
+				return "", err
+			}
+		case c == '$' || c == '%':
+			if err := p.templateSequence(&value); err != nil {
+				return "", err
+			}
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// templateSequence parses a '$' or a '%' in a string or a heredoc. Template
+// sequences are not supported, but their escaped form, "$${" or "%%{", is
+// replaced by "${" or "%{".
+func (p *parser) templateSequence(value *strings.Builder) error {
+	c := p.peek()
+	rest := string(p.in[p.pos+1:])
+	switch {
+	case strings.HasPrefix(rest, "{"):
+		return fmt.Errorf("template sequences are not supported, found %q", string(c)+"{")
+	case strings.HasPrefix(rest, string(c)+"{"):
+		value.WriteString(string(c) + "{")
+		p.pos += 3
+	default:
+		value.WriteByte(c)
+		p.pos++
+	}
+	return nil
+}
+
+// escape parses an escape sequence in a string, writing the character it
+// represents to value.
+func (p *parser) escape(value *strings.Builder) error {
+	p.pos++
+	if p.eof() {
+		return fmt.Errorf("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'n':
+		value.WriteByte('\n')
+	case 'r':
+		value.WriteByte('\r')
+	case 't':
+		value.WriteByte('\t')
+	case '"', '\\':
+		value.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.in) {
+			return fmt.Errorf("invalid escape sequence")
+		}
+		r, err := strconv.ParseUint(string(p.in[p.pos:p.pos+n]), 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("invalid escape sequence %q", "\\"+string(c)+string(p.in[p.pos:p.pos+n]))
+		}
+		p.pos += n
+		value.WriteRune(rune(r))
+	default:
+		return fmt.Errorf("invalid escape sequence %q", "\\"+string(c))
+	}
+	return nil
+}
+
+// heredoc parses a heredoc, "<<MARKER" or "<<-MARKER" followed by lines up to
+// a line containing only the marker. The lines of "<<-" heredocs have their
+// common leading white space removed.
+func (p *parser) heredoc() (string, error) {
+	if !strings.HasPrefix(string(p.in[p.pos:]), "<<") {
+		return "", fmt.Errorf("unexpected '<'")
+	}
+	p.pos += 2
+	indented := false
+	if !p.eof() && p.peek() == '-' {
+		indented = true
+		p.pos++
+	}
+	marker := p.identifier()
+	if marker == "" {
+		return "", fmt.Errorf("expected a heredoc marker")
+	}
+	if !p.eof() && p.peek() == '\r' {
+		p.pos++
+	}
+	if p.eof() || p.peek() != '\n' {
+		return "", fmt.Errorf("expected a newline after heredoc marker %q", marker)
+	}
+	p.newline()
+
+	var lines []string
+	for !p.eof() {
+		end := p.pos
+		for end < len(p.in) && p.in[end] != '\n' {
+			end++
+		}
+		line := strings.TrimSuffix(string(p.in[p.pos:end]), "\r")
+		if strings.TrimLeft(line, " \t") == marker {
+			p.pos = end
+			p.lineHasContent = true
+			return p.heredocContent(lines, indented)
+		}
+		lines = append(lines, line)
+		p.pos = end
+		if !p.eof() {
+			p.newline()
+		}
+	}
+	return "", fmt.Errorf("unterminated heredoc %q", marker)
+}
+
+// heredocContent returns the content of a heredoc made of the lines.
+func (p *parser) heredocContent(lines []string, indented bool) (string, error) {
+	if indented {
+		common := -1
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			n := len(line) - len(strings.TrimLeft(line, " \t"))
+			if common == -1 || n < common {
+				common = n
+			}
+		}
+		for i, line := range lines {
+			if len(line) >= common && common > 0 {
+				lines[i] = line[common:]
+			} else {
+				lines[i] = strings.TrimLeft(line, " \t")
+			}
+		}
+	}
+	var value strings.Builder
+	for _, line := range lines {
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if (c == '$' || c == '%') && strings.HasPrefix(line[i+1:], "{") {
+				return "", fmt.Errorf("template sequences are not supported, found %q", string(c)+"{")
+			}
+			if (c == '$' || c == '%') && strings.HasPrefix(line[i+1:], string(c)+"{") {
+				i++
+			}
+			value.WriteByte(c)
+		}
+		value.WriteByte('\n')
+	}
+	return value.String(), nil
+}
+
+// identifier parses an identifier, returning an empty string if there is none
+// at the current position.
+func (p *parser) identifier() string {
+	start := p.pos
+	for !p.eof() {
+		r, size := utf8.DecodeRune(p.in[p.pos:])
+		if !isIdentifierRune(r, p.pos == start) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos > start {
+		p.lineHasContent = true
+	}
+	return string(p.in[start:p.pos])
+}
+
+// sameLineComments parses the comments which are on the current line.
+func (p *parser) sameLineComments() ([]sops.Comment, error) {
+	var comments []sops.Comment
+	for {
+		p.skipBlanks()
+		if p.eof() || !p.atComment() {
+			return comments, nil
+		}
+		comment, err := p.comment()
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+}
+
+// skip skips white space and comments, and returns the comments. Blank lines
+// are returned as empty comments.
+func (p *parser) skip() ([]sops.Comment, error) {
+	var comments []sops.Comment
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == '\n':
+			if !p.lineHasContent {
+				comments = append(comments, sops.Comment{Value: ""})
+			}
+			p.newline()
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case p.atComment():
+			comment, err := p.comment()
+			if err != nil {
+				return nil, err
+			}
+			comments = append(comments, comment)
+		default:
+			p.lineHasContent = true
+			return comments, nil
+		}
+	}
+	return comments, nil
+}
+
+// skipBlanks skips spaces and tabs.
+func (p *parser) skipBlanks() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r') {
+		p.pos++
+	}
+}
+
+func (p *parser) atComment() bool {
+	rest := string(p.in[p.pos:])
+	return strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "//") || strings.HasPrefix(rest, "/*")
+}
+
+// comment parses a comment starting at the current position, and returns its
+// content without the comment delimiters.
+func (p *parser) comment() (sops.Comment, error) {
+	p.lineHasContent = true
+	if strings.HasPrefix(string(p.in[p.pos:]), "/*") {
+		start := p.pos + 2
+		end := strings.Index(string(p.in[start:]), "*/")
+		if end == -1 {
+			return sops.Comment{}, fmt.Errorf("unterminated block comment")
+		}
+		value := string(p.in[start : start+end])
+		for i := p.pos; i < start+end; i++ {
+			if p.in[i] == '\n' {
+				p.line++
+				p.lineStart = i + 1
+			}
+		}
+		p.pos = start + end + 2
+		return sops.Comment{Value: strings.ReplaceAll(value, "\r\n", "\n")}, nil
+	}
+	start := p.pos + 1
+	if p.peek() == '/' {
+		start++
+	}
+	end := start
+	for end < len(p.in) && p.in[end] != '\n' {
+		end++
+	}
+	p.pos = end
+	return sops.Comment{Value: strings.TrimSuffix(string(p.in[start:end]), "\r")}, nil
+}
+
+func (p *parser) newline() {
+	p.pos++
+	p.line++
+	p.lineStart = p.pos
+	p.lineHasContent = false
+}
+
+func (p *parser) peek() byte {
+	return p.in[p.pos]
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.in)
+}
+
+func isIdentifierRune(r rune, first bool) bool {
+	if r == '_' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && (r == '-' || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r) || unicode.Is(unicode.Pc, r))
+}
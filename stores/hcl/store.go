@@ -0,0 +1,397 @@
+// Package hcl implements a store for files written in the native syntax of
+// HCL, such as Terraform variable definition (.tfvars) files.
+//
+// Only attributes with literal values are supported, as other expressions
+// cannot be evaluated. The sops metadata is stored in a block named sops at
+// the end of the file.
+package hcl //import "github.com/getsops/sops/v3/stores/hcl"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+)
+
+// blockName is the name of the block the sops metadata is stored in.
+const blockName = stores.SopsMetadataKey
+
+const indent = "  "
+
+// Store handles storage of HCL data
+type Store struct {
+	config config.HCLStoreConfig
+}
+
+func NewStore(c *config.HCLStoreConfig) *Store {
+	return &Store{config: *c}
+}
+
+// LoadEncryptedFile loads an encrypted HCL file onto a sops.Tree object
+func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
+	branch, err := parse(in)
+	if err != nil {
+		return sops.Tree{}, fmt.Errorf("Could not unmarshal input data: %s", err)
+	}
+	var metadataItem *sops.TreeItem
+	for i, item := range branch {
+		if item.Key == blockName {
+			metadataItem = &item
+			// The blank line written before the metadata block is removed
+			// with it.
+			start := i
+			if i > 0 && branch[i-1].Key == (sops.Comment{Value: ""}) {
+				start--
+			}
+			branch = append(branch[:start], branch[i+1:]...)
+			break
+		}
+	}
+	if metadataItem == nil {
+		return sops.Tree{}, sops.MetadataNotFound
+	}
+	// The metadata is converted to JSON, and loaded the same way the JSON
+	// store loads it.
+	metadataJSON, err := (&sopsjson.Store{}).EmitValue(metadataItem.Value)
+	if err != nil {
+		return sops.Tree{}, fmt.Errorf("Error unmarshalling input hcl: %s", err)
+	}
+	var metadata stores.Metadata
+	if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+		return sops.Tree{}, fmt.Errorf("Error unmarshalling input hcl: %s", err)
+	}
+	internalMetadata, err := metadata.ToInternal()
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	return sops.Tree{
+		Branches: sops.TreeBranches{
+			branch,
+		},
+		Metadata: internalMetadata,
+	}, nil
+}
+
+// LoadPlainFile loads plaintext HCL file bytes onto a sops.TreeBranches object
+func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	branch, err := parse(in)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal input data: %s", err)
+	}
+	return sops.TreeBranches{
+		branch,
+	}, nil
+}
+
+// EmitEncryptedFile returns the encrypted bytes of the HCL file corresponding
+// to a sops.Tree runtime object
+func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
+	// The metadata is converted to a tree through JSON, so that it is written
+	// with the same keys as in JSON and YAML files.
+	metadataJSON, err := json.Marshal(stores.MetadataFromInternal(in.Metadata))
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling to hcl: %s", err)
+	}
+	metadata, err := (&sopsjson.Store{}).LoadPlainFile(metadataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling to hcl: %s", err)
+	}
+	// Unset fields are left out, instead of being written as null.
+	var metadataBranch sops.TreeBranch
+	for _, item := range metadata[0] {
+		if item.Value != nil {
+			metadataBranch = append(metadataBranch, item)
+		}
+	}
+	e := &encoder{}
+	if err := e.encodeBody(in.Branches[0]); err != nil {
+		return nil, fmt.Errorf("Error marshaling to hcl: %s", err)
+	}
+	if e.buf.Len() > 0 {
+		e.buf.WriteString("\n")
+	}
+	e.buf.WriteString(blockName + " {")
+	if err := e.encodeItems(metadataBranch, indent); err != nil {
+		return nil, fmt.Errorf("Error marshaling to hcl: %s", err)
+	}
+	e.buf.WriteString("\n}\n")
+	return e.buf.Bytes(), nil
+}
+
+// EmitPlainFile returns the plaintext bytes of the HCL file corresponding to a
+// sops.TreeBranches runtime object
+func (store *Store) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
+	e := &encoder{}
+	if err := e.encodeBody(in[0]); err != nil {
+		return nil, fmt.Errorf("Error marshaling to hcl: %s", err)
+	}
+	return e.buf.Bytes(), nil
+}
+
+// EmitValue returns bytes corresponding to a single encoded value
+// in a generic interface{} object
+func (store *Store) EmitValue(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	e := &encoder{}
+	if err := e.encodeValue(v, ""); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// EmitExample returns the bytes corresponding to an example complex tree
+func (store *Store) EmitExample() []byte {
+	bytes, err := store.EmitPlainFile(stores.ExampleComplexTree.Branches)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+// HasSopsTopLevelKey checks whether a top-level "sops" key exists.
+func (store *Store) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
+	return stores.HasSopsTopLevelKey(branch)
+}
+
+// encoder writes HCL, indented the way terraform fmt indents it.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+// encodeBody writes the items of the document as attributes, one per line.
+func (e *encoder) encodeBody(branch sops.TreeBranch) error {
+	for _, item := range branch {
+		if comment, ok := item.Key.(sops.Comment); ok {
+			e.encodeComment(comment.Value, "")
+			continue
+		}
+		key, ok := item.Key.(string)
+		if !ok || !isIdentifier(key) {
+			return fmt.Errorf("invalid attribute name in hcl file: %q", item.Key)
+		}
+		e.buf.WriteString(key + " = ")
+		if err := e.encodeValue(item.Value, ""); err != nil {
+			return err
+		}
+		e.buf.WriteString("\n")
+	}
+	return nil
+}
+
+// encodeItems writes the items of an object, each on its own line.
+func (e *encoder) encodeItems(branch sops.TreeBranch, prefix string) error {
+	for _, item := range branch {
+		e.buf.WriteString("\n")
+		if comment, ok := item.Key.(sops.Comment); ok {
+			e.encodeComment(comment.Value, prefix)
+			e.buf.Truncate(e.buf.Len() - 1)
+			continue
+		}
+		key, ok := item.Key.(string)
+		if !ok {
+			return fmt.Errorf("cannot use non-string key %v in hcl file", item.Key)
+		}
+		if isIdentifier(key) {
+			e.buf.WriteString(prefix + key + " = ")
+		} else {
+			e.buf.WriteString(prefix + quote(key) + " = ")
+		}
+		if err := e.encodeValue(item.Value, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeComment writes a comment, with one "#" per line. Empty comments are
+// written as blank lines.
+func (e *encoder) encodeComment(comment string, prefix string) {
+	if comment == "" {
+		e.buf.WriteString("\n")
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		e.buf.WriteString(prefix + "#" + line + "\n")
+	}
+}
+
+func (e *encoder) encodeValue(v interface{}, prefix string) error {
+	switch v := v.(type) {
+	case nil:
+		e.buf.WriteString("null")
+	case bool:
+		e.buf.WriteString(strconv.FormatBool(v))
+	case int:
+		e.buf.WriteString(strconv.Itoa(v))
+	case float64:
+		// Numbers are written the way they are written in JSON, which is
+		// valid HCL, and which fails for NaN and infinities.
+		out, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("cannot use %v in hcl file", v)
+		}
+		e.buf.Write(out)
+	case sops.Number:
+		e.buf.WriteString(string(v))
+	case sops.Timestamp:
+		return e.encodeValue(string(v), prefix)
+	case string:
+		if marker, ok := heredocMarker(v); ok {
+			e.buf.WriteString("<<" + marker + "\n" + escapeTemplates(v) + marker)
+		} else {
+			e.buf.WriteString(quote(v))
+		}
+	case sops.TreeBranch:
+		if len(v) == 0 {
+			e.buf.WriteString("{}")
+			return nil
+		}
+		e.buf.WriteString("{")
+		if err := e.encodeItems(v, prefix+indent); err != nil {
+			return err
+		}
+		e.buf.WriteString("\n" + prefix + "}")
+	case []interface{}:
+		return e.encodeTuple(v, prefix)
+	default:
+		return fmt.Errorf("cannot use value %v of type %T in hcl file", v, v)
+	}
+	return nil
+}
+
+// encodeTuple writes a tuple on a single line if it only contains scalar
+// values, and otherwise writes each of its items on its own line. Strings in
+// tuples are always quoted, as a heredoc marker must be alone on its line.
+func (e *encoder) encodeTuple(tuple []interface{}, prefix string) error {
+	inline := true
+	for _, item := range tuple {
+		switch item.(type) {
+		case sops.Comment, sops.TreeBranch, []interface{}:
+			inline = false
+		}
+	}
+	if inline {
+		e.buf.WriteString("[")
+		for i, item := range tuple {
+			if i > 0 {
+				e.buf.WriteString(", ")
+			}
+			if err := e.encodeTupleItem(item, prefix); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteString("]")
+		return nil
+	}
+	inner := prefix + indent
+	e.buf.WriteString("[")
+	for _, item := range tuple {
+		e.buf.WriteString("\n")
+		if comment, ok := item.(sops.Comment); ok {
+			e.encodeComment(comment.Value, inner)
+			e.buf.Truncate(e.buf.Len() - 1)
+			continue
+		}
+		e.buf.WriteString(inner)
+		if err := e.encodeTupleItem(item, inner); err != nil {
+			return err
+		}
+		e.buf.WriteString(",")
+	}
+	e.buf.WriteString("\n" + prefix + "]")
+	return nil
+}
+
+func (e *encoder) encodeTupleItem(item interface{}, prefix string) error {
+	if s, ok := item.(string); ok {
+		e.buf.WriteString(quote(s))
+		return nil
+	}
+	return e.encodeValue(item, prefix)
+}
+
+// heredocMarker returns the marker to write a string as a heredoc with, which
+// is done for strings made of lines ending with a newline, and without other
+// control characters.
+func heredocMarker(s string) (string, bool) {
+	if !strings.HasSuffix(s, "\n") {
+		return "", false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\n' && r != '\t' {
+			return "", false
+		}
+	}
+	lines := strings.Split(s, "\n")
+	for i := 0; ; i++ {
+		marker := "EOT"
+		if i > 0 {
+			marker += strconv.Itoa(i)
+		}
+		conflict := false
+		for _, line := range lines {
+			if strings.TrimLeft(line, " \t") == marker {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			return marker, true
+		}
+	}
+}
+
+// quote returns the string as an HCL quoted string.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range escapeTemplates(s) {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, "\\u%04x", r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// escapeTemplates escapes the sequences starting template interpolations and
+// directives, so that they are read literally.
+func escapeTemplates(s string) string {
+	s = strings.ReplaceAll(s, "${", "$${")
+	return strings.ReplaceAll(s, "%{", "%%{")
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !isIdentifierRune(r, i == 0) {
+			return false
+		}
+	}
+	return true
+}
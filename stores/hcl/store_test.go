@@ -0,0 +1,255 @@
+package hcl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
+	"github.com/stretchr/testify/assert"
+)
+
+var PLAIN = []byte(`# Database settings
+db_user     = "admin"
+db_password = "s3cr3t" // rotated monthly
+
+/* Network*/
+ports = [80, 443]
+enabled = true
+ratio   = 0.5
+tags = {
+  Environment = "production"
+  "cost-center": 1234, owner = null
+}
+servers = [
+  # primary
+  { name = "a", weight = 1 },
+  { name = "b", weight = 2 },
+]
+certificate = <<-EOT
+  -----BEGIN CERTIFICATE-----
+  MIIB
+  -----END CERTIFICATE-----
+  EOT
+`)
+
+var BRANCH = sops.TreeBranch{
+	sops.TreeItem{Key: sops.Comment{Value: " Database settings"}},
+	sops.TreeItem{Key: "db_user", Value: "admin"},
+	sops.TreeItem{Key: sops.Comment{Value: " rotated monthly"}},
+	sops.TreeItem{Key: "db_password", Value: "s3cr3t"},
+	sops.TreeItem{Key: sops.Comment{Value: ""}},
+	sops.TreeItem{Key: sops.Comment{Value: " Network"}},
+	sops.TreeItem{Key: "ports", Value: []interface{}{80, 443}},
+	sops.TreeItem{Key: "enabled", Value: true},
+	sops.TreeItem{Key: "ratio", Value: 0.5},
+	sops.TreeItem{Key: "tags", Value: sops.TreeBranch{
+		sops.TreeItem{Key: "Environment", Value: "production"},
+		sops.TreeItem{Key: "cost-center", Value: 1234},
+		sops.TreeItem{Key: "owner", Value: nil},
+	}},
+	sops.TreeItem{Key: "servers", Value: []interface{}{
+		sops.Comment{Value: " primary"},
+		sops.TreeBranch{
+			sops.TreeItem{Key: "name", Value: "a"},
+			sops.TreeItem{Key: "weight", Value: 1},
+		},
+		sops.TreeBranch{
+			sops.TreeItem{Key: "name", Value: "b"},
+			sops.TreeItem{Key: "weight", Value: 2},
+		},
+	}},
+	sops.TreeItem{Key: "certificate", Value: "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"},
+}
+
+var EMITTED = `# Database settings
+db_user = "admin"
+# rotated monthly
+db_password = "s3cr3t"
+
+# Network
+ports = [80, 443]
+enabled = true
+ratio = 0.5
+tags = {
+  Environment = "production"
+  cost-center = 1234
+  owner = null
+}
+servers = [
+  # primary
+  {
+    name = "a"
+    weight = 1
+  },
+  {
+    name = "b"
+    weight = 2
+  },
+]
+certificate = <<EOT
+-----BEGIN CERTIFICATE-----
+MIIB
+-----END CERTIFICATE-----
+EOT
+`
+
+func TestLoadPlainFile(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(PLAIN)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCH, branches[0])
+}
+
+func TestEmitPlainFile(t *testing.T) {
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{BRANCH})
+	assert.Nil(t, err)
+	assert.Equal(t, EMITTED, string(bytes))
+
+	branches, err := (&Store{}).LoadPlainFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCH, branches[0])
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		in    string
+		value string
+	}{
+		{`"a\"b\\c\nd\re\tf"`, "a\"b\\c\nd\re\tf"},
+		{`"\u00e9\U0001F600"`, "é\U0001F600"},
+		{`"$${var.x} %%{if} $ 100%"`, "${var.x} %{if} $ 100%"},
+		{"<<EOT\n  $${x}\nEOT", "  ${x}\n"},
+		{"<<-EOT\n    a\n\n      b\n    EOT", "a\n\n  b\n"},
+		{"<<EOT\nEOT", ""},
+	}
+	for _, tt := range tests {
+		branches, err := (&Store{}).LoadPlainFile([]byte("key = " + tt.in + "\n"))
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, sops.TreeBranch{{Key: "key", Value: tt.value}}, branches[0], tt.in)
+
+		bytes, err := (&Store{}).EmitPlainFile(branches)
+		assert.Nil(t, err)
+		branches, err = (&Store{}).LoadPlainFile(bytes)
+		assert.Nil(t, err, string(bytes))
+		assert.Equal(t, sops.TreeBranch{{Key: "key", Value: tt.value}}, branches[0], string(bytes))
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	in := []byte("a = -12\nb = 1.5e3\nc = 99999999999999999999\nd = 0.10000000000000000001\n")
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		{Key: "a", Value: -12},
+		{Key: "b", Value: 1500.0},
+		{Key: "c", Value: sops.Number("99999999999999999999")},
+		{Key: "d", Value: sops.Number("0.10000000000000000001")},
+	}, branches[0])
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, "a = -12\nb = 1500\nc = 99999999999999999999\nd = 0.10000000000000000001\n", string(bytes))
+}
+
+func TestEmitPlainFileHeredocMarker(t *testing.T) {
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{{
+		{Key: "a", Value: "EOT\n"},
+		{Key: "b", Value: []interface{}{"line\n", sops.TreeBranch{}}},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "a = <<EOT1\nEOT\nEOT1\nb = [\n  \"line\\n\",\n  {},\n]\n", string(bytes))
+}
+
+func TestLoadPlainFileErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		err string
+	}{
+		{"a = var.x", `invalid HCL input on line 1, column 8: unsupported expression starting with "var."`},
+		{"a = upper(\"x\")", `unsupported expression starting with "upper("`},
+		{"a = foo", `unsupported expression "foo"`},
+		{`a = "${var.x}"`, `template sequences are not supported, found "${"`},
+		{"a = <<EOT\n%{ if true }\nEOT", `template sequences are not supported, found "%{"`},
+		{"a = 1 b = 2", `expected a newline after the value of attribute "a", got 'b'`},
+		{"a = {b = 1 c = 2}", `expected ',', a newline or '}' after the value of object key "b", got 'c'`},
+		{"a = [1 2]", "expected ',' or ']'"},
+		{"a = \"b", "unterminated string"},
+		{"a = <<EOT\nb\n", `unterminated heredoc "EOT"`},
+		{"a = \"\\q\"", `invalid escape sequence "\\q"`},
+		{"a = 1.", `invalid number "1."`},
+		{"a =\n1", "expected a value"},
+		{"resource \"a\" \"b\" {\n}", `blocks are not supported, found block "resource"`},
+		{"variable {\n}", `blocks are not supported, found block "variable"`},
+		{"= 1", "expected an attribute name, got '='"},
+		{"a 1", `expected '=' after attribute name "a"`},
+		{"a = 1 /* comment", "unterminated block comment"},
+	}
+	for _, tt := range tests {
+		_, err := (&Store{}).LoadPlainFile([]byte(tt.in))
+		assert.ErrorContains(t, err, tt.err, tt.in)
+	}
+}
+
+func TestEmitPlainFileErrors(t *testing.T) {
+	_, err := (&Store{}).EmitPlainFile(sops.TreeBranches{{{Key: "not an identifier", Value: 1}}})
+	assert.ErrorContains(t, err, `invalid attribute name in hcl file: "not an identifier"`)
+}
+
+func TestEncryptedFileRoundTrip(t *testing.T) {
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{{
+			{Key: sops.Comment{Value: " comment"}},
+			{Key: "key", Value: "ENC[AES256_GCM,data:abc=,type:str]"},
+			{Key: sops.Comment{Value: ""}},
+		}},
+		Metadata: sops.Metadata{
+			Version:                   "3.9.0",
+			LastModified:              time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			MessageAuthenticationCode: "ENC[AES256_GCM,data:mac=,type:str]",
+			UnencryptedSuffix:         "_unencrypted",
+			ShamirThreshold:           2,
+			KeyGroups: []sops.KeyGroup{{&age.MasterKey{
+				Recipient:    "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw",
+				EncryptedKey: "-----BEGIN AGE ENCRYPTED FILE-----\nYWJj\n-----END AGE ENCRYPTED FILE-----\n",
+			}}},
+		},
+	}
+	bytes, err := (&Store{}).EmitEncryptedFile(tree)
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), `# comment
+key = "ENC[AES256_GCM,data:abc=,type:str]"
+
+
+sops {
+  shamir_threshold = 2
+  age = [
+    {
+      recipient = "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw"
+      enc = <<EOT
+-----BEGIN AGE ENCRYPTED FILE-----
+YWJj
+-----END AGE ENCRYPTED FILE-----
+EOT
+    },
+  ]
+`)
+
+	loaded, err := (&Store{}).LoadEncryptedFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, tree.Branches, loaded.Branches)
+	assert.Equal(t, tree.Metadata.MessageAuthenticationCode, loaded.Metadata.MessageAuthenticationCode)
+	assert.Equal(t, tree.Metadata.UnencryptedSuffix, loaded.Metadata.UnencryptedSuffix)
+	assert.Equal(t, tree.Metadata.ShamirThreshold, loaded.Metadata.ShamirThreshold)
+	assert.Equal(t, tree.Metadata.KeyGroups[0][0].EncryptedDataKey(), loaded.Metadata.KeyGroups[0][0].EncryptedDataKey())
+}
+
+func TestLoadEncryptedFileWithoutMetadata(t *testing.T) {
+	_, err := (&Store{}).LoadEncryptedFile([]byte("a = 1\n"))
+	assert.Equal(t, sops.MetadataNotFound, err)
+}
+
+func TestHasSopsTopLevelKey(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile([]byte("a = 1\nsops {\n  mac = \"x\"\n}\n"))
+	assert.Nil(t, err)
+	assert.True(t, (&Store{}).HasSopsTopLevelKey(branches[0]))
+	assert.False(t, (&Store{}).HasSopsTopLevelKey(sops.TreeBranch{{Key: "sops_", Value: "value"}}))
+}
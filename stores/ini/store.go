@@ -31,42 +31,83 @@ func (store Store) encodeTree(branches sops.TreeBranches) ([]byte, error) {
 			if _, ok := item.Key.(sops.Comment); ok {
 				continue
 			}
-			section, err := iniFile.NewSection(item.Key.(string))
-			if err != nil {
-				return nil, fmt.Errorf("Error encoding section %s: %s", item.Key, err)
-			}
 			itemTree, ok := item.Value.(sops.TreeBranch)
 			if !ok {
 				return nil, fmt.Errorf("Error encoding section: Section values should always be TreeBranches")
 			}
+			if err := store.encodeSection(iniFile, item.Key.(string), itemTree); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var buffer bytes.Buffer
+	iniFile.WriteTo(&buffer)
+	return buffer.Bytes(), nil
+}
 
-			first := 0
-			if len(itemTree) > 0 {
-				if sectionComment, ok := itemTree[0].Key.(sops.Comment); ok {
-					section.Comment = sectionComment.Value
-					first = 1
-				}
+// encodeSection adds a section with the keys of a branch to the file. Values
+// which are branches themselves are added as subsections after it, named
+// after the section and their key, separated by a dot. The section itself is
+// left out if it only holds subsections.
+func (store Store) encodeSection(iniFile *ini.File, name string, itemTree sops.TreeBranch) error {
+	var subsections sops.TreeBranch
+	hasKeys := len(itemTree) == 0
+	for _, keyVal := range itemTree {
+		if _, ok := keyVal.Value.(sops.TreeBranch); ok {
+			subsections = append(subsections, keyVal)
+		} else {
+			hasKeys = true
+		}
+	}
+
+	if hasKeys {
+		section, err := iniFile.NewSection(name)
+		if err != nil {
+			return fmt.Errorf("Error encoding section %s: %s", name, err)
+		}
+
+		first := 0
+		if len(itemTree) > 0 {
+			if sectionComment, ok := itemTree[0].Key.(sops.Comment); ok {
+				section.Comment = sectionComment.Value
+				first = 1
 			}
+		}
 
-			var lastItem *ini.Key
-			for i := first; i < len(itemTree); i++ {
-				keyVal := itemTree[i]
-				if comment, ok := keyVal.Key.(sops.Comment); ok {
-					if lastItem != nil {
-						lastItem.Comment = comment.Value
-					}
-				} else {
-					lastItem, err = section.NewKey(keyVal.Key.(string), store.valToString(keyVal.Value))
-					if err != nil {
-						return nil, fmt.Errorf("Error encoding key: %s", err)
-					}
+		var lastItem *ini.Key
+		for i := first; i < len(itemTree); i++ {
+			keyVal := itemTree[i]
+			if comment, ok := keyVal.Key.(sops.Comment); ok {
+				if lastItem != nil {
+					lastItem.Comment = comment.Value
 				}
+				continue
+			}
+			if _, ok := keyVal.Value.(sops.TreeBranch); ok {
+				lastItem = nil
+				continue
+			}
+			value, err := store.valToString(keyVal.Value)
+			if err != nil {
+				return fmt.Errorf("Error encoding key %s: %s", keyVal.Key, err)
+			}
+			lastItem, err = section.NewKey(keyVal.Key.(string), value)
+			if err != nil {
+				return fmt.Errorf("Error encoding key: %s", err)
 			}
 		}
 	}
-	var buffer bytes.Buffer
-	iniFile.WriteTo(&buffer)
-	return buffer.Bytes(), nil
+
+	for _, subsection := range subsections {
+		key, ok := subsection.Key.(string)
+		if !ok {
+			return fmt.Errorf("Error encoding section: Section names should always be strings")
+		}
+		if err := store.encodeSection(iniFile, name+"."+key, subsection.Value.(sops.TreeBranch)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (store Store) stripCommentChar(comment string) string {
@@ -78,16 +119,31 @@ func (store Store) stripCommentChar(comment string) string {
 	return comment
 }
 
-func (store Store) valToString(v interface{}) string {
+// valToString returns the text of a value. Numbers are written with as many
+// digits as needed to read them back exactly, so that their type can be
+// restored from the type of the encrypted value they were decrypted from.
+func (store Store) valToString(v interface{}) (string, error) {
 	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
 	case fmt.Stringer:
-		return v.String()
+		return v.String(), nil
+	case int:
+		return strconv.Itoa(v), nil
 	case float64:
-		return strconv.FormatFloat(v, 'f', 6, 64)
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case sops.Number:
+		return string(v), nil
+	case sops.Timestamp:
+		return string(v), nil
 	case bool:
-		return strconv.FormatBool(v)
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		return "", fmt.Errorf("cannot use a list as a value in an ini file")
 	default:
-		return fmt.Sprintf("%s", v)
+		return "", fmt.Errorf("cannot use value %v of type %T in an ini file", v, v)
 	}
 }
 
@@ -102,16 +158,56 @@ func (store Store) treeBranchesFromIni(in []byte) (sops.TreeBranches, error) {
 	}
 	var branch sops.TreeBranch
 	for _, section := range iniFile.Sections() {
-
+		// The default section is always there, but is only kept if the file
+		// has keys before its first section.
+		if section.Name() == ini.DefaultSection && len(section.Keys()) == 0 && section.Comment == "" {
+			continue
+		}
 		item, err := store.treeItemFromSection(section)
 		if err != nil {
 			return sops.TreeBranches{branch}, err
 		}
-		branch = append(branch, item)
+		if store.config != nil && store.config.NestedSections {
+			branch = insertSection(branch, sectionPath(section.Name()), item.Value.(sops.TreeBranch))
+		} else {
+			branch = append(branch, item)
+		}
 	}
 	return sops.TreeBranches{branch}, nil
 }
 
+// sectionPath splits the name of a section on dots, into the names of the
+// sections it is nested in followed by its own name. It is only used when
+// nested sections are enabled, as the path of a value is authenticated with
+// it, and nesting would change the path of values of files encrypted with
+// flat sections. Names with an empty part, such as "a..b", are not split.
+func sectionPath(name string) []string {
+	path := strings.Split(name, ".")
+	for _, part := range path {
+		if part == "" {
+			return []string{name}
+		}
+	}
+	return path
+}
+
+// insertSection adds the items of a section to the branch, nested at the given
+// path. A section is only nested into the item right before it, creating
+// parent sections which are not in the file, so that the values are kept in
+// the order of the file.
+func insertSection(branch sops.TreeBranch, path []string, items sops.TreeBranch) sops.TreeBranch {
+	if len(path) == 1 {
+		return append(branch, sops.TreeItem{Key: path[0], Value: items})
+	}
+	if last := len(branch) - 1; last >= 0 && branch[last].Key == path[0] {
+		if parent, ok := branch[last].Value.(sops.TreeBranch); ok {
+			branch[last].Value = insertSection(parent, path[1:], items)
+			return branch
+		}
+	}
+	return append(branch, sops.TreeItem{Key: path[0], Value: insertSection(sops.TreeBranch{}, path[1:], items)})
+}
+
 func (store Store) treeItemFromSection(section *ini.Section) (sops.TreeItem, error) {
 	var sectionItem sops.TreeItem
 	sectionItem.Key = section.Name()
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,10 +24,6 @@ file="payroll.dat"
 `
 	expected := sops.TreeBranches{
 		sops.TreeBranch{
-			sops.TreeItem{
-				Key:   "DEFAULT",
-				Value: sops.TreeBranch(nil),
-			},
 			sops.TreeItem{
 				Key: "owner",
 				Value: sops.TreeBranch{
@@ -130,10 +128,6 @@ func TestEncodeIniWithEscaping(t *testing.T) {
 func TestEncodeIniWithDuplicateSections(t *testing.T) {
 	branches := sops.TreeBranches{
 		sops.TreeBranch{
-			sops.TreeItem{
-				Key:   "DEFAULT",
-				Value: interface{}(sops.TreeBranch(nil)),
-			},
 			sops.TreeItem{
 				Key: "foo",
 				Value: sops.TreeBranch{
@@ -182,3 +176,210 @@ func TestUnmarshalMetadataFromNonSOPSFile(t *testing.T) {
 	_, err := store.LoadEncryptedFile(data)
 	assert.Equal(t, sops.MetadataNotFound, err)
 }
+
+func TestDecodeIniNestedSections(t *testing.T) {
+	in := `
+[server]
+host=example.com
+
+[server.tls]
+; certificate settings
+cert=/etc/cert.pem
+[server.tls.ciphers]
+default=ECDHE
+[database.primary]
+port=5432
+[example..com]
+key=value
+`
+	expected := sops.TreeBranch{
+		sops.TreeItem{
+			Key: "server",
+			Value: sops.TreeBranch{
+				sops.TreeItem{Key: "host", Value: "example.com"},
+				sops.TreeItem{
+					Key: "tls",
+					Value: sops.TreeBranch{
+						sops.TreeItem{Key: "cert", Value: "/etc/cert.pem"},
+						sops.TreeItem{Key: sops.Comment{Value: "certificate settings"}},
+						sops.TreeItem{
+							Key: "ciphers",
+							Value: sops.TreeBranch{
+								sops.TreeItem{Key: "default", Value: "ECDHE"},
+							},
+						},
+					},
+				},
+			},
+		},
+		sops.TreeItem{
+			Key: "database",
+			Value: sops.TreeBranch{
+				sops.TreeItem{
+					Key: "primary",
+					Value: sops.TreeBranch{
+						sops.TreeItem{Key: "port", Value: "5432"},
+					},
+				},
+			},
+		},
+		sops.TreeItem{
+			Key: "example..com",
+			Value: sops.TreeBranch{
+				sops.TreeItem{Key: "key", Value: "value"},
+			},
+		},
+	}
+	store := NewStore(&config.INIStoreConfig{NestedSections: true})
+	branches, err := store.treeBranchesFromIni([]byte(in))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, branches[0])
+
+	out, err := store.iniFromTreeBranches(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, `[server]
+host = example.com
+
+[server.tls]
+; certificate settings
+cert = /etc/cert.pem
+
+[server.tls.ciphers]
+default = ECDHE
+
+[database.primary]
+port = 5432
+
+[example..com]
+key = value
+`, string(out))
+}
+
+func TestDecodeIniSectionsKeepOrder(t *testing.T) {
+	in := `
+[a.b]
+x=1
+[c]
+y=2
+[a.d]
+z=3
+`
+	branches, err := NewStore(&config.INIStoreConfig{NestedSections: true}).treeBranchesFromIni([]byte(in))
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{Key: "a", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "b", Value: sops.TreeBranch{sops.TreeItem{Key: "x", Value: "1"}}},
+		}},
+		sops.TreeItem{Key: "c", Value: sops.TreeBranch{sops.TreeItem{Key: "y", Value: "2"}}},
+		sops.TreeItem{Key: "a", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "d", Value: sops.TreeBranch{sops.TreeItem{Key: "z", Value: "3"}}},
+		}},
+	}, branches[0])
+}
+
+func TestDecodeIniDottedSectionsFlatByDefault(t *testing.T) {
+	branches, err := Store{}.treeBranchesFromIni([]byte("[db.prod]\nuser=admin\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{Key: "db.prod", Value: sops.TreeBranch{sops.TreeItem{Key: "user", Value: "admin"}}},
+	}, branches[0])
+}
+
+// TestDecryptIniEncryptedWithFlatSections decrypts a file whose dotted
+// section was encrypted by a version of SOPS which did not nest sections.
+func TestDecryptIniEncryptedWithFlatSections(t *testing.T) {
+	in := `[db.prod]
+user     = ENC[AES256_GCM,data:/Fq43aU=,iv:H01uqwZx5wv78IZcutPeoa7Lo3SvyVnHVQDloPMaaiI=,tag:kmirbEaKDpq03uAZFmqthQ==,type:str]
+password = ENC[AES256_GCM,data:mg3KeOLbOw==,iv:Jp9oQdr7fVTht04d1s/qAhwLY+tkS8HJHvtZ5MAXVDY=,tag:HhycMggRMqufQdOC7UCFvw==,type:str]
+`
+	branches, err := Store{}.treeBranchesFromIni([]byte(in))
+	assert.Nil(t, err)
+	tree := sops.Tree{Branches: branches}
+	mac, err := tree.Decrypt(make([]byte, 32), aes.NewCipher())
+	assert.Nil(t, err)
+	assert.Equal(t, "2DA4C6F5598BE8E2A278E61AFAD2F2E1EE8B4AA3EA1A51D584C4ED8C9D6672A36B18E5DFEC3984C1E6821EA4F5EDC195B8CA0CB8048BDE9ECA78686CBEA12590", mac)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{Key: "db.prod", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "user", Value: "admin"},
+			sops.TreeItem{Key: "password", Value: "hunter2"},
+		}},
+	}, tree.Branches[0])
+}
+
+func TestEncodeIniTypedValues(t *testing.T) {
+	branches := sops.TreeBranches{
+		sops.TreeBranch{
+			sops.TreeItem{
+				Key: "app",
+				Value: sops.TreeBranch{
+					sops.TreeItem{
+						Key: "limits",
+						Value: sops.TreeBranch{
+							sops.TreeItem{Key: "ratio", Value: 0.125},
+						},
+					},
+					sops.TreeItem{Key: "port", Value: 8080},
+					sops.TreeItem{Key: "large", Value: 1e21},
+					sops.TreeItem{Key: "debug", Value: true},
+					sops.TreeItem{Key: "empty", Value: nil},
+				},
+			},
+		},
+	}
+	out, err := Store{}.iniFromTreeBranches(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, `[app]
+port  = 8080
+large = 1000000000000000000000
+debug = true
+empty = 
+
+[app.limits]
+ratio = 0.125
+`, string(out))
+}
+
+func TestEncodeIniErrors(t *testing.T) {
+	_, err := Store{}.iniFromTreeBranches(sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: "section", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "list", Value: []interface{}{1, 2}},
+		}},
+	}})
+	assert.ErrorContains(t, err, "cannot use a list as a value in an ini file")
+
+	_, err = Store{}.iniFromTreeBranches(sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: "key", Value: "value"},
+	}})
+	assert.ErrorContains(t, err, "Section values should always be TreeBranches")
+}
+
+func TestEncryptedValuesKeepTypes(t *testing.T) {
+	newBranch := func() sops.TreeBranch {
+		return sops.TreeBranch{
+			sops.TreeItem{Key: "app", Value: sops.TreeBranch{
+				sops.TreeItem{Key: "port", Value: 8080},
+				sops.TreeItem{Key: "ratio", Value: 0.5},
+				sops.TreeItem{Key: "debug", Value: false},
+				sops.TreeItem{Key: "db", Value: sops.TreeBranch{
+					sops.TreeItem{Key: "password", Value: "secret"},
+				}},
+			}},
+		}
+	}
+	key := make([]byte, 32)
+	tree := sops.Tree{Branches: sops.TreeBranches{newBranch()}}
+	_, err := tree.Encrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+
+	out, err := Store{}.iniFromTreeBranches(tree.Branches)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "type:int]")
+	assert.Contains(t, string(out), "[app.db]")
+
+	branches, err := NewStore(&config.INIStoreConfig{NestedSections: true}).treeBranchesFromIni(out)
+	assert.Nil(t, err)
+	loaded := sops.Tree{Branches: sops.TreeBranches{branches[0]}}
+	_, err = loaded.Decrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+	assert.Equal(t, newBranch(), loaded.Branches[0])
+}
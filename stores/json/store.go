@@ -110,11 +110,25 @@ func (store Store) sliceFromJSONDecoder(dec *json.Decoder) ([]interface{}, error
 			}
 			slice = append(slice, item)
 		} else {
-			slice = append(slice, t)
+			v, err := valueFromToken(t)
+			if err != nil {
+				return slice, err
+			}
+			slice = append(slice, v)
 		}
 	}
 }
 
+// valueFromToken returns the tree value of a JSON token which is not a
+// delimiter. Numbers are float64s, as with encoding/json, unless a float64
+// cannot hold them exactly.
+func valueFromToken(t json.Token) (interface{}, error) {
+	if n, ok := t.(json.Number); ok {
+		return sops.ParseFloat(string(n))
+	}
+	return t, nil
+}
+
 var errEndOfObject = fmt.Errorf("End of object")
 
 func (store Store) treeItemFromJSONDecoder(dec *json.Decoder) (sops.TreeItem, error) {
@@ -150,7 +164,10 @@ func (store Store) treeItemFromJSONDecoder(dec *json.Decoder) (sops.TreeItem, er
 			item.Value = v
 		}
 	} else {
-		item.Value = value
+		item.Value, err = valueFromToken(value)
+		if err != nil {
+			return item, err
+		}
 	}
 	return item, nil
 
@@ -239,6 +256,7 @@ func (store Store) jsonFromTreeBranch(branch sops.TreeBranch) ([]byte, error) {
 
 func (store Store) treeBranchFromJSON(in []byte) (sops.TreeBranch, error) {
 	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
 	value, err := dec.Token()
 	if err != nil {
 		return nil, err
@@ -248,6 +266,9 @@ func (store Store) treeBranchFromJSON(in []byte) (sops.TreeBranch, error) {
 			return nil, fmt.Errorf("Expected JSON object start, got delimiter %s instead", value)
 		}
 	} else {
+		if v, err := valueFromToken(value); err == nil {
+			value = v
+		}
 		return nil, fmt.Errorf("Expected JSON object start, got %#v of type %T instead", value, value)
 	}
 	return store.treeBranchFromJSONDecoder(dec)
@@ -417,6 +417,32 @@ func TestEmitValueString(t *testing.T) {
 	assert.Equal(t, []byte("\"hello\""), bytes)
 }
 
+func TestLargeNumbersRoundTrip(t *testing.T) {
+	in := `{
+  "account": 12345678901234567890,
+  "rate": 0.10000000000000000001,
+  "list": [
+    1.5,
+    -98765432109876543210,
+    1e400
+  ],
+  "none": null
+}
+`
+	store := Store{config: config.JSONStoreConfig{Indent: 2}}
+	branches, err := store.LoadPlainFile([]byte(in))
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{Key: "account", Value: sops.Number("12345678901234567890")},
+		sops.TreeItem{Key: "rate", Value: sops.Number("0.10000000000000000001")},
+		sops.TreeItem{Key: "list", Value: []interface{}{1.5, sops.Number("-98765432109876543210"), sops.Number("1e400")}},
+		sops.TreeItem{Key: "none", Value: nil},
+	}, branches[0])
+	out, err := store.EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, in, string(out))
+}
+
 func TestIndentTwoSpaces(t *testing.T) {
 	tree := sops.Tree{
 		Branches: sops.TreeBranches{
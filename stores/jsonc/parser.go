@@ -0,0 +1,520 @@
+package jsonc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/getsops/sops/v3"
+)
+
+// parser is a parser for JSON documents extended with the syntax accepted by
+// JSONC (JSON with comments, as used by VS Code) and JSON5:
+//
+//   - Line comments starting with "//", and block comments enclosed in "/*"
+//     and "*/".
+//   - Trailing commas in objects and arrays.
+//   - Object keys which are identifiers, or single quoted strings.
+//   - Single quoted strings, the \x, \v and \0 escape sequences, and lines
+//     continued with a backslash.
+//   - Hexadecimal numbers, numbers with a leading '+' or a leading or trailing
+//     decimal point, and Infinity and NaN.
+//
+// Comments are loaded as sops.Comment items preceding the value they are
+// before, or that they are on the line of. As with the JSON store, numbers are
+// loaded as float64 values, or as sops.Number values if a float64 cannot hold
+// them exactly.
+type parser struct {
+	in   []byte
+	pos  int
+	line int
+	// lineStart is the position of the first byte of the current line.
+	lineStart int
+}
+
+// parse parses a JSONC document, of which the root must be an object.
+func parse(in []byte) (sops.TreeBranch, error) {
+	p := &parser{in: in, line: 1}
+	if strings.HasPrefix(string(in), "\uFEFF") {
+		p.pos = len("\uFEFF")
+		p.lineStart = p.pos
+	}
+	branch, err := p.document()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSONC input on line %d, column %d: %w", p.line, p.pos-p.lineStart+1, err)
+	}
+	return branch, nil
+}
+
+func (p *parser) document() (sops.TreeBranch, error) {
+	leading, err := p.skip()
+	if err != nil {
+		return nil, err
+	}
+	if p.eof() || p.peek() != '{' {
+		return nil, fmt.Errorf("expected an object")
+	}
+	branch, err := p.object()
+	if err != nil {
+		return nil, err
+	}
+	trailing, err := p.skip()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("unexpected %q after the root object", p.peek())
+	}
+	for i := len(leading) - 1; i >= 0; i-- {
+		branch = append(sops.TreeBranch{{Key: leading[i], Value: nil}}, branch...)
+	}
+	for _, comment := range trailing {
+		branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+	}
+	return branch, nil
+}
+
+// object parses an object, starting at its opening brace.
+func (p *parser) object() (sops.TreeBranch, error) {
+	p.pos++
+	branch := sops.TreeBranch{}
+	for {
+		comments, err := p.skip()
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated object")
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return branch, nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		comments, err = p.skip()
+		if err != nil {
+			return nil, err
+		}
+		if p.eof() || p.peek() != ':' {
+			return nil, fmt.Errorf("expected ':' after object key %q", key)
+		}
+		p.pos++
+		more, err := p.skip()
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, more...)
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+		before, after, err := p.separator('}')
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range before {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+		branch = append(branch, sops.TreeItem{Key: key, Value: value})
+		for _, comment := range after {
+			branch = append(branch, sops.TreeItem{Key: comment, Value: nil})
+		}
+	}
+}
+
+// array parses an array, starting at its opening bracket.
+func (p *parser) array() ([]interface{}, error) {
+	p.pos++
+	array := []interface{}{}
+	for {
+		comments, err := p.skip()
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			array = append(array, comment)
+		}
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return array, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		before, after, err := p.separator(']')
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range before {
+			array = append(array, comment)
+		}
+		array = append(array, value)
+		for _, comment := range after {
+			array = append(array, comment)
+		}
+	}
+}
+
+// separator parses what follows a value in an object or an array: a comma, or
+// the closing delimiter, which is left to be consumed by the caller. It returns
+// the comments on the line of the value, which are loaded before it, and the
+// comments on the following lines, which are loaded after it.
+func (p *parser) separator(closing byte) (before []sops.Comment, after []sops.Comment, err error) {
+	before, err = p.sameLineComments()
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err = p.skip()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.eof() {
+		return nil, nil, fmt.Errorf("expected ',' or %q", closing)
+	}
+	if p.peek() == ',' {
+		p.pos++
+		more, err := p.sameLineComments()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(after) == 0 {
+			return append(before, more...), nil, nil
+		}
+		return before, append(after, more...), nil
+	}
+	if p.peek() != closing {
+		return nil, nil, fmt.Errorf("expected ',' or %q, got %q", closing, p.peek())
+	}
+	return before, after, nil
+}
+
+// sameLineComments parses the comments which are on the current line.
+func (p *parser) sameLineComments() ([]sops.Comment, error) {
+	var comments []sops.Comment
+	for {
+		for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+			p.pos++
+		}
+		if p.eof() || p.peek() != '/' {
+			return comments, nil
+		}
+		comment, err := p.comment()
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+}
+
+// skip skips white space and comments, and returns the comments.
+func (p *parser) skip() ([]sops.Comment, error) {
+	var comments []sops.Comment
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == '\n':
+			p.newline()
+		case c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f':
+			p.pos++
+		case c == '/':
+			comment, err := p.comment()
+			if err != nil {
+				return nil, err
+			}
+			comments = append(comments, comment)
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRune(p.in[p.pos:])
+			if !unicode.IsSpace(r) && r != '\uFEFF' {
+				return comments, nil
+			}
+			p.pos += size
+		default:
+			return comments, nil
+		}
+	}
+	return comments, nil
+}
+
+// comment parses a comment starting at the current position, and returns its
+// content without the comment delimiters.
+func (p *parser) comment() (sops.Comment, error) {
+	if p.pos+1 >= len(p.in) {
+		return sops.Comment{}, fmt.Errorf("unexpected '/'")
+	}
+	switch p.in[p.pos+1] {
+	case '/':
+		start := p.pos + 2
+		end := start
+		for end < len(p.in) && p.in[end] != '\n' {
+			end++
+		}
+		p.pos = end
+		return sops.Comment{Value: strings.TrimSuffix(string(p.in[start:end]), "\r")}, nil
+	case '*':
+		start := p.pos + 2
+		end := strings.Index(string(p.in[start:]), "*/")
+		if end == -1 {
+			return sops.Comment{}, fmt.Errorf("unterminated block comment")
+		}
+		value := string(p.in[start : start+end])
+		for i := p.pos; i < start+end; i++ {
+			if p.in[i] == '\n' {
+				p.line++
+				p.lineStart = i + 1
+			}
+		}
+		p.pos = start + end + 2
+		return sops.Comment{Value: strings.ReplaceAll(value, "\r\n", "\n")}, nil
+	default:
+		return sops.Comment{}, fmt.Errorf("unexpected '/'")
+	}
+}
+
+// key parses an object key, which is either a string or an identifier.
+func (p *parser) key() (string, error) {
+	if c := p.peek(); c == '"' || c == '\'' {
+		return p.string()
+	}
+	start := p.pos
+	for !p.eof() {
+		r, size := utf8.DecodeRune(p.in[p.pos:])
+		if !isIdentifierRune(r, p.pos == start) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return "", fmt.Errorf("expected an object key, got %q", p.peek())
+	}
+	return string(p.in[start:p.pos]), nil
+}
+
+// value parses any value.
+func (p *parser) value() (interface{}, error) {
+	if p.eof() {
+		return nil, fmt.Errorf("expected a value")
+	}
+	switch c := p.peek(); {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+	word := p.word()
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "NaN":
+		return math.NaN(), nil
+	case "":
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return nil, fmt.Errorf("unexpected %q", word)
+}
+
+// word parses an identifier.
+func (p *parser) word() string {
+	start := p.pos
+	for !p.eof() && isIdentifierRune(rune(p.peek()), p.pos == start) {
+		p.pos++
+	}
+	return string(p.in[start:p.pos])
+}
+
+// number parses a number, in any of the forms accepted by JSON5.
+func (p *parser) number() (interface{}, error) {
+	sign := 1.0
+	if c := p.peek(); c == '-' || c == '+' {
+		if c == '-' {
+			sign = -1
+		}
+		p.pos++
+	}
+	if !p.eof() && p.peek() == 'I' {
+		if word := p.word(); word != "Infinity" {
+			return 0, fmt.Errorf("invalid number %q", word)
+		}
+		return math.Inf(int(sign)), nil
+	}
+	if !p.eof() && p.peek() == 'N' {
+		if word := p.word(); word != "NaN" {
+			return 0, fmt.Errorf("invalid number %q", word)
+		}
+		return math.NaN(), nil
+	}
+	start := p.pos
+	for !p.eof() && isNumberByte(p.peek()) {
+		p.pos++
+	}
+	literal := string(p.in[start:p.pos])
+	if strings.HasPrefix(literal, "0x") || strings.HasPrefix(literal, "0X") {
+		n, err := strconv.ParseUint(literal[2:], 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", literal)
+		}
+		return sign * float64(n), nil
+	}
+	if literal == "" || strings.ContainsAny(literal, "xX_") || strings.HasPrefix(literal, "+") || strings.HasPrefix(literal, "-") {
+		return 0, fmt.Errorf("invalid number %q", literal)
+	}
+	if sign < 0 {
+		literal = "-" + literal
+	}
+	n, err := sops.ParseFloat(literal)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", literal)
+	}
+	return n, nil
+}
+
+// string parses a single or double quoted string.
+func (p *parser) string() (string, error) {
+	quote := p.peek()
+	p.pos++
+	var value strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == quote:
+			p.pos++
+			return value.String(), nil
+		case c == '\n':
+			return "", fmt.Errorf("unterminated string")
+		case c == '\\':
+			if err := p.escape(&value); err != nil {
+				return "", err
+			}
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// escape parses an escape sequence in a string, writing the character it
+// represents to value.
+func (p *parser) escape(value *strings.Builder) error {
+	p.pos++
+	if p.eof() {
+		return fmt.Errorf("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		value.WriteByte('\b')
+	case 'f':
+		value.WriteByte('\f')
+	case 'n':
+		value.WriteByte('\n')
+	case 'r':
+		value.WriteByte('\r')
+	case 't':
+		value.WriteByte('\t')
+	case 'v':
+		value.WriteByte('\v')
+	case '0':
+		value.WriteByte(0)
+	case 'x':
+		n, err := p.hex(2)
+		if err != nil {
+			return err
+		}
+		value.WriteRune(rune(n))
+	case 'u':
+		n, err := p.hex(4)
+		if err != nil {
+			return err
+		}
+		r := rune(n)
+		if utf16.IsSurrogate(r) && strings.HasPrefix(string(p.in[p.pos:]), "\\u") {
+			p.pos += 2
+			low, err := p.hex(4)
+			if err != nil {
+				return err
+			}
+			r = utf16.DecodeRune(r, rune(low))
+		}
+		value.WriteRune(r)
+	case '\r':
+		// Line continuation
+		if !p.eof() && p.peek() == '\n' {
+			p.pos++
+		}
+		p.line++
+		p.lineStart = p.pos
+	case '\n':
+		// Line continuation
+		p.line++
+		p.lineStart = p.pos
+	default:
+		value.WriteByte(c)
+	}
+	return nil
+}
+
+// hex parses a hexadecimal number of n digits.
+func (p *parser) hex(n int) (uint64, error) {
+	if p.pos+n > len(p.in) {
+		return 0, fmt.Errorf("invalid escape sequence")
+	}
+	v, err := strconv.ParseUint(string(p.in[p.pos:p.pos+n]), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid escape sequence %q", p.in[p.pos:p.pos+n])
+	}
+	p.pos += n
+	return v, nil
+}
+
+func (p *parser) newline() {
+	p.pos++
+	p.line++
+	p.lineStart = p.pos
+}
+
+func (p *parser) peek() byte {
+	return p.in[p.pos]
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.in)
+}
+
+func isIdentifierRune(r rune, first bool) bool {
+	if r == '$' || r == '_' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && (unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r) || unicode.Is(unicode.Pc, r))
+}
+
+func isNumberByte(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') || c == '.' || c == 'x' || c == 'X' || c == '+' || c == '-'
+}
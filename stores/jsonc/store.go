@@ -0,0 +1,255 @@
+// Package jsonc implements a store for JSON documents with comments, such as
+// the settings.json files of VS Code, tsconfig.json files or devcontainer.json
+// files. It also reads the syntax of JSON5 documents.
+//
+// Comments are loaded as sops.Comment items, the way the YAML store does, and
+// are written back as line comments, or as block comments when they span
+// several lines. Other JSON5 syntax, such as unquoted keys, single quoted
+// strings and trailing commas, is normalized to plain JSON when writing files.
+package jsonc //import "github.com/getsops/sops/v3/stores/jsonc"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+)
+
+// Store handles storage of JSONC data.
+type Store struct {
+	config config.JSONCStoreConfig
+}
+
+func NewStore(c *config.JSONCStoreConfig) *Store {
+	return &Store{config: *c}
+}
+
+// LoadEncryptedFile loads an encrypted JSONC file onto a sops.Tree object
+func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
+	branch, err := parse(in)
+	if err != nil {
+		return sops.Tree{}, fmt.Errorf("Could not unmarshal input data: %s", err)
+	}
+	var metadataItem *sops.TreeItem
+	for i, item := range branch {
+		if item.Key == stores.SopsMetadataKey {
+			metadataItem = &item
+			branch = append(branch[:i], branch[i+1:]...)
+			break
+		}
+	}
+	if metadataItem == nil {
+		return sops.Tree{}, sops.MetadataNotFound
+	}
+	// The metadata is converted to plain JSON, and loaded the same way the
+	// JSON store loads it.
+	metadataJSON, err := (&sopsjson.Store{}).EmitValue(metadataItem.Value)
+	if err != nil {
+		return sops.Tree{}, fmt.Errorf("Error unmarshalling input jsonc: %s", err)
+	}
+	var metadata stores.Metadata
+	if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+		return sops.Tree{}, fmt.Errorf("Error unmarshalling input jsonc: %s", err)
+	}
+	internalMetadata, err := metadata.ToInternal()
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	return sops.Tree{
+		Branches: sops.TreeBranches{
+			branch,
+		},
+		Metadata: internalMetadata,
+	}, nil
+}
+
+// LoadPlainFile loads plaintext JSONC file bytes onto a sops.TreeBranches object
+func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	branch, err := parse(in)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal input data: %s", err)
+	}
+	return sops.TreeBranches{
+		branch,
+	}, nil
+}
+
+// EmitEncryptedFile returns the encrypted bytes of the JSONC file corresponding
+// to a sops.Tree runtime object
+func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
+	branch := append(sops.TreeBranch(nil), in.Branches[0]...)
+	branch = append(branch, sops.TreeItem{Key: stores.SopsMetadataKey, Value: stores.MetadataFromInternal(in.Metadata)})
+	out, err := store.encode(branch)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling to jsonc: %s", err)
+	}
+	return append(out, '\n'), nil
+}
+
+// EmitPlainFile returns the plaintext bytes of the JSONC file corresponding to
+// a sops.TreeBranches runtime object
+func (store *Store) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
+	out, err := store.encode(in[0])
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling to jsonc: %s", err)
+	}
+	return append(out, '\n'), nil
+}
+
+// EmitValue returns bytes corresponding to a single encoded value
+// in a generic interface{} object
+func (store *Store) EmitValue(v interface{}) ([]byte, error) {
+	return store.encode(v)
+}
+
+// EmitExample returns the bytes corresponding to an example complex tree
+func (store *Store) EmitExample() []byte {
+	bytes, err := store.EmitPlainFile(stores.ExampleComplexTree.Branches)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+// HasSopsTopLevelKey checks whether a top-level "sops" key exists.
+func (store *Store) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
+	return stores.HasSopsTopLevelKey(branch)
+}
+
+func (store *Store) encode(v interface{}) ([]byte, error) {
+	indent := "\t"
+	if store.config.Indent > -1 {
+		indent = strings.Repeat(" ", store.config.Indent)
+	} else if store.config.Indent < -1 {
+		return nil, errors.New("JSONC Indentation parameter smaller than -1 is not accepted")
+	}
+	e := &encoder{indent: indent}
+	if err := e.encodeValue(v, ""); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// encoder writes indented JSON, with comments.
+type encoder struct {
+	buf    bytes.Buffer
+	indent string
+}
+
+// entry is an item of an object or an array, or a comment, written on its own
+// line.
+type entry struct {
+	comment *sops.Comment
+	key     string
+	value   interface{}
+}
+
+func (e *encoder) encodeValue(v interface{}, prefix string) error {
+	switch v := v.(type) {
+	case sops.TreeBranch:
+		var entries []entry
+		for _, item := range v {
+			if comment, ok := item.Key.(sops.Comment); ok {
+				entries = append(entries, entry{comment: &comment})
+				continue
+			}
+			key, ok := item.Key.(string)
+			if !ok {
+				return fmt.Errorf("cannot use non-string key %v in jsonc file", item.Key)
+			}
+			entries = append(entries, entry{key: key, value: item.Value})
+		}
+		return e.encodeEntries(entries, prefix, true)
+	case []interface{}:
+		var entries []entry
+		for _, item := range v {
+			if comment, ok := item.(sops.Comment); ok {
+				entries = append(entries, entry{comment: &comment})
+				continue
+			}
+			entries = append(entries, entry{value: item})
+		}
+		return e.encodeEntries(entries, prefix, false)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			e.buf.WriteString("NaN")
+			return nil
+		case math.IsInf(v, 1):
+			e.buf.WriteString("Infinity")
+			return nil
+		case math.IsInf(v, -1):
+			e.buf.WriteString("-Infinity")
+			return nil
+		}
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Indent(&e.buf, out, prefix, e.indent)
+}
+
+// encodeEntries writes the entries of an object or an array, each on its own
+// line.
+func (e *encoder) encodeEntries(entries []entry, prefix string, object bool) error {
+	open, close := "[", "]"
+	if object {
+		open, close = "{", "}"
+	}
+	if len(entries) == 0 {
+		e.buf.WriteString(open + close)
+		return nil
+	}
+	last := -1
+	for i, entry := range entries {
+		if entry.comment == nil {
+			last = i
+		}
+	}
+	e.buf.WriteString(open)
+	inner := prefix + e.indent
+	for i, entry := range entries {
+		e.buf.WriteString("\n" + inner)
+		if entry.comment != nil {
+			e.encodeComment(entry.comment.Value, inner)
+			continue
+		}
+		if object {
+			key, err := json.Marshal(entry.key)
+			if err != nil {
+				return err
+			}
+			e.buf.Write(key)
+			e.buf.WriteString(": ")
+		}
+		if err := e.encodeValue(entry.value, inner); err != nil {
+			return err
+		}
+		if i != last {
+			e.buf.WriteString(",")
+		}
+	}
+	e.buf.WriteString("\n" + prefix + close)
+	return nil
+}
+
+func (e *encoder) encodeComment(comment string, prefix string) {
+	if strings.Contains(comment, "\n") && !strings.Contains(comment, "*/") {
+		e.buf.WriteString("/*" + comment + "*/")
+		return
+	}
+	for i, line := range strings.Split(comment, "\n") {
+		if i > 0 {
+			e.buf.WriteString("\n" + prefix)
+		}
+		e.buf.WriteString("//" + line)
+	}
+}
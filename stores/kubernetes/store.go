@@ -0,0 +1,227 @@
+// Package kubernetes implements a store for Kubernetes manifests, on top of
+// the YAML store.
+//
+// The values of the data field of Secret documents are base64 encoded, so
+// encrypting them as is gives values which cannot be read once decrypted
+// without decoding them again. This store decodes them when loading plaintext
+// files, so that the decoded values are encrypted, and encodes them again when
+// emitting plaintext files, so that decrypted files can be applied with
+// kubectl. Null data values are kept as is. The values of the stringData field
+// are not encoded, so they are left as is. Documents of other kinds are
+// handled as plain YAML documents.
+//
+// Manifests are not detected from their content: the data values of Secrets
+// encrypted as plain YAML files are encrypted encoded, and would be encoded
+// twice by this store when decrypted. It is only used when chosen explicitly.
+package kubernetes //import "github.com/getsops/sops/v3/stores/kubernetes"
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores/yaml"
+)
+
+// DefaultEncryptedRegex is the encrypted regex used for Kubernetes manifests
+// when no other encryption rule is specified. Only the data and stringData
+// fields are encrypted, and the apiVersion, kind, metadata and type fields
+// are left unencrypted.
+const DefaultEncryptedRegex = "^(data|stringData)$"
+
+const (
+	kindKey    = "kind"
+	dataKey    = "data"
+	secretKind = "Secret"
+)
+
+// Store handles storage of Kubernetes manifests
+type Store struct {
+	*yaml.Store
+}
+
+func NewStore(c *config.YAMLStoreConfig) *Store {
+	return &Store{Store: yaml.NewStore(c)}
+}
+
+// LoadPlainFile loads the contents of a plaintext Kubernetes manifest onto a
+// sops.TreeBranches runtime object, decoding the data values of Secrets
+func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	branches, err := store.Store.LoadPlainFile(in)
+	if err != nil {
+		return nil, err
+	}
+	for i, branch := range branches {
+		branches[i], err = mapSecretData(branch, decode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return branches, nil
+}
+
+// EmitPlainFile returns the plaintext bytes of the Kubernetes manifest
+// corresponding to a sops.TreeBranches runtime object, encoding the data
+// values of Secrets
+func (store *Store) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
+	branches := make(sops.TreeBranches, len(in))
+	for i, branch := range in {
+		var err error
+		branches[i], err = mapSecretData(branch, encode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store.Store.EmitPlainFile(branches)
+}
+
+// EmitExample returns the bytes corresponding to an example Secret
+func (store *Store) EmitExample() []byte {
+	bytes, err := store.EmitPlainFile(sops.TreeBranches{exampleSecret})
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+var exampleSecret = sops.TreeBranch{
+	sops.TreeItem{Key: "apiVersion", Value: "v1"},
+	sops.TreeItem{Key: kindKey, Value: secretKind},
+	sops.TreeItem{Key: "metadata", Value: sops.TreeBranch{
+		sops.TreeItem{Key: "name", Value: "example"},
+	}},
+	sops.TreeItem{Key: "type", Value: "Opaque"},
+	sops.TreeItem{Key: dataKey, Value: sops.TreeBranch{
+		sops.TreeItem{Key: "username", Value: "admin"},
+		sops.TreeItem{Key: "password", Value: "Welcome to SOPS!"},
+	}},
+}
+
+// isSecret returns whether the document is a Secret.
+func isSecret(branch sops.TreeBranch) bool {
+	for _, item := range branch {
+		if item.Key == kindKey {
+			kind, ok := unanchored(item.Value).(string)
+			return ok && kind == secretKind
+		}
+	}
+	return false
+}
+
+// mapSecretData returns a copy of the document in which the values of the
+// data field are replaced with the result of f, if the document is a Secret.
+// Other documents are returned as is.
+func mapSecretData(branch sops.TreeBranch, f func(interface{}) (interface{}, error)) (sops.TreeBranch, error) {
+	if !isSecret(branch) {
+		return branch, nil
+	}
+	out := make(sops.TreeBranch, len(branch))
+	for i, item := range branch {
+		out[i] = item
+		if item.Key != dataKey {
+			continue
+		}
+		value, err := mapValue(item.Value, func(data interface{}) (interface{}, error) {
+			if data == nil {
+				return nil, nil
+			}
+			dataBranch, ok := data.(sops.TreeBranch)
+			if !ok {
+				return nil, fmt.Errorf("the data field of a Secret must be a mapping")
+			}
+			outData := make(sops.TreeBranch, len(dataBranch))
+			for j, dataItem := range dataBranch {
+				outData[j] = dataItem
+				if _, ok := dataItem.Key.(sops.Comment); ok {
+					continue
+				}
+				value, err := mapValue(dataItem.Value, func(v interface{}) (interface{}, error) {
+					v, err := f(v)
+					if err != nil {
+						return nil, fmt.Errorf("the value of key %q in the data field of a Secret %s", dataItem.Key, err)
+					}
+					return v, nil
+				})
+				if err != nil {
+					return nil, err
+				}
+				outData[j].Value = value
+			}
+			return outData, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		out[i].Value = value
+	}
+	return out, nil
+}
+
+// mapValue applies f to the value, or to the value an anchor or a style is
+// set on. Aliases are returned as is, as the value they refer to is mapped
+// where the anchor is set.
+func mapValue(v interface{}, f func(interface{}) (interface{}, error)) (interface{}, error) {
+	switch v := v.(type) {
+	case sops.Anchored:
+		value, err := mapValue(v.Value, f)
+		if err != nil {
+			return nil, err
+		}
+		return sops.Anchored{Anchor: v.Anchor, Value: value}, nil
+	case sops.Styled:
+		value, err := mapValue(v.Value, f)
+		if err != nil {
+			return nil, err
+		}
+		return sops.Styled{Style: v.Style, Value: value}, nil
+	case sops.Alias:
+		return v, nil
+	}
+	return f(v)
+}
+
+func unanchored(v interface{}) interface{} {
+	switch v := v.(type) {
+	case sops.Anchored:
+		return unanchored(v.Value)
+	case sops.Styled:
+		return unanchored(v.Value)
+	}
+	return v
+}
+
+// decode returns the value of base64 encoded data. Data which is not valid
+// UTF-8, such as keystores, is returned as []byte, so that it is encrypted as
+// bytes rather than as a string, which JSON and YAML could not represent.
+func decode(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("must be a string")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("is not valid base64: %s", err)
+	}
+	if !utf8.Valid(decoded) {
+		return decoded, nil
+	}
+	return string(decoded), nil
+}
+
+func encode(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v)), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("must be a string")
+	}
+}
@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var PLAIN = []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+type: Opaque
+data:
+  # The database user
+  username: YWRtaW4=
+  password: cGFzc3dvcmQ=
+stringData:
+  token: not encoded
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  username: YWRtaW4=
+`)
+
+var BRANCHES = sops.TreeBranches{
+	sops.TreeBranch{
+		sops.TreeItem{Key: "apiVersion", Value: "v1"},
+		sops.TreeItem{Key: "kind", Value: "Secret"},
+		sops.TreeItem{Key: "metadata", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "name", Value: "credentials"},
+		}},
+		sops.TreeItem{Key: "type", Value: "Opaque"},
+		sops.TreeItem{Key: "data", Value: sops.TreeBranch{
+			sops.TreeItem{Key: sops.Comment{Value: " The database user"}},
+			sops.TreeItem{Key: "username", Value: "admin"},
+			sops.TreeItem{Key: "password", Value: "password"},
+		}},
+		sops.TreeItem{Key: "stringData", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "token", Value: "not encoded"},
+		}},
+	},
+	sops.TreeBranch{
+		sops.TreeItem{Key: "apiVersion", Value: "v1"},
+		sops.TreeItem{Key: "kind", Value: "ConfigMap"},
+		sops.TreeItem{Key: "data", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "username", Value: "YWRtaW4="},
+		}},
+	},
+}
+
+// expandAliases returns the branches without the anchors and styles of their
+// values, which are kept for the YAML store.
+func expandAliases(t *testing.T, branches sops.TreeBranches) sops.TreeBranches {
+	var expanded sops.TreeBranches
+	for _, branch := range branches {
+		branch, err := branch.ExpandAliases()
+		assert.Nil(t, err)
+		expanded = append(expanded, branch)
+	}
+	return expanded
+}
+
+func TestLoadPlainFile(t *testing.T) {
+	branches, err := NewStore(&config.YAMLStoreConfig{Indent: 2}).LoadPlainFile(PLAIN)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCHES, expandAliases(t, branches))
+}
+
+func TestEmitPlainFile(t *testing.T) {
+	bytes, err := NewStore(&config.YAMLStoreConfig{Indent: 2}).EmitPlainFile(BRANCHES)
+	assert.Nil(t, err)
+	assert.Equal(t, string(PLAIN), string(bytes))
+	// The branches are not modified
+	assert.Equal(t, "admin", BRANCHES[0][4].Value.(sops.TreeBranch)[1].Value)
+}
+
+func TestBinaryData(t *testing.T) {
+	store := NewStore(&config.YAMLStoreConfig{Indent: 2})
+	in := []byte("kind: Secret\ndata:\n  key: AP+AYQ==\n")
+	branches, err := store.LoadPlainFile(in)
+	assert.Nil(t, err)
+	// Data which is not valid UTF-8 is kept as bytes
+	assert.Equal(t, []byte{0x00, 0xff, 0x80, 'a'}, expandAliases(t, branches)[0][1].Value.(sops.TreeBranch)[0].Value)
+
+	bytes, err := store.EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(in), string(bytes))
+
+	// Files encrypted before were decrypted as strings
+	bytes, err = store.EmitPlainFile(sops.TreeBranches{sops.TreeBranch{
+		sops.TreeItem{Key: "kind", Value: "Secret"},
+		sops.TreeItem{Key: "data", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "key", Value: "\x00\xff\x80a"},
+		}},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, string(in), string(bytes))
+}
+
+func TestAnchoredData(t *testing.T) {
+	store := NewStore(&config.YAMLStoreConfig{Indent: 2})
+	in := []byte("kind: Secret\ndata: &data\n  key: &key dmFsdWU=\n  other: *key\n")
+	branches, err := store.LoadPlainFile(in)
+	assert.Nil(t, err)
+	// The data is styled with the indentation of the file.
+	anchored := branches[0][1].Value.(sops.Anchored)
+	assert.Equal(t, "data", anchored.Anchor)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{Key: "key", Value: sops.Anchored{Anchor: "key", Value: "value"}},
+		sops.TreeItem{Key: "other", Value: sops.Alias{Anchor: "key"}},
+	}, anchored.Value.(sops.Styled).Value)
+
+	bytes, err := store.EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(in), string(bytes))
+}
+
+func TestNullData(t *testing.T) {
+	in := []byte(`kind: Secret
+data:
+  empty: null
+  username: YWRtaW4=
+stringData:
+  token: null
+`)
+	store := NewStore(&config.YAMLStoreConfig{Indent: 2})
+	branches, err := store.LoadPlainFile(in)
+	assert.Nil(t, err)
+	data := expandAliases(t, branches)[0][1].Value.(sops.TreeBranch)
+	assert.Equal(t, sops.TreeItem{Key: "empty", Value: nil}, data[0])
+	assert.Equal(t, sops.TreeItem{Key: "username", Value: "admin"}, data[1])
+
+	bytes, err := store.EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(in), string(bytes))
+}
+
+func TestLoadPlainFileErrors(t *testing.T) {
+	store := NewStore(&config.YAMLStoreConfig{Indent: 2})
+	_, err := store.LoadPlainFile([]byte("kind: Secret\ndata:\n  key: not base64\n"))
+	assert.ErrorContains(t, err, `the value of key "key" in the data field of a Secret is not valid base64`)
+
+	_, err = store.LoadPlainFile([]byte("kind: Secret\ndata:\n  key: 1\n"))
+	assert.ErrorContains(t, err, `the value of key "key" in the data field of a Secret must be a string`)
+
+	_, err = store.LoadPlainFile([]byte("kind: Secret\ndata: [a]\n"))
+	assert.ErrorContains(t, err, "the data field of a Secret must be a mapping")
+}
+
+func TestEmitExample(t *testing.T) {
+	store := NewStore(&config.YAMLStoreConfig{Indent: 2})
+	branches, err := store.LoadPlainFile(store.EmitExample())
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranches{exampleSecret}, expandAliases(t, branches))
+}
@@ -0,0 +1,239 @@
+package properties
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/getsops/sops/v3"
+)
+
+// parser reads .properties files with the syntax of java.util.Properties:
+//
+//   - Lines are terminated by "\n", "\r" or "\r\n". A line ending with an odd
+//     number of backslashes continues on the next line, of which the leading
+//     white space is skipped.
+//   - Lines of which the first non-white space character is '#' or '!' are
+//     comments. Lines containing only white space are blank lines.
+//   - Other lines are "key=value", "key:value" or "key value" pairs. The key
+//     ends at the first unescaped '=', ':' or white space character, and white
+//     space around the separator is skipped.
+//   - In keys and values, the escape sequences \t, \n, \r, \f and \uXXXX are
+//     replaced, and a backslash followed by another character is replaced by
+//     that character.
+//
+// Comments are loaded as sops.Comment items, without their leading '#' or '!',
+// and blank lines as empty comments. Input which is not valid UTF-8 is read as
+// ISO-8859-1, the encoding used by Properties.load.
+type parser struct {
+	lines []string
+	pos   int
+}
+
+// parse parses .properties data into a sops.TreeBranch.
+func parse(in []byte) (sops.TreeBranch, error) {
+	text := string(in)
+	if !utf8.Valid(in) {
+		runes := make([]rune, len(in))
+		for i, b := range in {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	p := &parser{}
+	if text != "" {
+		p.lines = strings.Split(text, "\n")
+	}
+
+	branch := sops.TreeBranch{}
+	for p.pos < len(p.lines) {
+		lineNumber := p.pos + 1
+		line := strings.TrimLeft(p.lines[p.pos], " \t\f")
+		switch {
+		case line == "":
+			p.pos++
+			branch = append(branch, sops.TreeItem{Key: sops.Comment{Value: ""}, Value: nil})
+		case line[0] == '#' || line[0] == '!':
+			p.pos++
+			branch = append(branch, sops.TreeItem{Key: sops.Comment{Value: line[1:]}, Value: nil})
+		default:
+			key, value, err := splitPair(p.logicalLine())
+			if err != nil {
+				return nil, fmt.Errorf("invalid properties input on line %d: %w", lineNumber, err)
+			}
+			branch = append(branch, sops.TreeItem{Key: key, Value: value})
+		}
+	}
+	return branch, nil
+}
+
+// logicalLine returns the line at the current position, joined with the lines
+// it continues on, without the backslashes ending the lines and the leading
+// white space of the continuation lines.
+func (p *parser) logicalLine() string {
+	var b strings.Builder
+	line := strings.TrimLeft(p.lines[p.pos], " \t\f")
+	p.pos++
+	for {
+		if !continues(line) || p.pos >= len(p.lines) {
+			b.WriteString(line)
+			return b.String()
+		}
+		b.WriteString(line[:len(line)-1])
+		line = strings.TrimLeft(p.lines[p.pos], " \t\f")
+		p.pos++
+	}
+}
+
+// continues returns whether the line ends with an odd number of backslashes.
+func continues(line string) bool {
+	count := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		count++
+	}
+	return count%2 == 1
+}
+
+// splitPair splits a logical line into its unescaped key and value.
+func splitPair(line string) (string, string, error) {
+	end := 0
+	for end < len(line) {
+		c := line[end]
+		if c == '\\' {
+			end += 2
+			continue
+		}
+		if c == '=' || c == ':' || isBlank(c) {
+			break
+		}
+		end++
+	}
+	if end > len(line) {
+		end = len(line)
+	}
+	rawKey := line[:end]
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	key, err := unescape(rawKey)
+	if err != nil {
+		return "", "", err
+	}
+	value, err := unescape(rest)
+	if err != nil {
+		return "", "", fmt.Errorf("value of key %q: %w", key, err)
+	}
+	return key, value, nil
+}
+
+// unescape replaces the escape sequences of a key or value.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			break
+		}
+		switch c = s[i]; c {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\uXXXX escape sequence")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\uXXXX escape sequence %q", s[i-1:i+5])
+			}
+			i += 4
+			// Characters outside of the Basic Multilingual Plane are written
+			// as UTF-16 surrogate pairs.
+			if utf16.IsSurrogate(rune(r)) && i+7 <= len(s) && strings.HasPrefix(s[i+1:], "\\u") {
+				if low, err := strconv.ParseUint(s[i+3:i+7], 16, 16); err == nil {
+					if decoded := utf16.DecodeRune(rune(r), rune(low)); decoded != utf8.RuneError {
+						b.WriteRune(decoded)
+						i += 6
+						continue
+					}
+				}
+			}
+			b.WriteRune(rune(r))
+		default:
+			// The backslash is dropped before other characters.
+			if c < utf8.RuneSelf {
+				b.WriteByte(c)
+				continue
+			}
+			r, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteRune(r)
+			i += size - 1
+		}
+	}
+	return b.String(), nil
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\f'
+}
+
+// escape returns the key or value as it is written in a .properties file, the
+// way Properties.store writes it: backslashes and control characters are
+// escaped, characters outside of printable ASCII are written as \uXXXX escape
+// sequences, and leading spaces are escaped. In keys, white space and the
+// characters '=', ':', '#' and '!' are also escaped.
+func escape(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(' ')
+		case '=', ':', '#', '!':
+			if isKey {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			if r < 0x20 || r > 0x7e {
+				for _, u := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&b, "\\u%04X", u)
+				}
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
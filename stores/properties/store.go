@@ -0,0 +1,186 @@
+package properties //import "github.com/getsops/sops/v3/stores/properties"
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores"
+)
+
+// SopsPrefix is the prefix for all metadatada entry keys
+const SopsPrefix = stores.SopsMetadataKey + "_"
+
+// Store handles storage of Java .properties data
+type Store struct {
+	config config.PropertiesStoreConfig
+}
+
+func NewStore(c *config.PropertiesStoreConfig) *Store {
+	return &Store{config: *c}
+}
+
+// LoadEncryptedFile loads an encrypted file's bytes onto a sops.Tree runtime object
+func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
+	branches, err := store.LoadPlainFile(in)
+	if err != nil {
+		return sops.Tree{}, err
+	}
+
+	var resultBranch sops.TreeBranch
+	mdMap := make(map[string]interface{})
+	for _, item := range branches[0] {
+		switch key := item.Key.(type) {
+		case string:
+			if strings.HasPrefix(key, SopsPrefix) {
+				key = key[len(SopsPrefix):]
+				mdMap[key] = item.Value
+			} else {
+				resultBranch = append(resultBranch, item)
+			}
+		case sops.Comment:
+			resultBranch = append(resultBranch, item)
+		default:
+			panic(fmt.Sprintf("Unexpected type: %T (value %#v)", key, key))
+		}
+	}
+	if len(mdMap) == 0 {
+		return sops.Tree{}, sops.MetadataNotFound
+	}
+
+	err = stores.DecodeNonStrings(mdMap)
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	metadata, err := stores.UnflattenMetadata(mdMap)
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	internalMetadata, err := metadata.ToInternal()
+	if err != nil {
+		return sops.Tree{}, err
+	}
+
+	return sops.Tree{
+		Branches: sops.TreeBranches{
+			resultBranch,
+		},
+		Metadata: internalMetadata,
+	}, nil
+}
+
+// LoadPlainFile returns the contents of a plaintext file loaded onto a
+// sops runtime object
+func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	branch, err := parse(in)
+	if err != nil {
+		return nil, err
+	}
+	return sops.TreeBranches{branch}, nil
+}
+
+// EmitEncryptedFile returns the encrypted file's bytes corresponding to a sops
+// runtime object
+func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
+	metadata := stores.MetadataFromInternal(in.Metadata)
+	mdItems, err := stores.FlattenMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	stores.EncodeNonStrings(mdItems)
+
+	var keys []string
+	for k := range mdItems {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	branch := append(sops.TreeBranch(nil), in.Branches[0]...)
+	for _, key := range keys {
+		var value = mdItems[key]
+		if value == nil {
+			continue
+		}
+		// Numbers are decoded from the flattened JSON as float64 values, and
+		// are written the way they are written in JSON.
+		if _, ok := value.(string); !ok {
+			value = fmt.Sprint(value)
+		}
+		branch = append(branch, sops.TreeItem{Key: SopsPrefix + key, Value: value})
+	}
+	return store.EmitPlainFile(sops.TreeBranches{branch})
+}
+
+// EmitPlainFile returns the plaintext file's bytes corresponding to a sops
+// runtime object. Empty comments are written as blank lines.
+func (store *Store) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	for _, item := range in[0] {
+		if IsComplexValue(item.Value) {
+			return nil, fmt.Errorf("cannot use complex value in properties file: %s", item.Value)
+		}
+		if comment, ok := item.Key.(sops.Comment); ok {
+			if comment.Value == "" {
+				buffer.WriteString("\n")
+				continue
+			}
+			for _, line := range strings.Split(comment.Value, "\n") {
+				buffer.WriteString("#" + line + "\n")
+			}
+			continue
+		}
+		key, ok := item.Key.(string)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid key in properties file: %q", item.Key)
+		}
+		value, ok := item.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot use non-string value in properties file: %v", item.Value)
+		}
+		buffer.WriteString(escape(key, true) + "=" + escape(value, false) + "\n")
+	}
+	return buffer.Bytes(), nil
+}
+
+// EmitValue returns a single value as bytes
+func (Store) EmitValue(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("the properties store only supports emitting strings, got %T", v)
+}
+
+// EmitExample returns the bytes corresponding to an example Flat Tree runtime object
+func (store *Store) EmitExample() []byte {
+	bytes, err := store.EmitPlainFile(stores.ExampleFlatTree.Branches)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+func IsComplexValue(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}:
+		return true
+	case sops.TreeBranch:
+		return true
+	}
+	return false
+}
+
+// HasSopsTopLevelKey checks whether a top-level "sops" key exists.
+func (store *Store) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
+	for _, b := range branch {
+		if key, ok := b.Key.(string); ok {
+			if strings.HasPrefix(key, SopsPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package properties
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
+	"github.com/stretchr/testify/assert"
+)
+
+var PLAIN = []byte(`# Database settings
+! Also a comment
+db.url=jdbc:postgresql://localhost/app
+db.user : admin
+db.password   s3cr3t
+
+message = Hello, \
+          World!
+path=C:\\Program Files\\App
+unicode=caf\u00e9 \uD83D\uDE00
+key\ with\ spaces=value
+empty
+`)
+
+var BRANCH = sops.TreeBranch{
+	sops.TreeItem{Key: sops.Comment{Value: " Database settings"}},
+	sops.TreeItem{Key: sops.Comment{Value: " Also a comment"}},
+	sops.TreeItem{Key: "db.url", Value: "jdbc:postgresql://localhost/app"},
+	sops.TreeItem{Key: "db.user", Value: "admin"},
+	sops.TreeItem{Key: "db.password", Value: "s3cr3t"},
+	sops.TreeItem{Key: sops.Comment{Value: ""}},
+	sops.TreeItem{Key: "message", Value: "Hello, World!"},
+	sops.TreeItem{Key: "path", Value: `C:\Program Files\App`},
+	sops.TreeItem{Key: "unicode", Value: "café \U0001F600"},
+	sops.TreeItem{Key: "key with spaces", Value: "value"},
+	sops.TreeItem{Key: "empty", Value: ""},
+}
+
+var EMITTED = `# Database settings
+# Also a comment
+db.url=jdbc:postgresql://localhost/app
+db.user=admin
+db.password=s3cr3t
+
+message=Hello, World!
+path=C:\\Program Files\\App
+unicode=caf\u00E9 \uD83D\uDE00
+key\ with\ spaces=value
+empty=
+`
+
+func TestLoadPlainFile(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(PLAIN)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCH, branches[0])
+}
+
+func TestEmitPlainFile(t *testing.T) {
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{BRANCH})
+	assert.Nil(t, err)
+	assert.Equal(t, EMITTED, string(bytes))
+
+	branches, err := (&Store{}).LoadPlainFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCH, branches[0])
+}
+
+func TestLoadPlainFileSyntax(t *testing.T) {
+	tests := []struct {
+		in    string
+		key   string
+		value string
+	}{
+		{"key=value", "key", "value"},
+		{"  key = value  ", "key", "value  "},
+		{"key:value", "key", "value"},
+		{"key\tvalue", "key", "value"},
+		{"key = =value", "key", "=value"},
+		{`a\=b\:c=d`, "a=b:c", "d"},
+		{`key=\ leading`, "key", " leading"},
+		{`key=a\tb\nc\rd\fe\qf`, "key", "a\tb\nc\rd\fe" + "qf"},
+		{"key=first\\\r\n  second\\\r  third", "key", "firstsecondthird"},
+		{"key=not continued\\\\", "key", `not continued\`},
+		{"key=value\\", "key", "value"},
+		{"key=# not a comment", "key", "# not a comment"},
+		{"key=caf\xe9", "key", "café"},
+	}
+	for _, tt := range tests {
+		branches, err := (&Store{}).LoadPlainFile([]byte(tt.in))
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, sops.TreeBranch{{Key: tt.key, Value: tt.value}}, branches[0], tt.in)
+	}
+}
+
+func TestLoadPlainFileErrors(t *testing.T) {
+	_, err := (&Store{}).LoadPlainFile([]byte("a=b\nkey=\\u12"))
+	assert.ErrorContains(t, err, `invalid properties input on line 2: value of key "key": malformed \uXXXX escape sequence`)
+
+	_, err = (&Store{}).LoadPlainFile([]byte(`key=\uZZZZ`))
+	assert.ErrorContains(t, err, `malformed \uXXXX escape sequence "\\uZZZZ"`)
+}
+
+func TestEmitPlainFileEscaping(t *testing.T) {
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{{
+		{Key: "#key=:!", Value: "  leading spaces, trailing spaces  "},
+		{Key: "multiline", Value: "first\nsecond"},
+		{Key: sops.Comment{Value: " multiline\n comment"}},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "\\#key\\=\\:\\!=\\  leading spaces, trailing spaces  \n"+
+		"multiline=first\\nsecond\n"+
+		"# multiline\n"+
+		"# comment\n", string(bytes))
+}
+
+func TestEmitPlainFileErrors(t *testing.T) {
+	_, err := (&Store{}).EmitPlainFile(sops.TreeBranches{{{Key: "", Value: "value"}}})
+	assert.ErrorContains(t, err, "invalid key in properties file")
+
+	_, err = (&Store{}).EmitPlainFile(sops.TreeBranches{{{Key: "key", Value: 1}}})
+	assert.ErrorContains(t, err, "cannot use non-string value in properties file")
+
+	_, err = (&Store{}).EmitPlainFile(sops.TreeBranches{{{Key: "key", Value: sops.TreeBranch{}}}})
+	assert.ErrorContains(t, err, "cannot use complex value in properties file")
+}
+
+func TestEncryptedFileRoundTrip(t *testing.T) {
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{{
+			{Key: sops.Comment{Value: " comment"}},
+			{Key: "key", Value: "ENC[AES256_GCM,data:abc=,type:str]"},
+		}},
+		Metadata: sops.Metadata{
+			Version:                   "3.9.0",
+			LastModified:              time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			MessageAuthenticationCode: "ENC[AES256_GCM,data:mac=,type:str]",
+			UnencryptedSuffix:         "_unencrypted",
+			ShamirThreshold:           2,
+			KeyGroups: []sops.KeyGroup{{&age.MasterKey{
+				Recipient:    "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw",
+				EncryptedKey: "-----BEGIN AGE ENCRYPTED FILE-----\nYWJj\n-----END AGE ENCRYPTED FILE-----\n",
+			}}},
+		},
+	}
+	bytes, err := (&Store{}).EmitEncryptedFile(tree)
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), "# comment\nkey=ENC[AES256_GCM,data:abc=,type:str]\nsops_age__list_0__map_enc=-----BEGIN AGE ENCRYPTED FILE-----\\nYWJj\\n-----END AGE ENCRYPTED FILE-----\\n\n")
+
+	loaded, err := (&Store{}).LoadEncryptedFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, tree.Branches, loaded.Branches)
+	assert.Equal(t, tree.Metadata.MessageAuthenticationCode, loaded.Metadata.MessageAuthenticationCode)
+	assert.Equal(t, tree.Metadata.UnencryptedSuffix, loaded.Metadata.UnencryptedSuffix)
+	assert.Equal(t, tree.Metadata.ShamirThreshold, loaded.Metadata.ShamirThreshold)
+	assert.Equal(t, tree.Metadata.KeyGroups[0][0].EncryptedDataKey(), loaded.Metadata.KeyGroups[0][0].EncryptedDataKey())
+}
+
+func TestLoadEncryptedFileWithoutMetadata(t *testing.T) {
+	_, err := (&Store{}).LoadEncryptedFile([]byte("key=value\n"))
+	assert.Equal(t, sops.MetadataNotFound, err)
+}
+
+func TestHasSopsTopLevelKey(t *testing.T) {
+	assert.True(t, (&Store{}).HasSopsTopLevelKey(sops.TreeBranch{{Key: "sops_mac", Value: "value"}}))
+	assert.False(t, (&Store{}).HasSopsTopLevelKey(sops.TreeBranch{{Key: "sops", Value: "value"}}))
+}
@@ -17,10 +17,13 @@ import (
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/age"
 	"github.com/getsops/sops/v3/azkv"
+	"github.com/getsops/sops/v3/execplugin"
 	"github.com/getsops/sops/v3/gcpkms"
 	"github.com/getsops/sops/v3/hcvault"
 	"github.com/getsops/sops/v3/kms"
+	"github.com/getsops/sops/v3/passphrase"
 	"github.com/getsops/sops/v3/pgp"
+	"github.com/getsops/sops/v3/pkcs11"
 )
 
 const (
@@ -42,33 +45,51 @@ type SopsFile struct {
 // in order to allow the binary format to stay backwards compatible over time, but at the same time allow the internal
 // representation SOPS uses to change over time.
 type Metadata struct {
-	ShamirThreshold           int         `yaml:"shamir_threshold,omitempty" json:"shamir_threshold,omitempty"`
-	KeyGroups                 []keygroup  `yaml:"key_groups,omitempty" json:"key_groups,omitempty"`
-	KMSKeys                   []kmskey    `yaml:"kms" json:"kms"`
-	GCPKMSKeys                []gcpkmskey `yaml:"gcp_kms" json:"gcp_kms"`
-	AzureKeyVaultKeys         []azkvkey   `yaml:"azure_kv" json:"azure_kv"`
-	VaultKeys                 []vaultkey  `yaml:"hc_vault" json:"hc_vault"`
-	AgeKeys                   []agekey    `yaml:"age" json:"age"`
-	LastModified              string      `yaml:"lastmodified" json:"lastmodified"`
-	MessageAuthenticationCode string      `yaml:"mac" json:"mac"`
-	PGPKeys                   []pgpkey    `yaml:"pgp" json:"pgp"`
-	UnencryptedSuffix         string      `yaml:"unencrypted_suffix,omitempty" json:"unencrypted_suffix,omitempty"`
-	EncryptedSuffix           string      `yaml:"encrypted_suffix,omitempty" json:"encrypted_suffix,omitempty"`
-	UnencryptedRegex          string      `yaml:"unencrypted_regex,omitempty" json:"unencrypted_regex,omitempty"`
-	EncryptedRegex            string      `yaml:"encrypted_regex,omitempty" json:"encrypted_regex,omitempty"`
-	UnencryptedCommentRegex   string      `yaml:"unencrypted_comment_regex,omitempty" json:"unencrypted_comment_regex,omitempty"`
-	EncryptedCommentRegex     string      `yaml:"encrypted_comment_regex,omitempty" json:"encrypted_comment_regex,omitempty"`
-	MACOnlyEncrypted          bool        `yaml:"mac_only_encrypted,omitempty" json:"mac_only_encrypted,omitempty"`
-	Version                   string      `yaml:"version" json:"version"`
+	ShamirThreshold           int             `yaml:"shamir_threshold,omitempty" json:"shamir_threshold,omitempty"`
+	KeyGroups                 []keygroup      `yaml:"key_groups,omitempty" json:"key_groups,omitempty"`
+	Zones                     []zone          `yaml:"zones,omitempty" json:"zones,omitempty"`
+	KMSKeys                   []kmskey        `yaml:"kms" json:"kms"`
+	GCPKMSKeys                []gcpkmskey     `yaml:"gcp_kms" json:"gcp_kms"`
+	AzureKeyVaultKeys         []azkvkey       `yaml:"azure_kv" json:"azure_kv"`
+	VaultKeys                 []vaultkey      `yaml:"hc_vault" json:"hc_vault"`
+	AgeKeys                   []agekey        `yaml:"age" json:"age"`
+	PassphraseKeys            []passphrasekey `yaml:"passphrase,omitempty" json:"passphrase,omitempty"`
+	PKCS11Keys                []pkcs11key     `yaml:"pkcs11,omitempty" json:"pkcs11,omitempty"`
+	ExecPluginKeys            []execpluginkey `yaml:"exec_plugin,omitempty" json:"exec_plugin,omitempty"`
+	LastModified              string          `yaml:"lastmodified" json:"lastmodified"`
+	MessageAuthenticationCode string          `yaml:"mac" json:"mac"`
+	CiphertextMAC             string          `yaml:"ciphertext_mac,omitempty" json:"ciphertext_mac,omitempty"`
+	PGPKeys                   []pgpkey        `yaml:"pgp" json:"pgp"`
+	UnencryptedSuffix         string          `yaml:"unencrypted_suffix,omitempty" json:"unencrypted_suffix,omitempty"`
+	EncryptedSuffix           string          `yaml:"encrypted_suffix,omitempty" json:"encrypted_suffix,omitempty"`
+	UnencryptedRegex          string          `yaml:"unencrypted_regex,omitempty" json:"unencrypted_regex,omitempty"`
+	EncryptedRegex            string          `yaml:"encrypted_regex,omitempty" json:"encrypted_regex,omitempty"`
+	UnencryptedCommentRegex   string          `yaml:"unencrypted_comment_regex,omitempty" json:"unencrypted_comment_regex,omitempty"`
+	EncryptedCommentRegex     string          `yaml:"encrypted_comment_regex,omitempty" json:"encrypted_comment_regex,omitempty"`
+	EncryptedPaths            []string        `yaml:"encrypted_paths,omitempty" json:"encrypted_paths,omitempty"`
+	UnencryptedPaths          []string        `yaml:"unencrypted_paths,omitempty" json:"unencrypted_paths,omitempty"`
+	MACOnlyEncrypted          bool            `yaml:"mac_only_encrypted,omitempty" json:"mac_only_encrypted,omitempty"`
+	Cipher                    string          `yaml:"cipher,omitempty" json:"cipher,omitempty"`
+	MACVersion                int             `yaml:"mac_version,omitempty" json:"mac_version,omitempty"`
+	Version                   string          `yaml:"version" json:"version"`
 }
 
 type keygroup struct {
-	PGPKeys           []pgpkey    `yaml:"pgp,omitempty" json:"pgp,omitempty"`
-	KMSKeys           []kmskey    `yaml:"kms,omitempty" json:"kms,omitempty"`
-	GCPKMSKeys        []gcpkmskey `yaml:"gcp_kms,omitempty" json:"gcp_kms,omitempty"`
-	AzureKeyVaultKeys []azkvkey   `yaml:"azure_kv,omitempty" json:"azure_kv,omitempty"`
-	VaultKeys         []vaultkey  `yaml:"hc_vault" json:"hc_vault"`
-	AgeKeys           []agekey    `yaml:"age" json:"age"`
+	PGPKeys           []pgpkey        `yaml:"pgp,omitempty" json:"pgp,omitempty"`
+	KMSKeys           []kmskey        `yaml:"kms,omitempty" json:"kms,omitempty"`
+	GCPKMSKeys        []gcpkmskey     `yaml:"gcp_kms,omitempty" json:"gcp_kms,omitempty"`
+	AzureKeyVaultKeys []azkvkey       `yaml:"azure_kv,omitempty" json:"azure_kv,omitempty"`
+	VaultKeys         []vaultkey      `yaml:"hc_vault" json:"hc_vault"`
+	AgeKeys           []agekey        `yaml:"age" json:"age"`
+	PassphraseKeys    []passphrasekey `yaml:"passphrase,omitempty" json:"passphrase,omitempty"`
+	PKCS11Keys        []pkcs11key     `yaml:"pkcs11,omitempty" json:"pkcs11,omitempty"`
+	ExecPluginKeys    []execpluginkey `yaml:"exec_plugin,omitempty" json:"exec_plugin,omitempty"`
+}
+
+type zone struct {
+	Paths           []string   `yaml:"paths" json:"paths"`
+	ShamirThreshold int        `yaml:"shamir_threshold,omitempty" json:"shamir_threshold,omitempty"`
+	KeyGroups       []keygroup `yaml:"key_groups" json:"key_groups"`
 }
 
 type pgpkey struct {
@@ -78,12 +99,13 @@ type pgpkey struct {
 }
 
 type kmskey struct {
-	Arn              string             `yaml:"arn" json:"arn"`
-	Role             string             `yaml:"role,omitempty" json:"role,omitempty"`
-	Context          map[string]*string `yaml:"context,omitempty" json:"context,omitempty"`
-	CreatedAt        string             `yaml:"created_at" json:"created_at"`
-	EncryptedDataKey string             `yaml:"enc" json:"enc"`
-	AwsProfile       string             `yaml:"aws_profile" json:"aws_profile"`
+	Arn                 string             `yaml:"arn" json:"arn"`
+	Role                string             `yaml:"role,omitempty" json:"role,omitempty"`
+	Context             map[string]*string `yaml:"context,omitempty" json:"context,omitempty"`
+	CreatedAt           string             `yaml:"created_at" json:"created_at"`
+	EncryptedDataKey    string             `yaml:"enc" json:"enc"`
+	AwsProfile          string             `yaml:"aws_profile" json:"aws_profile"`
+	EncryptionAlgorithm string             `yaml:"encryption_algorithm,omitempty" json:"encryption_algorithm,omitempty"`
 }
 
 type gcpkmskey struct {
@@ -96,6 +118,10 @@ type vaultkey struct {
 	VaultAddress     string `yaml:"vault_address" json:"vault_address"`
 	EnginePath       string `yaml:"engine_path" json:"engine_path"`
 	KeyName          string `yaml:"key_name" json:"key_name"`
+	Namespace        string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	AuthMethod       string `yaml:"auth_method,omitempty" json:"auth_method,omitempty"`
+	AuthMount        string `yaml:"auth_mount,omitempty" json:"auth_mount,omitempty"`
+	AuthRole         string `yaml:"auth_role,omitempty" json:"auth_role,omitempty"`
 	CreatedAt        string `yaml:"created_at" json:"created_at"`
 	EncryptedDataKey string `yaml:"enc" json:"enc"`
 }
@@ -113,6 +139,28 @@ type agekey struct {
 	EncryptedDataKey string `yaml:"enc" json:"enc"`
 }
 
+type passphrasekey struct {
+	Name             string `yaml:"name" json:"name"`
+	CreatedAt        string `yaml:"created_at" json:"created_at"`
+	EncryptedDataKey string `yaml:"enc" json:"enc"`
+}
+
+type pkcs11key struct {
+	ModulePath       string `yaml:"module_path,omitempty" json:"module_path,omitempty"`
+	TokenLabel       string `yaml:"token_label,omitempty" json:"token_label,omitempty"`
+	SlotID           string `yaml:"slot_id,omitempty" json:"slot_id,omitempty"`
+	KeyLabel         string `yaml:"key_label" json:"key_label"`
+	CreatedAt        string `yaml:"created_at" json:"created_at"`
+	EncryptedDataKey string `yaml:"enc" json:"enc"`
+}
+
+type execpluginkey struct {
+	Name             string `yaml:"name" json:"name"`
+	KeyID            string `yaml:"key_id,omitempty" json:"key_id,omitempty"`
+	CreatedAt        string `yaml:"created_at" json:"created_at"`
+	EncryptedDataKey string `yaml:"enc" json:"enc"`
+}
+
 // MetadataFromInternal converts an internal SOPS metadata representation to a representation appropriate for storage
 func MetadataFromInternal(sopsMetadata sops.Metadata) Metadata {
 	var m Metadata
@@ -123,8 +171,13 @@ func MetadataFromInternal(sopsMetadata sops.Metadata) Metadata {
 	m.EncryptedRegex = sopsMetadata.EncryptedRegex
 	m.UnencryptedCommentRegex = sopsMetadata.UnencryptedCommentRegex
 	m.EncryptedCommentRegex = sopsMetadata.EncryptedCommentRegex
+	m.EncryptedPaths = sopsMetadata.EncryptedPaths
+	m.UnencryptedPaths = sopsMetadata.UnencryptedPaths
 	m.MessageAuthenticationCode = sopsMetadata.MessageAuthenticationCode
+	m.CiphertextMAC = sopsMetadata.CiphertextMAC
 	m.MACOnlyEncrypted = sopsMetadata.MACOnlyEncrypted
+	m.Cipher = sopsMetadata.Cipher
+	m.MACVersion = sopsMetadata.MACVersion
 	m.Version = sopsMetadata.Version
 	m.ShamirThreshold = sopsMetadata.ShamirThreshold
 	if len(sopsMetadata.KeyGroups) == 1 {
@@ -135,21 +188,41 @@ func MetadataFromInternal(sopsMetadata sops.Metadata) Metadata {
 		m.VaultKeys = vaultKeysFromGroup(group)
 		m.AzureKeyVaultKeys = azkvKeysFromGroup(group)
 		m.AgeKeys = ageKeysFromGroup(group)
+		m.PassphraseKeys = passphraseKeysFromGroup(group)
+		m.PKCS11Keys = pkcs11KeysFromGroup(group)
+		m.ExecPluginKeys = execPluginKeysFromGroup(group)
 	} else {
 		for _, group := range sopsMetadata.KeyGroups {
-			m.KeyGroups = append(m.KeyGroups, keygroup{
-				KMSKeys:           kmsKeysFromGroup(group),
-				PGPKeys:           pgpKeysFromGroup(group),
-				GCPKMSKeys:        gcpkmsKeysFromGroup(group),
-				VaultKeys:         vaultKeysFromGroup(group),
-				AzureKeyVaultKeys: azkvKeysFromGroup(group),
-				AgeKeys:           ageKeysFromGroup(group),
-			})
+			m.KeyGroups = append(m.KeyGroups, keygroupFromInternal(group))
 		}
 	}
+	for _, z := range sopsMetadata.Zones {
+		stored := zone{
+			Paths:           z.Paths,
+			ShamirThreshold: z.ShamirThreshold,
+		}
+		for _, group := range z.KeyGroups {
+			stored.KeyGroups = append(stored.KeyGroups, keygroupFromInternal(group))
+		}
+		m.Zones = append(m.Zones, stored)
+	}
 	return m
 }
 
+func keygroupFromInternal(group sops.KeyGroup) keygroup {
+	return keygroup{
+		KMSKeys:           kmsKeysFromGroup(group),
+		PGPKeys:           pgpKeysFromGroup(group),
+		GCPKMSKeys:        gcpkmsKeysFromGroup(group),
+		VaultKeys:         vaultKeysFromGroup(group),
+		AzureKeyVaultKeys: azkvKeysFromGroup(group),
+		AgeKeys:           ageKeysFromGroup(group),
+		PassphraseKeys:    passphraseKeysFromGroup(group),
+		PKCS11Keys:        pkcs11KeysFromGroup(group),
+		ExecPluginKeys:    execPluginKeysFromGroup(group),
+	}
+}
+
 func pgpKeysFromGroup(group sops.KeyGroup) (keys []pgpkey) {
 	for _, key := range group {
 		switch key := key.(type) {
@@ -169,12 +242,13 @@ func kmsKeysFromGroup(group sops.KeyGroup) (keys []kmskey) {
 		switch key := key.(type) {
 		case *kms.MasterKey:
 			keys = append(keys, kmskey{
-				Arn:              key.Arn,
-				CreatedAt:        key.CreationDate.Format(time.RFC3339),
-				EncryptedDataKey: key.EncryptedKey,
-				Context:          key.EncryptionContext,
-				Role:             key.Role,
-				AwsProfile:       key.AwsProfile,
+				Arn:                 key.Arn,
+				CreatedAt:           key.CreationDate.Format(time.RFC3339),
+				EncryptedDataKey:    key.EncryptedKey,
+				Context:             key.EncryptionContext,
+				Role:                key.Role,
+				AwsProfile:          key.AwsProfile,
+				EncryptionAlgorithm: key.EncryptionAlgorithm,
 			})
 		}
 	}
@@ -203,6 +277,10 @@ func vaultKeysFromGroup(group sops.KeyGroup) (keys []vaultkey) {
 				VaultAddress:     key.VaultAddress,
 				EnginePath:       key.EnginePath,
 				KeyName:          key.KeyName,
+				Namespace:        key.Namespace,
+				AuthMethod:       key.AuthMethod,
+				AuthMount:        key.AuthMount,
+				AuthRole:         key.AuthRole,
 				CreatedAt:        key.CreationDate.Format(time.RFC3339),
 				EncryptedDataKey: key.EncryptedKey,
 			})
@@ -240,6 +318,52 @@ func ageKeysFromGroup(group sops.KeyGroup) (keys []agekey) {
 	return
 }
 
+func passphraseKeysFromGroup(group sops.KeyGroup) (keys []passphrasekey) {
+	for _, key := range group {
+		switch key := key.(type) {
+		case *passphrase.MasterKey:
+			keys = append(keys, passphrasekey{
+				Name:             key.Name,
+				CreatedAt:        key.CreationDate.Format(time.RFC3339),
+				EncryptedDataKey: key.EncryptedKey,
+			})
+		}
+	}
+	return
+}
+
+func pkcs11KeysFromGroup(group sops.KeyGroup) (keys []pkcs11key) {
+	for _, key := range group {
+		switch key := key.(type) {
+		case *pkcs11.MasterKey:
+			keys = append(keys, pkcs11key{
+				ModulePath:       key.ModulePath,
+				TokenLabel:       key.TokenLabel,
+				SlotID:           key.SlotID,
+				KeyLabel:         key.KeyLabel,
+				CreatedAt:        key.CreationDate.Format(time.RFC3339),
+				EncryptedDataKey: key.EncryptedKey,
+			})
+		}
+	}
+	return
+}
+
+func execPluginKeysFromGroup(group sops.KeyGroup) (keys []execpluginkey) {
+	for _, key := range group {
+		switch key := key.(type) {
+		case *execplugin.MasterKey:
+			keys = append(keys, execpluginkey{
+				Name:             key.Name,
+				KeyID:            key.KeyID,
+				CreatedAt:        key.CreationDate.Format(time.RFC3339),
+				EncryptedDataKey: key.EncryptedKey,
+			})
+		}
+	}
+	return
+}
+
 // ToInternal converts a storage-appropriate Metadata struct to a SOPS internal representation
 func (m *Metadata) ToInternal() (sops.Metadata, error) {
 	lastModified, err := time.Parse(time.RFC3339, m.LastModified)
@@ -250,6 +374,10 @@ func (m *Metadata) ToInternal() (sops.Metadata, error) {
 	if err != nil {
 		return sops.Metadata{}, err
 	}
+	zones, err := m.internalZones()
+	if err != nil {
+		return sops.Metadata{}, err
+	}
 
 	cryptRuleCount := 0
 	if m.UnencryptedSuffix != "" {
@@ -270,9 +398,17 @@ func (m *Metadata) ToInternal() (sops.Metadata, error) {
 	if m.EncryptedCommentRegex != "" {
 		cryptRuleCount++
 	}
+	if len(m.EncryptedPaths) > 0 || len(m.UnencryptedPaths) > 0 {
+		cryptRuleCount++
+	}
 
 	if cryptRuleCount > 1 {
-		return sops.Metadata{}, fmt.Errorf("Cannot use more than one of encrypted_suffix, unencrypted_suffix, encrypted_regex, unencrypted_regex, encrypted_comment_regex, or unencrypted_comment_regex in the same file")
+		return sops.Metadata{}, fmt.Errorf("Cannot use more than one of encrypted_suffix, unencrypted_suffix, encrypted_regex, unencrypted_regex, encrypted_comment_regex, unencrypted_comment_regex, or encrypted_paths and unencrypted_paths in the same file")
+	}
+	for _, paths := range [][]string{m.EncryptedPaths, m.UnencryptedPaths} {
+		if err := sops.CheckPathGlobs(paths); err != nil {
+			return sops.Metadata{}, err
+		}
 	}
 
 	if cryptRuleCount == 0 {
@@ -283,18 +419,24 @@ func (m *Metadata) ToInternal() (sops.Metadata, error) {
 		ShamirThreshold:           m.ShamirThreshold,
 		Version:                   m.Version,
 		MessageAuthenticationCode: m.MessageAuthenticationCode,
+		CiphertextMAC:             m.CiphertextMAC,
 		UnencryptedSuffix:         m.UnencryptedSuffix,
 		EncryptedSuffix:           m.EncryptedSuffix,
 		UnencryptedRegex:          m.UnencryptedRegex,
 		EncryptedRegex:            m.EncryptedRegex,
 		UnencryptedCommentRegex:   m.UnencryptedCommentRegex,
 		EncryptedCommentRegex:     m.EncryptedCommentRegex,
+		EncryptedPaths:            m.EncryptedPaths,
+		UnencryptedPaths:          m.UnencryptedPaths,
 		MACOnlyEncrypted:          m.MACOnlyEncrypted,
+		Cipher:                    m.Cipher,
+		MACVersion:                m.MACVersion,
 		LastModified:              lastModified,
+		Zones:                     zones,
 	}, nil
 }
 
-func internalGroupFrom(kmsKeys []kmskey, pgpKeys []pgpkey, gcpKmsKeys []gcpkmskey, azkvKeys []azkvkey, vaultKeys []vaultkey, ageKeys []agekey) (sops.KeyGroup, error) {
+func internalGroupFrom(kmsKeys []kmskey, pgpKeys []pgpkey, gcpKmsKeys []gcpkmskey, azkvKeys []azkvkey, vaultKeys []vaultkey, ageKeys []agekey, passphraseKeys []passphrasekey, pkcs11Keys []pkcs11key, execPluginKeys []execpluginkey) (sops.KeyGroup, error) {
 	var internalGroup sops.KeyGroup
 	for _, kmsKey := range kmsKeys {
 		k, err := kmsKey.toInternal()
@@ -338,13 +480,34 @@ func internalGroupFrom(kmsKeys []kmskey, pgpKeys []pgpkey, gcpKmsKeys []gcpkmske
 		}
 		internalGroup = append(internalGroup, k)
 	}
+	for _, passphraseKey := range passphraseKeys {
+		k, err := passphraseKey.toInternal()
+		if err != nil {
+			return nil, err
+		}
+		internalGroup = append(internalGroup, k)
+	}
+	for _, pkcs11Key := range pkcs11Keys {
+		k, err := pkcs11Key.toInternal()
+		if err != nil {
+			return nil, err
+		}
+		internalGroup = append(internalGroup, k)
+	}
+	for _, execPluginKey := range execPluginKeys {
+		k, err := execPluginKey.toInternal()
+		if err != nil {
+			return nil, err
+		}
+		internalGroup = append(internalGroup, k)
+	}
 	return internalGroup, nil
 }
 
 func (m *Metadata) internalKeygroups() ([]sops.KeyGroup, error) {
 	var internalGroups []sops.KeyGroup
-	if len(m.PGPKeys) > 0 || len(m.KMSKeys) > 0 || len(m.GCPKMSKeys) > 0 || len(m.AzureKeyVaultKeys) > 0 || len(m.VaultKeys) > 0 || len(m.AgeKeys) > 0 {
-		internalGroup, err := internalGroupFrom(m.KMSKeys, m.PGPKeys, m.GCPKMSKeys, m.AzureKeyVaultKeys, m.VaultKeys, m.AgeKeys)
+	if len(m.PGPKeys) > 0 || len(m.KMSKeys) > 0 || len(m.GCPKMSKeys) > 0 || len(m.AzureKeyVaultKeys) > 0 || len(m.VaultKeys) > 0 || len(m.AgeKeys) > 0 || len(m.PassphraseKeys) > 0 || len(m.PKCS11Keys) > 0 || len(m.ExecPluginKeys) > 0 {
+		internalGroup, err := internalGroupFrom(m.KMSKeys, m.PGPKeys, m.GCPKMSKeys, m.AzureKeyVaultKeys, m.VaultKeys, m.AgeKeys, m.PassphraseKeys, m.PKCS11Keys, m.ExecPluginKeys)
 		if err != nil {
 			return nil, err
 		}
@@ -352,7 +515,7 @@ func (m *Metadata) internalKeygroups() ([]sops.KeyGroup, error) {
 		return internalGroups, nil
 	} else if len(m.KeyGroups) > 0 {
 		for _, group := range m.KeyGroups {
-			internalGroup, err := internalGroupFrom(group.KMSKeys, group.PGPKeys, group.GCPKMSKeys, group.AzureKeyVaultKeys, group.VaultKeys, group.AgeKeys)
+			internalGroup, err := internalGroupFrom(group.KMSKeys, group.PGPKeys, group.GCPKMSKeys, group.AzureKeyVaultKeys, group.VaultKeys, group.AgeKeys, group.PassphraseKeys, group.PKCS11Keys, group.ExecPluginKeys)
 			if err != nil {
 				return nil, err
 			}
@@ -364,18 +527,47 @@ func (m *Metadata) internalKeygroups() ([]sops.KeyGroup, error) {
 	}
 }
 
+func (m *Metadata) internalZones() ([]sops.Zone, error) {
+	var zones []sops.Zone
+	for _, z := range m.Zones {
+		if len(z.Paths) == 0 {
+			return nil, fmt.Errorf("No paths found in zone")
+		}
+		if err := sops.CheckPathGlobs(z.Paths); err != nil {
+			return nil, err
+		}
+		if len(z.KeyGroups) == 0 {
+			return nil, fmt.Errorf("No keys found in zone")
+		}
+		internalZone := sops.Zone{
+			Paths:           z.Paths,
+			ShamirThreshold: z.ShamirThreshold,
+		}
+		for _, group := range z.KeyGroups {
+			internalGroup, err := internalGroupFrom(group.KMSKeys, group.PGPKeys, group.GCPKMSKeys, group.AzureKeyVaultKeys, group.VaultKeys, group.AgeKeys, group.PassphraseKeys, group.PKCS11Keys, group.ExecPluginKeys)
+			if err != nil {
+				return nil, err
+			}
+			internalZone.KeyGroups = append(internalZone.KeyGroups, internalGroup)
+		}
+		zones = append(zones, internalZone)
+	}
+	return zones, nil
+}
+
 func (kmsKey *kmskey) toInternal() (*kms.MasterKey, error) {
 	creationDate, err := time.Parse(time.RFC3339, kmsKey.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &kms.MasterKey{
-		Role:              kmsKey.Role,
-		EncryptionContext: kmsKey.Context,
-		EncryptedKey:      kmsKey.EncryptedDataKey,
-		CreationDate:      creationDate,
-		Arn:               kmsKey.Arn,
-		AwsProfile:        kmsKey.AwsProfile,
+		Role:                kmsKey.Role,
+		EncryptionContext:   kmsKey.Context,
+		EncryptedKey:        kmsKey.EncryptedDataKey,
+		CreationDate:        creationDate,
+		Arn:                 kmsKey.Arn,
+		AwsProfile:          kmsKey.AwsProfile,
+		EncryptionAlgorithm: kmsKey.EncryptionAlgorithm,
 	}, nil
 }
 
@@ -414,6 +606,10 @@ func (vaultKey *vaultkey) toInternal() (*hcvault.MasterKey, error) {
 		VaultAddress: vaultKey.VaultAddress,
 		EnginePath:   vaultKey.EnginePath,
 		KeyName:      vaultKey.KeyName,
+		Namespace:    vaultKey.Namespace,
+		AuthMethod:   vaultKey.AuthMethod,
+		AuthMount:    vaultKey.AuthMount,
+		AuthRole:     vaultKey.AuthRole,
 		CreationDate: creationDate,
 		EncryptedKey: vaultKey.EncryptedDataKey,
 	}, nil
@@ -438,6 +634,49 @@ func (ageKey *agekey) toInternal() (*age.MasterKey, error) {
 	}, nil
 }
 
+func (passphraseKey *passphrasekey) toInternal() (*passphrase.MasterKey, error) {
+	creationDate, err := time.Parse(time.RFC3339, passphraseKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &passphrase.MasterKey{
+		Name:         passphraseKey.Name,
+		EncryptedKey: passphraseKey.EncryptedDataKey,
+		CreationDate: creationDate,
+	}, nil
+}
+
+func (pkcs11Key *pkcs11key) toInternal() (*pkcs11.MasterKey, error) {
+	creationDate, err := time.Parse(time.RFC3339, pkcs11Key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11.MasterKey{
+		ModulePath:   pkcs11Key.ModulePath,
+		TokenLabel:   pkcs11Key.TokenLabel,
+		SlotID:       pkcs11Key.SlotID,
+		KeyLabel:     pkcs11Key.KeyLabel,
+		EncryptedKey: pkcs11Key.EncryptedDataKey,
+		CreationDate: creationDate,
+	}, nil
+}
+
+func (execPluginKey *execpluginkey) toInternal() (*execplugin.MasterKey, error) {
+	if err := execplugin.CheckName(execPluginKey.Name); err != nil {
+		return nil, err
+	}
+	creationDate, err := time.Parse(time.RFC3339, execPluginKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &execplugin.MasterKey{
+		Name:         execPluginKey.Name,
+		KeyID:        execPluginKey.KeyID,
+		EncryptedKey: execPluginKey.EncryptedDataKey,
+		CreationDate: creationDate,
+	}, nil
+}
+
 // ExampleComplexTree is an example sops.Tree object exhibiting complex relationships
 var ExampleComplexTree = sops.Tree{
 	Branches: sops.TreeBranches{
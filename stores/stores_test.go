@@ -0,0 +1,30 @@
+package stores
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/pkcs11"
+)
+
+func TestPkcs11KeyMetadataRoundTrip(t *testing.T) {
+	key := &pkcs11.MasterKey{
+		ModulePath:   "/usr/lib/softhsm/libsofthsm2.so",
+		TokenLabel:   "sops",
+		KeyLabel:     "data-key",
+		EncryptedKey: "some-encrypted-key",
+		CreationDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	metadata := MetadataFromInternal(sops.Metadata{
+		Version:   "3.9.0",
+		KeyGroups: []sops.KeyGroup{{key}},
+	})
+	assert.Equal(t, "/usr/lib/softhsm/libsofthsm2.so", metadata.PKCS11Keys[0].ModulePath)
+
+	internal, err := metadata.ToInternal()
+	assert.NoError(t, err)
+	assert.Equal(t, key, internal.KeyGroups[0][0])
+}
@@ -0,0 +1,173 @@
+// Package stream implements the binary_stream format, for binary files too
+// large to be held in memory, such as keystores, database dumps or model
+// files.
+//
+// An encrypted file starts with a header on a single line, which is a JSON
+// document holding the sops metadata and a random stream key, encrypted with
+// the data key like the values of any other file. The rest of the file is the
+// payload, encrypted with the stream key in chunks (see Writer and Reader).
+// As the payload does not depend on the data key, changing the master keys or
+// the data key of a file only rewrites its header.
+package stream //import "github.com/getsops/sops/v3/stores/stream"
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+)
+
+// KeyName is the key of the header holding the stream key.
+const KeyName = "stream_key"
+
+const keySize = 32
+
+// maxHeaderSize bounds the size of the header line, so that reading a file
+// which is not in this format does not load it into memory.
+const maxHeaderSize = 1024 * 1024
+
+// ErrNotStreamable is returned by the methods of Store which would need to
+// hold the payload of a file in memory.
+var ErrNotStreamable = errors.New("binary_stream files can only be encrypted, decrypted, rotated and have their keys updated")
+
+// Store handles the header of files in the binary_stream format. The
+// payload is read and written with Reader and Writer.
+type Store struct {
+	config config.BinaryStreamStoreConfig
+}
+
+func NewStore(c *config.BinaryStreamStoreConfig) *Store {
+	return &Store{config: *c}
+}
+
+// NewKey returns a new random stream key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate stream key: %s", err)
+	}
+	return key, nil
+}
+
+// NewBranches returns the branches of the header of a file whose payload is
+// encrypted with the stream key.
+func NewBranches(key []byte) sops.TreeBranches {
+	return sops.TreeBranches{
+		sops.TreeBranch{
+			sops.TreeItem{
+				Key:   KeyName,
+				Value: base64.StdEncoding.EncodeToString(key),
+			},
+		},
+	}
+}
+
+// Key returns the stream key of a decrypted header.
+func Key(tree sops.Tree) ([]byte, error) {
+	if len(tree.Branches) != 1 {
+		return nil, errors.New("the header of the stream must have exactly one tree branch")
+	}
+	for _, item := range tree.Branches[0] {
+		if item.Key != KeyName {
+			continue
+		}
+		value, ok := item.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("the %s of the stream is not a string", KeyName)
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("the %s of the stream is not a valid key, it may not have been decrypted", KeyName)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no %s found in the header of the stream", KeyName)
+}
+
+// LoadEncryptedHeader reads the header of an encrypted file, leaving the
+// reader at the start of the payload.
+func (store *Store) LoadEncryptedHeader(r *bufio.Reader) (sops.Tree, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxHeaderSize {
+			return sops.Tree{}, fmt.Errorf("Error unmarshalling input stream: the header is larger than %d bytes", maxHeaderSize)
+		}
+		if err == nil {
+			break
+		}
+		if err == io.EOF {
+			return sops.Tree{}, errors.New("Error unmarshalling input stream: the header is not terminated by a newline")
+		}
+		if err != bufio.ErrBufferFull {
+			return sops.Tree{}, err
+		}
+	}
+	if !bytes.HasPrefix(line, []byte("{")) {
+		return sops.Tree{}, sops.MetadataNotFound
+	}
+	return (&sopsjson.Store{}).LoadEncryptedFile(line)
+}
+
+// EmitEncryptedHeader writes the header of an encrypted file, after which the
+// payload is to be written.
+func (store *Store) EmitEncryptedHeader(w io.Writer, in sops.Tree) error {
+	out, err := (&sopsjson.Store{}).EmitEncryptedFile(in)
+	if err != nil {
+		return err
+	}
+	var header bytes.Buffer
+	if err := json.Compact(&header, out); err != nil {
+		return fmt.Errorf("Error marshaling stream header: %s", err)
+	}
+	header.WriteByte('\n')
+	_, err = w.Write(header.Bytes())
+	return err
+}
+
+// LoadEncryptedFile loads the header of an encrypted file from its first
+// bytes.
+func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
+	return store.LoadEncryptedHeader(bufio.NewReader(bytes.NewReader(in)))
+}
+
+// LoadPlainFile returns ErrNotStreamable, as the payload is not held in a tree.
+func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	return nil, ErrNotStreamable
+}
+
+// EmitEncryptedFile returns ErrNotStreamable, as the payload is not held in a
+// tree.
+func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
+	return nil, ErrNotStreamable
+}
+
+// EmitPlainFile returns ErrNotStreamable, as the payload is not held in a tree.
+func (store *Store) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
+	return nil, ErrNotStreamable
+}
+
+// EmitValue returns ErrNotStreamable, as the payload is not structured.
+func (store *Store) EmitValue(v interface{}) ([]byte, error) {
+	return nil, ErrNotStreamable
+}
+
+// EmitExample returns the example's plaintext bytes
+func (store *Store) EmitExample() []byte {
+	return []byte("Welcome to SOPS! Edit this file as you please!")
+}
+
+// HasSopsTopLevelKey checks whether a top-level "sops" key exists.
+func (store *Store) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
+	return stores.HasSopsTopLevelKey(branch)
+}
@@ -0,0 +1,94 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	key, err := NewKey()
+	assert.Nil(t, err)
+	loaded, err := Key(sops.Tree{Branches: NewBranches(key)})
+	assert.Nil(t, err)
+	assert.Equal(t, key, loaded)
+
+	_, err = Key(sops.Tree{Branches: sops.TreeBranches{{{Key: KeyName, Value: "ENC[AES256_GCM,data:abc=,type:str]"}}}})
+	assert.ErrorContains(t, err, "the stream_key of the stream is not a valid key")
+
+	_, err = Key(sops.Tree{Branches: sops.TreeBranches{{{Key: "data", Value: "value"}}}})
+	assert.ErrorContains(t, err, "no stream_key found in the header of the stream")
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{{{Key: KeyName, Value: "ENC[AES256_GCM,data:abc=,type:str]"}}},
+		Metadata: sops.Metadata{
+			Version:                   "3.9.0",
+			LastModified:              time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			MessageAuthenticationCode: "ENC[AES256_GCM,data:mac=,type:str]",
+			UnencryptedSuffix:         "_unencrypted",
+			KeyGroups: []sops.KeyGroup{{&age.MasterKey{
+				Recipient:    "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw",
+				EncryptedKey: "-----BEGIN AGE ENCRYPTED FILE-----\nYWJj\n-----END AGE ENCRYPTED FILE-----\n",
+			}}},
+		},
+	}
+	store := NewStore(&config.BinaryStreamStoreConfig{})
+	var out bytes.Buffer
+	assert.Nil(t, store.EmitEncryptedHeader(&out, tree))
+	header := out.String()
+	assert.True(t, strings.HasPrefix(header, `{"stream_key":"ENC[AES256_GCM,data:abc=,type:str]","sops":{`), header)
+	assert.Equal(t, 1, strings.Count(header, "\n"))
+	assert.True(t, strings.HasSuffix(header, "}\n"))
+
+	payload := []byte("\x00payload\n{}")
+	r := bufio.NewReader(io.MultiReader(&out, bytes.NewReader(payload)))
+	loaded, err := store.LoadEncryptedHeader(r)
+	assert.Nil(t, err)
+	assert.Equal(t, tree.Branches, loaded.Branches)
+	assert.Equal(t, tree.Metadata.MessageAuthenticationCode, loaded.Metadata.MessageAuthenticationCode)
+	assert.Equal(t, tree.Metadata.KeyGroups[0][0].EncryptedDataKey(), loaded.Metadata.KeyGroups[0][0].EncryptedDataKey())
+	rest, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, rest)
+
+	loaded, err = store.LoadEncryptedFile([]byte(header + string(payload)))
+	assert.Nil(t, err)
+	assert.Equal(t, tree.Branches, loaded.Branches)
+}
+
+func TestLoadEncryptedHeaderErrors(t *testing.T) {
+	store := NewStore(&config.BinaryStreamStoreConfig{})
+	_, err := store.LoadEncryptedFile([]byte("\x00\x01binary\n"))
+	assert.Equal(t, sops.MetadataNotFound, err)
+
+	_, err = store.LoadEncryptedFile([]byte(`{"stream_key":"value"}` + "\n"))
+	assert.Equal(t, sops.MetadataNotFound, err)
+
+	_, err = store.LoadEncryptedFile([]byte(`{"stream_key":"value"}`))
+	assert.ErrorContains(t, err, "the header is not terminated by a newline")
+
+	_, err = store.LoadEncryptedFile(bytes.Repeat([]byte("{"), maxHeaderSize+10))
+	assert.ErrorContains(t, err, "the header is larger than")
+}
+
+func TestTreeMethodsAreNotStreamable(t *testing.T) {
+	store := NewStore(&config.BinaryStreamStoreConfig{})
+	_, err := store.LoadPlainFile([]byte("data"))
+	assert.Equal(t, ErrNotStreamable, err)
+	_, err = store.EmitPlainFile(NewBranches(make([]byte, keySize)))
+	assert.Equal(t, ErrNotStreamable, err)
+	_, err = store.EmitEncryptedFile(sops.Tree{})
+	assert.Equal(t, ErrNotStreamable, err)
+	_, err = store.EmitValue("data")
+	assert.Equal(t, ErrNotStreamable, err)
+}
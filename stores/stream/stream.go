@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkSize is the size of the plaintext of each chunk of a stream.
+const ChunkSize = 64 * 1024
+
+const (
+	tagSize       = 16
+	nonceSize     = 12
+	encChunkSize  = ChunkSize + tagSize
+	lastChunkFlag = 0x01
+)
+
+// The payload is encrypted with the STREAM construction used by age: it is
+// split into chunks of ChunkSize bytes, each encrypted with AES-256-GCM. The
+// nonce of a chunk is its number, followed by a byte set to 1 for the last
+// chunk, so that chunks cannot be reordered, removed or added, and the
+// stream cannot be truncated.
+
+// nonce keeps track of the number of the chunk to encrypt or decrypt next.
+type nonce [nonceSize]byte
+
+func (n *nonce) next() error {
+	for i := nonceSize - 2; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return nil
+		}
+	}
+	return errors.New("stream is too large, the chunk counter overflowed")
+}
+
+func (n *nonce) setLast(last bool) {
+	n[nonceSize-1] = 0
+	if last {
+		n[nonceSize-1] = lastChunkFlag
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize stream cipher: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Writer encrypts the data written to it, and writes it to the underlying
+// writer one chunk at a time. Close must be called to write the last chunk.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	nonce  nonce
+	buf    []byte
+	out    []byte
+	closed bool
+}
+
+// NewWriter returns a Writer which encrypts data with the stream key.
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, ChunkSize),
+		out:  make([]byte, 0, encChunkSize),
+	}, nil
+}
+
+// Write encrypts p. A chunk is only written once it is known not to be the
+// last one, which is when more data is written after it is full.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	total := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return total - len(p), err
+			}
+		}
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Close writes the last chunk. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *Writer) flush(last bool) error {
+	w.nonce.setLast(last)
+	w.out = w.aead.Seal(w.out[:0], w.nonce[:], w.buf, nil)
+	if _, err := w.w.Write(w.out); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	if last {
+		return nil
+	}
+	return w.nonce.next()
+}
+
+// Reader decrypts the data read from the underlying reader, one chunk at a
+// time.
+type Reader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	nonce nonce
+	in    []byte
+	buf   []byte
+	first bool
+	done  bool
+	err   error
+}
+
+// NewReader returns a Reader which decrypts data with the stream key.
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{
+		r:     br,
+		aead:  aead,
+		in:    make([]byte, encChunkSize),
+		first: true,
+	}, nil
+}
+
+// Read returns decrypted data. Data is only returned once the chunk it is in
+// has been authenticated.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.readChunk()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) readChunk() error {
+	n, err := io.ReadFull(r.r, r.in)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		// The chunk is the last one if nothing comes after it.
+		if _, err := r.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if n < tagSize {
+		return errors.New("encrypted stream is truncated")
+	}
+	if last && n == tagSize && !r.first {
+		return errors.New("encrypted stream ends with an empty chunk")
+	}
+	r.nonce.setLast(last)
+	out, err := r.aead.Open(r.in[:0], r.nonce[:], r.in[:n], nil)
+	if err != nil {
+		if !last {
+			return errors.New("could not decrypt chunk of the stream: authentication failed")
+		}
+		return errors.New("could not decrypt the last chunk of the stream: authentication failed, the stream may be truncated")
+	}
+	r.buf = out
+	r.first = false
+	if last {
+		r.done = true
+		return nil
+	}
+	return r.nonce.next()
+}
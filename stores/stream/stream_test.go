@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptBytes(t *testing.T, key, plaintext []byte) []byte {
+	var out bytes.Buffer
+	w, err := NewWriter(&out, key)
+	assert.Nil(t, err)
+	// Writing in small pieces must give the same chunks as writing at once.
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), 1000)
+		_, err := w.Write(plaintext[:n])
+		assert.Nil(t, err)
+		plaintext = plaintext[n:]
+	}
+	assert.Nil(t, w.Close())
+	return out.Bytes()
+}
+
+func decryptBytes(key, ciphertext []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key, err := NewKey()
+	assert.Nil(t, err)
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize, 3*ChunkSize + 10} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		assert.Nil(t, err)
+
+		ciphertext := encryptBytes(t, key, plaintext)
+		chunks := max(1, (size+ChunkSize-1)/ChunkSize)
+		assert.Equal(t, size+chunks*tagSize, len(ciphertext), "size %d", size)
+
+		decrypted, err := decryptBytes(key, ciphertext)
+		assert.Nil(t, err, "size %d", size)
+		assert.Equal(t, plaintext, decrypted, "size %d", size)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key, err := NewKey()
+	assert.Nil(t, err)
+	plaintext := make([]byte, 2*ChunkSize+100)
+	ciphertext := encryptBytes(t, key, plaintext)
+
+	otherKey, err := NewKey()
+	assert.Nil(t, err)
+	_, err = decryptBytes(otherKey, ciphertext)
+	assert.ErrorContains(t, err, "authentication failed")
+
+	// Truncated at a chunk boundary, the last chunk is missing.
+	_, err = decryptBytes(key, ciphertext[:2*encChunkSize])
+	assert.ErrorContains(t, err, "the stream may be truncated")
+
+	_, err = decryptBytes(key, ciphertext[:encChunkSize+5])
+	assert.ErrorContains(t, err, "encrypted stream is truncated")
+
+	_, err = decryptBytes(key, nil)
+	assert.ErrorContains(t, err, "encrypted stream is truncated")
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[encChunkSize+10] ^= 1
+	_, err = decryptBytes(key, tampered)
+	assert.ErrorContains(t, err, "authentication failed")
+
+	// Chunks cannot be reordered.
+	var swapped []byte
+	swapped = append(swapped, ciphertext[encChunkSize:2*encChunkSize]...)
+	swapped = append(swapped, ciphertext[:encChunkSize]...)
+	swapped = append(swapped, ciphertext[2*encChunkSize:]...)
+	_, err = decryptBytes(key, swapped)
+	assert.ErrorContains(t, err, "authentication failed")
+
+	// Data after the last chunk is not ignored.
+	_, err = decryptBytes(key, append(bytes.Clone(ciphertext), 0))
+	assert.ErrorContains(t, err, "authentication failed")
+}
+
+func TestDecryptReturnsAuthenticatedChunks(t *testing.T) {
+	key, err := NewKey()
+	assert.Nil(t, err)
+	plaintext := bytes.Repeat([]byte("a"), 2*ChunkSize)
+	ciphertext := encryptBytes(t, key, plaintext)
+	ciphertext[len(ciphertext)-1] ^= 1
+
+	r, err := NewReader(bytes.NewReader(ciphertext), key)
+	assert.Nil(t, err)
+	decrypted, err := io.ReadAll(r)
+	assert.ErrorContains(t, err, "authentication failed")
+	assert.Equal(t, plaintext[:ChunkSize], decrypted)
+}
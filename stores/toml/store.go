@@ -0,0 +1,857 @@
+package toml //import "github.com/getsops/sops/v3/stores/toml"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores"
+	// The stable go-toml API decodes into maps, which loses the order of
+	// keys and all comments. Only the parser of the unstable package keeps
+	// them. It is not covered by the semantic versioning of go-toml, which is
+	// why go-toml is pinned in go.mod.
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// Store handles storage of TOML data
+type Store struct {
+	config config.TOMLStoreConfig
+}
+
+func NewStore(c *config.TOMLStoreConfig) *Store {
+	return &Store{config: *c}
+}
+
+// table is the mutable representation of a TOML table used while parsing.
+// TOML allows a table to be extended by later headers and dotted keys, which
+// is awkward to do on a sops.TreeBranch, so the document is first collected
+// into tables and converted to a sops.TreeBranch once parsing is done.
+type table struct {
+	items []*tableItem
+	// explicit is true if the table was defined through a [header], as
+	// opposed to implicitly through a longer header or a dotted key
+	explicit bool
+}
+
+type tableItem struct {
+	// key is either a string or a sops.Comment
+	key interface{}
+	// value is either a *table, a *tableArray, or a value that can be
+	// used directly in a sops.TreeBranch
+	value interface{}
+}
+
+// tableArray is an array of tables, defined through [[header]]s
+type tableArray struct {
+	// elements contains *table and sops.Comment values
+	elements []interface{}
+}
+
+func commentItem(comment []byte) *tableItem {
+	return &tableItem{
+		key: sops.Comment{Value: strings.TrimPrefix(string(comment), "#")},
+	}
+}
+
+func (t *table) lookup(key string) *tableItem {
+	for _, item := range t.items {
+		if item.key == key {
+			return item
+		}
+	}
+	return nil
+}
+
+// subTable returns the table stored under key, creating it if needed. If
+// key holds an array of tables, its last table is returned, as mandated by
+// the TOML specification for headers nested under an array of tables.
+func (t *table) subTable(key string) (*table, error) {
+	item := t.lookup(key)
+	if item == nil {
+		sub := &table{}
+		t.items = append(t.items, &tableItem{key: key, value: sub})
+		return sub, nil
+	}
+	switch value := item.value.(type) {
+	case *table:
+		return value, nil
+	case *tableArray:
+		for i := len(value.elements) - 1; i >= 0; i-- {
+			if sub, ok := value.elements[i].(*table); ok {
+				return sub, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("key %q is already defined and is not a table", key)
+}
+
+// descend returns the table found by following keys, creating the tables that
+// do not exist yet. The comments are inserted before the first table that
+// gets created, and returned if all tables already existed.
+func (t *table) descend(keys []string, comments []*tableItem) (*table, []*tableItem, error) {
+	current := t
+	for _, key := range keys {
+		if len(comments) > 0 && current.lookup(key) == nil {
+			current.items = append(current.items, comments...)
+			comments = nil
+		}
+		var err error
+		current, err = current.subTable(key)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return current, comments, nil
+}
+
+func (t *table) set(keys []string, value interface{}) error {
+	parent, _, err := t.descend(keys[:len(keys)-1], nil)
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if parent.lookup(key) != nil {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+	parent.items = append(parent.items, &tableItem{key: key, value: value})
+	return nil
+}
+
+func (t *table) toTreeBranch() sops.TreeBranch {
+	branch := make(sops.TreeBranch, 0, len(t.items))
+	for _, item := range t.items {
+		var value interface{}
+		switch v := item.value.(type) {
+		case *table:
+			value = v.toTreeBranch()
+		case *tableArray:
+			var list []interface{}
+			for _, element := range v.elements {
+				if sub, ok := element.(*table); ok {
+					list = append(list, sub.toTreeBranch())
+				} else {
+					list = append(list, element)
+				}
+			}
+			value = list
+		default:
+			value = v
+		}
+		branch = append(branch, sops.TreeItem{
+			Key:   item.key,
+			Value: value,
+		})
+	}
+	return branch
+}
+
+func keyParts(node *unstable.Node) []string {
+	var parts []string
+	it := node.Key()
+	for it.Next() {
+		parts = append(parts, string(it.Node().Data))
+	}
+	return parts
+}
+
+// appendComments appends a comment node and the comments chained to it to
+// list. The TOML parser groups consecutive comment lines inside arrays as
+// children of the first one.
+func appendComments(node *unstable.Node, list []interface{}) []interface{} {
+	list = append(list, sops.Comment{Value: strings.TrimPrefix(string(node.Data), "#")})
+	it := node.Children()
+	for it.Next() {
+		list = appendComments(it.Node(), list)
+	}
+	return list
+}
+
+func (store Store) nodeToTreeValue(node *unstable.Node) (interface{}, error) {
+	switch node.Kind {
+	case unstable.String:
+		return string(node.Data), nil
+	case unstable.Bool:
+		return string(node.Data) == "true", nil
+	case unstable.Integer:
+		// Base 0 accepts the 0x, 0o and 0b prefixes as well as the
+		// underscores that TOML allows between digits.
+		i, err := strconv.ParseInt(string(node.Data), 0, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			// Decimal integers too large for 64 bits are kept as they
+			// are written.
+			if n, err := sops.ParseInt(strings.ReplaceAll(string(node.Data), "_", "")); err == nil {
+				return n, nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q: %w", node.Data, err)
+		}
+		return int(i), nil
+	case unstable.Float:
+		return parseFloat(string(node.Data))
+	case unstable.DateTime, unstable.LocalDate, unstable.LocalTime, unstable.LocalDateTime:
+		// Dates and times are kept as they are written, including their
+		// precision and whether they have an offset.
+		return sops.Timestamp(node.Data), nil
+	case unstable.Array:
+		var list []interface{}
+		it := node.Children()
+		for it.Next() {
+			child := it.Node()
+			if child.Kind == unstable.Comment {
+				list = appendComments(child, list)
+				continue
+			}
+			value, err := store.nodeToTreeValue(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case unstable.InlineTable:
+		inline := &table{}
+		it := node.Children()
+		for it.Next() {
+			if err := store.appendKeyValue(inline, it.Node()); err != nil {
+				return nil, err
+			}
+		}
+		return inline.toTreeBranch(), nil
+	}
+	return nil, fmt.Errorf("unsupported TOML value of kind %s", node.Kind)
+}
+
+func parseFloat(s string) (interface{}, error) {
+	switch strings.TrimLeft(s, "+-") {
+	case "inf":
+		if strings.HasPrefix(s, "-") {
+			return math.Inf(-1), nil
+		}
+		return math.Inf(1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	f, err := sops.ParseFloat(strings.ReplaceAll(s, "_", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid float %q: %w", s, err)
+	}
+	return f, nil
+}
+
+// dateTimeRe matches the dates and times of TOML, with or without an offset.
+var dateTimeRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}:\d{2}(\.\d+)?([Zz]|[+-]\d{2}:\d{2})?)?|\d{2}:\d{2}:\d{2}(\.\d+)?)$`)
+
+func (store Store) appendKeyValue(t *table, node *unstable.Node) error {
+	value, err := store.nodeToTreeValue(node.Value())
+	if err != nil {
+		return err
+	}
+	return t.set(keyParts(node), value)
+}
+
+// followsBlankLine returns whether the line containing offset is the first
+// line of the input or is preceded by a line containing only whitespace.
+func followsBlankLine(in []byte, offset int) bool {
+	end := bytes.LastIndexByte(in[:offset], '\n')
+	if end == -1 {
+		return true
+	}
+	start := bytes.LastIndexByte(in[:end], '\n') + 1
+	return len(bytes.TrimSpace(in[start:end])) == 0
+}
+
+func (store Store) treeBranchFromTOML(in []byte) (sops.TreeBranch, error) {
+	root := &table{explicit: true}
+	current := root
+	// Comments on their own lines are kept aside until the next expression.
+	// If it is a header, the comments directly following the previous line
+	// stay in the current table, and the comments after a blank line are
+	// placed before the table the header defines.
+	var comments []*tableItem
+	attached := 0
+	p := unstable.Parser{KeepComments: true}
+	p.Reset(in)
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Comment:
+			if attached == len(comments) && !followsBlankLine(in, int(expr.Raw.Offset)) {
+				attached++
+			}
+			comments = append(comments, commentItem(expr.Data))
+			continue
+		case unstable.KeyValue:
+			current.items = append(current.items, comments...)
+			if err := store.appendKeyValue(current, expr); err != nil {
+				return nil, err
+			}
+		case unstable.Table:
+			keys := keyParts(expr)
+			current.items = append(current.items, comments[:attached]...)
+			t, rest, err := root.descend(keys, comments[attached:])
+			if err != nil {
+				return nil, err
+			}
+			if t.explicit {
+				return nil, fmt.Errorf("table [%s] is defined more than once", strings.Join(keys, "."))
+			}
+			t.explicit = true
+			t.items = append(t.items, rest...)
+			current = t
+		case unstable.ArrayTable:
+			keys := keyParts(expr)
+			current.items = append(current.items, comments[:attached]...)
+			parent, rest, err := root.descend(keys[:len(keys)-1], comments[attached:])
+			if err != nil {
+				return nil, err
+			}
+			key := keys[len(keys)-1]
+			array := &tableArray{}
+			if item := parent.lookup(key); item == nil {
+				parent.items = append(parent.items, rest...)
+				parent.items = append(parent.items, &tableItem{key: key, value: array})
+			} else if existing, ok := item.value.(*tableArray); ok {
+				array = existing
+				for _, comment := range rest {
+					array.elements = append(array.elements, comment.key)
+				}
+			} else {
+				return nil, fmt.Errorf("key %q is already defined and is not an array of tables", strings.Join(keys, "."))
+			}
+			current = &table{explicit: true}
+			array.elements = append(array.elements, current)
+		}
+		comments = nil
+		attached = 0
+		// A comment on the same line as an expression is chained to it
+		if next := expr.Next(); next != nil && next.Kind == unstable.Comment {
+			current.items = append(current.items, commentItem(next.Data))
+		}
+	}
+	current.items = append(current.items, comments...)
+	if err := p.Error(); err != nil {
+		if perr, ok := err.(*unstable.ParserError); ok {
+			shape := p.Shape(p.Range(perr.Highlight))
+			return nil, fmt.Errorf("line %d, column %d: %s", shape.Start.Line, shape.Start.Column, perr.Message)
+		}
+		return nil, err
+	}
+	return root.toTreeBranch(), nil
+}
+
+var bareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func encodeKey(key string) string {
+	if bareKeyRe.MatchString(key) {
+		return key
+	}
+	return encodeString(key)
+}
+
+func encodePath(path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		keys[i] = encodeKey(key)
+	}
+	return strings.Join(keys, ".")
+}
+
+func encodeString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func encodeFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		// TOML floats must have a fractional part or an exponent
+		s += ".0"
+	}
+	return s
+}
+
+func (store Store) encodeValue(v interface{}, indent string) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return encodeString(v), nil
+	case []byte:
+		return encodeString(string(v)), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return encodeFloat(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case sops.Timestamp:
+		if !dateTimeRe.MatchString(string(v)) {
+			// Timestamps of other formats, such as YAML dates without
+			// leading zeros, are not valid TOML dates.
+			return encodeString(string(v)), nil
+		}
+		return string(v), nil
+	case sops.Number:
+		return string(v), nil
+	case []interface{}:
+		return store.encodeArray(v, indent)
+	case sops.TreeBranch:
+		return store.encodeInlineTable(v, indent)
+	case nil:
+		return "", fmt.Errorf("TOML does not support null values")
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+func (store Store) encodeArray(array []interface{}, indent string) (string, error) {
+	hasComments := false
+	for _, item := range array {
+		if _, ok := item.(sops.Comment); ok {
+			hasComments = true
+			break
+		}
+	}
+	var values []string
+	for _, item := range array {
+		if comment, ok := item.(sops.Comment); ok {
+			values = append(values, "#"+comment.Value)
+			continue
+		}
+		value, err := store.encodeValue(item, indent+"    ")
+		if err != nil {
+			return "", err
+		}
+		values = append(values, value)
+	}
+	if !hasComments {
+		return "[" + strings.Join(values, ", ") + "]", nil
+	}
+	// Comments can only be kept in arrays spanning multiple lines
+	var b strings.Builder
+	b.WriteString("[\n")
+	for _, value := range values {
+		b.WriteString(indent + "    " + value)
+		if !strings.HasPrefix(value, "#") {
+			b.WriteByte(',')
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString(indent + "]")
+	return b.String(), nil
+}
+
+func (store Store) encodeInlineTable(branch sops.TreeBranch, indent string) (string, error) {
+	var values []string
+	for _, item := range branch {
+		// Inline tables cannot contain comments, so they are dropped
+		if _, ok := item.Key.(sops.Comment); ok {
+			continue
+		}
+		value, err := store.encodeValue(item.Value, indent)
+		if err != nil {
+			return "", fmt.Errorf("error encoding value of key %q: %w", item.Key, err)
+		}
+		values = append(values, encodeKey(item.Key.(string))+" = "+value)
+	}
+	if len(values) == 0 {
+		return "{}", nil
+	}
+	return "{ " + strings.Join(values, ", ") + " }", nil
+}
+
+// isTableArray returns whether a list can be emitted as an array of tables,
+// which is the case if it contains at least one table and nothing but
+// tables and comments.
+func isTableArray(list []interface{}) bool {
+	found := false
+	for _, item := range list {
+		switch item.(type) {
+		case sops.TreeBranch:
+			found = true
+		case sops.Comment:
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+func writeComments(w io.Writer, comments []string) {
+	for _, comment := range comments {
+		fmt.Fprintf(w, "#%s\n", comment)
+	}
+}
+
+// isTable returns whether a value can be emitted as a table or as an array of
+// tables.
+func isTable(v interface{}) bool {
+	switch v := v.(type) {
+	case sops.TreeBranch:
+		return true
+	case []interface{}:
+		return isTableArray(v)
+	}
+	return false
+}
+
+// encodeTable writes the key/values of branch to b, followed by its tables
+// and arrays of tables. TOML requires every key/value of a table to appear
+// before any header of a nested table. The order of the items must not
+// change since the MAC covers it, so tables followed by a key/value are
+// written as inline tables instead. Comments preceding a table are moved
+// along with it.
+func (store Store) encodeTable(b *bytes.Buffer, path []string, branch sops.TreeBranch) error {
+	lastValue := -1
+	for i, item := range branch {
+		if _, ok := item.Key.(sops.Comment); !ok && !isTable(item.Value) {
+			lastValue = i
+		}
+	}
+	var comments []string
+	for i, item := range branch {
+		if comment, ok := item.Key.(sops.Comment); ok {
+			comments = append(comments, comment.Value)
+			continue
+		}
+		key, ok := item.Key.(string)
+		if !ok {
+			return fmt.Errorf("TOML keys must be strings, got %T", item.Key)
+		}
+		if i > lastValue && isTable(item.Value) {
+			tablePath := append(append([]string(nil), path...), key)
+			if err := store.encodeSubTable(b, tablePath, comments, item.Value); err != nil {
+				return err
+			}
+			comments = nil
+			continue
+		}
+		writeComments(b, comments)
+		comments = nil
+		value, err := store.encodeValue(item.Value, "")
+		if err != nil {
+			return fmt.Errorf("error encoding value of key %q: %w", key, err)
+		}
+		fmt.Fprintf(b, "%s = %s\n", encodeKey(key), value)
+	}
+	// Trailing comments are written right after the last line of the table,
+	// which is how the parser tells them apart from comments preceding the
+	// next header.
+	writeComments(b, comments)
+	return nil
+}
+
+// encodeSubTable writes a table or an array of tables under its header,
+// preceded by a blank line and its comments.
+func (store Store) encodeSubTable(b *bytes.Buffer, path []string, comments []string, value interface{}) error {
+	switch value := value.(type) {
+	case sops.TreeBranch:
+		if len(comments) > 0 || !isImplicitTable(value) {
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			writeComments(b, comments)
+			fmt.Fprintf(b, "[%s]\n", encodePath(path))
+		}
+		return store.encodeTable(b, path, value)
+	case []interface{}:
+		for _, element := range value {
+			if comment, ok := element.(sops.Comment); ok {
+				comments = append(comments, comment.Value)
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			writeComments(b, comments)
+			comments = nil
+			fmt.Fprintf(b, "[[%s]]\n", encodePath(path))
+			if err := store.encodeTable(b, path, element.(sops.TreeBranch)); err != nil {
+				return err
+			}
+		}
+		writeComments(b, comments)
+	}
+	return nil
+}
+
+// isImplicitTable returns whether a table only contains other tables, in
+// which case its header can be omitted.
+func isImplicitTable(branch sops.TreeBranch) bool {
+	if len(branch) == 0 {
+		return false
+	}
+	for _, item := range branch {
+		if _, ok := item.Key.(sops.Comment); ok {
+			return false
+		}
+		switch value := item.Value.(type) {
+		case sops.TreeBranch:
+		case []interface{}:
+			if !isTableArray(value) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (store Store) tomlFromTreeBranch(branch sops.TreeBranch) ([]byte, error) {
+	var b bytes.Buffer
+	if err := store.encodeTable(&b, nil, branch); err != nil {
+		return nil, fmt.Errorf("Error marshaling to TOML: %s", err)
+	}
+	return b.Bytes(), nil
+}
+
+// metadataToTreeBranch converts the metadata to a sops.TreeBranch. Fields
+// without a value are left out, since TOML has no null, and the key groups
+// are moved after the other fields so they can be written as arrays of
+// tables.
+func metadataToTreeBranch(md stores.Metadata) (sops.TreeBranch, error) {
+	jsonBytes, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(jsonBytes))
+	dec.UseNumber()
+	value, err := treeValueFromJSONDecoder(dec)
+	if err != nil {
+		return nil, err
+	}
+	branch := value.(sops.TreeBranch)
+	sort.SliceStable(branch, func(i, j int) bool {
+		return !isTable(branch[i].Value) && isTable(branch[j].Value)
+	})
+	return branch, nil
+}
+
+func treeValueFromJSONDecoder(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			branch := sops.TreeBranch{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := treeValueFromJSONDecoder(dec)
+				if err != nil {
+					return nil, err
+				}
+				if value != nil {
+					branch = append(branch, sops.TreeItem{Key: key, Value: value})
+				}
+			}
+			_, err := dec.Token()
+			return branch, err
+		case '[':
+			var list []interface{}
+			for dec.More() {
+				value, err := treeValueFromJSONDecoder(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+			}
+			_, err := dec.Token()
+			return list, err
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return int(i), nil
+		}
+		return t.Float64()
+	}
+	return t, nil
+}
+
+// treeValueToGeneric converts a value of the tree to plain maps and slices,
+// dropping comments, so it can be unmarshaled into a struct.
+func treeValueToGeneric(v interface{}) interface{} {
+	switch v := v.(type) {
+	case sops.TreeBranch:
+		m := make(map[string]interface{})
+		for _, item := range v {
+			if key, ok := item.Key.(string); ok {
+				m[key] = treeValueToGeneric(item.Value)
+			}
+		}
+		return m
+	case []interface{}:
+		var list []interface{}
+		for _, item := range v {
+			if _, ok := item.(sops.Comment); !ok {
+				list = append(list, treeValueToGeneric(item))
+			}
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+func metadataFromTreeValue(v interface{}) (*stores.Metadata, error) {
+	if _, ok := v.(sops.TreeBranch); !ok {
+		return nil, fmt.Errorf("SOPS metadata must be a table, got %T", v)
+	}
+	jsonBytes, err := json.Marshal(treeValueToGeneric(v))
+	if err != nil {
+		return nil, err
+	}
+	var md stores.Metadata
+	if err := json.Unmarshal(jsonBytes, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// LoadEncryptedFile loads the contents of an encrypted TOML file onto a
+// sops.Tree runtime object
+func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
+	branch, err := store.treeBranchFromTOML(in)
+	if err != nil {
+		return sops.Tree{}, fmt.Errorf("Error unmarshalling input TOML: %s", err)
+	}
+	var metadata *stores.Metadata
+	for i, item := range branch {
+		if item.Key == stores.SopsMetadataKey {
+			metadata, err = metadataFromTreeValue(item.Value)
+			if err != nil {
+				return sops.Tree{}, fmt.Errorf("Error unmarshalling SOPS metadata: %s", err)
+			}
+			branch = append(branch[:i], branch[i+1:]...)
+			break
+		}
+	}
+	if metadata == nil {
+		return sops.Tree{}, sops.MetadataNotFound
+	}
+	internalMetadata, err := metadata.ToInternal()
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	return sops.Tree{
+		Branches: sops.TreeBranches{
+			branch,
+		},
+		Metadata: internalMetadata,
+	}, nil
+}
+
+// LoadPlainFile loads the contents of a plaintext TOML file onto a
+// sops.TreeBranches runtime object
+func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	branch, err := store.treeBranchFromTOML(in)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling input TOML: %s", err)
+	}
+	// Prevent use of reserved keywords
+	if stores.HasSopsTopLevelKey(branch) {
+		return nil, fmt.Errorf("TOML doc used reserved word '%v'", stores.SopsMetadataKey)
+	}
+	return sops.TreeBranches{
+		branch,
+	}, nil
+}
+
+// EmitEncryptedFile returns the encrypted bytes of the TOML file corresponding
+// to a sops.Tree runtime object
+func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
+	metadata, err := metadataToTreeBranch(stores.MetadataFromInternal(in.Metadata))
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling metadata: %s", err)
+	}
+	branch := append(sops.TreeBranch(nil), in.Branches[0]...)
+	branch = append(branch, sops.TreeItem{
+		Key:   stores.SopsMetadataKey,
+		Value: metadata,
+	})
+	return store.tomlFromTreeBranch(branch)
+}
+
+// EmitPlainFile returns the plaintext bytes of the TOML file corresponding to
+// a sops.TreeBranches runtime object
+func (store *Store) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
+	return store.tomlFromTreeBranch(in[0])
+}
+
+// EmitValue returns bytes corresponding to a single encoded value
+// in a generic interface{} object
+func (store *Store) EmitValue(v interface{}) ([]byte, error) {
+	if branch, ok := v.(sops.TreeBranch); ok {
+		return store.tomlFromTreeBranch(branch)
+	}
+	s, err := store.encodeValue(v, "")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// EmitExample returns the bytes corresponding to an example complex tree
+func (store *Store) EmitExample() []byte {
+	bytes, err := store.EmitPlainFile(stores.ExampleComplexTree.Branches)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+// HasSopsTopLevelKey checks whether a top-level "sops" key exists.
+func (store *Store) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
+	return stores.HasSopsTopLevelKey(branch)
+}
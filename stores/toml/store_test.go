@@ -0,0 +1,468 @@
+package toml
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var PLAIN = []byte(strings.TrimLeft(`
+# comment 0
+title = "TOML example"
+count = 1_000
+pi = 3.14
+enabled = true
+dob = 1979-05-27T07:32:00Z
+day = 1979-05-27
+ports = [8000, 8001]
+point = { x = 1, y = 2 }
+
+# comment 1
+[owner]
+name = "Tom" # comment 2
+
+[servers.alpha]
+ip = "10.0.0.1"
+
+[[products]]
+name = "Hammer"
+
+[[products]]
+name = "Nail"
+`, "\n"))
+
+var BRANCH = sops.TreeBranch{
+	sops.TreeItem{
+		Key:   sops.Comment{Value: " comment 0"},
+		Value: nil,
+	},
+	sops.TreeItem{
+		Key:   "title",
+		Value: "TOML example",
+	},
+	sops.TreeItem{
+		Key:   "count",
+		Value: 1000,
+	},
+	sops.TreeItem{
+		Key:   "pi",
+		Value: 3.14,
+	},
+	sops.TreeItem{
+		Key:   "enabled",
+		Value: true,
+	},
+	sops.TreeItem{
+		Key:   "dob",
+		Value: sops.Timestamp("1979-05-27T07:32:00Z"),
+	},
+	sops.TreeItem{
+		Key:   "day",
+		Value: sops.Timestamp("1979-05-27"),
+	},
+	sops.TreeItem{
+		Key:   "ports",
+		Value: []interface{}{8000, 8001},
+	},
+	sops.TreeItem{
+		Key: "point",
+		Value: sops.TreeBranch{
+			sops.TreeItem{
+				Key:   "x",
+				Value: 1,
+			},
+			sops.TreeItem{
+				Key:   "y",
+				Value: 2,
+			},
+		},
+	},
+	sops.TreeItem{
+		Key:   sops.Comment{Value: " comment 1"},
+		Value: nil,
+	},
+	sops.TreeItem{
+		Key: "owner",
+		Value: sops.TreeBranch{
+			sops.TreeItem{
+				Key:   "name",
+				Value: "Tom",
+			},
+			sops.TreeItem{
+				Key:   sops.Comment{Value: " comment 2"},
+				Value: nil,
+			},
+		},
+	},
+	sops.TreeItem{
+		Key: "servers",
+		Value: sops.TreeBranch{
+			sops.TreeItem{
+				Key: "alpha",
+				Value: sops.TreeBranch{
+					sops.TreeItem{
+						Key:   "ip",
+						Value: "10.0.0.1",
+					},
+				},
+			},
+		},
+	},
+	sops.TreeItem{
+		Key: "products",
+		Value: []interface{}{
+			sops.TreeBranch{
+				sops.TreeItem{
+					Key:   "name",
+					Value: "Hammer",
+				},
+			},
+			sops.TreeBranch{
+				sops.TreeItem{
+					Key:   "name",
+					Value: "Nail",
+				},
+			},
+		},
+	},
+}
+
+// EMITTED is PLAIN as emitted by the store: line comments are moved to their
+// own line, integers lose their separators and the trailing inline table is
+// written as a regular table.
+var EMITTED = []byte(strings.TrimLeft(`
+# comment 0
+title = "TOML example"
+count = 1000
+pi = 3.14
+enabled = true
+dob = 1979-05-27T07:32:00Z
+day = 1979-05-27
+ports = [8000, 8001]
+
+[point]
+x = 1
+y = 2
+
+# comment 1
+[owner]
+name = "Tom"
+# comment 2
+
+[servers.alpha]
+ip = "10.0.0.1"
+
+[[products]]
+name = "Hammer"
+
+[[products]]
+name = "Nail"
+`, "\n"))
+
+func TestLoadPlainFile(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(PLAIN)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCH, branches[0])
+}
+
+func TestLoadPlainFileArrayComments(t *testing.T) {
+	in := []byte(`list = [
+  # first
+  "a",
+  "b", # after b
+]
+`)
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{
+			Key: "list",
+			Value: []interface{}{
+				sops.Comment{Value: " first"},
+				"a",
+				"b",
+				sops.Comment{Value: " after b"},
+			},
+		},
+	}, branches[0])
+}
+
+func TestLoadPlainFileNumbers(t *testing.T) {
+	in := []byte(`hex = 0xff
+oct = 0o17
+bin = 0b101
+neg = -17
+exp = 5e+22
+inf = -inf
+`)
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	assert.Equal(t, 255, branches[0][0].Value)
+	assert.Equal(t, 15, branches[0][1].Value)
+	assert.Equal(t, 5, branches[0][2].Value)
+	assert.Equal(t, -17, branches[0][3].Value)
+	assert.Equal(t, 5e+22, branches[0][4].Value)
+	assert.Equal(t, "-inf", encodeFloat(branches[0][5].Value.(float64)))
+}
+
+func TestLargeNumbersAndDatesRoundTrip(t *testing.T) {
+	in := []byte(`account = 12_345_678_901_234_567_890
+rate = 0.10000000000000000001
+local = 1979-05-27T07:32:00.999
+time = 07:32:00
+offset = 1979-05-27 07:32:00z
+`)
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		{Key: "account", Value: sops.Number("12345678901234567890")},
+		{Key: "rate", Value: sops.Number("0.10000000000000000001")},
+		{Key: "local", Value: sops.Timestamp("1979-05-27T07:32:00.999")},
+		{Key: "time", Value: sops.Timestamp("07:32:00")},
+		{Key: "offset", Value: sops.Timestamp("1979-05-27 07:32:00z")},
+	}, branches[0])
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Replace(string(in), "12_345_678_901_234_567_890", "12345678901234567890", 1), string(bytes))
+
+	// Timestamps which are not valid TOML dates are written as strings.
+	bytes, err = (&Store{}).EmitPlainFile(sops.TreeBranches{{{Key: "day", Value: sops.Timestamp("2024-1-2")}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "day = \"2024-1-2\"\n", string(bytes))
+}
+
+func TestLoadPlainFileDottedKeys(t *testing.T) {
+	in := []byte(`a.b = 1
+a.c = 2
+
+[x.y]
+z = 3
+
+[x]
+w = 4
+`)
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{
+			Key: "a",
+			Value: sops.TreeBranch{
+				sops.TreeItem{Key: "b", Value: 1},
+				sops.TreeItem{Key: "c", Value: 2},
+			},
+		},
+		sops.TreeItem{
+			Key: "x",
+			Value: sops.TreeBranch{
+				sops.TreeItem{
+					Key: "y",
+					Value: sops.TreeBranch{
+						sops.TreeItem{Key: "z", Value: 3},
+					},
+				},
+				sops.TreeItem{Key: "w", Value: 4},
+			},
+		},
+	}, branches[0])
+}
+
+func TestLoadPlainFileDuplicateKey(t *testing.T) {
+	_, err := (&Store{}).LoadPlainFile([]byte("a = 1\na = 2\n"))
+	assert.NotNil(t, err)
+	_, err = (&Store{}).LoadPlainFile([]byte("[a]\n[a]\n"))
+	assert.NotNil(t, err)
+}
+
+func TestLoadPlainFileInvalid(t *testing.T) {
+	_, err := (&Store{}).LoadPlainFile([]byte("a = \n"))
+	assert.NotNil(t, err)
+}
+
+func TestLoadPlainFileReservedKey(t *testing.T) {
+	_, err := (&Store{}).LoadPlainFile([]byte("[sops]\nfoo = 1\n"))
+	assert.NotNil(t, err)
+}
+
+func TestEmitPlainFile(t *testing.T) {
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{BRANCH})
+	assert.Nil(t, err)
+	assert.Equal(t, string(EMITTED), string(bytes))
+}
+
+func TestEmitPlainFileKeepsOrder(t *testing.T) {
+	// A table followed by a value cannot be written as a [table] without
+	// changing the order of the items, so it is written inline.
+	branch := sops.TreeBranch{
+		sops.TreeItem{
+			Key: "nested",
+			Value: sops.TreeBranch{
+				sops.TreeItem{Key: "foo", Value: "bar"},
+			},
+		},
+		sops.TreeItem{Key: "key with spaces", Value: "multi\nline \"value\""},
+		sops.TreeItem{Key: "float", Value: 1.0},
+	}
+	bytes, err := (&Store{}).EmitPlainFile(sops.TreeBranches{branch})
+	assert.Nil(t, err)
+	assert.Equal(t, `nested = { foo = "bar" }
+"key with spaces" = "multi\nline \"value\""
+float = 1.0
+`, string(bytes))
+	branches, err := (&Store{}).LoadPlainFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, branch, branches[0])
+}
+
+func TestEmitPlainFileNull(t *testing.T) {
+	_, err := (&Store{}).EmitPlainFile(sops.TreeBranches{{
+		sops.TreeItem{Key: "foo", Value: nil},
+	}})
+	assert.NotNil(t, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(PLAIN)
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, EMITTED, bytes)
+	branches, err = (&Store{}).LoadPlainFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCH, branches[0])
+}
+
+func TestEncryptedFileRoundTrip(t *testing.T) {
+	store := NewStore(&config.TOMLStoreConfig{})
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{BRANCH},
+		Metadata: sops.Metadata{
+			KeyGroups: []sops.KeyGroup{{
+				&age.MasterKey{
+					Recipient:    "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw",
+					EncryptedKey: "-----BEGIN AGE ENCRYPTED FILE-----\nfoo\n-----END AGE ENCRYPTED FILE-----\n",
+				},
+			}},
+			UnencryptedSuffix: "_unencrypted",
+			Version:           "1.0",
+			LastModified:      time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	bytes, err := store.EmitEncryptedFile(tree)
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), "\n[sops]\n")
+	assert.Contains(t, string(bytes), "\n[[sops.age]]\n")
+
+	loaded, err := store.LoadEncryptedFile(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, BRANCH, loaded.Branches[0])
+	assert.Equal(t, tree.Metadata.UnencryptedSuffix, loaded.Metadata.UnencryptedSuffix)
+	assert.Equal(t, tree.Metadata.Version, loaded.Metadata.Version)
+	assert.Equal(t, tree.Metadata.LastModified, loaded.Metadata.LastModified)
+	assert.Equal(t, tree.Metadata.KeyGroups, loaded.Metadata.KeyGroups)
+}
+
+func TestLoadEncryptedFileWithoutMetadata(t *testing.T) {
+	_, err := (&Store{}).LoadEncryptedFile(PLAIN)
+	assert.Equal(t, sops.MetadataNotFound, err)
+}
+
+func TestEmitEncryptedFileStability(t *testing.T) {
+	// emit the same tree multiple times to ensure the output is stable
+	// i.e. emitting the same tree always yields exactly the same output
+	var previous []byte
+	for i := 0; i < 10; i += 1 {
+		bytes, err := (&Store{}).EmitEncryptedFile(sops.Tree{
+			Branches: sops.TreeBranches{BRANCH},
+		})
+		assert.Nil(t, err)
+		assert.NotEmpty(t, bytes)
+		if previous != nil {
+			assert.Equal(t, previous, bytes)
+		}
+		previous = bytes
+	}
+}
+
+func TestEmitValue(t *testing.T) {
+	bytes, err := (&Store{}).EmitValue([]interface{}{"a", 1, true})
+	assert.Nil(t, err)
+	assert.Equal(t, `["a", 1, true]`, string(bytes))
+	bytes, err = (&Store{}).EmitValue(sops.TreeBranch{
+		sops.TreeItem{Key: "foo", Value: "bar"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "foo = \"bar\"\n", string(bytes))
+}
+
+func TestEmitExample(t *testing.T) {
+	bytes := (&Store{}).EmitExample()
+	branches, err := (&Store{}).LoadPlainFile(bytes)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, branches[0])
+}
+
+func TestHasSopsTopLevelKey(t *testing.T) {
+	ok := (&Store{}).HasSopsTopLevelKey(sops.TreeBranch{
+		sops.TreeItem{
+			Key:   "sops",
+			Value: "value",
+		},
+	})
+	assert.True(t, ok)
+	ok = (&Store{}).HasSopsTopLevelKey(BRANCH)
+	assert.False(t, ok)
+}
+
+func TestLoadPlainFileCommentsBeforeHeaders(t *testing.T) {
+	// Comments directly following a line stay in the table of that line,
+	// comments following a blank line belong to the next header.
+	in := []byte(`[a]
+foo = 1
+# end of a
+
+# before b
+[b]
+bar = 2
+
+# before products
+[[products]]
+name = "Hammer"
+`)
+	expected := sops.TreeBranch{
+		sops.TreeItem{
+			Key: "a",
+			Value: sops.TreeBranch{
+				sops.TreeItem{Key: "foo", Value: 1},
+				sops.TreeItem{Key: sops.Comment{Value: " end of a"}},
+			},
+		},
+		sops.TreeItem{Key: sops.Comment{Value: " before b"}},
+		sops.TreeItem{
+			Key: "b",
+			Value: sops.TreeBranch{
+				sops.TreeItem{Key: "bar", Value: 2},
+			},
+		},
+		sops.TreeItem{Key: sops.Comment{Value: " before products"}},
+		sops.TreeItem{
+			Key: "products",
+			Value: []interface{}{
+				sops.TreeBranch{
+					sops.TreeItem{Key: "name", Value: "Hammer"},
+				},
+			},
+		},
+	}
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, branches[0])
+
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(in), string(bytes))
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/config"
@@ -15,6 +17,10 @@ import (
 
 const IndentDefault = 4
 
+// mergeKey is the key of merge keys, which merge the mappings they refer to
+// into the mapping they are in.
+const mergeKey = "<<"
+
 // Store handles storage of YAML data
 type Store struct {
 	config config.YAMLStoreConfig
@@ -24,82 +30,428 @@ func NewStore(c *config.YAMLStoreConfig) *Store {
 	return &Store{config: *c}
 }
 
-func (store Store) appendCommentToList(comment string, list []interface{}) []interface{} {
+// style is the style a value is written with, and the style of its key if
+// it is the value of a mapping item. It is kept in sops.Styled values, only
+// when it differs from the style the encoder would choose.
+type style struct {
+	key   yaml.Style
+	value yaml.Style
+	// indent is the number of columns the first indented block collection
+	// of a file is indented by from its key, if it is not IndentDefault, and
+	// compact is set for block sequences which are not indented from their
+	// key at all.
+	indent  int
+	compact bool
+	// strip is set for block scalars without a final line break.
+	strip bool
+	// text is the value of a folded scalar, and folded the lines it is
+	// written with in its file, which are written back as long as the value
+	// is not changed. They are only kept if the lines differ from the ones
+	// foldedLines returns for the value.
+	text   string
+	folded string
+	// null is the text of a null value, if it is neither "null" nor empty,
+	// and empty is set for null values written as nothing, as in "key:".
+	// Only the datatype of encrypted null values is encrypted, so they are
+	// written as "null" once decrypted from a file.
+	null  string
+	empty bool
+}
+
+const (
+	quotingStyles = yaml.DoubleQuotedStyle | yaml.SingleQuotedStyle
+	scalarStyles  = quotingStyles | yaml.LiteralStyle | yaml.FoldedStyle
+)
+
+// styleOf returns the style of a value, looking through anchors.
+func styleOf(v interface{}) style {
+	switch v := v.(type) {
+	case sops.Anchored:
+		return styleOf(v.Value)
+	case sops.Styled:
+		if s, ok := v.Style.(style); ok {
+			return s
+		}
+	}
+	return style{}
+}
+
+// decoder converts the nodes of the documents of a file to tree branches.
+// The parser does not keep blank lines, so they are found in the lines of
+// the file, and converted to empty comments like in other stores.
+type decoder struct {
+	lines []string
+	// next is the index of the first line which has not been converted yet.
+	next int
+	// keptBlankLines is the number of blank lines at the end of the last
+	// block scalar, which are part of its value.
+	keptBlankLines int
+	// flow is set while converting the content of a flow collection, in
+	// which blank lines are not kept.
+	flow bool
+	// encrypted is set while converting an encrypted file, in which
+	// encrypted values may be written as block scalars.
+	encrypted bool
+	// indented is set once the indentation of the file is found, which is
+	// only kept in the style of the collection it is found in.
+	indented bool
+}
+
+func newDecoder(in []byte) *decoder {
+	return &decoder{lines: strings.Split(string(in), "\n")}
+}
+
+// blankLinesBefore returns an empty comment for each blank line right before
+// the line with the given number, and marks the lines up to it as converted.
+func (d *decoder) blankLinesBefore(line int) []sops.Comment {
+	if line <= 0 {
+		return nil
+	}
+	var count int
+	for i := line - 2; i >= d.next && strings.TrimSpace(d.lines[i]) == ""; i-- {
+		count++
+	}
+	count -= d.keptBlankLines
+	d.keptBlankLines = 0
+	if line > d.next {
+		d.next = line
+	}
+	if d.flow || count <= 0 {
+		return nil
+	}
+	return make([]sops.Comment, count)
+}
+
+// commentLine returns the number of the next line which only holds the
+// comment, or 0 if there is none.
+func (d *decoder) commentLine(comment string) int {
+	for i := d.next; i < len(d.lines); i++ {
+		if strings.TrimSpace(d.lines[i]) == comment {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// comments converts the lines of a comment to sops.Comment values, preceded
+// by the blank lines before them. Line comments follow a value on the same
+// line, so there are no blank lines before them.
+func (d *decoder) comments(comment string, lineComment bool) []sops.Comment {
+	var result []sops.Comment
 	if comment != "" {
 		for _, commentLine := range strings.Split(comment, "\n") {
 			if commentLine != "" {
-				list = append(list, sops.Comment{
-					Value: commentLine[1:],
+				if !lineComment {
+					result = append(result, d.blankLinesBefore(d.commentLine(strings.TrimSpace(commentLine)))...)
+				}
+				result = append(result, sops.Comment{
+					Value:  commentLine[1:],
+					Inline: lineComment,
 				})
 			}
 		}
 	}
+	return result
+}
+
+func (d *decoder) appendCommentToList(comment string, lineComment bool, list []interface{}) []interface{} {
+	for _, c := range d.comments(comment, lineComment) {
+		list = append(list, c)
+	}
 	return list
 }
 
-func (store Store) appendCommentToMap(comment string, branch sops.TreeBranch) sops.TreeBranch {
-	if comment != "" {
-		for _, commentLine := range strings.Split(comment, "\n") {
-			if commentLine != "" {
-				branch = append(branch, sops.TreeItem{
-					Key: sops.Comment{
-						Value: commentLine[1:],
-					},
-					Value: nil,
-				})
+func (d *decoder) appendCommentToMap(comment string, lineComment bool, branch sops.TreeBranch) sops.TreeBranch {
+	for _, c := range d.comments(comment, lineComment) {
+		branch = append(branch, sops.TreeItem{
+			Key:   c,
+			Value: nil,
+		})
+	}
+	return branch
+}
+
+func (d *decoder) appendBlankLinesToList(line int, list []interface{}) []interface{} {
+	for _, c := range d.blankLinesBefore(line) {
+		list = append(list, c)
+	}
+	return list
+}
+
+func (d *decoder) appendBlankLinesToMap(line int, branch sops.TreeBranch) sops.TreeBranch {
+	for _, c := range d.blankLinesBefore(line) {
+		branch = append(branch, sops.TreeItem{Key: c, Value: nil})
+	}
+	return branch
+}
+
+// nodeToTreeValue converts a node to a tree value. Anchored nodes are converted
+// to sops.Anchored values, and aliases to sops.Alias values, so that the values
+// they refer to are only encrypted once and the anchors are kept. The style of
+// the node and of its key, if it is the value of a mapping item, is kept in a
+// sops.Styled value.
+func (d *decoder) nodeToTreeValue(node *yaml.Node, key *yaml.Node, commentsWereHandled bool) (interface{}, error) {
+	var s style
+	if key != nil {
+		indent, ok := d.indentation(node, key)
+		switch {
+		case !ok:
+		case indent == 0:
+			s.compact = node.Kind == yaml.SequenceNode
+		case !d.indented:
+			// The file is indented like its first indented collection.
+			d.indented = true
+			if indent != IndentDefault {
+				s.indent = indent
 			}
 		}
 	}
-	return branch
+	value, err := d.nodeToUnanchoredTreeValue(node, commentsWereHandled)
+	if err != nil {
+		return nil, err
+	}
+	s.value = d.valueStyle(node, value)
+	if key != nil {
+		var keyValue interface{}
+		key.Decode(&keyValue)
+		s.key = d.valueStyle(key, keyValue) & quotingStyles
+	}
+	if node.Kind == yaml.ScalarNode {
+		if s.value&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			s.strip = !strings.HasSuffix(node.Value, "\n")
+		}
+		if s.value&yaml.FoldedStyle != 0 {
+			s.text, s.folded = d.foldedSource(node)
+		}
+		if value == nil && node.Value != "null" && !(d.flow && node.Value == "") {
+			// Empty nulls are written as empty strings in flow
+			// collections, so they are not kept there.
+			s.null = node.Value
+			s.empty = node.Value == ""
+		}
+	}
+	if s != (style{}) {
+		value = sops.Styled{Style: s, Value: value}
+	}
+	if node.Anchor == "" {
+		return value, nil
+	}
+	return sops.Anchored{Anchor: node.Anchor, Value: value}, nil
+}
+
+// valueStyle returns the style of a node to keep, which is none if the
+// encoder would choose it for the value of the node.
+func (d *decoder) valueStyle(node *yaml.Node, value interface{}) yaml.Style {
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		// Empty collections are always written in flow style.
+		if len(node.Content) == 0 {
+			return 0
+		}
+		return node.Style & yaml.FlowStyle
+	case yaml.ScalarNode:
+		s := node.Style & scalarStyles
+		if _, ok := value.(string); !ok || s == 0 {
+			return 0
+		}
+		var encoded yaml.Node
+		encoded.Encode(value)
+		if encoded.Style&scalarStyles == s {
+			return 0
+		}
+		// Values which cannot be plain in a flow collection, such as
+		// encrypted values, are single-quoted by the encoder, unless they
+		// are tagged because their single quotes are kept, see
+		// tagQuotesInFlow.
+		if d.flow && s == yaml.SingleQuotedStyle && encoded.Style&scalarStyles == 0 &&
+			node.Style&yaml.TaggedStyle == 0 && quotedInFlow(&encoded) {
+			return 0
+		}
+		return s
+	}
+	return 0
+}
+
+// indentation returns the number of columns the block collection of a
+// mapping item is indented by from its key, if there is one.
+func (d *decoder) indentation(node *yaml.Node, key *yaml.Node) (int, bool) {
+	if node.Style&yaml.FlowStyle != 0 || len(node.Content) == 0 {
+		return 0, false
+	}
+	// The column of an anchored or tagged node is the one of its anchor or
+	// tag, so the column of its first item is used.
+	column := node.Content[0].Column
+	switch node.Kind {
+	case yaml.MappingNode:
+	case yaml.SequenceNode:
+		// The column of an item is the one of its value, after its dash.
+		line := d.lines[node.Content[0].Line-1]
+		if column > len(line) {
+			return 0, false
+		}
+		column = strings.LastIndex(line[:column-1], "-") + 1
+		if column == 0 {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	return column - key.Column, column >= key.Column
+}
+
+// foldedSource returns the value of a folded scalar and the lines it is
+// written with in the file, without their indentation, unless they are the
+// lines foldedLines returns for the value.
+func (d *decoder) foldedSource(node *yaml.Node) (string, string) {
+	var lines []string
+	indent := -1
+	for _, line := range d.lines[min(node.Line, len(d.lines)):] {
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		if indent < 0 {
+			indent = n
+		}
+		if n < indent {
+			break
+		}
+		lines = append(lines, line[indent:])
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	body := strings.TrimRight(node.Value, "\n")
+	if slices.Equal(lines, foldedLines(body)) {
+		return "", ""
+	}
+	// The lines are only kept if they are the ones of the value, which
+	// they are not if the scalar has an indentation indicator for example.
+	var value string
+	if err := yaml.Unmarshal([]byte(">-\n  "+strings.Join(lines, "\n  ")), &value); err != nil || value != body {
+		return "", ""
+	}
+	return node.Value, strings.Join(lines, "\n")
+}
+
+// foldedLines returns the lines a folded scalar is written with for its
+// value without its final line breaks, with a line for each line of the
+// value, or nil if the value cannot be written as a folded scalar this way.
+func foldedLines(body string) []string {
+	var lines []string
+	for i, line := range strings.Split(body, "\n") {
+		// Lines starting with white space are not folded, and the encoder
+		// does not write lines ending with it in block scalars.
+		if line != strings.TrimSpace(line) || (i == 0 && line == "") {
+			return nil
+		}
+		// The line breaks between lines are folded, so that a line
+		// break is written as an empty line.
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// quotedInFlow returns whether the encoder quotes the scalar node in a flow
+// collection.
+func quotedInFlow(node *yaml.Node) bool {
+	out, err := yaml.Marshal(&yaml.Node{
+		Kind:    yaml.SequenceNode,
+		Style:   yaml.FlowStyle,
+		Content: []*yaml.Node{node},
+	})
+	return err != nil || string(out) != "["+node.Value+"]\n"
+}
+
+// isEncrypted returns whether a string is an encrypted value.
+func isEncrypted(s string) bool {
+	return strings.HasPrefix(s, "ENC[") && strings.HasSuffix(s, "]") && !strings.Contains(s, "\n")
 }
 
-func (store Store) nodeToTreeValue(node *yaml.Node, commentsWereHandled bool) (interface{}, error) {
+func (d *decoder) nodeToUnanchoredTreeValue(node *yaml.Node, commentsWereHandled bool) (interface{}, error) {
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		flow := d.flow
+		d.flow = flow || node.Style&yaml.FlowStyle != 0
+		defer func() { d.flow = flow }()
+	}
 	switch node.Kind {
 	case yaml.DocumentNode:
 		panic("documents should never be passed here")
 	case yaml.SequenceNode:
 		var result []interface{}
 		if !commentsWereHandled {
-			result = store.appendCommentToList(node.HeadComment, result)
-			result = store.appendCommentToList(node.LineComment, result)
+			result = d.appendCommentToList(node.HeadComment, false, result)
+			result = d.appendCommentToList(node.LineComment, true, result)
 		}
 		for _, item := range node.Content {
-			result = store.appendCommentToList(item.HeadComment, result)
-			result = store.appendCommentToList(item.LineComment, result)
-			val, err := store.nodeToTreeValue(item, true)
+			result = d.appendCommentToList(item.HeadComment, false, result)
+			result = d.appendBlankLinesToList(item.Line, result)
+			val, err := d.nodeToTreeValue(item, nil, true)
 			if err != nil {
 				return nil, err
 			}
 			result = append(result, val)
-			result = store.appendCommentToList(item.FootComment, result)
+			result = d.appendCommentToList(item.LineComment, true, result)
+			result = d.appendCommentToList(item.FootComment, false, result)
 		}
 		if !commentsWereHandled {
-			result = store.appendCommentToList(node.FootComment, result)
+			result = d.appendCommentToList(node.FootComment, false, result)
 		}
 		return result, nil
 	case yaml.MappingNode:
 		branch := make(sops.TreeBranch, 0)
-		return store.appendYamlNodeToTreeBranch(node, branch, commentsWereHandled)
+		return d.appendYamlNodeToTreeBranch(node, branch, commentsWereHandled)
 	case yaml.ScalarNode:
 		var result interface{}
 		node.Decode(&result)
+		switch value := result.(type) {
+		case uint64:
+			// Integers too large for an int.
+			if n, err := sops.ParseInt(node.Value); err == nil {
+				result = n
+			}
+		case float64:
+			if n, err := sops.ParseFloat(node.Value); err == nil {
+				result = n
+			}
+		case time.Time:
+			result = sops.Timestamp(node.Value)
+		case string:
+			// Encrypted values are written as block scalars with the final
+			// line break of the value they hold.
+			value = strings.TrimRight(value, "\n")
+			if d.encrypted && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 && isEncrypted(value) {
+				result = value
+			}
+		}
+		if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			// Blank lines at the end of a scalar kept with the "+"
+			// indicator are part of its value.
+			d.keptBlankLines = max(0, len(node.Value)-len(strings.TrimRight(node.Value, "\n"))-1)
+		}
 		return result, nil
 	case yaml.AliasNode:
-		return store.nodeToTreeValue(node.Alias, false)
+		return sops.Alias{Anchor: node.Value}, nil
 	}
 	return nil, nil
 }
 
-func (store Store) appendYamlNodeToTreeBranch(node *yaml.Node, branch sops.TreeBranch, commentsWereHandled bool) (sops.TreeBranch, error) {
+func (d *decoder) appendYamlNodeToTreeBranch(node *yaml.Node, branch sops.TreeBranch, commentsWereHandled bool) (sops.TreeBranch, error) {
 	var err error
 	if !commentsWereHandled {
-		branch = store.appendCommentToMap(node.HeadComment, branch)
-		branch = store.appendCommentToMap(node.LineComment, branch)
+		branch = d.appendCommentToMap(node.HeadComment, false, branch)
+		branch = d.appendCommentToMap(node.LineComment, true, branch)
 	}
 	switch node.Kind {
 	case yaml.DocumentNode:
 		for _, item := range node.Content {
-			branch, err = store.appendYamlNodeToTreeBranch(item, branch, false)
+			branch, err = d.appendYamlNodeToTreeBranch(item, branch, false)
 			if err != nil {
 				return nil, err
 			}
@@ -110,16 +462,22 @@ func (store Store) appendYamlNodeToTreeBranch(node *yaml.Node, branch sops.TreeB
 		for i := 0; i < len(node.Content); i += 2 {
 			key := node.Content[i]
 			value := node.Content[i+1]
-			branch = store.appendCommentToMap(key.HeadComment, branch)
-			branch = store.appendCommentToMap(key.LineComment, branch)
+			branch = d.appendCommentToMap(key.HeadComment, false, branch)
+			branch = d.appendBlankLinesToMap(key.Line, branch)
 			handleValueComments := value.Kind == yaml.ScalarNode || value.Kind == yaml.AliasNode
 			if handleValueComments {
-				branch = store.appendCommentToMap(value.HeadComment, branch)
-				branch = store.appendCommentToMap(value.LineComment, branch)
+				branch = d.appendCommentToMap(value.HeadComment, false, branch)
+			}
+			// Line comments are kept after the item, on the line of which
+			// they are written.
+			lineComments := []string{key.LineComment}
+			if handleValueComments || value.Style&yaml.FlowStyle != 0 {
+				lineComments = append(lineComments, value.LineComment)
+				value.LineComment = ""
 			}
 			var keyValue interface{}
 			key.Decode(&keyValue)
-			valueTV, err := store.nodeToTreeValue(value, handleValueComments)
+			valueTV, err := d.nodeToTreeValue(value, key, handleValueComments)
 			if err != nil {
 				return nil, err
 			}
@@ -127,10 +485,13 @@ func (store Store) appendYamlNodeToTreeBranch(node *yaml.Node, branch sops.TreeB
 				Key:   keyValue,
 				Value: valueTV,
 			})
+			for _, comment := range lineComments {
+				branch = d.appendCommentToMap(comment, true, branch)
+			}
 			if handleValueComments {
-				branch = store.appendCommentToMap(value.FootComment, branch)
+				branch = d.appendCommentToMap(value.FootComment, false, branch)
 			}
-			branch = store.appendCommentToMap(key.FootComment, branch)
+			branch = d.appendCommentToMap(key.FootComment, false, branch)
 		}
 	case yaml.ScalarNode:
 		// A empty document with a document start marker without comments results in null
@@ -139,21 +500,21 @@ func (store Store) appendYamlNodeToTreeBranch(node *yaml.Node, branch sops.TreeB
 		}
 		return nil, fmt.Errorf("YAML documents that are values are not supported")
 	case yaml.AliasNode:
-		branch, err = store.appendYamlNodeToTreeBranch(node.Alias, branch, false)
+		branch, err = d.appendYamlNodeToTreeBranch(node.Alias, branch, false)
 		if err != nil {
 			// This should never happen since node.Alias was already successfully decoded before
 			return nil, err
 		}
 	}
 	if !commentsWereHandled {
-		branch = store.appendCommentToMap(node.FootComment, branch)
+		branch = d.appendCommentToMap(node.FootComment, false, branch)
 	}
 	return branch, nil
 }
 
-func (store Store) yamlDocumentNodeToTreeBranch(in yaml.Node) (sops.TreeBranch, error) {
+func (d *decoder) yamlDocumentNodeToTreeBranch(in yaml.Node) (sops.TreeBranch, error) {
 	branch := make(sops.TreeBranch, 0)
-	return store.appendYamlNodeToTreeBranch(&in, branch, false)
+	return d.appendYamlNodeToTreeBranch(&in, branch, false)
 }
 
 func (store *Store) addCommentsHead(node *yaml.Node, comments []string) []string {
@@ -182,6 +543,31 @@ func (store *Store) addCommentsFoot(node *yaml.Node, comments []string) []string
 
 func (store *Store) treeValueToNode(in interface{}) *yaml.Node {
 	switch in := in.(type) {
+	case sops.Anchored:
+		node := store.treeValueToNode(in.Value)
+		node.Anchor = in.Anchor
+		return node
+	case sops.Alias:
+		return &yaml.Node{Kind: yaml.AliasNode, Value: in.Anchor}
+	case sops.Styled:
+		node := store.treeValueToNode(in.Value)
+		if s, ok := in.Style.(style); ok {
+			applyStyle(node, s)
+		}
+		return node
+	case sops.Number:
+		return plainScalar(string(in))
+	case []byte:
+		// Strings which are not valid UTF-8 are encoded as !!binary.
+		var valueNode = &yaml.Node{}
+		valueNode.Encode(string(in))
+		return valueNode
+	case sops.Timestamp:
+		node := plainScalar(string(in))
+		if node.Tag != "!!timestamp" {
+			node.Encode(string(in))
+		}
+		return node
 	case sops.TreeBranch:
 		var mapping = &yaml.Node{}
 		mapping.Kind = yaml.MappingNode
@@ -199,12 +585,260 @@ func (store *Store) treeValueToNode(in interface{}) *yaml.Node {
 	}
 }
 
+// plainScalar returns a plain scalar node with the tag its value resolves to.
+func plainScalar(value string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	node.Tag = node.ShortTag()
+	return node
+}
+
+// setStyle sets the style of a node, if it can be written with it. Only
+// strings can be quoted, and only collections can be written in flow style.
+func setStyle(node *yaml.Node, s yaml.Style) {
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		node.Style |= s & yaml.FlowStyle
+	case yaml.ScalarNode:
+		if s&scalarStyles != 0 && node.Tag == "!!str" {
+			node.Style = s & scalarStyles
+		}
+	}
+}
+
+// applyStyle sets the style of the node of a value to the style it was
+// written with, as far as it still applies to the value.
+func applyStyle(node *yaml.Node, s style) {
+	setStyle(node, s.value)
+	switch {
+	case node.Tag == "!!null" && (s.null != "" || s.empty):
+		node.Value = s.null
+	case node.Tag == "!!str" && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		if !s.strip && isEncrypted(node.Value) {
+			// Encrypted values are written with the final line break of
+			// the value they hold, so that the block scalar is written
+			// with the same indicator.
+			node.Value += "\n"
+		}
+		if node.Style == yaml.FoldedStyle {
+			writeFolded(node, s)
+		}
+	}
+}
+
+// foldedMarker is written in the line comment of folded scalars, which are
+// encoded as literal scalars with their lines, as the encoder does not keep
+// the lines of folded scalars and writes an extra line after them. The
+// indicator of the literal scalars is replaced once the documents are
+// encoded.
+const foldedMarker = "\x02"
+
+// writeFolded makes the encoder write a folded scalar with the lines it was
+// written with, or else with a line for each line of its value.
+func writeFolded(node *yaml.Node, s style) {
+	body := strings.TrimRight(node.Value, "\n")
+	lines := foldedLines(body)
+	if s.folded != "" && node.Value == s.text {
+		lines = strings.Split(s.folded, "\n")
+	}
+	if lines == nil {
+		return
+	}
+	literal := &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   "!!str",
+		Style: yaml.LiteralStyle,
+		Value: strings.Join(lines, "\n") + node.Value[len(body):],
+	}
+	// The encoder writes values it cannot write as a literal scalar in
+	// another style, in which the lines would be the value.
+	if out, err := yaml.Marshal(literal); err != nil || !strings.HasPrefix(string(out), "|") {
+		return
+	}
+	node.Style = literal.Style
+	node.Value = literal.Value
+	node.LineComment = "#" + foldedMarker
+}
+
+// writeFoldedScalars replaces the indicator of the literal scalars marked with
+// foldedMarker with the one of folded scalars, and removes the marker.
+func writeFoldedScalars(lines []string) {
+	for i, line := range lines {
+		header, comment, found := strings.Cut(line, " #"+foldedMarker)
+		if !found {
+			continue
+		}
+		indicator := strings.LastIndex(header, "|")
+		header = header[:indicator] + ">" + header[indicator+1:]
+		if comment != "" {
+			header += " #" + comment
+		}
+		lines[i] = header
+	}
+}
+
+// addLineComment adds a comment to the line comment of a node, after the
+// marker of folded scalars.
+func addLineComment(node *yaml.Node, comment string) {
+	switch node.LineComment {
+	case "":
+		node.LineComment = "#" + comment
+	case "#" + foldedMarker:
+		node.LineComment += comment
+	default:
+		node.LineComment += " #" + comment
+	}
+}
+
+// lineCommentNode returns the node of a mapping item the encoder writes the
+// line comment of on the line of the item, which is the key for block
+// collections.
+func lineCommentNode(key *yaml.Node, value *yaml.Node) *yaml.Node {
+	if isBlockCollection(value) {
+		return key
+	}
+	return value
+}
+
+func isBlockCollection(node *yaml.Node) bool {
+	return (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) &&
+		node.Style&yaml.FlowStyle == 0 && len(node.Content) > 0
+}
+
+// compactMarker is written at the end of the line comment of the keys of
+// sequences which are not indented from their key, as the encoder always
+// indents them. The sequences are moved to the column of their key once the
+// documents are encoded. Like the other markers, it cannot be mistaken for a
+// comment of the document, as YAML documents cannot hold control characters.
+const compactMarker = "\x01"
+
+// dedentCompactSequences moves the lines of the sequences after a key
+// marked with compactMarker to the column of the key, and removes the
+// marker.
+func dedentCompactSequences(lines []string) {
+	for i, line := range lines {
+		line, found := strings.CutSuffix(line, compactMarker)
+		if !found {
+			continue
+		}
+		lines[i] = strings.TrimSuffix(line, " #")
+		column := keyColumn(line)
+		indent := -1
+		for j := i + 1; j < len(lines); j++ {
+			trimmed := strings.TrimLeft(lines[j], " ")
+			if trimmed == "" {
+				continue
+			}
+			n := len(lines[j]) - len(trimmed)
+			if n <= column {
+				break
+			}
+			if indent < 0 {
+				indent = n - column
+			}
+			lines[j] = lines[j][min(indent, n):]
+		}
+	}
+}
+
+// keyColumn returns the column of the key on a line, after the dashes of the
+// sequence items it is in.
+func keyColumn(line string) int {
+	var column int
+	for {
+		rest := strings.TrimLeft(line[column:], " ")
+		column = len(line) - len(rest)
+		if !strings.HasPrefix(rest, "- ") {
+			return column
+		}
+		column += 2
+	}
+}
+
+// tagQuotesInFlow tags the single-quoted scalars in flow collections which
+// the encoder would single-quote anyway, so that their single quotes are
+// told apart from the ones of encrypted values, and are kept, see
+// decoder.valueStyle.
+func tagQuotesInFlow(node *yaml.Node, flow bool) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.MappingNode, yaml.SequenceNode:
+		flow = flow || node.Style&yaml.FlowStyle != 0
+		for _, item := range node.Content {
+			tagQuotesInFlow(item, flow)
+		}
+	case yaml.ScalarNode:
+		if !flow || node.Tag != "!!str" || node.Style&yaml.SingleQuotedStyle == 0 {
+			return
+		}
+		plain := &yaml.Node{Kind: yaml.ScalarNode, Tag: node.Tag, Value: node.Value}
+		if quotedInFlow(plain) {
+			node.Style |= yaml.TaggedStyle
+		}
+	}
+}
+
+// blankLineMarker is written for empty comments, which are blank lines, and
+// replaced with blank lines once the documents are encoded, as the encoder
+// does not write blank lines. YAML documents cannot hold a NUL character, so
+// it cannot be mistaken for a comment of the document.
+const blankLineMarker = "#\x00"
+
+func commentValue(comment sops.Comment) string {
+	if comment.Value == "" {
+		return blankLineMarker[1:]
+	}
+	return comment.Value
+}
+
+// writeBlankLines replaces the blank line markers in the encoded documents
+// with blank lines. The encoder writes the head comments of a mapping in a
+// sequence after the dash of the item, so the blank lines are moved before
+// the item.
+func writeBlankLines(lines []string) []string {
+	out := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		prefix, found := strings.CutSuffix(lines[i], blankLineMarker)
+		if !found {
+			out = append(out, lines[i])
+			continue
+		}
+		out = append(out, "")
+		if strings.TrimSpace(prefix) == "" {
+			continue
+		}
+		for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) == blankLineMarker {
+			out = append(out, "")
+			i++
+		}
+		if i+1 < len(lines) {
+			i++
+			out = append(out, prefix+strings.TrimLeft(lines[i], " "))
+		}
+	}
+	return out
+}
+
+// writeMarkers replaces the markers written in the comments of the nodes in
+// the encoded documents with what they stand for.
+func writeMarkers(in []byte) []byte {
+	if !bytes.ContainsAny(in, blankLineMarker[1:]+foldedMarker+compactMarker) {
+		return in
+	}
+	lines := strings.Split(string(in), "\n")
+	writeFoldedScalars(lines)
+	dedentCompactSequences(lines)
+	return []byte(strings.Join(writeBlankLines(lines), "\n"))
+}
+
 func (store *Store) appendSequence(in []interface{}, sequence *yaml.Node) {
 	var comments []string
 	var beginning bool = true
 	for _, item := range in {
 		if comment, ok := item.(sops.Comment); ok {
-			comments = append(comments, comment.Value)
+			if comment.Inline && len(comments) == 0 && len(sequence.Content) > 0 {
+				addLineComment(sequence.Content[len(sequence.Content)-1], comment.Value)
+				continue
+			}
+			comments = append(comments, commentValue(comment))
 		} else {
 			if beginning {
 				comments = store.addCommentsHead(sequence, comments)
@@ -227,19 +861,34 @@ func (store *Store) appendSequence(in []interface{}, sequence *yaml.Node) {
 func (store *Store) appendTreeBranch(branch sops.TreeBranch, mapping *yaml.Node) {
 	var comments []string
 	var beginning bool = true
+	var compactKeys []*yaml.Node
 	for _, item := range branch {
 		if comment, ok := item.Key.(sops.Comment); ok {
-			comments = append(comments, comment.Value)
+			if n := len(mapping.Content); comment.Inline && len(comments) == 0 && n > 0 {
+				addLineComment(lineCommentNode(mapping.Content[n-2], mapping.Content[n-1]), comment.Value)
+				continue
+			}
+			comments = append(comments, commentValue(comment))
 		} else {
 			if beginning {
 				comments = store.addCommentsHead(mapping, comments)
 				beginning = false
 			}
 			var keyNode = &yaml.Node{}
-			keyNode.Encode(item.Key)
+			if item.Key == mergeKey {
+				// Encoding "<<" results in an explicitly tagged merge key
+				keyNode.Kind = yaml.ScalarNode
+				keyNode.Value = mergeKey
+			} else {
+				keyNode.Encode(item.Key)
+				setStyle(keyNode, styleOf(item.Value).key)
+			}
 			comments = store.addCommentsHead(keyNode, comments)
 			valueNode := store.treeValueToNode(item.Value)
 			mapping.Content = append(mapping.Content, keyNode, valueNode)
+			if styleOf(item.Value).compact && valueNode.Kind == yaml.SequenceNode && isBlockCollection(valueNode) {
+				compactKeys = append(compactKeys, keyNode)
+			}
 		}
 	}
 	if len(comments) > 0 {
@@ -249,6 +898,13 @@ func (store *Store) appendTreeBranch(branch sops.TreeBranch, mapping *yaml.Node)
 			store.addCommentsFoot(mapping.Content[len(mapping.Content)-2], comments)
 		}
 	}
+	// The markers are added once the line comments of the keys are known.
+	for _, keyNode := range compactKeys {
+		if keyNode.LineComment == "" {
+			keyNode.LineComment = "#"
+		}
+		keyNode.LineComment += compactMarker
+	}
 }
 
 // LoadEncryptedFile loads the contents of an encrypted yaml file onto a
@@ -275,6 +931,8 @@ func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
 	}
 	var branches sops.TreeBranches
 	d := yaml.NewDecoder(bytes.NewReader(in))
+	dec := newDecoder(in)
+	dec.encrypted = true
 	for {
 		var data yaml.Node
 		err := d.Decode(&data)
@@ -285,7 +943,7 @@ func (store *Store) LoadEncryptedFile(in []byte) (sops.Tree, error) {
 			return sops.Tree{}, fmt.Errorf("Error unmarshaling input YAML: %s", err)
 		}
 
-		branch, err := store.yamlDocumentNodeToTreeBranch(data)
+		branch, err := dec.yamlDocumentNodeToTreeBranch(data)
 		if err != nil {
 			return sops.Tree{}, fmt.Errorf("Error unmarshaling input YAML: %s", err)
 		}
@@ -315,6 +973,7 @@ func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
 		}
 	}
 	d := yaml.NewDecoder(bytes.NewReader(in))
+	dec := newDecoder(in)
 	for {
 		var data yaml.Node
 		err := d.Decode(&data)
@@ -325,7 +984,7 @@ func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
 			return nil, fmt.Errorf("Error unmarshaling input YAML: %s", err)
 		}
 
-		branch, err := store.yamlDocumentNodeToTreeBranch(data)
+		branch, err := dec.yamlDocumentNodeToTreeBranch(data)
 		if err != nil {
 			return nil, fmt.Errorf("Error unmarshaling input YAML: %s", err)
 		}
@@ -340,21 +999,59 @@ func (store *Store) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
 	return branches, nil
 }
 
-func (store *Store) getIndentation() (int, error) {
+// getIndentation returns the indentation of the store, or else the
+// indentation of the file the value was loaded from.
+func (store *Store) getIndentation(in interface{}) (int, error) {
 	if store.config.Indent > 0 {
 		return store.config.Indent, nil
 	} else if store.config.Indent < 0 {
 		return 0, errors.New("YAML Negative indentation not accepted")
 	}
+	if indent := indentOf(in); indent > 0 {
+		return indent, nil
+	}
 	return IndentDefault, nil
 }
 
+// indentOf returns the indentation kept in the style of a collection in a
+// value, or 0 if there is none.
+func indentOf(in interface{}) int {
+	switch in := in.(type) {
+	case sops.Styled:
+		if s, ok := in.Style.(style); ok && s.indent > 0 {
+			return s.indent
+		}
+		return indentOf(in.Value)
+	case sops.Anchored:
+		return indentOf(in.Value)
+	case sops.TreeBranches:
+		for _, branch := range in {
+			if indent := indentOf(branch); indent > 0 {
+				return indent
+			}
+		}
+	case sops.TreeBranch:
+		for _, item := range in {
+			if indent := indentOf(item.Value); indent > 0 {
+				return indent
+			}
+		}
+	case []interface{}:
+		for _, item := range in {
+			if indent := indentOf(item); indent > 0 {
+				return indent
+			}
+		}
+	}
+	return 0
+}
+
 // EmitEncryptedFile returns the encrypted bytes of the yaml file corresponding to a
 // sops.Tree runtime object
 func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
 	var b bytes.Buffer
 	e := yaml.NewEncoder(io.Writer(&b))
-	indent, err := store.getIndentation()
+	indent, err := store.getIndentation(in.Branches)
 	if err != nil {
 		return nil, err
 	}
@@ -375,6 +1072,7 @@ func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
 		})
 		// Marshal branch to global mapping node
 		store.appendTreeBranch(branch, &mapping)
+		tagQuotesInFlow(&doc, false)
 		// Encode YAML
 		err := e.Encode(&doc)
 		if err != nil {
@@ -382,7 +1080,7 @@ func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
 		}
 	}
 	e.Close()
-	return b.Bytes(), nil
+	return writeMarkers(b.Bytes()), nil
 }
 
 // EmitPlainFile returns the plaintext bytes of the yaml file corresponding to a
@@ -390,7 +1088,7 @@ func (store *Store) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
 func (store *Store) EmitPlainFile(branches sops.TreeBranches) ([]byte, error) {
 	var b bytes.Buffer
 	e := yaml.NewEncoder(io.Writer(&b))
-	indent, err := store.getIndentation()
+	indent, err := store.getIndentation(branches)
 	if err != nil {
 		return nil, err
 	}
@@ -405,6 +1103,7 @@ func (store *Store) EmitPlainFile(branches sops.TreeBranches) ([]byte, error) {
 		// Marshal branch to global mapping node
 		store.appendTreeBranch(branch, &mapping)
 		doc.Content = append(doc.Content, &mapping)
+		tagQuotesInFlow(&doc, false)
 		// Encode YAML
 		err := e.Encode(&doc)
 		if err != nil {
@@ -412,14 +1111,26 @@ func (store *Store) EmitPlainFile(branches sops.TreeBranches) ([]byte, error) {
 		}
 	}
 	e.Close()
-	return b.Bytes(), nil
+	return writeMarkers(b.Bytes()), nil
 }
 
 // EmitValue returns bytes corresponding to a single encoded value
 // in a generic interface{} object
 func (store *Store) EmitValue(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := yaml.NewEncoder(io.Writer(&b))
+	indent, err := store.getIndentation(v)
+	if err != nil {
+		return nil, err
+	}
+	e.SetIndent(indent)
 	n := store.treeValueToNode(v)
-	return yaml.Marshal(n)
+	tagQuotesInFlow(n, false)
+	if err := e.Encode(n); err != nil {
+		return nil, err
+	}
+	e.Close()
+	return writeMarkers(b.Bytes()), nil
 }
 
 // EmitExample returns the bytes corresponding to an example complex tree
@@ -431,6 +1142,11 @@ func (store *Store) EmitExample() []byte {
 	return bytes
 }
 
+// EmitsAliases returns true, as anchors and aliases are kept in YAML files.
+func (store *Store) EmitsAliases() bool {
+	return true
+}
+
 // HasSopsTopLevelKey checks whether a top-level "sops" key exists.
 func (store *Store) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
 	return stores.HasSopsTopLevelKey(branch)
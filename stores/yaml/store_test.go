@@ -1,11 +1,16 @@
 package yaml
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/age"
 	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores/json"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 var PLAIN = []byte(`---
@@ -60,6 +65,47 @@ key4: *bar
 `)
 
 var ALIASES_BRANCHES = sops.TreeBranches{
+	sops.TreeBranch{
+		sops.TreeItem{
+			Key: "key1",
+			Value: sops.Anchored{
+				Anchor: "foo",
+				Value: sops.Styled{
+					Style: style{indent: 2},
+					Value: []interface{}{
+						"foo",
+					},
+				},
+			},
+		},
+		sops.TreeItem{
+			Key:   "key2",
+			Value: sops.Alias{Anchor: "foo"},
+		},
+		sops.TreeItem{
+			Key: "key3",
+			Value: sops.Anchored{
+				Anchor: "bar",
+				Value: sops.TreeBranch{
+					sops.TreeItem{
+						Key:   "foo",
+						Value: "bar",
+					},
+					sops.TreeItem{
+						Key:   "baz",
+						Value: "bam",
+					},
+				},
+			},
+		},
+		sops.TreeItem{
+			Key:   "key4",
+			Value: sops.Alias{Anchor: "bar"},
+		},
+	},
+}
+
+var ALIASES_EXPANDED_BRANCHES = sops.TreeBranches{
 	sops.TreeBranch{
 		sops.TreeItem{
 			Key: "key1",
@@ -102,6 +148,21 @@ var ALIASES_BRANCHES = sops.TreeBranches{
 	},
 }
 
+var MERGE_KEYS = []byte(`defaults: &defaults
+    image: nginx
+    password: &password secret
+    resources:
+        cpu: 1
+production:
+    <<: *defaults
+    replicas: 3
+    admin_password: *password
+staging:
+    <<:
+        - *defaults
+    replicas: 1
+`)
+
 var COMMENT_1 = []byte(`# test
 a:
     b: null
@@ -131,6 +192,7 @@ prometheus-node-exporter:
     podLabels:
         ## Add the 'node-exporter' label to be used by serviceMonitor to match standard common usage in rules and grafana dashboards
         ##
+
         jobLabel: node-exporter
     extraArgs:
         - --collector.filesystem.ignored-mount-points=^/(dev|proc|sys|var/lib/docker/.+)($|/)
@@ -195,6 +257,10 @@ var COMMENT_7_BRANCHES = sops.TreeBranches{
 				},
 			},
 		},
+		sops.TreeItem{
+			Key:   sops.Comment{Value: ""},
+			Value: nil,
+		},
 		sops.TreeItem{
 			Key: "e",
 			Value: []interface{}{
@@ -208,6 +274,7 @@ var COMMENT_7_OUT = []byte(`a:
     b:
         c: d
     # comment
+
 e:
     - f
 `)
@@ -231,6 +298,7 @@ prometheus-node-exporter:
   podLabels:
     ## Add the 'node-exporter' label to be used by serviceMonitor to match standard common usage in rules and grafana dashboards
     ##
+
     jobLabel: node-exporter
   extraArgs:
     - --collector.filesystem.ignored-mount-points=^/(dev|proc|sys|var/lib/docker/.+)($|/)
@@ -255,6 +323,73 @@ func TestLoadAliasesPlainFile(t *testing.T) {
 	assert.Equal(t, ALIASES_BRANCHES, branches)
 }
 
+func TestExpandAliasesPlainFile(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(ALIASES)
+	assert.Nil(t, err)
+	branch, err := branches[0].ExpandAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, ALIASES_EXPANDED_BRANCHES[0], branch)
+}
+
+func TestEmitAliasesPlainFile(t *testing.T) {
+	bytes, err := (&Store{}).EmitPlainFile(ALIASES_BRANCHES)
+	assert.Nil(t, err)
+	assert.Equal(t, `key1: &foo
+  - foo
+key2: *foo
+key3: &bar
+  foo: bar
+  baz: bam
+key4: *bar
+`, string(bytes))
+}
+
+func TestMergeKeysRoundTrip(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(MERGE_KEYS)
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeItem{Key: "<<", Value: sops.Alias{Anchor: "defaults"}}, branches[0][1].Value.(sops.TreeBranch)[0])
+	assert.Equal(t, sops.TreeItem{Key: "<<", Value: []interface{}{sops.Alias{Anchor: "defaults"}}}, branches[0][2].Value.(sops.TreeBranch)[0])
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(MERGE_KEYS), string(bytes))
+}
+
+func TestMergeKeysToJSON(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(MERGE_KEYS)
+	assert.Nil(t, err)
+	branch, err := branches[0].ExpandAliases()
+	assert.Nil(t, err)
+	bytes, err := (&json.Store{}).EmitPlainFile(sops.TreeBranches{branch})
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+"defaults": {
+"image": "nginx",
+"password": "secret",
+"resources": {
+"cpu": 1
+}
+},
+"production": {
+"image": "nginx",
+"password": "secret",
+"resources": {
+"cpu": 1
+},
+"replicas": 3,
+"admin_password": "secret"
+},
+"staging": {
+"image": "nginx",
+"password": "secret",
+"resources": {
+"cpu": 1
+},
+"replicas": 1
+}
+}
+`, string(bytes))
+}
+
 func TestComment1(t *testing.T) {
 	// First iteration: load and store
 	branches, err := (&Store{}).LoadPlainFile(COMMENT_1)
@@ -279,7 +414,11 @@ func TestComment3(t *testing.T) {
 	// First iteration: load and store
 	branches, err := (&Store{}).LoadPlainFile(COMMENT_3_IN)
 	assert.Nil(t, err)
-	bytes, err := (&Store{}).EmitPlainFile(branches)
+	bytes, err := (&Store{
+		config: config.YAMLStoreConfig{
+			Indent: IndentDefault,
+		},
+	}).EmitPlainFile(branches)
 	assert.Nil(t, err)
 	assert.Equal(t, string(COMMENT_3_OUT), string(bytes))
 	assert.Equal(t, COMMENT_3_OUT, bytes)
@@ -429,3 +568,233 @@ sops: The attribute 'sops' must be rejected, otherwise the file cannot be opened
 	assert.NotNil(t, err)
 	assert.Equal(t, `YAML doc used reserved word 'sops'`, err.Error())
 }
+
+var STYLES = []byte(`# comment
+
+name: "service"
+version: '1.2'
+"quoted key": value
+ports: [80, 443]
+labels: {app: web, tier: 'front', zone: "a"}
+
+database:
+    host: db.example.com
+
+    # options
+    options:
+        - name: a
+
+
+        - 'b'
+    description: |+
+        text
+
+next: >-
+    folded
+---
+
+other: value
+`)
+
+func TestStylesRoundTrip(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(STYLES)
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(STYLES), string(bytes))
+}
+
+func TestLoadStyledValues(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile([]byte("a: 'x'\nb: [1, \"2\"]\n\n\"c\": d\ne: \"123\"\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranches{{
+		sops.TreeItem{Key: "a", Value: sops.Styled{Style: style{value: yaml.SingleQuotedStyle}, Value: "x"}},
+		sops.TreeItem{Key: "b", Value: sops.Styled{Style: style{value: yaml.FlowStyle}, Value: []interface{}{1, "2"}}},
+		sops.TreeItem{Key: sops.Comment{Value: ""}, Value: nil},
+		sops.TreeItem{Key: "c", Value: sops.Styled{Style: style{key: yaml.DoubleQuotedStyle}, Value: "d"}},
+		// The encoder quotes strings which look like numbers itself.
+		sops.TreeItem{Key: "e", Value: "123"},
+	}}, branches)
+
+	// Other stores do not see the styles.
+	expanded, err := branches[0].ExpandAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, "x", expanded[0].Value)
+}
+
+func TestStylesSurviveEncryption(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(STYLES)
+	assert.Nil(t, err)
+	key := make([]byte, 32)
+	tree := sops.Tree{Branches: branches}
+	_, err = tree.Encrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+
+	encrypted, err := (&Store{}).EmitPlainFile(tree.Branches)
+	assert.Nil(t, err)
+	assert.Contains(t, string(encrypted), "\nname: \"ENC[")
+	assert.Contains(t, string(encrypted), "\nports: ['ENC[")
+
+	branches, err = (&Store{}).LoadPlainFile(encrypted)
+	assert.Nil(t, err)
+	tree = sops.Tree{Branches: branches}
+	_, err = tree.Decrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(tree.Branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(STYLES), string(bytes))
+}
+
+var NUMBERS_AND_TIMESTAMPS = []byte(`account: 12345678901234567890
+big: 123456789012345678901234
+rate: 0.10000000000000000001
+pi: 3.14
+day: 2024-01-02
+at: 2001-12-14t21:59:43.10-05:00
+quoted: "2024-01-02"
+none: null
+list:
+    - -98765432109876543210
+    - 2024-1-2
+`)
+
+func TestLoadNumbersAndTimestamps(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(NUMBERS_AND_TIMESTAMPS)
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{Key: "account", Value: sops.Number("12345678901234567890")},
+		sops.TreeItem{Key: "big", Value: sops.Number("123456789012345678901234")},
+		sops.TreeItem{Key: "rate", Value: sops.Number("0.10000000000000000001")},
+		sops.TreeItem{Key: "pi", Value: 3.14},
+		sops.TreeItem{Key: "day", Value: sops.Timestamp("2024-01-02")},
+		sops.TreeItem{Key: "at", Value: sops.Timestamp("2001-12-14t21:59:43.10-05:00")},
+		sops.TreeItem{Key: "quoted", Value: "2024-01-02"},
+		sops.TreeItem{Key: "none", Value: nil},
+		sops.TreeItem{Key: "list", Value: []interface{}{sops.Number("-98765432109876543210"), sops.Timestamp("2024-1-2")}},
+	}, branches[0])
+}
+
+func TestNumbersAndTimestampsSurviveEncryption(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(NUMBERS_AND_TIMESTAMPS)
+	assert.Nil(t, err)
+	key := make([]byte, 32)
+	tree := sops.Tree{Branches: branches}
+	encryptedMac, err := tree.Encrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+
+	encrypted, err := (&Store{}).EmitPlainFile(tree.Branches)
+	assert.Nil(t, err)
+	assert.Contains(t, string(encrypted), ",type:int]")
+	assert.Contains(t, string(encrypted), ",type:timestamp]")
+	assert.Contains(t, string(encrypted), ",type:null]")
+
+	branches, err = (&Store{}).LoadPlainFile(encrypted)
+	assert.Nil(t, err)
+	tree = sops.Tree{Branches: branches}
+	decryptedMac, err := tree.Decrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+	assert.Equal(t, encryptedMac, decryptedMac)
+	bytes, err := (&Store{}).EmitPlainFile(tree.Branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(NUMBERS_AND_TIMESTAMPS), string(bytes))
+}
+
+var LAYOUT = []byte(`# comment
+app:
+  name: web # the name
+  tags: ['a', b]
+  ports:
+  - 80 # http
+  - 443
+  hosts:
+  - name: a
+    aliases:
+    - b
+  description: >
+    A description
+    on two lines
+
+    and a second paragraph
+  summary: >-
+    one line
+  script: |
+    echo hi
+other: value # inline
+`)
+
+func TestLayoutRoundTrip(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(LAYOUT)
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(LAYOUT), string(bytes))
+}
+
+func TestLayoutSurvivesEncryption(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(LAYOUT)
+	assert.Nil(t, err)
+	key := make([]byte, 32)
+	tree := sops.Tree{Branches: branches, Metadata: sops.Metadata{
+		KeyGroups: []sops.KeyGroup{{&age.MasterKey{
+			Recipient:    "age1yt3tfqlfrwdwx0z0ynwplcr6qxcxfaqycuprpmy89nr83ltx74tqdpszlw",
+			EncryptedKey: "-----BEGIN AGE ENCRYPTED FILE-----\nYWJj\n-----END AGE ENCRYPTED FILE-----\n",
+		}}},
+	}}
+	_, err = tree.Encrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+
+	encrypted, err := (&Store{}).EmitEncryptedFile(tree)
+	assert.Nil(t, err)
+	assert.Contains(t, string(encrypted), "\n  tags: [!!str 'ENC[")
+	assert.Contains(t, string(encrypted), "\n  ports:\n  - ENC[")
+	assert.Contains(t, string(encrypted), ",type:int] #ENC[")
+	assert.Contains(t, string(encrypted), "\n  description: >\n    ENC[")
+	assert.Contains(t, string(encrypted), "\n  summary: >-\n    ENC[")
+
+	tree, err = (&Store{}).LoadEncryptedFile(encrypted)
+	assert.Nil(t, err)
+	_, err = tree.Decrypt(key, aes.NewCipher())
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(tree.Branches)
+	assert.Nil(t, err)
+	// The lines of the description are only kept as long as it is not
+	// encrypted, and its paragraphs are written on a line each.
+	assert.Equal(t, strings.Replace(string(LAYOUT), "A description\n    on two lines", "A description on two lines", 1), string(bytes))
+}
+
+func TestLoadInlineComments(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile([]byte("a: 1 # one\nb: # two\n    c: [x] # three\nl:\n    - x # four\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, sops.TreeBranch{
+		sops.TreeItem{Key: "a", Value: 1},
+		sops.TreeItem{Key: sops.Comment{Value: " one", Inline: true}},
+		sops.TreeItem{Key: "b", Value: sops.TreeBranch{
+			sops.TreeItem{Key: "c", Value: sops.Styled{Style: style{value: yaml.FlowStyle}, Value: []interface{}{"x"}}},
+			sops.TreeItem{Key: sops.Comment{Value: " three", Inline: true}},
+		}},
+		sops.TreeItem{Key: sops.Comment{Value: " two", Inline: true}},
+		sops.TreeItem{Key: "l", Value: []interface{}{"x", sops.Comment{Value: " four", Inline: true}}},
+	}, branches[0])
+}
+
+func TestNullsRoundTrip(t *testing.T) {
+	in := []byte("a:\nb: ~\nc: null\nd: Null\nl:\n    -\n    - ~\nf: [~, null]\n")
+	branches, err := (&Store{}).LoadPlainFile(in)
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(in), string(bytes))
+}
+
+func TestIndentationOfFile(t *testing.T) {
+	branches, err := (&Store{}).LoadPlainFile(COMMENT_3_IN)
+	assert.Nil(t, err)
+	bytes, err := (&Store{}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(COMMENT_3_IN), string(bytes))
+
+	// The indentation of the store is used over the one of the file.
+	bytes, err = (&Store{config: config.YAMLStoreConfig{Indent: 4}}).EmitPlainFile(branches)
+	assert.Nil(t, err)
+	assert.Equal(t, string(COMMENT_3_OUT), string(bytes))
+}
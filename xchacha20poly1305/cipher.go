@@ -0,0 +1,146 @@
+/*
+Package xchacha20poly1305 defines a Cipher that uses XChaCha20-Poly1305 authenticated encryption to encrypt values
+of the SOPS tree. It is an alternative to AES-GCM for platforms without hardware support for AES.
+*/
+package xchacha20poly1305 //import "github.com/getsops/sops/v3/xchacha20poly1305"
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+
+	"github.com/getsops/sops/v3"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Prefix is the prefix of the values encrypted with this cipher.
+const Prefix = "ENC[XCHACHA20_POLY1305,"
+
+type encryptedValue struct {
+	data     []byte
+	nonce    []byte
+	tag      []byte
+	datatype string
+}
+
+type stashKey struct {
+	additionalData string
+	plaintext      interface{}
+}
+
+// newStashKey returns the stash key of a plaintext. Byte slices cannot be
+// compared, so they are stashed as strings.
+func newStashKey(plaintext interface{}, additionalData string) stashKey {
+	if b, ok := plaintext.([]byte); ok {
+		plaintext = string(b)
+	}
+	return stashKey{plaintext: plaintext, additionalData: additionalData}
+}
+
+// Cipher encrypts and decrypts values with XChaCha20-Poly1305
+type Cipher struct {
+	// stash is a map that stores nonces for reuse, so that the ciphertext doesn't change when decrypting and
+	// reencrypting the same values.
+	stash map[stashKey][]byte
+}
+
+// NewCipher is the constructor for a new Cipher object
+func NewCipher() Cipher {
+	return Cipher{
+		stash: make(map[stashKey][]byte),
+	}
+}
+
+var encre = regexp.MustCompile(`^ENC\[XCHACHA20_POLY1305,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+
+func parse(value string) (*encryptedValue, error) {
+	matches := encre.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, fmt.Errorf("Input string %s does not match sops' data format", value)
+	}
+	data, err := base64.StdEncoding.DecodeString(matches[1])
+	if err != nil {
+		return nil, fmt.Errorf("Error base64-decoding data: %s", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(matches[2])
+	if err != nil {
+		return nil, fmt.Errorf("Error base64-decoding iv: %s", err)
+	}
+	if len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("Invalid iv of %d bytes, expected %d bytes", len(nonce), chacha20poly1305.NonceSizeX)
+	}
+	tag, err := base64.StdEncoding.DecodeString(matches[3])
+	if err != nil {
+		return nil, fmt.Errorf("Error base64-decoding tag: %s", err)
+	}
+	return &encryptedValue{data, nonce, tag, matches[4]}, nil
+}
+
+// Decrypt takes a sops-format value string and a key and returns the decrypted value
+func (c Cipher) Decrypt(ciphertext string, key []byte, additionalData string) (plaintext interface{}, err error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	encryptedValue, err := parse(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	data := append(encryptedValue.data, encryptedValue.tag...)
+	decryptedBytes, err := aead.Open(nil, encryptedValue.nonce, data, []byte(additionalData))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt with XCHACHA20_POLY1305: %s", err)
+	}
+	plaintext, err = sops.DecodeValue(encryptedValue.datatype, decryptedBytes)
+	if err != nil {
+		return nil, err
+	}
+	c.stash[newStashKey(plaintext, additionalData)] = encryptedValue.nonce
+	return plaintext, nil
+}
+
+func isEmpty(value interface{}) bool {
+	switch value := value.(type) {
+	case string:
+		return value == ""
+	case []byte:
+		return len(value) == 0
+	case sops.Comment:
+		return isEmpty(value.Value)
+	default:
+		return false
+	}
+}
+
+// Encrypt takes one of (string, bytes, int, float, bool, number, timestamp, null) and encrypts it with the provided key
+// and additional auth data, returning a sops-format encrypted string.
+func (c Cipher) Encrypt(plaintext interface{}, key []byte, additionalData string) (ciphertext string, err error) {
+	if isEmpty(plaintext) {
+		return "", nil
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", fmt.Errorf("Could not initialize XChaCha20-Poly1305 encryption cipher: %s", err)
+	}
+	nonce, ok := c.stash[newStashKey(plaintext, additionalData)]
+	if !ok {
+		nonce = make([]byte, chacha20poly1305.NonceSizeX)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", fmt.Errorf("Could not generate random bytes for nonce: %s", err)
+		}
+	}
+	encryptedType, plainBytes, err := sops.EncodeValue(plaintext)
+	if err != nil {
+		return "", err
+	}
+	out := aead.Seal(nil, nonce, plainBytes, []byte(additionalData))
+	return fmt.Sprintf("ENC[XCHACHA20_POLY1305,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(out[:len(out)-chacha20poly1305.Overhead]),
+		base64.StdEncoding.EncodeToString(nonce),
+		base64.StdEncoding.EncodeToString(out[len(out)-chacha20poly1305.Overhead:]),
+		encryptedType), nil
+}
@@ -0,0 +1,81 @@
+package xchacha20poly1305
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/getsops/sops/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundtrip(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	values := []interface{}{
+		"foo",
+		[]byte{0x00, 0xff, 0x80, 'a'},
+		42,
+		1.5,
+		true,
+		sops.Number("12345678901234567890"),
+		sops.Timestamp("2024-01-02"),
+		nil,
+		sops.Comment{Value: "comment"},
+	}
+	for _, value := range values {
+		s, err := NewCipher().Encrypt(value, key, "bar:")
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(s, Prefix), s)
+		d, err := NewCipher().Decrypt(s, key, "bar:")
+		assert.Nil(t, err)
+		assert.Equal(t, value, d)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	message, err := NewCipher().Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	d, err := NewCipher().Decrypt(message, key, "bar:")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", d)
+
+	_, err = NewCipher().Decrypt(message, key, "baz:")
+	assert.ErrorContains(t, err, "Could not decrypt with XCHACHA20_POLY1305")
+
+	_, err = NewCipher().Decrypt("ENC[AES256_GCM,data:oYyi,iv:MyIDYbT718JRr11QtBkcj3Dwm4k1aCGZBVeZf0EyV8o=,tag:t5z2Z023Up0kxwCgw1gNxg==,type:str]", key, "bar:")
+	assert.ErrorContains(t, err, "does not match sops' data format")
+
+	_, err = NewCipher().Decrypt("ENC[XCHACHA20_POLY1305,data:/w7V,iv:AAAA,tag:c2/C8hW2jOsrvVWGNmfN+w==,type:str]", key, "bar:")
+	assert.ErrorContains(t, err, "Invalid iv of 3 bytes")
+}
+
+func TestEncryptEmptyValues(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	s, err := NewCipher().Encrypt("", key, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", s)
+	s, err = NewCipher().Encrypt(sops.Comment{}, key, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", s)
+	s, err = NewCipher().Encrypt([]byte{}, key, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", s)
+	d, err := NewCipher().Decrypt("", key, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", d)
+}
+
+func TestStash(t *testing.T) {
+	key := []byte(strings.Repeat("f", 32))
+	c := NewCipher()
+	s, err := c.Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	_, err = c.Decrypt(s, key, "bar:")
+	assert.Nil(t, err)
+	again, err := c.Encrypt("foo", key, "bar:")
+	assert.Nil(t, err)
+	assert.Equal(t, s, again)
+	other, err := c.Encrypt("foo", key, "baz:")
+	assert.Nil(t, err)
+	assert.NotEqual(t, s, other)
+}